@@ -0,0 +1,80 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/wagaru/task/internal/auth"
+	"github.com/wagaru/task/internal/model"
+	"github.com/wagaru/task/internal/service"
+)
+
+const keysUsage = `usage:
+  keys create -name NAME -scopes SCOPE[,SCOPE...]
+  keys list
+  keys revoke ID
+
+scopes: ` + "%s"
+
+// runKeys manages the API keys the server accepts. The token of a created
+// key is printed once; only its hash is stored.
+func runKeys(svc service.Service, args []string, out io.Writer) error {
+	usage := fmt.Errorf(keysUsage, strings.Join(auth.Scopes, ", "))
+	if len(args) == 0 {
+		return usage
+	}
+	switch args[0] {
+	case "create":
+		fs := flag.NewFlagSet("keys create", flag.ContinueOnError)
+		fs.SetOutput(out)
+		name := fs.String("name", "", "金鑰名稱")
+		scopes := fs.String("scopes", "", "以逗號分隔的權限")
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+		var list []string
+		if *scopes != "" {
+			list = strings.Split(*scopes, ",")
+		}
+		key, err := svc.CreateAPIKey(&model.APIKey{Name: *name, Scopes: list})
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "created key %d (%s) with scopes %s\n", key.ID, key.Name, strings.Join(key.Scopes, ","))
+		fmt.Fprintf(out, "token: %s\n", key.Token)
+		fmt.Fprintln(out, "the token is not shown again")
+		return nil
+	case "list":
+		keys, err := svc.GetAPIKeys()
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tNAME\tPREFIX\tSCOPES\tCREATED")
+		for _, key := range keys {
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\n", key.ID, key.Name, key.Prefix, strings.Join(key.Scopes, ","), key.CreatedAt.Format(time.RFC3339))
+		}
+		return w.Flush()
+	case "revoke":
+		if len(args) != 2 {
+			return usage
+		}
+		id, err := strconv.ParseUint(args[1], 10, 32)
+		if err != nil || id == 0 {
+			return errors.New("keys revoke: ID must be a positive integer")
+		}
+		if err := svc.DeleteAPIKey(uint32(id)); err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "revoked key %d\n", id)
+		return nil
+	default:
+		return usage
+	}
+}
@@ -2,7 +2,9 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
@@ -10,17 +12,33 @@ import (
 	"strings"
 	"syscall"
 	"time"
+	// Embeds the zone database, so that Task.TimeZone loads on hosts
+	// without one.
+	_ "time/tzdata"
 
 	"github.com/spf13/viper"
 	"github.com/wagaru/task/config"
+	"github.com/wagaru/task/internal/auth"
 	"github.com/wagaru/task/internal/delivery"
+	"github.com/wagaru/task/internal/errcode"
+	"github.com/wagaru/task/internal/event"
 	"github.com/wagaru/task/internal/repository"
+	"github.com/wagaru/task/internal/rpc"
+	"github.com/wagaru/task/internal/scheduler"
 	"github.com/wagaru/task/internal/service"
+	"github.com/wagaru/task/internal/webhook"
 )
 
 var (
 	configFile string
 	conf       *config.ServerConfig
+	grpcConf   *config.GRPCConfig
+	repoConf   *config.RepositoryConfig
+	taskConf   *config.TaskConfig
+	remindConf *config.ReminderConfig
+	hookConf   *config.WebhookConfig
+	eventConf  *config.EventConfig
+	authConf   *config.AuthConfig
 )
 
 func init() {
@@ -34,14 +52,62 @@ func init() {
 }
 
 func main() {
-	repo := repository.NewRepository()
-	svc := service.NewService(repo)
-	delivery := delivery.NewDelivery(svc, conf)
+	repo, err := repository.New(repoConf)
+	if errors.Is(err, repository.ErrLocked) && flag.NArg() > 0 {
+		log.Fatalf("%v: stop the server first, or use /admin/keys while it runs", err)
+	}
+	if err != nil {
+		log.Fatalf("repository.New err:%v", err)
+	}
+	if flag.NArg() > 0 {
+		err := runCommand(repo, flag.Args())
+		if cerr := repo.Close(); err == nil {
+			err = cerr
+		}
+		if e, ok := err.(*errcode.Error); ok && len(e.Details()) > 0 {
+			log.Fatalf("%v: %s", e, strings.Join(e.Details(), "; "))
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	var bufferSize int
+	if eventConf != nil {
+		bufferSize = eventConf.BufferSize
+	}
+	bus := event.NewBus(bufferSize)
+	dispatcher := webhook.NewDispatcher(repo, hookConf)
+	svc, err := service.NewService(repo, taskConf, service.WithEvents(bus), service.WithWebhooks(dispatcher))
+	if err != nil {
+		log.Fatalf("service.NewService err:%v", err)
+	}
+	var jwtConf *config.JWTConfig
+	if authConf != nil {
+		jwtConf = &authConf.JWT
+	}
+	verifier, err := auth.NewJWTVerifier(jwtConf)
+	if err != nil {
+		log.Fatalf("auth.NewJWTVerifier err:%v", err)
+	}
+	delivery := delivery.NewDelivery(svc, conf, delivery.WithAuth(authConf), delivery.WithJWT(verifier))
 	go func() {
 		if err := delivery.Run(); err != nil && err != http.ErrServerClosed {
 			log.Fatalf("server run error: %v", err)
 		}
 	}()
+	grpcServer := rpc.NewServer(svc, grpcConf, rpc.WithAuth(authConf), rpc.WithJWT(verifier))
+	go func() {
+		if err := grpcServer.Run(); err != nil {
+			log.Fatalf("gRPC server run error: %v", err)
+		}
+	}()
+	reminders := scheduler.New(svc, remindConf)
+	go func() {
+		if err := reminders.Run(); err != nil {
+			log.Fatalf("scheduler run error: %v", err)
+		}
+	}()
 
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGTERM, syscall.SIGINT)
@@ -51,13 +117,42 @@ func main() {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
+	// Ending the event streams first lets the servers drain without waiting
+	// on watchers, which resume after the restart.
+	bus.Close()
 	if err := delivery.Shutdown(ctx); err != nil {
 		log.Fatalf("server forced to shutdown: %v", err)
 	}
+	if err := grpcServer.Shutdown(ctx); err != nil {
+		log.Printf("gRPC server forced to shutdown: %v", err)
+	}
+	if err := reminders.Shutdown(ctx); err != nil {
+		log.Printf("scheduler shutdown error: %v", err)
+	}
+	if err := dispatcher.Shutdown(ctx); err != nil {
+		log.Printf("webhook shutdown error: %v", err)
+	}
+	if err := repo.Close(); err != nil {
+		log.Printf("repository close error: %v", err)
+	}
 	log.Println("server exiting...")
 
 }
 
+// runCommand runs the admin command named by args instead of the servers.
+func runCommand(repo repository.Repository, args []string) error {
+	switch args[0] {
+	case "keys":
+		svc, err := service.NewService(repo, taskConf)
+		if err != nil {
+			return err
+		}
+		return runKeys(svc, args[1:], os.Stdout)
+	default:
+		return fmt.Errorf("unknown command %q, expected keys", args[0])
+	}
+}
+
 func setupFlag() error {
 	flag.StringVar(&configFile, "c", "../config", "設定檔路徑")
 	flag.Parse()
@@ -77,9 +172,36 @@ func setupConfig() error {
 	if err := viper.UnmarshalKey("Server", &conf); err != nil {
 		return err
 	}
+	if err := viper.UnmarshalKey("GRPC", &grpcConf); err != nil {
+		return err
+	}
+	if err := viper.UnmarshalKey("Repository", &repoConf); err != nil {
+		return err
+	}
+	if err := viper.UnmarshalKey("Task", &taskConf); err != nil {
+		return err
+	}
+	if err := viper.UnmarshalKey("Reminder", &remindConf); err != nil {
+		return err
+	}
+	if err := viper.UnmarshalKey("Webhook", &hookConf); err != nil {
+		return err
+	}
+	if err := viper.UnmarshalKey("Event", &eventConf); err != nil {
+		return err
+	}
+	if err := viper.UnmarshalKey("Auth", &authConf); err != nil {
+		return err
+	}
 	if port := viper.GetString("port"); port != "" {
 		conf.Port = port
 	}
+	if port := viper.GetString("grpc_port"); port != "" {
+		if grpcConf == nil {
+			grpcConf = &config.GRPCConfig{}
+		}
+		grpcConf.Port = port
+	}
 	if mode := viper.GetString("mode"); mode != "" {
 		conf.RunMode = mode
 	}
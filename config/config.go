@@ -1,7 +1,97 @@
 package config
 
+import "time"
+
 type ServerConfig struct {
 	RunMode string
 	Host    string
 	Port    string
 }
+
+// GRPCConfig.Port is where the gRPC server listens; leaving it empty keeps
+// the server off.
+type GRPCConfig struct {
+	Port string
+}
+
+type RepositoryConfig struct {
+	Driver          string
+	Path            string
+	CompactInterval time.Duration
+}
+
+// TaskConfig.Transitions maps each status to the statuses a task may move to
+// from it. Leaving it empty allows every transition. AutoCompleteParent marks
+// a parent done once none of its subtasks is left open. TimeZone, an IANA
+// name such as Asia/Taipei, is where recurrence rules are evaluated; it
+// defaults to UTC.
+type TaskConfig struct {
+	Transitions        map[string][]string
+	AutoCompleteParent bool
+	TimeZone           string
+}
+
+// ReminderConfig.Offsets lists how long before its due time a task is
+// reminded of, e.g. [24h, 1h]. PollInterval bounds how long the scheduler
+// sleeps before rescanning the repository.
+type ReminderConfig struct {
+	Offsets      []time.Duration
+	PollInterval time.Duration
+}
+
+// WebhookConfig.MaxAttempts bounds how often a delivery is tried before it
+// becomes a dead letter. Retries wait Backoff, doubling each time up to
+// MaxBackoff. LogSize caps the delivery log kept in memory. AllowPrivate
+// lets webhooks post to loopback, private and link-local addresses.
+type WebhookConfig struct {
+	MaxAttempts  int
+	Backoff      time.Duration
+	MaxBackoff   time.Duration
+	Timeout      time.Duration
+	LogSize      int
+	AllowPrivate bool
+}
+
+// EventConfig.BufferSize is how many of the latest task events are kept for
+// clients resuming an event stream.
+type EventConfig struct {
+	BufferSize int
+}
+
+// AuthConfig.Enabled has every route but the API documentation require an
+// API key, or a JWT when JWT is configured, with the scope of the route.
+type AuthConfig struct {
+	Enabled bool
+	JWT     JWTConfig
+}
+
+// JWTConfig accepts bearer tokens from an identity provider. Secret verifies
+// HS256 tokens and JWKS, a file or an http(s) URL, holds the keys of RS256
+// and ES256 ones; leaving both empty turns JWTs off. Issuer and Audience,
+// when set, must match the iss and aud claims, and Leeway allows for clock
+// skew on the exp and nbf claims. A URL is fetched again when a token names
+// a key it lacks, at most once per JWKSRefresh.
+//
+// The scope or scp claim of a token grants only what Scopes maps it to, or,
+// with ScopePrefix set, the API scope named after the prefix, e.g.
+// "task/tasks:read" for "task/". Other scopes are ignored; a token granted
+// none gets DefaultScopes.
+type JWTConfig struct {
+	Secret        string
+	JWKS          string
+	JWKSRefresh   time.Duration
+	Issuer        string
+	Audience      string
+	Leeway        time.Duration
+	Scopes        []JWTScopeConfig
+	ScopePrefix   string
+	DefaultScopes []string
+}
+
+// JWTScopeConfig grants Grant to tokens with the scope Claim. It is a list
+// rather than a map because viper lower-cases map keys and splits them on
+// dots.
+type JWTScopeConfig struct {
+	Claim string
+	Grant []string
+}
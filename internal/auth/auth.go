@@ -0,0 +1,61 @@
+// Package auth carries who a request is made by, and what they may do, from
+// the delivery layers that authenticate it to the code that acts on it.
+package auth
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/wagaru/task/internal/errcode"
+)
+
+// Scopes an API key can be granted. Writing does not imply reading.
+const (
+	ScopeTasksRead     = "tasks:read"
+	ScopeTasksWrite    = "tasks:write"
+	ScopeWebhooksRead  = "webhooks:read"
+	ScopeWebhooksWrite = "webhooks:write"
+	// ScopeAdmin manages the API keys themselves.
+	ScopeAdmin = "admin"
+)
+
+var Scopes = []string{ScopeTasksRead, ScopeTasksWrite, ScopeWebhooksRead, ScopeWebhooksWrite, ScopeAdmin}
+
+// Principal is the authenticated caller. Subject identifies it in the
+// records it leaves: "apikey:<id>" for an API key and "jwt:<sub>" for a
+// token of the identity provider, so that neither can pass for the other.
+type Principal struct {
+	Subject string
+	Scopes  []string
+}
+
+func (p *Principal) Allows(scope string) bool {
+	for _, s := range p.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+type contextKey struct{}
+
+func NewContext(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, contextKey{}, p)
+}
+
+func FromContext(ctx context.Context) (*Principal, bool) {
+	p, ok := ctx.Value(contextKey{}).(*Principal)
+	return p, ok
+}
+
+// Check fails with errcode.Forbidden unless the principal of ctx has scope.
+// A context without a principal passes: it comes from a server running
+// without authentication.
+func Check(ctx context.Context, scope string) error {
+	p, ok := FromContext(ctx)
+	if !ok || p.Allows(scope) {
+		return nil
+	}
+	return errcode.Forbidden.WithDetails(fmt.Sprintf("需要 %s 權限", scope))
+}
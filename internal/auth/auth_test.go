@@ -0,0 +1,25 @@
+package auth
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wagaru/task/internal/errcode"
+)
+
+func TestCheck(t *testing.T) {
+	t.Run("NoPrincipal", func(t *testing.T) {
+		assert.NoError(t, Check(context.Background(), ScopeAdmin))
+	})
+	t.Run("Scopes", func(t *testing.T) {
+		ctx := NewContext(context.Background(), &Principal{Subject: "apikey:1", Scopes: []string{ScopeTasksRead}})
+		p, ok := FromContext(ctx)
+		assert.True(t, ok)
+		assert.Equal(t, "apikey:1", p.Subject)
+		assert.NoError(t, Check(ctx, ScopeTasksRead))
+		err := Check(ctx, ScopeTasksWrite)
+		assert.ErrorIs(t, err, errcode.Forbidden)
+		assert.Equal(t, []string{"需要 tasks:write 權限"}, err.(*errcode.Error).Details())
+	})
+}
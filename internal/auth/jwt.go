@@ -0,0 +1,315 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/go-jose/go-jose/v4/jwt"
+	"github.com/wagaru/task/config"
+	"github.com/wagaru/task/internal/errcode"
+)
+
+const (
+	// minSecretSize is the shortest HS256 secret accepted, as RFC 7518
+	// requires a key at least as long as the hash.
+	minSecretSize      = 32
+	defaultJWKSRefresh = time.Minute
+	maxJWKSSize        = 1 << 20
+	// subjectPrefix keeps the sub claim apart from the subjects of API keys.
+	subjectPrefix = "jwt:"
+)
+
+// JWTVerifier authenticates the bearer tokens of an identity provider. The
+// subject of a token is its sub claim behind "jwt:".
+type JWTVerifier struct {
+	secret        []byte
+	keys          *keySet
+	algorithms    []jose.SignatureAlgorithm
+	issuer        string
+	audience      string
+	leeway        time.Duration
+	scopes        map[string][]string
+	scopePrefix   string
+	defaultScopes []string
+	now           func() time.Time
+}
+
+// NewJWTVerifier returns nil when conf has neither a secret nor a JWKS. A
+// JWKS file is read right away; a URL is only fetched for the first token,
+// so that the server starts while the identity provider is unreachable.
+func NewJWTVerifier(conf *config.JWTConfig) (*JWTVerifier, error) {
+	if conf == nil || conf.Secret == "" && conf.JWKS == "" {
+		return nil, nil
+	}
+	for _, scope := range conf.DefaultScopes {
+		if !known(scope) {
+			return nil, fmt.Errorf("Auth.JWT.DefaultScopes: unknown scope %q", scope)
+		}
+	}
+	v := &JWTVerifier{
+		issuer:        conf.Issuer,
+		audience:      conf.Audience,
+		leeway:        conf.Leeway,
+		scopes:        map[string][]string{},
+		scopePrefix:   conf.ScopePrefix,
+		defaultScopes: conf.DefaultScopes,
+		now:           time.Now,
+	}
+	for i, mapping := range conf.Scopes {
+		if mapping.Claim == "" {
+			return nil, fmt.Errorf("Auth.JWT.Scopes[%d].Claim: required", i)
+		}
+		for _, scope := range mapping.Grant {
+			if !known(scope) {
+				return nil, fmt.Errorf("Auth.JWT.Scopes[%d].Grant: unknown scope %q", i, scope)
+			}
+		}
+		v.scopes[mapping.Claim] = append(v.scopes[mapping.Claim], mapping.Grant...)
+	}
+	if conf.Secret != "" {
+		if len(conf.Secret) < minSecretSize {
+			return nil, fmt.Errorf("Auth.JWT.Secret: must be at least %d bytes", minSecretSize)
+		}
+		v.secret = []byte(conf.Secret)
+		v.algorithms = append(v.algorithms, jose.HS256)
+	}
+	if conf.JWKS != "" {
+		if conf.Issuer == "" {
+			return nil, errors.New("Auth.JWT.Issuer: required with Auth.JWT.JWKS")
+		}
+		keys, err := newKeySet(conf.JWKS, conf.JWKSRefresh)
+		if err != nil {
+			return nil, fmt.Errorf("Auth.JWT.JWKS: %w", err)
+		}
+		v.keys = keys
+		v.algorithms = append(v.algorithms, jose.RS256, jose.ES256)
+	}
+	return v, nil
+}
+
+// Verify checks the signature of token and its exp, nbf, iss and aud claims.
+// Tokens that fail are errcode.Unauthorized, with the failing part in the
+// details. Scopes of the token are granted only as conf maps them.
+func (v *JWTVerifier) Verify(ctx context.Context, token string) (*Principal, error) {
+	tok, err := jwt.ParseSigned(token, v.algorithms)
+	if err != nil {
+		return nil, errcode.Unauthorized.WithDetails("token: 不是使用支援演算法簽署的 JWT")
+	}
+	var key interface{} = v.secret
+	if header := tok.Headers[0]; header.Algorithm != string(jose.HS256) {
+		if key, err = v.keys.key(ctx, header.KeyID); err != nil {
+			return nil, err
+		}
+	}
+	var claims jwt.Claims
+	var extra struct {
+		Scope scopeClaim `json:"scope"`
+		Scp   scopeClaim `json:"scp"`
+	}
+	if err := tok.Claims(key, &claims, &extra); err != nil {
+		return nil, errcode.Unauthorized.WithDetails("token: 簽章無效")
+	}
+	if claims.Expiry == nil {
+		return nil, errcode.Unauthorized.WithDetails("exp: 必填")
+	}
+	if claims.Subject == "" {
+		return nil, errcode.Unauthorized.WithDetails("sub: 必填")
+	}
+	expected := jwt.Expected{Issuer: v.issuer, Time: v.now()}
+	if v.audience != "" {
+		expected.AnyAudience = jwt.Audience{v.audience}
+	}
+	if err := claims.ValidateWithLeeway(expected, v.leeway); err != nil {
+		return nil, errcode.Unauthorized.WithDetails(v.claimDetail(err))
+	}
+
+	scopes := v.grant(append([]string(extra.Scope), extra.Scp...))
+	if len(scopes) == 0 {
+		scopes = v.defaultScopes
+	}
+	return &Principal{Subject: subjectPrefix + claims.Subject, Scopes: scopes}, nil
+}
+
+// grant maps the scopes of the identity provider to those of the API,
+// dropping any neither Scopes nor ScopePrefix maps.
+func (v *JWTVerifier) grant(claimed []string) []string {
+	var granted []string
+	for _, scope := range claimed {
+		if mapped, ok := v.scopes[scope]; ok {
+			granted = append(granted, mapped...)
+		} else if v.scopePrefix != "" && strings.HasPrefix(scope, v.scopePrefix) && known(strings.TrimPrefix(scope, v.scopePrefix)) {
+			granted = append(granted, strings.TrimPrefix(scope, v.scopePrefix))
+		}
+	}
+	return granted
+}
+
+func (v *JWTVerifier) claimDetail(err error) string {
+	switch {
+	case errors.Is(err, jwt.ErrExpired):
+		return "exp: 權杖已過期"
+	case errors.Is(err, jwt.ErrNotValidYet):
+		return "nbf: 權杖尚未生效"
+	case errors.Is(err, jwt.ErrIssuedInTheFuture):
+		return "iat: 簽發時間晚於現在"
+	case errors.Is(err, jwt.ErrInvalidIssuer):
+		return "iss: 必須是 " + v.issuer
+	case errors.Is(err, jwt.ErrInvalidAudience):
+		return "aud: 必須包含 " + v.audience
+	}
+	return "token: " + err.Error()
+}
+
+// scopeClaim reads scopes given either as one space-separated string, as in
+// RFC 8693, or as an array of strings.
+type scopeClaim []string
+
+func (s *scopeClaim) UnmarshalJSON(b []byte) error {
+	var list []string
+	if err := json.Unmarshal(b, &list); err == nil {
+		*s = list
+		return nil
+	}
+	var str string
+	if err := json.Unmarshal(b, &str); err != nil {
+		return err
+	}
+	*s = strings.Fields(str)
+	return nil
+}
+
+func known(scope string) bool {
+	for _, s := range Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// keySet holds the keys of a JWKS. Keys from a URL are fetched again when a
+// token names one the set lacks, at most once per refresh, so that rotated
+// keys are picked up without letting bad tokens flood the provider. The
+// fetch runs without the lock, and tokens arriving meanwhile wait for it
+// rather than fetching again.
+type keySet struct {
+	url     string
+	refresh time.Duration
+	client  *http.Client
+
+	mu      sync.Mutex
+	keys    jose.JSONWebKeySet
+	fetched time.Time
+	// fetching is closed when the fetch in flight ends, leaving its error
+	// in fetchErr.
+	fetching chan struct{}
+	fetchErr error
+}
+
+func newKeySet(source string, refresh time.Duration) (*keySet, error) {
+	if strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://") {
+		if refresh <= 0 {
+			refresh = defaultJWKSRefresh
+		}
+		return &keySet{url: source, refresh: refresh, client: &http.Client{Timeout: 10 * time.Second}}, nil
+	}
+	b, err := os.ReadFile(source)
+	if err != nil {
+		return nil, err
+	}
+	s := &keySet{}
+	if err := json.Unmarshal(b, &s.keys); err != nil {
+		return nil, fmt.Errorf("%s: %w", source, err)
+	}
+	return s, nil
+}
+
+func (s *keySet) key(ctx context.Context, kid string) (*jose.JSONWebKey, error) {
+	s.mu.Lock()
+	key := s.find(kid)
+	if key == nil && s.url != "" {
+		if s.fetching == nil && time.Since(s.fetched) >= s.refresh {
+			s.fetched = time.Now()
+			s.fetching = make(chan struct{})
+			go s.refetch(s.fetching)
+		}
+		if fetching := s.fetching; fetching != nil {
+			s.mu.Unlock()
+			select {
+			case <-fetching:
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+			s.mu.Lock()
+			if s.fetchErr != nil {
+				s.mu.Unlock()
+				return nil, errcode.AuthUnavailable.WithDetails("jwks: 無法取得金鑰")
+			}
+			key = s.find(kid)
+		}
+	}
+	s.mu.Unlock()
+	if key == nil {
+		return nil, errcode.Unauthorized.WithDetails(fmt.Sprintf("kid: 找不到金鑰 %q", kid))
+	}
+	return key, nil
+}
+
+// find looks kid up. A token without a kid can only use a set of one key.
+func (s *keySet) find(kid string) *jose.JSONWebKey {
+	if kid == "" {
+		if len(s.keys.Keys) == 1 {
+			return &s.keys.Keys[0]
+		}
+		return nil
+	}
+	if keys := s.keys.Key(kid); len(keys) > 0 {
+		return &keys[0]
+	}
+	return nil
+}
+
+// refetch swaps in the keys of the URL and closes done. It is not tied to
+// the request that started it, as other requests may be waiting for it.
+func (s *keySet) refetch(done chan struct{}) {
+	keys, err := s.fetch()
+	if err != nil {
+		log.Printf("auth: fetch JWKS %s: %v", s.url, err)
+	}
+	s.mu.Lock()
+	if err == nil {
+		s.keys = keys
+	}
+	s.fetchErr = err
+	s.fetching = nil
+	s.mu.Unlock()
+	close(done)
+}
+
+func (s *keySet) fetch() (jose.JSONWebKeySet, error) {
+	var keys jose.JSONWebKeySet
+	resp, err := s.client.Get(s.url)
+	if err != nil {
+		return keys, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return keys, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	b, err := io.ReadAll(io.LimitReader(resp.Body, maxJWKSSize))
+	if err != nil {
+		return keys, err
+	}
+	err = json.Unmarshal(b, &keys)
+	return keys, err
+}
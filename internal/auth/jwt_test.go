@@ -0,0 +1,310 @@
+package auth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/go-jose/go-jose/v4/jwt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wagaru/task/config"
+	"github.com/wagaru/task/internal/errcode"
+)
+
+const testSecret = "0123456789abcdef0123456789abcdef"
+
+// jwksServer serves the public halves of keys, counting the requests.
+type jwksServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	keys     []jose.JSONWebKey
+	requests int
+}
+
+func newJWKSServer(t *testing.T, keys ...jose.JSONWebKey) *jwksServer {
+	s := &jwksServer{keys: keys}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.requests++
+		json.NewEncoder(w).Encode(publicKeys(s.keys...))
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *jwksServer) setKeys(keys ...jose.JSONWebKey) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys = keys
+}
+
+func publicKeys(keys ...jose.JSONWebKey) jose.JSONWebKeySet {
+	var set jose.JSONWebKeySet
+	for _, key := range keys {
+		set.Keys = append(set.Keys, key.Public())
+	}
+	return set
+}
+
+func signToken(t *testing.T, key jose.JSONWebKey, claims interface{}) string {
+	opts := (&jose.SignerOptions{}).WithType("JWT")
+	if key.KeyID != "" {
+		opts = opts.WithHeader("kid", key.KeyID)
+	}
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.SignatureAlgorithm(key.Algorithm), Key: key.Key}, opts)
+	require.NoError(t, err)
+	token, err := jwt.Signed(signer).Claims(claims).Serialize()
+	require.NoError(t, err)
+	return token
+}
+
+func TestJWTVerifier(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	rs256 := jose.JSONWebKey{Key: rsaKey, KeyID: "rsa", Algorithm: string(jose.RS256), Use: "sig"}
+	es256 := jose.JSONWebKey{Key: ecKey, KeyID: "ec", Algorithm: string(jose.ES256), Use: "sig"}
+	hs256 := jose.JSONWebKey{Key: []byte(testSecret), Algorithm: string(jose.HS256)}
+	server := newJWKSServer(t, rs256, es256)
+
+	now := time.Date(2022, 5, 1, 8, 0, 0, 0, time.UTC)
+	claims := func() jwt.Claims {
+		return jwt.Claims{
+			Subject:  "alice",
+			Issuer:   "https://sso.example.com",
+			Audience: jwt.Audience{"task"},
+			IssuedAt: jwt.NewNumericDate(now.Add(-time.Minute)),
+			Expiry:   jwt.NewNumericDate(now.Add(time.Hour)),
+		}
+	}
+	newVerifier := func(t *testing.T, conf *config.JWTConfig) *JWTVerifier {
+		v, err := NewJWTVerifier(conf)
+		require.NoError(t, err)
+		v.now = func() time.Time { return now }
+		return v
+	}
+	conf := &config.JWTConfig{
+		Secret:      testSecret,
+		JWKS:        server.URL,
+		JWKSRefresh: time.Hour,
+		Issuer:      "https://sso.example.com",
+		Audience:    "task",
+		Leeway:      time.Minute,
+		Scopes: []config.JWTScopeConfig{
+			{Claim: "task.admin", Grant: []string{ScopeAdmin, ScopeWebhooksRead}},
+		},
+		ScopePrefix:   "task/",
+		DefaultScopes: []string{ScopeTasksRead},
+	}
+	verifier := newVerifier(t, conf)
+
+	t.Run("Algorithms", func(t *testing.T) {
+		for _, key := range []jose.JSONWebKey{hs256, rs256, es256} {
+			t.Run(key.Algorithm, func(t *testing.T) {
+				p, err := verifier.Verify(context.Background(), signToken(t, key, claims()))
+				if assert.NoError(t, err) {
+					assert.Equal(t, &Principal{Subject: "jwt:alice", Scopes: []string{ScopeTasksRead}}, p)
+				}
+			})
+		}
+		assert.Equal(t, 1, server.requests)
+	})
+	t.Run("Subject", func(t *testing.T) {
+		c := claims()
+		c.Subject = "apikey:1"
+		p, err := verifier.Verify(context.Background(), signToken(t, hs256, c))
+		if assert.NoError(t, err) {
+			assert.Equal(t, "jwt:apikey:1", p.Subject)
+		}
+	})
+	t.Run("Scopes", func(t *testing.T) {
+		tests := []struct {
+			name   string
+			extra  map[string]interface{}
+			scopes []string
+		}{
+			{"Scope", map[string]interface{}{"scope": "task/tasks:read task/tasks:write"}, []string{ScopeTasksRead, ScopeTasksWrite}},
+			{"Scp", map[string]interface{}{"scp": []string{"task.admin"}}, []string{ScopeAdmin, ScopeWebhooksRead}},
+			{"ScpString", map[string]interface{}{"scp": "task/webhooks:write openid"}, []string{ScopeWebhooksWrite}},
+			{"Unmapped", map[string]interface{}{"scope": "admin tasks:write task/tasks:all"}, []string{ScopeTasksRead}},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				opts := (&jose.SignerOptions{}).WithType("JWT").WithHeader("kid", "rsa")
+				signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.RS256, Key: rsaKey}, opts)
+				require.NoError(t, err)
+				token, err := jwt.Signed(signer).Claims(claims()).Claims(tt.extra).Serialize()
+				require.NoError(t, err)
+
+				p, err := verifier.Verify(context.Background(), token)
+				if assert.NoError(t, err) {
+					assert.Equal(t, tt.scopes, p.Scopes)
+				}
+			})
+		}
+	})
+	t.Run("Invalid", func(t *testing.T) {
+		expired := claims()
+		expired.Expiry = jwt.NewNumericDate(now.Add(-2 * time.Minute))
+		skewed := claims()
+		skewed.Expiry = jwt.NewNumericDate(now.Add(-30 * time.Second))
+		notYet := claims()
+		notYet.NotBefore = jwt.NewNumericDate(now.Add(2 * time.Minute))
+		issuer := claims()
+		issuer.Issuer = "https://evil.example.com"
+		audience := claims()
+		audience.Audience = jwt.Audience{"other"}
+		noExpiry := claims()
+		noExpiry.Expiry = nil
+		noSubject := claims()
+		noSubject.Subject = ""
+		forged := jose.JSONWebKey{Key: otherKey, KeyID: "rsa", Algorithm: string(jose.RS256)}
+		unknown := jose.JSONWebKey{Key: otherKey, KeyID: "gone", Algorithm: string(jose.RS256)}
+		hs384 := jose.JSONWebKey{Key: []byte(testSecret + testSecret), Algorithm: string(jose.HS384)}
+
+		tests := []struct {
+			name   string
+			token  string
+			detail string
+		}{
+			{"Malformed", "not.a.jwt", "token: 不是使用支援演算法簽署的 JWT"},
+			{"Algorithm", signToken(t, hs384, claims()), "token: 不是使用支援演算法簽署的 JWT"},
+			{"Signature", signToken(t, forged, claims()), "token: 簽章無效"},
+			{"Secret", signToken(t, jose.JSONWebKey{Key: []byte(testSecret + "!"), Algorithm: string(jose.HS256)}, claims()), "token: 簽章無效"},
+			{"UnknownKey", signToken(t, unknown, claims()), `kid: 找不到金鑰 "gone"`},
+			{"Expired", signToken(t, rs256, expired), "exp: 權杖已過期"},
+			{"NotYetValid", signToken(t, es256, notYet), "nbf: 權杖尚未生效"},
+			{"Issuer", signToken(t, rs256, issuer), "iss: 必須是 https://sso.example.com"},
+			{"Audience", signToken(t, hs256, audience), "aud: 必須包含 task"},
+			{"NoExpiry", signToken(t, rs256, noExpiry), "exp: 必填"},
+			{"NoSubject", signToken(t, rs256, noSubject), "sub: 必填"},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				_, err := verifier.Verify(context.Background(), tt.token)
+				assert.ErrorIs(t, err, errcode.Unauthorized)
+				if e, ok := err.(*errcode.Error); ok {
+					assert.Equal(t, []string{tt.detail}, e.Details())
+				}
+			})
+		}
+		_, err := verifier.Verify(context.Background(), signToken(t, rs256, skewed))
+		assert.NoError(t, err, "expiry within the leeway")
+	})
+	t.Run("Rotation", func(t *testing.T) {
+		rotated := jose.JSONWebKey{Key: otherKey, KeyID: "rsa-2", Algorithm: string(jose.RS256)}
+		rotating := newJWKSServer(t, rs256)
+		conf := *conf
+		conf.JWKS = rotating.URL
+		conf.JWKSRefresh = time.Hour
+		v := newVerifier(t, &conf)
+		_, err := v.Verify(context.Background(), signToken(t, rs256, claims()))
+		assert.NoError(t, err)
+
+		rotating.setKeys(rs256, rotated)
+		_, err = v.Verify(context.Background(), signToken(t, rotated, claims()))
+		assert.ErrorIs(t, err, errcode.Unauthorized, "refetched before JWKSRefresh")
+		assert.Equal(t, 1, rotating.requests)
+
+		v.keys.fetched = time.Now().Add(-time.Hour)
+		_, err = v.Verify(context.Background(), signToken(t, rotated, claims()))
+		assert.NoError(t, err)
+		assert.Equal(t, 2, rotating.requests)
+	})
+	t.Run("Unreachable", func(t *testing.T) {
+		down := httptest.NewServer(http.NotFoundHandler())
+		down.Close()
+		conf := *conf
+		conf.JWKS = down.URL
+		v := newVerifier(t, &conf)
+		_, err := v.Verify(context.Background(), signToken(t, rs256, claims()))
+		assert.ErrorIs(t, err, errcode.AuthUnavailable)
+	})
+	t.Run("SlowFetch", func(t *testing.T) {
+		release := make(chan struct{})
+		var requests int32
+		slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if atomic.AddInt32(&requests, 1) > 1 {
+				<-release
+			}
+			json.NewEncoder(w).Encode(publicKeys(rs256, es256))
+		}))
+		defer slow.Close()
+		conf := *conf
+		conf.JWKS = slow.URL
+		v := newVerifier(t, &conf)
+		_, err := v.Verify(context.Background(), signToken(t, rs256, claims()))
+		require.NoError(t, err)
+
+		v.keys.fetched = time.Now().Add(-time.Hour)
+		unknown := jose.JSONWebKey{Key: otherKey, KeyID: "rsa-2", Algorithm: string(jose.RS256)}
+		var wg sync.WaitGroup
+		for i := 0; i < 3; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, err := v.Verify(context.Background(), signToken(t, unknown, claims()))
+				assert.ErrorIs(t, err, errcode.Unauthorized)
+			}()
+		}
+		require.Eventually(t, func() bool { return atomic.LoadInt32(&requests) == 2 }, time.Second, time.Millisecond)
+		_, err = v.Verify(context.Background(), signToken(t, es256, claims()))
+		assert.NoError(t, err, "known key while fetching")
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		_, err = v.Verify(ctx, signToken(t, unknown, claims()))
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+		close(release)
+		wg.Wait()
+		assert.Equal(t, int32(2), atomic.LoadInt32(&requests))
+	})
+	t.Run("File", func(t *testing.T) {
+		b, err := json.Marshal(publicKeys(es256))
+		require.NoError(t, err)
+		path := filepath.Join(t.TempDir(), "jwks.json")
+		require.NoError(t, os.WriteFile(path, b, 0600))
+		v := newVerifier(t, &config.JWTConfig{JWKS: path, Issuer: "https://sso.example.com"})
+		p, err := v.Verify(context.Background(), signToken(t, jose.JSONWebKey{Key: ecKey, Algorithm: string(jose.ES256)}, claims()))
+		if assert.NoError(t, err) {
+			assert.Equal(t, "jwt:alice", p.Subject)
+			assert.Empty(t, p.Scopes)
+		}
+		_, err = v.Verify(context.Background(), signToken(t, hs256, claims()))
+		assert.ErrorIs(t, err, errcode.Unauthorized, "HS256 without a secret")
+	})
+	t.Run("Config", func(t *testing.T) {
+		v, err := NewJWTVerifier(&config.JWTConfig{Issuer: "https://sso.example.com"})
+		assert.NoError(t, err)
+		assert.Nil(t, v)
+		_, err = NewJWTVerifier(&config.JWTConfig{Secret: "short"})
+		assert.EqualError(t, err, "Auth.JWT.Secret: must be at least 32 bytes")
+		_, err = NewJWTVerifier(&config.JWTConfig{Secret: testSecret, DefaultScopes: []string{"tasks:all"}})
+		assert.EqualError(t, err, `Auth.JWT.DefaultScopes: unknown scope "tasks:all"`)
+		_, err = NewJWTVerifier(&config.JWTConfig{Secret: testSecret, Scopes: []config.JWTScopeConfig{{Claim: "task.admin", Grant: []string{"root"}}}})
+		assert.EqualError(t, err, `Auth.JWT.Scopes[0].Grant: unknown scope "root"`)
+		_, err = NewJWTVerifier(&config.JWTConfig{Secret: testSecret, Scopes: []config.JWTScopeConfig{{Grant: []string{ScopeAdmin}}}})
+		assert.EqualError(t, err, "Auth.JWT.Scopes[0].Claim: required")
+		_, err = NewJWTVerifier(&config.JWTConfig{JWKS: "https://sso.example.com/jwks.json"})
+		assert.EqualError(t, err, "Auth.JWT.Issuer: required with Auth.JWT.JWKS")
+		_, err = NewJWTVerifier(&config.JWTConfig{JWKS: filepath.Join(t.TempDir(), "missing.json"), Issuer: "https://sso.example.com"})
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,85 @@
+package delivery
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/wagaru/task/internal/auth"
+	"github.com/wagaru/task/internal/errcode"
+	"github.com/wagaru/task/internal/service"
+)
+
+const apiKeyHeader = "X-API-Key"
+
+// operationScopes maps each route of operations that requires a scope to
+// it, keyed like the routes of the validator.
+func operationScopes() map[string]string {
+	scopes := make(map[string]string)
+	for _, op := range operations {
+		if op.scope != "" {
+			scopes[op.method+" "+op.path] = op.scope
+		}
+	}
+	return scopes
+}
+
+// Authenticate lets a request through when it carries an API key, or a JWT
+// of the identity provider, with the scope of its route, either as a bearer
+// token or in the X-API-Key header, and puts the caller on the request
+// context. Routes without a scope, such
+// as the API documentation, stay open.
+func (d *delivery) Authenticate(c *gin.Context) {
+	scope, ok := d.scopes[c.Request.Method+" "+specPath(c.FullPath())]
+	if !ok {
+		c.Next()
+		return
+	}
+	token := requestToken(c)
+	if token == "" {
+		d.unauthorized(c, errcode.Unauthorized.WithDetails("Authorization: 需要 Bearer 權杖或 "+apiKeyHeader+" 標頭"))
+		return
+	}
+	principal, err := d.authenticate(c.Request.Context(), token)
+	if err != nil {
+		d.unauthorized(c, err)
+		return
+	}
+	ctx := auth.NewContext(c.Request.Context(), principal)
+	if err := auth.Check(ctx, scope); err != nil {
+		d.ToErrorResponse(c, err)
+		c.Abort()
+		return
+	}
+	c.Request = c.Request.WithContext(ctx)
+	c.Next()
+}
+
+// authenticate tells API keys from JWTs by their prefix. Without a JWT
+// verifier every token is taken for an API key.
+func (d *delivery) authenticate(ctx context.Context, token string) (*auth.Principal, error) {
+	if d.jwt == nil || strings.HasPrefix(token, service.APIKeyPrefix) {
+		return d.svc.Authenticate(token)
+	}
+	return d.jwt.Verify(ctx, token)
+}
+
+func (d *delivery) unauthorized(c *gin.Context, err error) {
+	if errors.Is(err, errcode.Unauthorized) {
+		c.Header("WWW-Authenticate", `Bearer realm="task"`)
+	}
+	d.ToErrorResponse(c, err)
+	c.Abort()
+}
+
+func requestToken(c *gin.Context) string {
+	if token := c.GetHeader(apiKeyHeader); token != "" {
+		return token
+	}
+	scheme, token, ok := strings.Cut(c.GetHeader("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return ""
+	}
+	return strings.TrimSpace(token)
+}
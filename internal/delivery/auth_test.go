@@ -0,0 +1,173 @@
+package delivery
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/go-jose/go-jose/v4/jwt"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"github.com/wagaru/task/config"
+	"github.com/wagaru/task/internal/auth"
+	"github.com/wagaru/task/internal/errcode"
+	"github.com/wagaru/task/internal/model"
+	"github.com/wagaru/task/internal/service/mocks"
+)
+
+func TestAuthenticate(t *testing.T) {
+	task := &model.Task{ID: 1, Name: "task1", Status: model.StatusTodo}
+	reader := &auth.Principal{Subject: "apikey:1", Scopes: []string{auth.ScopeTasksRead}}
+	newService := func() *mocks.Service {
+		mockService := new(mocks.Service)
+		mockService.On("Authenticate", "tk_reader").Return(reader, nil)
+		mockService.On("Authenticate", "tk_revoked").Return(nil, errcode.Unauthorized)
+		return mockService
+	}
+	newDelivery := func(svc *mocks.Service) *delivery {
+		return NewDelivery(svc, &config.ServerConfig{}, WithAuth(&config.AuthConfig{Enabled: true}))
+	}
+
+	t.Run("Errors", func(t *testing.T) {
+		tests := []struct {
+			name   string
+			method string
+			header string
+			value  string
+			err    *errcode.Error
+		}{
+			{"Missing", "GET", "", "", errcode.Unauthorized.WithDetails("Authorization: 需要 Bearer 權杖或 X-API-Key 標頭")},
+			{"Basic", "GET", "Authorization", "Basic dXNlcjpwYXNz", errcode.Unauthorized.WithDetails("Authorization: 需要 Bearer 權杖或 X-API-Key 標頭")},
+			{"Revoked", "GET", "Authorization", "Bearer tk_revoked", errcode.Unauthorized},
+			{"Scope", "DELETE", "X-API-Key", "tk_reader", errcode.Forbidden.WithDetails("需要 tasks:write 權限")},
+		}
+		mockService := newService()
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				w := httptest.NewRecorder()
+				req, _ := http.NewRequest(tt.method, "/tasks/1", nil)
+				if tt.header != "" {
+					req.Header.Set(tt.header, tt.value)
+				}
+				newDelivery(mockService).engine.ServeHTTP(w, req)
+
+				assert.Equal(t, tt.err.StatusCode(), w.Code)
+				if tt.err.Is(errcode.Unauthorized) {
+					assert.Equal(t, `Bearer realm="task"`, w.Header().Get("WWW-Authenticate"))
+				}
+				_, body := errorBody(tt.err)
+				expected, _ := json.Marshal(body)
+				assert.JSONEq(t, string(expected), w.Body.String())
+			})
+		}
+		mockService.AssertNotCalled(t, "GetTask", mock.Anything)
+		mockService.AssertNotCalled(t, "DeleteTask", mock.Anything, mock.Anything)
+	})
+	t.Run("Allowed", func(t *testing.T) {
+		mockService := newService()
+		mockService.On("GetTask", uint32(1)).Return(task, nil).Twice()
+		for _, header := range []string{"Authorization", "X-API-Key"} {
+			value := "tk_reader"
+			if header == "Authorization" {
+				value = "bearer " + value
+			}
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", "/tasks/1", nil)
+			req.Header.Set(header, value)
+			newDelivery(mockService).engine.ServeHTTP(w, req)
+			assert.Equal(t, http.StatusOK, w.Code, header)
+		}
+		mockService.AssertNumberOfCalls(t, "GetTask", 2)
+	})
+	t.Run("Open", func(t *testing.T) {
+		mockService := newService()
+		delivery := newDelivery(mockService)
+		for path, status := range map[string]int{"/openapi.json": http.StatusOK, "/docs/": http.StatusOK, "/missing": http.StatusNotFound} {
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", path, nil)
+			delivery.engine.ServeHTTP(w, req)
+			assert.Equal(t, status, w.Code, path)
+		}
+		mockService.AssertNotCalled(t, "Authenticate", mock.Anything)
+	})
+	t.Run("Disabled", func(t *testing.T) {
+		mockService := new(mocks.Service)
+		mockService.On("GetTask", uint32(1)).Return(task, nil).Once()
+		delivery := NewDelivery(mockService, &config.ServerConfig{}, WithAuth(&config.AuthConfig{}))
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/tasks/1", nil)
+		delivery.engine.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+		mockService.AssertExpectations(t)
+	})
+	t.Run("JWT", func(t *testing.T) {
+		secret := "0123456789abcdef0123456789abcdef"
+		verifier, err := auth.NewJWTVerifier(&config.JWTConfig{Secret: secret, Audience: "task", ScopePrefix: "task/"})
+		require.NoError(t, err)
+		signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.HS256, Key: []byte(secret)}, nil)
+		require.NoError(t, err)
+		sign := func(audience string) string {
+			token, err := jwt.Signed(signer).Claims(jwt.Claims{
+				Subject:  "alice",
+				Audience: jwt.Audience{audience},
+				Expiry:   jwt.NewNumericDate(time.Now().Add(time.Hour)),
+			}).Claims(map[string]interface{}{"scope": "task/tasks:read task/tasks:write"}).Serialize()
+			require.NoError(t, err)
+			return token
+		}
+
+		mockService := newService()
+		byAlice := mock.MatchedBy(func(ctx context.Context) bool {
+			p, ok := auth.FromContext(ctx)
+			return ok && p.Subject == "jwt:alice"
+		})
+		mockService.On("DeleteTask", byAlice, uint32(1)).Return(nil).Once()
+		mockService.On("GetTask", uint32(1)).Return(task, nil).Once()
+		delivery := NewDelivery(mockService, &config.ServerConfig{}, WithAuth(&config.AuthConfig{Enabled: true}), WithJWT(verifier))
+		for _, tt := range []struct {
+			method string
+			token  string
+			status int
+		}{
+			{"DELETE", sign("task"), http.StatusOK},
+			{"DELETE", sign("other"), http.StatusUnauthorized},
+			{"GET", "tk_reader", http.StatusOK},
+		} {
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest(tt.method, "/tasks/1", nil)
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+			delivery.engine.ServeHTTP(w, req)
+			assert.Equal(t, tt.status, w.Code, w.Body.String())
+		}
+		mockService.AssertNumberOfCalls(t, "DeleteTask", 1)
+		mockService.AssertNumberOfCalls(t, "GetTask", 1)
+		mockService.AssertNumberOfCalls(t, "Authenticate", 1)
+	})
+	t.Run("Socket", func(t *testing.T) {
+		// A read-only key may connect and subscribe but not send commands.
+		mockService := newService()
+		server := httptest.NewServer(newDelivery(mockService).engine)
+		defer server.Close()
+		url := "ws" + strings.TrimPrefix(server.URL, "http") + "/tasks/ws"
+		_, resp, err := websocket.DefaultDialer.Dial(url, nil)
+		assert.Error(t, err)
+		if assert.NotNil(t, resp) {
+			assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+		}
+
+		conn, _, err := websocket.DefaultDialer.Dial(url, http.Header{"X-Api-Key": {"tk_reader"}})
+		require.NoError(t, err)
+		defer conn.Close()
+		msg := roundTrip(t, conn, `{"id":"1","type":"delete","task_id":1}`)
+		_, body := errorBody(errcode.Forbidden.WithDetails("需要 tasks:write 權限"))
+		assert.Equal(t, map[string]interface{}{"id": "1", "type": "error", "error": toMap(body)}, msg)
+		mockService.AssertNotCalled(t, "DeleteTask", mock.Anything, mock.Anything)
+	})
+}
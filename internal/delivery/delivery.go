@@ -3,13 +3,22 @@ package delivery
 import (
 	"context"
 	"fmt"
+	"io"
 	"log"
+	"math"
 	"net/http"
 	"strconv"
+	"time"
 
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/gin-contrib/sse"
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
 	"github.com/wagaru/task/config"
+	"github.com/wagaru/task/internal/auth"
 	"github.com/wagaru/task/internal/errcode"
+	"github.com/wagaru/task/internal/gql"
+	"github.com/wagaru/task/internal/model"
 	"github.com/wagaru/task/internal/service"
 )
 
@@ -18,28 +27,99 @@ type delivery struct {
 	engine *gin.Engine
 	config *config.ServerConfig
 	server *http.Server
+	// done is closed when the server starts shutting down, to end the
+	// event streams it would otherwise wait for.
+	done      chan struct{}
+	graphql   *gql.Handler
+	spec      *openapi3.T
+	validator *validator
+	// scopes maps each route to the scope it requires, when authRequired.
+	scopes       map[string]string
+	authRequired bool
+	jwt          *auth.JWTVerifier
 }
 
-func NewDelivery(svc service.Service, config *config.ServerConfig) *delivery {
+type Option func(*delivery)
+
+// WithAuth has the routes require an API key when conf enables it.
+func WithAuth(conf *config.AuthConfig) Option {
+	return func(d *delivery) { d.authRequired = conf != nil && conf.Enabled }
+}
+
+// WithJWT has the routes accept the bearer tokens verifier accepts besides
+// API keys. A nil verifier leaves JWTs off.
+func WithJWT(verifier *auth.JWTVerifier) Option {
+	return func(d *delivery) { d.jwt = verifier }
+}
+
+// eventsKeepAlive is how often an idle event stream gets a comment, so
+// proxies do not time it out.
+const eventsKeepAlive = 15 * time.Second
+
+func NewDelivery(svc service.Service, config *config.ServerConfig, opts ...Option) *delivery {
 	gin.SetMode(config.RunMode)
 	delivery := &delivery{
 		svc:    svc,
 		engine: gin.New(),
 		config: config,
+		done:   make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(delivery)
 	}
 	delivery.server = &http.Server{
 		Addr:    fmt.Sprintf(":%s", delivery.config.Port),
 		Handler: delivery.engine,
 	}
+	delivery.server.RegisterOnShutdown(func() { close(delivery.done) })
+	delivery.graphql = gql.NewHandler(svc, delivery.done)
+	delivery.spec = newSpec()
+	delivery.validator = newValidator(delivery.spec, gin.Mode() == gin.DebugMode)
+	delivery.scopes = operationScopes()
 	delivery.buildRoute()
 	return delivery
 }
 
 func (d *delivery) buildRoute() {
+	if d.authRequired {
+		d.engine.Use(d.Authenticate)
+	}
+	d.engine.Use(d.Validate)
 	d.engine.GET("/tasks", d.GetTasks)
+	d.engine.GET("/tasks/events", d.GetTaskEvents)
+	d.engine.GET("/tasks/ws", d.TaskSocket)
+	d.engine.GET("/tasks/:id", d.GetTask)
 	d.engine.POST("/tasks", d.CreateTask)
 	d.engine.PUT("/tasks/:id", d.UpdateTask)
+	d.engine.PATCH("/tasks/:id", d.PatchTask)
 	d.engine.DELETE("/tasks/:id", d.DeleteTask)
+	d.engine.GET("/tasks/:id/children", d.GetTaskChildren)
+	d.engine.POST("/tasks/:id/tags", d.AddTaskTags)
+	d.engine.DELETE("/tasks/:id/tags/:tag", d.RemoveTaskTag)
+	d.engine.GET("/tasks/:id/dependencies", d.GetTaskDependencies)
+	d.engine.POST("/tasks/:id/dependencies", d.AddTaskBlockers)
+	d.engine.DELETE("/tasks/:id/dependencies/:blocker", d.RemoveTaskBlocker)
+	d.engine.GET("/tags", d.GetTags)
+	d.engine.GET("/projects", d.GetProjects)
+	d.engine.GET("/projects/:pid", d.GetProject)
+	d.engine.POST("/projects", d.CreateProject)
+	d.engine.PUT("/projects/:pid", d.UpdateProject)
+	d.engine.DELETE("/projects/:pid", d.DeleteProject)
+	d.engine.GET("/projects/:pid/tasks", d.GetProjectTasks)
+	d.engine.POST("/projects/:pid/tasks", d.CreateProjectTask)
+	d.engine.GET("/webhooks", d.GetWebhooks)
+	d.engine.GET("/webhooks/dead-letters", d.GetDeadLetters)
+	d.engine.GET("/webhooks/:wid", d.GetWebhook)
+	d.engine.POST("/webhooks", d.CreateWebhook)
+	d.engine.PUT("/webhooks/:wid", d.UpdateWebhook)
+	d.engine.DELETE("/webhooks/:wid", d.DeleteWebhook)
+	d.engine.GET("/webhooks/:wid/deliveries", d.GetWebhookDeliveries)
+	d.engine.GET("/admin/keys", d.GetAPIKeys)
+	d.engine.POST("/admin/keys", d.CreateAPIKey)
+	d.engine.DELETE("/admin/keys/:kid", d.DeleteAPIKey)
+	d.engine.POST("/graphql", d.GraphQL)
+	d.engine.GET("/openapi.json", d.OpenAPI)
+	d.engine.GET("/docs/*file", d.Docs)
 	d.engine.NoRoute(d.NoRoute)
 }
 
@@ -52,8 +132,55 @@ func (d *delivery) Shutdown(ctx context.Context) error {
 	return d.server.Shutdown(ctx)
 }
 
+func (d *delivery) GraphQL(c *gin.Context) {
+	d.graphql.ServeHTTP(c.Writer, c.Request)
+}
+
 func (d *delivery) GetTasks(c *gin.Context) {
-	tasks, err := d.svc.GetTasks()
+	d.getTasks(c, nil)
+}
+
+// getTasks lists tasks matching the query string. scope, when set, narrows
+// the query for the nested routes. With tree=true the tasks are returned
+// nested under their parents instead of as one page, and with
+// order=topological each task comes after its blockers.
+func (d *delivery) getTasks(c *gin.Context, scope func(*model.TaskQuery)) {
+	var params GetTasksRequest
+	if err := c.ShouldBindQuery(&params); err != nil {
+		d.ToErrorResponse(c, errcode.InvalidParams)
+		return
+	}
+	query, err := params.Query()
+	if err != nil {
+		d.ToErrorResponse(c, err)
+		return
+	}
+	if scope != nil {
+		scope(query)
+	}
+	if params.Tree {
+		tree, err := d.svc.GetTaskTree(query)
+		if err != nil {
+			d.ToErrorResponse(c, err)
+			return
+		}
+		d.ToResponse(c, http.StatusOK, map[string]interface{}{
+			"result": tree,
+		})
+		return
+	}
+	if params.Order == orderTopological {
+		tasks, err := d.svc.GetTaskOrder(query)
+		if err != nil {
+			d.ToErrorResponse(c, err)
+			return
+		}
+		d.ToResponse(c, http.StatusOK, map[string]interface{}{
+			"result": tasks,
+		})
+		return
+	}
+	tasks, next, err := d.svc.GetTasks(query)
 	if err != nil {
 		d.ToErrorResponse(c, err)
 		return
@@ -61,17 +188,99 @@ func (d *delivery) GetTasks(c *gin.Context) {
 	data := map[string]interface{}{
 		"result": tasks,
 	}
+	if next != "" {
+		data["next_cursor"] = next
+	}
+	d.ToResponse(c, http.StatusOK, data)
+}
+
+// GetTaskEvents streams task events as Server-Sent Events until the client
+// goes away. A client that reconnects with Last-Event-ID gets the events it
+// missed, as far as the event bus still holds them.
+func (d *delivery) GetTaskEvents(c *gin.Context) {
+	var params TaskEventsRequest
+	if err := c.ShouldBindQuery(&params); err != nil {
+		d.ToErrorResponse(c, errcode.InvalidParams.WithDetails("project_id、last_event_id: 必須是非負整數"))
+		return
+	}
+	match, err := params.Match()
+	if err != nil {
+		d.ToErrorResponse(c, err)
+		return
+	}
+	lastID := params.LastEventID
+	if header := c.GetHeader("Last-Event-ID"); header != "" {
+		if lastID, err = strconv.ParseUint(header, 10, 64); err != nil {
+			d.ToErrorResponse(c, errcode.InvalidParams.WithDetails("Last-Event-ID: 必須是非負整數"))
+			return
+		}
+	}
+
+	sub := d.svc.SubscribeEvents(lastID)
+	defer sub.Close()
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	c.Writer.WriteHeaderNow()
+	c.Writer.Flush()
+
+	keepAlive := time.NewTicker(eventsKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case <-d.done:
+			return
+		case <-keepAlive.C:
+			io.WriteString(c.Writer, ": keep-alive\n\n")
+		case e, ok := <-sub.C:
+			if !ok {
+				return
+			}
+			if !match(e) {
+				continue
+			}
+			c.Render(-1, sse.Event{Id: strconv.FormatUint(e.ID, 10), Event: e.Type, Data: e})
+		}
+		c.Writer.Flush()
+	}
+}
+
+func (d *delivery) GetTask(c *gin.Context) {
+	id, ok := d.bindTaskID(c)
+	if !ok {
+		return
+	}
+	task, err := d.svc.GetTask(id)
+	if err != nil {
+		d.ToErrorResponse(c, err)
+		return
+	}
+	data := map[string]interface{}{
+		"result": task,
+	}
 	d.ToResponse(c, http.StatusOK, data)
 }
 
 func (d *delivery) CreateTask(c *gin.Context) {
+	d.createTask(c, nil)
+}
+
+// createTask creates a task from the request body, in projectID when it is
+// set.
+func (d *delivery) createTask(c *gin.Context, projectID *uint32) {
 	var params CreateTaskRequest
 	var err error
 	if err = c.ShouldBindJSON(&params); err != nil {
 		d.ToErrorResponse(c, errcode.InvalidParams)
 		return
 	}
-	task, err := d.svc.CreateTask(params.Name)
+	if projectID != nil {
+		params.ProjectID = *projectID
+	}
+	task, err := d.svc.CreateTask(c.Request.Context(), params.Task())
 	if err != nil {
 		d.ToErrorResponse(c, err)
 		return
@@ -83,14 +292,46 @@ func (d *delivery) CreateTask(c *gin.Context) {
 }
 
 func (d *delivery) UpdateTask(c *gin.Context) {
+	id, ok := d.bindTaskID(c)
+	if !ok {
+		return
+	}
 	var params UpdateTaskRequest
 	if err := c.ShouldBindJSON(&params); err != nil {
 		d.ToErrorResponse(c, errcode.InvalidParams)
 		return
 	}
-	id := c.Param("id")
-	uid, _ := strconv.Atoi(id)
-	task, err := d.svc.UpdateTask(uint32(uid), params.Name, *params.Status)
+	task, err := d.svc.UpdateTask(c.Request.Context(), id, params.Task())
+	if err != nil {
+		d.ToErrorResponse(c, err)
+		return
+	}
+	data := map[string]interface{}{
+		"result": task,
+	}
+	d.ToResponse(c, http.StatusOK, data)
+}
+
+func (d *delivery) PatchTask(c *gin.Context) {
+	id, ok := d.bindTaskID(c)
+	if !ok {
+		return
+	}
+	var patch *model.TaskPatch
+	var err error
+	switch c.ContentType() {
+	case jsonPatchContentType:
+		patch, err = parseJSONPatch(c.Request.Body)
+	case mergePatchContentType, binding.MIMEJSON:
+		patch, err = parseMergePatch(c.Request.Body)
+	default:
+		err = errcode.InvalidParams.WithDetails(fmt.Sprintf("Content-Type: 只支援 %s 或 %s", mergePatchContentType, jsonPatchContentType))
+	}
+	if err != nil {
+		d.ToErrorResponse(c, err)
+		return
+	}
+	task, err := d.svc.PatchTask(c.Request.Context(), id, patch)
 	if err != nil {
 		d.ToErrorResponse(c, err)
 		return
@@ -102,9 +343,11 @@ func (d *delivery) UpdateTask(c *gin.Context) {
 }
 
 func (d *delivery) DeleteTask(c *gin.Context) {
-	id := c.Param("id")
-	uid, _ := strconv.Atoi(id)
-	err := d.svc.DeleteTask(uint32(uid))
+	id, ok := d.bindTaskID(c)
+	if !ok {
+		return
+	}
+	err := d.svc.DeleteTask(c.Request.Context(), id)
 	if err != nil {
 		d.ToErrorResponse(c, err)
 		return
@@ -112,6 +355,433 @@ func (d *delivery) DeleteTask(c *gin.Context) {
 	d.ToResponse(c, http.StatusOK, nil)
 }
 
+func (d *delivery) GetTaskChildren(c *gin.Context) {
+	id, ok := d.bindTaskID(c)
+	if !ok {
+		return
+	}
+	d.getTasks(c, func(query *model.TaskQuery) {
+		query.ParentID = &id
+	})
+}
+
+func (d *delivery) AddTaskTags(c *gin.Context) {
+	id, ok := d.bindTaskID(c)
+	if !ok {
+		return
+	}
+	var params TaskTagsRequest
+	if err := c.ShouldBindJSON(&params); err != nil {
+		d.ToErrorResponse(c, errcode.InvalidParams)
+		return
+	}
+	task, err := d.svc.AddTaskTags(c.Request.Context(), id, params.Tags)
+	if err != nil {
+		d.ToErrorResponse(c, err)
+		return
+	}
+	data := map[string]interface{}{
+		"result": task,
+	}
+	d.ToResponse(c, http.StatusOK, data)
+}
+
+func (d *delivery) RemoveTaskTag(c *gin.Context) {
+	id, ok := d.bindTaskID(c)
+	if !ok {
+		return
+	}
+	task, err := d.svc.RemoveTaskTags(c.Request.Context(), id, []string{c.Param("tag")})
+	if err != nil {
+		d.ToErrorResponse(c, err)
+		return
+	}
+	data := map[string]interface{}{
+		"result": task,
+	}
+	d.ToResponse(c, http.StatusOK, data)
+}
+
+func (d *delivery) GetTaskDependencies(c *gin.Context) {
+	id, ok := d.bindTaskID(c)
+	if !ok {
+		return
+	}
+	deps, err := d.svc.GetTaskDependencies(id)
+	if err != nil {
+		d.ToErrorResponse(c, err)
+		return
+	}
+	data := map[string]interface{}{
+		"result": deps,
+	}
+	d.ToResponse(c, http.StatusOK, data)
+}
+
+func (d *delivery) AddTaskBlockers(c *gin.Context) {
+	id, ok := d.bindTaskID(c)
+	if !ok {
+		return
+	}
+	var params TaskBlockersRequest
+	if err := c.ShouldBindJSON(&params); err != nil {
+		d.ToErrorResponse(c, errcode.InvalidParams)
+		return
+	}
+	task, err := d.svc.AddTaskBlockers(c.Request.Context(), id, params.BlockedBy)
+	if err != nil {
+		d.ToErrorResponse(c, err)
+		return
+	}
+	data := map[string]interface{}{
+		"result": task,
+	}
+	d.ToResponse(c, http.StatusOK, data)
+}
+
+func (d *delivery) RemoveTaskBlocker(c *gin.Context) {
+	id, ok := d.bindTaskID(c)
+	if !ok {
+		return
+	}
+	var uri BlockerURI
+	if err := c.ShouldBindUri(&uri); err != nil {
+		d.ToErrorResponse(c, errcode.InvalidParams.WithDetails(fmt.Sprintf("blocker: 必須是 1 到 %d 之間的整數", uint32(math.MaxUint32))))
+		return
+	}
+	task, err := d.svc.RemoveTaskBlockers(c.Request.Context(), id, []uint32{uri.ID})
+	if err != nil {
+		d.ToErrorResponse(c, err)
+		return
+	}
+	data := map[string]interface{}{
+		"result": task,
+	}
+	d.ToResponse(c, http.StatusOK, data)
+}
+
+func (d *delivery) GetTags(c *gin.Context) {
+	tags, err := d.svc.GetTags()
+	if err != nil {
+		d.ToErrorResponse(c, err)
+		return
+	}
+	data := map[string]interface{}{
+		"result": tags,
+	}
+	d.ToResponse(c, http.StatusOK, data)
+}
+
+func (d *delivery) GetProjects(c *gin.Context) {
+	projects, err := d.svc.GetProjects()
+	if err != nil {
+		d.ToErrorResponse(c, err)
+		return
+	}
+	data := map[string]interface{}{
+		"result": projects,
+	}
+	d.ToResponse(c, http.StatusOK, data)
+}
+
+func (d *delivery) GetProject(c *gin.Context) {
+	id, ok := d.bindProjectID(c)
+	if !ok {
+		return
+	}
+	project, err := d.svc.GetProject(id)
+	if err != nil {
+		d.ToErrorResponse(c, err)
+		return
+	}
+	data := map[string]interface{}{
+		"result": project,
+	}
+	d.ToResponse(c, http.StatusOK, data)
+}
+
+func (d *delivery) CreateProject(c *gin.Context) {
+	var params ProjectRequest
+	if err := c.ShouldBindJSON(&params); err != nil {
+		d.ToErrorResponse(c, errcode.InvalidParams)
+		return
+	}
+	project, err := d.svc.CreateProject(params.Project())
+	if err != nil {
+		d.ToErrorResponse(c, err)
+		return
+	}
+	data := map[string]interface{}{
+		"result": project,
+	}
+	d.ToResponse(c, http.StatusCreated, data)
+}
+
+func (d *delivery) UpdateProject(c *gin.Context) {
+	id, ok := d.bindProjectID(c)
+	if !ok {
+		return
+	}
+	var params ProjectRequest
+	if err := c.ShouldBindJSON(&params); err != nil {
+		d.ToErrorResponse(c, errcode.InvalidParams)
+		return
+	}
+	project, err := d.svc.UpdateProject(id, params.Project())
+	if err != nil {
+		d.ToErrorResponse(c, err)
+		return
+	}
+	data := map[string]interface{}{
+		"result": project,
+	}
+	d.ToResponse(c, http.StatusOK, data)
+}
+
+func (d *delivery) DeleteProject(c *gin.Context) {
+	id, ok := d.bindProjectID(c)
+	if !ok {
+		return
+	}
+	var params DeleteProjectRequest
+	if err := c.ShouldBindQuery(&params); err != nil {
+		d.ToErrorResponse(c, errcode.InvalidParams.WithDetails("cascade: 只能是 true 或 false"))
+		return
+	}
+	if err := d.svc.DeleteProject(id, params.Cascade); err != nil {
+		d.ToErrorResponse(c, err)
+		return
+	}
+	d.ToResponse(c, http.StatusOK, nil)
+}
+
+func (d *delivery) GetProjectTasks(c *gin.Context) {
+	id, ok := d.bindProjectID(c)
+	if !ok {
+		return
+	}
+	d.getTasks(c, func(query *model.TaskQuery) {
+		query.ProjectID = &id
+	})
+}
+
+func (d *delivery) CreateProjectTask(c *gin.Context) {
+	id, ok := d.bindProjectID(c)
+	if !ok {
+		return
+	}
+	d.createTask(c, &id)
+}
+
+func (d *delivery) GetWebhooks(c *gin.Context) {
+	webhooks, err := d.svc.GetWebhooks()
+	if err != nil {
+		d.ToErrorResponse(c, err)
+		return
+	}
+	result := make([]*model.Webhook, 0, len(webhooks))
+	for _, webhook := range webhooks {
+		result = append(result, webhookView(webhook, false))
+	}
+	data := map[string]interface{}{
+		"result": result,
+	}
+	d.ToResponse(c, http.StatusOK, data)
+}
+
+func (d *delivery) GetWebhook(c *gin.Context) {
+	id, ok := d.bindWebhookID(c)
+	if !ok {
+		return
+	}
+	webhook, err := d.svc.GetWebhook(id)
+	if err != nil {
+		d.ToErrorResponse(c, err)
+		return
+	}
+	data := map[string]interface{}{
+		"result": webhookView(webhook, false),
+	}
+	d.ToResponse(c, http.StatusOK, data)
+}
+
+func (d *delivery) CreateWebhook(c *gin.Context) {
+	var params WebhookRequest
+	if err := c.ShouldBindJSON(&params); err != nil {
+		d.ToErrorResponse(c, errcode.InvalidParams)
+		return
+	}
+	webhook, err := d.svc.CreateWebhook(params.Webhook())
+	if err != nil {
+		d.ToErrorResponse(c, err)
+		return
+	}
+	data := map[string]interface{}{
+		"result": webhookView(webhook, true),
+	}
+	d.ToResponse(c, http.StatusCreated, data)
+}
+
+func (d *delivery) UpdateWebhook(c *gin.Context) {
+	id, ok := d.bindWebhookID(c)
+	if !ok {
+		return
+	}
+	var params WebhookRequest
+	if err := c.ShouldBindJSON(&params); err != nil {
+		d.ToErrorResponse(c, errcode.InvalidParams)
+		return
+	}
+	webhook, err := d.svc.UpdateWebhook(id, params.Webhook())
+	if err != nil {
+		d.ToErrorResponse(c, err)
+		return
+	}
+	data := map[string]interface{}{
+		"result": webhookView(webhook, false),
+	}
+	d.ToResponse(c, http.StatusOK, data)
+}
+
+func (d *delivery) DeleteWebhook(c *gin.Context) {
+	id, ok := d.bindWebhookID(c)
+	if !ok {
+		return
+	}
+	if err := d.svc.DeleteWebhook(id); err != nil {
+		d.ToErrorResponse(c, err)
+		return
+	}
+	d.ToResponse(c, http.StatusOK, nil)
+}
+
+func (d *delivery) GetWebhookDeliveries(c *gin.Context) {
+	id, ok := d.bindWebhookID(c)
+	if !ok {
+		return
+	}
+	deliveries, err := d.svc.GetWebhookDeliveries(id)
+	if err != nil {
+		d.ToErrorResponse(c, err)
+		return
+	}
+	data := map[string]interface{}{
+		"result": deliveries,
+	}
+	d.ToResponse(c, http.StatusOK, data)
+}
+
+func (d *delivery) GetDeadLetters(c *gin.Context) {
+	deliveries, err := d.svc.GetDeadLetters()
+	if err != nil {
+		d.ToErrorResponse(c, err)
+		return
+	}
+	data := map[string]interface{}{
+		"result": deliveries,
+	}
+	d.ToResponse(c, http.StatusOK, data)
+}
+
+func (d *delivery) GetAPIKeys(c *gin.Context) {
+	keys, err := d.svc.GetAPIKeys()
+	if err != nil {
+		d.ToErrorResponse(c, err)
+		return
+	}
+	views := make([]*model.APIKey, len(keys))
+	for i, key := range keys {
+		views[i] = apiKeyView(key)
+	}
+	data := map[string]interface{}{
+		"result": views,
+	}
+	d.ToResponse(c, http.StatusOK, data)
+}
+
+// CreateAPIKey answers with the token of the new key, which is not shown
+// again.
+func (d *delivery) CreateAPIKey(c *gin.Context) {
+	var params APIKeyRequest
+	if err := c.ShouldBindJSON(&params); err != nil {
+		d.ToErrorResponse(c, errcode.InvalidParams)
+		return
+	}
+	key, err := d.svc.CreateAPIKey(params.APIKey())
+	if err != nil {
+		d.ToErrorResponse(c, err)
+		return
+	}
+	data := map[string]interface{}{
+		"result": apiKeyView(key),
+	}
+	d.ToResponse(c, http.StatusCreated, data)
+}
+
+func (d *delivery) DeleteAPIKey(c *gin.Context) {
+	var uri APIKeyURI
+	if err := c.ShouldBindUri(&uri); err != nil {
+		d.ToErrorResponse(c, errcode.InvalidParams.WithDetails(fmt.Sprintf("kid: 必須是 1 到 %d 之間的整數", uint32(math.MaxUint32))))
+		return
+	}
+	if err := d.svc.DeleteAPIKey(uri.ID); err != nil {
+		d.ToErrorResponse(c, err)
+		return
+	}
+	d.ToResponse(c, http.StatusOK, nil)
+}
+
 func (d *delivery) NoRoute(c *gin.Context) {
 	d.ToErrorResponse(c, errcode.NotFound)
 }
+
+// bindTaskID validates the :id path parameter shared by the task routes. It
+// writes the error response itself and reports whether the handler should go on.
+func (d *delivery) bindTaskID(c *gin.Context) (uint32, bool) {
+	var uri TaskURI
+	if err := c.ShouldBindUri(&uri); err != nil {
+		d.ToErrorResponse(c, errcode.InvalidParams.WithDetails(fmt.Sprintf("id: 必須是 1 到 %d 之間的整數", uint32(math.MaxUint32))))
+		return 0, false
+	}
+	return uri.ID, true
+}
+
+// bindProjectID is bindTaskID for the :pid parameter of the project routes.
+func (d *delivery) bindProjectID(c *gin.Context) (uint32, bool) {
+	var uri ProjectURI
+	if err := c.ShouldBindUri(&uri); err != nil {
+		d.ToErrorResponse(c, errcode.InvalidParams.WithDetails(fmt.Sprintf("pid: 必須是 1 到 %d 之間的整數", uint32(math.MaxUint32))))
+		return 0, false
+	}
+	return uri.ID, true
+}
+
+// bindWebhookID is bindTaskID for the :wid parameter of the webhook routes.
+func (d *delivery) bindWebhookID(c *gin.Context) (uint32, bool) {
+	var uri WebhookURI
+	if err := c.ShouldBindUri(&uri); err != nil {
+		d.ToErrorResponse(c, errcode.InvalidParams.WithDetails(fmt.Sprintf("wid: 必須是 1 到 %d 之間的整數", uint32(math.MaxUint32))))
+		return 0, false
+	}
+	return uri.ID, true
+}
+
+// apiKeyView prepares a key for a response, leaving out the hash.
+func apiKeyView(key *model.APIKey) *model.APIKey {
+	view := *key
+	view.Hash = ""
+	return &view
+}
+
+// webhookView prepares a webhook for a response. The secret is only shown
+// when the webhook is created.
+func webhookView(webhook *model.Webhook, secret bool) *model.Webhook {
+	view := *webhook
+	if !secret {
+		view.Secret = ""
+	}
+	if view.Events == nil {
+		view.Events = []string{}
+	}
+	return &view
+}
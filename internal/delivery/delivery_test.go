@@ -2,16 +2,21 @@ package delivery
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
 	"github.com/wagaru/task/config"
 	"github.com/wagaru/task/internal/errcode"
+	"github.com/wagaru/task/internal/event"
 	"github.com/wagaru/task/internal/model"
 	"github.com/wagaru/task/internal/service/mocks"
 )
@@ -19,7 +24,7 @@ import (
 func TestGetTasks(t *testing.T) {
 	mockService := new(mocks.Service)
 	t.Run("UnknownError", func(t *testing.T) {
-		mockService.On("GetTasks").Return(nil, errors.New("fake")).Once()
+		mockService.On("GetTasks", &model.TaskQuery{Sort: model.SortByID, Limit: defaultTasksLimit}).Return(nil, "", errors.New("fake")).Once()
 		delivery := NewDelivery(mockService, &config.ServerConfig{})
 		w := httptest.NewRecorder()
 		req, _ := http.NewRequest("GET", "/tasks", nil)
@@ -37,15 +42,15 @@ func TestGetTasks(t *testing.T) {
 			{
 				ID:     1,
 				Name:   "task1",
-				Status: 0,
+				Status: model.StatusTodo,
 			},
 			{
 				ID:     2,
 				Name:   "task2",
-				Status: 1,
+				Status: model.StatusDone,
 			},
 		}
-		mockService.On("GetTasks").Return(tasks, nil).Once()
+		mockService.On("GetTasks", &model.TaskQuery{Sort: model.SortByID, Limit: defaultTasksLimit}).Return(tasks, "", nil).Once()
 		delivery := NewDelivery(mockService, &config.ServerConfig{})
 		w := httptest.NewRecorder()
 		req, _ := http.NewRequest("GET", "/tasks", nil)
@@ -59,6 +64,103 @@ func TestGetTasks(t *testing.T) {
 	})
 }
 
+func TestGetTasksQuery(t *testing.T) {
+	mockService := new(mocks.Service)
+	t.Run("InvalidParams", func(t *testing.T) {
+		for _, query := range []string{"status=2", "status=doing", "tag_match=some", "tags=" + strings.Repeat("a", 33), "limit=-1", "limit=1001", "limit=a", "sort=status", "sort=name:up", "order=random", "order=topological&cursor=abc", "order=topological&tree=true"} {
+			t.Run(query, func(t *testing.T) {
+				delivery := NewDelivery(mockService, &config.ServerConfig{})
+				w := httptest.NewRecorder()
+				req, _ := http.NewRequest("GET", "/tasks?"+query, nil)
+				delivery.engine.ServeHTTP(w, req)
+				assert.Equal(t, errcode.InvalidParams.StatusCode(), w.Code)
+			})
+		}
+		mockService.AssertNotCalled(t, "GetTasks")
+	})
+	t.Run("Success", func(t *testing.T) {
+		status := model.StatusDone
+		tasks := []*model.Task{
+			{
+				ID:     2,
+				Name:   "task2",
+				Status: model.StatusDone,
+			},
+		}
+		query := &model.TaskQuery{
+			Status: &status,
+			Name:   "task",
+			Sort:   model.SortByName,
+			Desc:   true,
+			Limit:  1,
+			Cursor: "abc",
+		}
+		mockService.On("GetTasks", query).Return(tasks, "def", nil).Once()
+		delivery := NewDelivery(mockService, &config.ServerConfig{})
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/tasks?status=1&name=task&sort=name:desc&limit=1&cursor=abc", nil)
+		delivery.engine.ServeHTTP(w, req)
+		expected, _ := json.Marshal(map[string]interface{}{
+			"result":      tasks,
+			"next_cursor": "def",
+		})
+		assert.Equal(t, 200, w.Code)
+		assert.JSONEq(t, string(expected), w.Body.String())
+		mockService.AssertExpectations(t)
+	})
+}
+
+func TestGetTask(t *testing.T) {
+	mockService := new(mocks.Service)
+	t.Run("InvalidParams", func(t *testing.T) {
+		delivery := NewDelivery(mockService, &config.ServerConfig{})
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/tasks/abc", nil)
+		delivery.engine.ServeHTTP(w, req)
+		expected, _ := json.Marshal(map[string]interface{}{
+			"code":    errcode.InvalidParams.Code(),
+			"message": errcode.InvalidParams.Message(),
+			"details": []string{"id: 必須是 1 到 4294967295 之間的整數"},
+		})
+		assert.Equal(t, errcode.InvalidParams.StatusCode(), w.Code)
+		assert.JSONEq(t, w.Body.String(), string(expected))
+		mockService.AssertNotCalled(t, "GetTask")
+	})
+	t.Run("RecordNotExists", func(t *testing.T) {
+		id := uint32(1)
+		mockService.On("GetTask", id).Return(nil, errcode.RecordNotExists).Once()
+		delivery := NewDelivery(mockService, &config.ServerConfig{})
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", fmt.Sprintf("/tasks/%d", id), nil)
+		delivery.engine.ServeHTTP(w, req)
+		expected, _ := json.Marshal(map[string]interface{}{
+			"code":    errcode.RecordNotExists.Code(),
+			"message": errcode.RecordNotExists.Message(),
+		})
+		assert.Equal(t, http.StatusNotFound, w.Code)
+		assert.JSONEq(t, w.Body.String(), string(expected))
+		mockService.AssertExpectations(t)
+	})
+	t.Run("Success", func(t *testing.T) {
+		task := &model.Task{
+			ID:     1,
+			Name:   "task",
+			Status: model.StatusTodo,
+		}
+		mockService.On("GetTask", task.ID).Return(task, nil).Once()
+		delivery := NewDelivery(mockService, &config.ServerConfig{})
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", fmt.Sprintf("/tasks/%d", task.ID), nil)
+		delivery.engine.ServeHTTP(w, req)
+		expected, _ := json.Marshal(map[string]interface{}{
+			"result": task,
+		})
+		assert.Equal(t, 200, w.Code)
+		assert.JSONEq(t, w.Body.String(), string(expected))
+		mockService.AssertExpectations(t)
+	})
+}
+
 func TestCreateTask(t *testing.T) {
 	mockService := new(mocks.Service)
 	t.Run("InvalidParams", func(t *testing.T) {
@@ -69,14 +171,54 @@ func TestCreateTask(t *testing.T) {
 		expected, _ := json.Marshal(map[string]interface{}{
 			"code":    errcode.InvalidParams.Code(),
 			"message": errcode.InvalidParams.Message(),
+			"details": []string{"body: 必填"},
 		})
 		assert.Equal(t, errcode.InvalidParams.StatusCode(), w.Code)
 		assert.JSONEq(t, w.Body.String(), string(expected))
 		mockService.AssertNotCalled(t, "CreateTasks")
 	})
+	t.Run("Validation", func(t *testing.T) {
+		for _, body := range []string{
+			`{"name":""}`,
+			`{"name":"task","priority":6}`,
+			`{"name":"task","due_at":"2022-05-01"}`,
+			fmt.Sprintf(`{"name":"task","description":"%s"}`, strings.Repeat("a", 2001)),
+		} {
+			delivery := NewDelivery(mockService, &config.ServerConfig{})
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("POST", "/tasks", bytes.NewBufferString(body))
+			delivery.engine.ServeHTTP(w, req)
+			assert.Equal(t, errcode.InvalidParams.StatusCode(), w.Code, body)
+		}
+		mockService.AssertNotCalled(t, "CreateTask")
+	})
+	t.Run("Details", func(t *testing.T) {
+		dueAt := time.Date(2022, 5, 1, 8, 0, 0, 0, time.UTC)
+		input := &model.Task{
+			Name:        "task",
+			Description: "description",
+			Priority:    5,
+			DueAt:       &dueAt,
+		}
+		task := *input
+		task.ID = 1
+		task.Status = model.StatusTodo
+		task.CreatedAt = dueAt
+		task.UpdatedAt = dueAt
+		mockService.On("CreateTask", mock.Anything, input).Return(&task, nil).Once()
+		delivery := NewDelivery(mockService, &config.ServerConfig{})
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/tasks", bytes.NewBufferString(`{"name":"task","description":"description","priority":5,"due_at":"2022-05-01T08:00:00Z"}`))
+		delivery.engine.ServeHTTP(w, req)
+
+		assert.Equal(t, 201, w.Code)
+		assert.JSONEq(t, `{"result":{"id":1,"name":"task","status":"todo","description":"description","priority":5,
+			"due_at":"2022-05-01T08:00:00Z","created_at":"2022-05-01T08:00:00Z","updated_at":"2022-05-01T08:00:00Z","completed_at":null}}`, w.Body.String())
+		mockService.AssertExpectations(t)
+	})
 	t.Run("DuplicateRecords", func(t *testing.T) {
 		taskName := "task"
-		mockService.On("CreateTask", taskName).Return(nil, errcode.DuplicateRecords).Once()
+		mockService.On("CreateTask", mock.Anything, &model.Task{Name: taskName}).Return(nil, errcode.DuplicateRecords).Once()
 		delivery := NewDelivery(mockService, &config.ServerConfig{})
 		w := httptest.NewRecorder()
 		req, _ := http.NewRequest("POST", "/tasks", bytes.NewBufferString(fmt.Sprintf(`{"name":"%s"}`, taskName)))
@@ -93,9 +235,9 @@ func TestCreateTask(t *testing.T) {
 		task := &model.Task{
 			ID:     1,
 			Name:   "task",
-			Status: 0,
+			Status: model.StatusTodo,
 		}
-		mockService.On("CreateTask", task.Name).Return(task, nil).Once()
+		mockService.On("CreateTask", mock.Anything, &model.Task{Name: task.Name}).Return(task, nil).Once()
 		delivery := NewDelivery(mockService, &config.ServerConfig{})
 		w := httptest.NewRecorder()
 		req, _ := http.NewRequest("POST", "/tasks", bytes.NewBufferString(fmt.Sprintf(`{"name":"%s"}`, task.Name)))
@@ -122,6 +264,7 @@ func TestUpdateTask(t *testing.T) {
 		expected, _ := json.Marshal(map[string]interface{}{
 			"code":    errcode.InvalidParams.Code(),
 			"message": errcode.InvalidParams.Message(),
+			"details": []string{"status: 必填"},
 		})
 		assert.JSONEq(t, w.Body.String(), string(expected))
 		mockService.AssertNotCalled(t, "UpdateTask")
@@ -130,12 +273,12 @@ func TestUpdateTask(t *testing.T) {
 		task := &model.Task{
 			ID:     1,
 			Name:   "duplicated_name",
-			Status: 1,
+			Status: model.StatusDone,
 		}
-		mockService.On("UpdateTask", task.ID, task.Name, task.Status).Return(nil, errcode.DuplicateRecords).Once()
+		mockService.On("UpdateTask", mock.Anything, task.ID, &model.Task{Name: task.Name, Status: task.Status}).Return(nil, errcode.DuplicateRecords).Once()
 		delivery := NewDelivery(mockService, &config.ServerConfig{})
 		w := httptest.NewRecorder()
-		req, _ := http.NewRequest("PUT", fmt.Sprintf("/tasks/%d", task.ID), bytes.NewBufferString(fmt.Sprintf(`{"name":"%s", "status":%d}`, task.Name, task.Status)))
+		req, _ := http.NewRequest("PUT", fmt.Sprintf("/tasks/%d", task.ID), bytes.NewBufferString(fmt.Sprintf(`{"name":"%s", "status":"%s"}`, task.Name, task.Status)))
 		delivery.engine.ServeHTTP(w, req)
 		expected, _ := json.Marshal(map[string]interface{}{
 			"code":    errcode.DuplicateRecords.Code(),
@@ -149,13 +292,136 @@ func TestUpdateTask(t *testing.T) {
 		task := &model.Task{
 			ID:     1,
 			Name:   "modified_task",
-			Status: 1,
+			Status: model.StatusDone,
 		}
-		mockService.On("UpdateTask", task.ID, task.Name, task.Status).Return(task, nil).Once()
+		mockService.On("UpdateTask", mock.Anything, task.ID, &model.Task{Name: task.Name, Status: task.Status}).Return(task, nil).Once()
+
+		delivery := NewDelivery(mockService, &config.ServerConfig{})
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("PUT", fmt.Sprintf("/tasks/%d", task.ID), bytes.NewBufferString(fmt.Sprintf(`{"name":"%s", "status":1}`, task.Name)))
+		delivery.engine.ServeHTTP(w, req)
+
+		assert.Equal(t, 200, w.Code)
+		expected, _ := json.Marshal(map[string]interface{}{
+			"result": task,
+		})
+		assert.JSONEq(t, w.Body.String(), string(expected))
+		mockService.AssertExpectations(t)
+	})
+}
+
+func TestPatchTask(t *testing.T) {
+	mockService := new(mocks.Service)
+	name := "modified_task"
+	status := model.StatusDone
+	task := &model.Task{
+		ID:     1,
+		Name:   name,
+		Status: status,
+	}
+	t.Run("InvalidParams", func(t *testing.T) {
+		tests := []struct {
+			name        string
+			contentType string
+			body        string
+			details     []string
+		}{
+			{"ContentType", "text/plain", `{"status":1}`, []string{"Content-Type: 只支援 application/merge-patch+json 或 application/json-patch+json"}},
+			{"MergeNull", mergePatchContentType, `{"name":null,"status":2}`, []string{"name: 必須是非空字串", "status: 只能是 todo、in_progress、blocked、done、cancelled、0 或 1"}},
+			{"MergeReadOnly", mergePatchContentType, `{"id":2}`, []string{"id: 不可修改的欄位"}},
+			{"MergeFields", mergePatchContentType, `{"priority":6,"due_at":"tomorrow","created_at":null}`, []string{"due_at: 必須是 RFC 3339 格式的時間或 null", "priority: 必須是 0 到 5 之間的整數"}},
+			{"MergeRecurrence", mergePatchContentType, `{"recurrence":7}`, []string{"recurrence: 必須是長度不超過 200 的字串或 null"}},
+			{"JSONPatchRemove", jsonPatchContentType, `[{"op":"remove","path":"/name"}]`, []string{`0.op: 不支援的操作 "remove"`}},
+			{"JSONPatchPath", jsonPatchContentType, `[{"op":"replace","path":"/name/0","value":"a"}]`, []string{`0.path: 不支援的路徑 "/name/0"`}},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				delivery := NewDelivery(mockService, &config.ServerConfig{})
+				w := httptest.NewRecorder()
+				req, _ := http.NewRequest("PATCH", "/tasks/1", bytes.NewBufferString(tt.body))
+				req.Header.Set("Content-Type", tt.contentType)
+				delivery.engine.ServeHTTP(w, req)
+
+				assert.Equal(t, errcode.InvalidParams.StatusCode(), w.Code)
+				expected, _ := json.Marshal(map[string]interface{}{
+					"code":    errcode.InvalidParams.Code(),
+					"message": errcode.InvalidParams.Message(),
+					"details": tt.details,
+				})
+				assert.JSONEq(t, string(expected), w.Body.String())
+			})
+		}
+		mockService.AssertNotCalled(t, "PatchTask")
+	})
+	t.Run("PatchTestFailed", func(t *testing.T) {
+		delivery := NewDelivery(mockService, &config.ServerConfig{})
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("PATCH", "/tasks/1", bytes.NewBufferString(`[{"op":"replace","path":"/name","value":"a"},{"op":"test","path":"/name","value":"b"}]`))
+		req.Header.Set("Content-Type", jsonPatchContentType)
+		delivery.engine.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusConflict, w.Code)
+		mockService.AssertNotCalled(t, "PatchTask")
+	})
+	t.Run("IllegalTransition", func(t *testing.T) {
+		cancelled := model.StatusCancelled
+		detail := "status: 不能從 done 變更為 cancelled"
+		mockService.On("PatchTask", mock.Anything, task.ID, &model.TaskPatch{Status: &cancelled}).Return(nil, errcode.IllegalTransition.WithDetails(detail)).Once()
+		delivery := NewDelivery(mockService, &config.ServerConfig{})
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("PATCH", fmt.Sprintf("/tasks/%d", task.ID), bytes.NewBufferString(`{"status":"cancelled"}`))
+		req.Header.Set("Content-Type", mergePatchContentType)
+		delivery.engine.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusConflict, w.Code)
+		expected, _ := json.Marshal(map[string]interface{}{
+			"code":    errcode.IllegalTransition.Code(),
+			"message": errcode.IllegalTransition.Message(),
+			"details": []string{detail},
+		})
+		assert.JSONEq(t, string(expected), w.Body.String())
+		mockService.AssertExpectations(t)
+	})
+	t.Run("MergePatch", func(t *testing.T) {
+		mockService.On("PatchTask", mock.Anything, task.ID, &model.TaskPatch{Status: &status}).Return(task, nil).Once()
+		delivery := NewDelivery(mockService, &config.ServerConfig{})
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("PATCH", fmt.Sprintf("/tasks/%d", task.ID), bytes.NewBufferString(`{"status":1}`))
+		req.Header.Set("Content-Type", mergePatchContentType)
+		delivery.engine.ServeHTTP(w, req)
+
+		assert.Equal(t, 200, w.Code)
+		expected, _ := json.Marshal(map[string]interface{}{
+			"result": task,
+		})
+		assert.JSONEq(t, w.Body.String(), string(expected))
+		mockService.AssertExpectations(t)
+	})
+	t.Run("MergePatchNull", func(t *testing.T) {
+		description := ""
+		mockService.On("PatchTask", mock.Anything, task.ID, &model.TaskPatch{Description: &description, DueAt: &time.Time{}}).Return(task, nil).Once()
+		delivery := NewDelivery(mockService, &config.ServerConfig{})
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("PATCH", fmt.Sprintf("/tasks/%d", task.ID), bytes.NewBufferString(`{"description":null,"due_at":null}`))
+		req.Header.Set("Content-Type", mergePatchContentType)
+		delivery.engine.ServeHTTP(w, req)
 
+		assert.Equal(t, 200, w.Code)
+		mockService.AssertExpectations(t)
+	})
+	t.Run("JSONPatch", func(t *testing.T) {
+		oldName := "task"
+		patch := &model.TaskPatch{
+			Name:   &name,
+			Status: &status,
+			Expect: &model.TaskPatch{Name: &oldName},
+		}
+		mockService.On("PatchTask", mock.Anything, task.ID, patch).Return(task, nil).Once()
 		delivery := NewDelivery(mockService, &config.ServerConfig{})
 		w := httptest.NewRecorder()
-		req, _ := http.NewRequest("PUT", fmt.Sprintf("/tasks/%d", task.ID), bytes.NewBufferString(fmt.Sprintf(`{"name":"%s", "status":%d}`, task.Name, task.Status)))
+		body := fmt.Sprintf(`[{"op":"test","path":"/name","value":"%s"},{"op":"replace","path":"/name","value":"%s"},{"op":"add","path":"/status","value":"%s"}]`, oldName, name, status)
+		req, _ := http.NewRequest("PATCH", fmt.Sprintf("/tasks/%d", task.ID), bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", jsonPatchContentType)
 		delivery.engine.ServeHTTP(w, req)
 
 		assert.Equal(t, 200, w.Code)
@@ -171,7 +437,7 @@ func TestDeleteTask(t *testing.T) {
 	mockService := new(mocks.Service)
 	t.Run("RecordNotExists", func(t *testing.T) {
 		id := uint32(1)
-		mockService.On("DeleteTask", id).Return(errcode.RecordNotExists).Once()
+		mockService.On("DeleteTask", mock.Anything, id).Return(errcode.RecordNotExists).Once()
 		delivery := NewDelivery(mockService, &config.ServerConfig{})
 		w := httptest.NewRecorder()
 		req, _ := http.NewRequest("DELETE", fmt.Sprintf("/tasks/%d", id), nil)
@@ -187,7 +453,7 @@ func TestDeleteTask(t *testing.T) {
 	})
 	t.Run("Success", func(t *testing.T) {
 		id := uint32(1)
-		mockService.On("DeleteTask", id).Return(nil).Once()
+		mockService.On("DeleteTask", mock.Anything, id).Return(nil).Once()
 		delivery := NewDelivery(mockService, &config.ServerConfig{})
 		w := httptest.NewRecorder()
 		req, _ := http.NewRequest("DELETE", fmt.Sprintf("/tasks/%d", id), nil)
@@ -197,3 +463,783 @@ func TestDeleteTask(t *testing.T) {
 		mockService.AssertExpectations(t)
 	})
 }
+
+func TestTaskTags(t *testing.T) {
+	mockService := new(mocks.Service)
+	task := &model.Task{
+		ID:     1,
+		Name:   "task",
+		Status: model.StatusTodo,
+		Tags:   []string{"home", "urgent"},
+	}
+	t.Run("Query", func(t *testing.T) {
+		query := &model.TaskQuery{Tags: []string{"home", "urgent"}, AllTags: true, Sort: model.SortByID, Limit: defaultTasksLimit}
+		mockService.On("GetTasks", query).Return([]*model.Task{task}, "", nil).Once()
+		delivery := NewDelivery(mockService, &config.ServerConfig{})
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/tasks?tags=Urgent,home,&tag_match=all", nil)
+		delivery.engine.ServeHTTP(w, req)
+
+		assert.Equal(t, 200, w.Code)
+		mockService.AssertExpectations(t)
+	})
+	t.Run("Add", func(t *testing.T) {
+		mockService.On("AddTaskTags", mock.Anything, task.ID, []string{"home", "urgent"}).Return(task, nil).Once()
+		delivery := NewDelivery(mockService, &config.ServerConfig{})
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/tasks/1/tags", bytes.NewBufferString(`{"tags":["home","urgent"]}`))
+		delivery.engine.ServeHTTP(w, req)
+
+		assert.Equal(t, 200, w.Code)
+		expected, _ := json.Marshal(map[string]interface{}{
+			"result": task,
+		})
+		assert.JSONEq(t, string(expected), w.Body.String())
+		mockService.AssertExpectations(t)
+	})
+	t.Run("AddInvalidParams", func(t *testing.T) {
+		for _, body := range []string{`{}`, `{"tags":[]}`, `{"tags":[""]}`, `{"tags":["a,b"]}`, fmt.Sprintf(`{"tags":["%s"]}`, strings.Repeat("a", 33))} {
+			delivery := NewDelivery(mockService, &config.ServerConfig{})
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("POST", "/tasks/1/tags", bytes.NewBufferString(body))
+			delivery.engine.ServeHTTP(w, req)
+			assert.Equal(t, errcode.InvalidParams.StatusCode(), w.Code, body)
+		}
+		mockService.AssertNotCalled(t, "AddTaskTags")
+	})
+	t.Run("Remove", func(t *testing.T) {
+		mockService.On("RemoveTaskTags", mock.Anything, task.ID, []string{"urgent"}).Return(task, nil).Once()
+		delivery := NewDelivery(mockService, &config.ServerConfig{})
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("DELETE", "/tasks/1/tags/urgent", nil)
+		delivery.engine.ServeHTTP(w, req)
+
+		assert.Equal(t, 200, w.Code)
+		mockService.AssertExpectations(t)
+	})
+	t.Run("RecordNotExists", func(t *testing.T) {
+		mockService.On("RemoveTaskTags", mock.Anything, uint32(2), []string{"urgent"}).Return(nil, errcode.RecordNotExists).Once()
+		delivery := NewDelivery(mockService, &config.ServerConfig{})
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("DELETE", "/tasks/2/tags/urgent", nil)
+		delivery.engine.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusNotFound, w.Code)
+		mockService.AssertExpectations(t)
+	})
+	t.Run("GetTags", func(t *testing.T) {
+		tags := []*model.Tag{{Name: "home", Count: 1}, {Name: "urgent", Count: 2}}
+		mockService.On("GetTags").Return(tags, nil).Once()
+		delivery := NewDelivery(mockService, &config.ServerConfig{})
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/tags", nil)
+		delivery.engine.ServeHTTP(w, req)
+
+		assert.Equal(t, 200, w.Code)
+		assert.JSONEq(t, `{"result":[{"name":"home","count":1},{"name":"urgent","count":2}]}`, w.Body.String())
+		mockService.AssertExpectations(t)
+	})
+}
+
+func TestProjects(t *testing.T) {
+	mockService := new(mocks.Service)
+	project := &model.Project{
+		ID:   1,
+		Name: "project",
+	}
+	t.Run("GetProjects", func(t *testing.T) {
+		mockService.On("GetProjects").Return([]*model.Project{project}, nil).Once()
+		delivery := NewDelivery(mockService, &config.ServerConfig{})
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/projects", nil)
+		delivery.engine.ServeHTTP(w, req)
+
+		assert.Equal(t, 200, w.Code)
+		expected, _ := json.Marshal(map[string]interface{}{
+			"result": []*model.Project{project},
+		})
+		assert.JSONEq(t, string(expected), w.Body.String())
+		mockService.AssertExpectations(t)
+	})
+	t.Run("GetProject", func(t *testing.T) {
+		mockService.On("GetProject", uint32(2)).Return(nil, errcode.RecordNotExists).Once()
+		delivery := NewDelivery(mockService, &config.ServerConfig{})
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/projects/2", nil)
+		delivery.engine.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusNotFound, w.Code)
+		mockService.AssertExpectations(t)
+	})
+	t.Run("CreateProject", func(t *testing.T) {
+		mockService.On("CreateProject", &model.Project{Name: "project", Description: "description"}).Return(project, nil).Once()
+		delivery := NewDelivery(mockService, &config.ServerConfig{})
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/projects", bytes.NewBufferString(`{"name":"project","description":"description"}`))
+		delivery.engine.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusCreated, w.Code)
+		mockService.AssertExpectations(t)
+	})
+	t.Run("CreateProjectInvalidParams", func(t *testing.T) {
+		for _, body := range []string{`{}`, `{"name":""}`, fmt.Sprintf(`{"name":"%s"}`, strings.Repeat("a", 201))} {
+			delivery := NewDelivery(mockService, &config.ServerConfig{})
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("POST", "/projects", bytes.NewBufferString(body))
+			delivery.engine.ServeHTTP(w, req)
+			assert.Equal(t, errcode.InvalidParams.StatusCode(), w.Code, body)
+		}
+	})
+	t.Run("UpdateProject", func(t *testing.T) {
+		mockService.On("UpdateProject", project.ID, &model.Project{Name: "project_rename"}).Return(project, nil).Once()
+		delivery := NewDelivery(mockService, &config.ServerConfig{})
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("PUT", "/projects/1", bytes.NewBufferString(`{"name":"project_rename"}`))
+		delivery.engine.ServeHTTP(w, req)
+
+		assert.Equal(t, 200, w.Code)
+		mockService.AssertExpectations(t)
+	})
+	t.Run("DeleteProject", func(t *testing.T) {
+		mockService.On("DeleteProject", project.ID, false).Return(errcode.ProjectNotEmpty).Once()
+		mockService.On("DeleteProject", project.ID, true).Return(nil).Once()
+		delivery := NewDelivery(mockService, &config.ServerConfig{})
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("DELETE", "/projects/1", nil)
+		delivery.engine.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusConflict, w.Code)
+
+		w = httptest.NewRecorder()
+		req, _ = http.NewRequest("DELETE", "/projects/1?cascade=true", nil)
+		delivery.engine.ServeHTTP(w, req)
+		assert.Equal(t, 200, w.Code)
+
+		w = httptest.NewRecorder()
+		req, _ = http.NewRequest("DELETE", "/projects/1?cascade=maybe", nil)
+		delivery.engine.ServeHTTP(w, req)
+		assert.Equal(t, errcode.InvalidParams.StatusCode(), w.Code)
+		mockService.AssertExpectations(t)
+	})
+	t.Run("GetProjectTasks", func(t *testing.T) {
+		query := &model.TaskQuery{ProjectID: &project.ID, Name: "task", Sort: model.SortByID, Limit: defaultTasksLimit}
+		mockService.On("GetTasks", query).Return([]*model.Task{}, "", nil).Once()
+		delivery := NewDelivery(mockService, &config.ServerConfig{})
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/projects/1/tasks?name=task&project_id=2", nil)
+		delivery.engine.ServeHTTP(w, req)
+
+		assert.Equal(t, 200, w.Code)
+		assert.JSONEq(t, `{"result":[]}`, w.Body.String())
+		mockService.AssertExpectations(t)
+	})
+	t.Run("CreateProjectTask", func(t *testing.T) {
+		task := &model.Task{ID: 1, ProjectID: project.ID, Name: "task", Status: model.StatusTodo}
+		mockService.On("CreateTask", mock.Anything, &model.Task{ProjectID: project.ID, Name: "task"}).Return(task, nil).Once()
+		delivery := NewDelivery(mockService, &config.ServerConfig{})
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/projects/1/tasks", bytes.NewBufferString(`{"name":"task"}`))
+		delivery.engine.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusCreated, w.Code)
+		assert.Contains(t, w.Body.String(), `"project_id":1`)
+		mockService.AssertExpectations(t)
+	})
+	t.Run("InvalidProjectID", func(t *testing.T) {
+		expected, _ := json.Marshal(map[string]interface{}{
+			"code":    errcode.InvalidParams.Code(),
+			"message": errcode.InvalidParams.Message(),
+			"details": []string{"pid: 必須是 1 到 4294967295 之間的整數"},
+		})
+		for _, path := range []string{"/projects/0", "/projects/abc", "/projects/abc/tasks"} {
+			delivery := NewDelivery(mockService, &config.ServerConfig{})
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", path, nil)
+			delivery.engine.ServeHTTP(w, req)
+			assert.Equal(t, errcode.InvalidParams.StatusCode(), w.Code, path)
+			assert.JSONEq(t, string(expected), w.Body.String(), path)
+		}
+	})
+}
+
+func TestSubtasks(t *testing.T) {
+	mockService := new(mocks.Service)
+	parent := &model.Task{ID: 1, Name: "task1", Status: model.StatusTodo}
+	child := &model.Task{ID: 2, ParentID: parent.ID, Name: "task2", Status: model.StatusTodo}
+	t.Run("GetTaskChildren", func(t *testing.T) {
+		query := &model.TaskQuery{ParentID: &parent.ID, Sort: model.SortByID, Limit: defaultTasksLimit}
+		mockService.On("GetTasks", query).Return([]*model.Task{child}, "", nil).Once()
+		delivery := NewDelivery(mockService, &config.ServerConfig{})
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/tasks/1/children?parent_id=5", nil)
+		delivery.engine.ServeHTTP(w, req)
+
+		assert.Equal(t, 200, w.Code)
+		assert.Contains(t, w.Body.String(), `"parent_id":1`)
+		mockService.AssertExpectations(t)
+	})
+	t.Run("Tree", func(t *testing.T) {
+		query := &model.TaskQuery{Sort: model.SortByID, Limit: defaultTasksLimit}
+		tree := model.BuildTree([]*model.Task{parent, child}, 0)
+		mockService.On("GetTaskTree", query).Return(tree, nil).Once()
+		delivery := NewDelivery(mockService, &config.ServerConfig{})
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/tasks?tree=true", nil)
+		delivery.engine.ServeHTTP(w, req)
+
+		assert.Equal(t, 200, w.Code)
+		expected := `{"result":[{"id":1,"name":"task1","status":"todo","description":"","priority":0,"due_at":null,"created_at":"0001-01-01T00:00:00Z","updated_at":"0001-01-01T00:00:00Z","completed_at":null,"children":[
+			{"id":2,"parent_id":1,"name":"task2","status":"todo","description":"","priority":0,"due_at":null,"created_at":"0001-01-01T00:00:00Z","updated_at":"0001-01-01T00:00:00Z","completed_at":null,"children":[]}]}]}`
+		assert.JSONEq(t, expected, w.Body.String())
+		mockService.AssertExpectations(t)
+	})
+	t.Run("TreeInvalidParams", func(t *testing.T) {
+		mockService := new(mocks.Service)
+		delivery := NewDelivery(mockService, &config.ServerConfig{})
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/tasks?tree=true&cursor=abc", nil)
+		delivery.engine.ServeHTTP(w, req)
+
+		assert.Equal(t, errcode.InvalidParams.StatusCode(), w.Code)
+		assert.Contains(t, w.Body.String(), "tree: 不能與 cursor 一起使用")
+		mockService.AssertNotCalled(t, "GetTaskTree")
+	})
+	t.Run("CreateTask", func(t *testing.T) {
+		mockService.On("CreateTask", mock.Anything, &model.Task{ParentID: parent.ID, Name: "task2"}).Return(child, nil).Once()
+		delivery := NewDelivery(mockService, &config.ServerConfig{})
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/tasks", bytes.NewBufferString(`{"name":"task2","parent_id":1}`))
+		delivery.engine.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusCreated, w.Code)
+		mockService.AssertExpectations(t)
+	})
+	t.Run("PatchTask", func(t *testing.T) {
+		for body, parentID := range map[string]uint32{`{"parent_id":3}`: 3, `{"parent_id":null}`: 0} {
+			mockService.On("PatchTask", mock.Anything, child.ID, &model.TaskPatch{ParentID: &parentID}).Return(child, nil).Once()
+			delivery := NewDelivery(mockService, &config.ServerConfig{})
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("PATCH", "/tasks/2", bytes.NewBufferString(body))
+			req.Header.Set("Content-Type", mergePatchContentType)
+			delivery.engine.ServeHTTP(w, req)
+			assert.Equal(t, 200, w.Code, body)
+		}
+		mockService.AssertExpectations(t)
+	})
+	t.Run("Cycle", func(t *testing.T) {
+		parentID := child.ID
+		mockService.On("PatchTask", mock.Anything, parent.ID, &model.TaskPatch{ParentID: &parentID}).Return(nil, errcode.CycleDetected).Once()
+		delivery := NewDelivery(mockService, &config.ServerConfig{})
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("PATCH", "/tasks/1", bytes.NewBufferString(`{"parent_id":2}`))
+		req.Header.Set("Content-Type", mergePatchContentType)
+		delivery.engine.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusConflict, w.Code)
+		mockService.AssertExpectations(t)
+	})
+}
+
+func TestDependencies(t *testing.T) {
+	mockService := new(mocks.Service)
+	blocker := &model.Task{ID: 1, Name: "task1", Status: model.StatusTodo}
+	task := &model.Task{ID: 2, Name: "task2", Status: model.StatusTodo, BlockedBy: []uint32{blocker.ID}}
+	t.Run("GetTaskDependencies", func(t *testing.T) {
+		deps := &model.TaskDependencies{BlockedBy: []*model.Task{blocker}, Blocking: []*model.Task{}}
+		mockService.On("GetTaskDependencies", task.ID).Return(deps, nil).Once()
+		delivery := NewDelivery(mockService, &config.ServerConfig{})
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/tasks/2/dependencies", nil)
+		delivery.engine.ServeHTTP(w, req)
+
+		assert.Equal(t, 200, w.Code)
+		expected, _ := json.Marshal(map[string]interface{}{
+			"result": deps,
+		})
+		assert.JSONEq(t, string(expected), w.Body.String())
+		mockService.AssertExpectations(t)
+	})
+	t.Run("AddTaskBlockers", func(t *testing.T) {
+		mockService.On("AddTaskBlockers", mock.Anything, task.ID, []uint32{blocker.ID}).Return(task, nil).Once()
+		delivery := NewDelivery(mockService, &config.ServerConfig{})
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/tasks/2/dependencies", bytes.NewBufferString(`{"blocked_by":[1]}`))
+		delivery.engine.ServeHTTP(w, req)
+
+		assert.Equal(t, 200, w.Code)
+		assert.Contains(t, w.Body.String(), `"blocked_by":[1]`)
+		mockService.AssertExpectations(t)
+	})
+	t.Run("AddTaskBlockersInvalidParams", func(t *testing.T) {
+		for _, body := range []string{`{}`, `{"blocked_by":[]}`, `{"blocked_by":[0]}`, `{"blocked_by":["a"]}`} {
+			delivery := NewDelivery(mockService, &config.ServerConfig{})
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("POST", "/tasks/2/dependencies", bytes.NewBufferString(body))
+			delivery.engine.ServeHTTP(w, req)
+			assert.Equal(t, errcode.InvalidParams.StatusCode(), w.Code, body)
+		}
+	})
+	t.Run("Cycle", func(t *testing.T) {
+		mockService.On("AddTaskBlockers", mock.Anything, blocker.ID, []uint32{task.ID}).Return(nil, errcode.CycleDetected).Once()
+		delivery := NewDelivery(mockService, &config.ServerConfig{})
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/tasks/1/dependencies", bytes.NewBufferString(`{"blocked_by":[2]}`))
+		delivery.engine.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusConflict, w.Code)
+		mockService.AssertExpectations(t)
+	})
+	t.Run("RemoveTaskBlocker", func(t *testing.T) {
+		mockService.On("RemoveTaskBlockers", mock.Anything, task.ID, []uint32{blocker.ID}).Return(&model.Task{ID: 2, Name: "task2", Status: model.StatusTodo}, nil).Once()
+		delivery := NewDelivery(mockService, &config.ServerConfig{})
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("DELETE", "/tasks/2/dependencies/1", nil)
+		delivery.engine.ServeHTTP(w, req)
+
+		assert.Equal(t, 200, w.Code)
+		assert.NotContains(t, w.Body.String(), "blocked_by")
+
+		for _, blocker := range []string{"0", "abc"} {
+			w = httptest.NewRecorder()
+			req, _ = http.NewRequest("DELETE", "/tasks/2/dependencies/"+blocker, nil)
+			delivery.engine.ServeHTTP(w, req)
+			assert.Equal(t, errcode.InvalidParams.StatusCode(), w.Code)
+			assert.Contains(t, w.Body.String(), "blocker: 必須是 1 到 4294967295 之間的整數")
+		}
+		mockService.AssertExpectations(t)
+	})
+	t.Run("TaskBlocked", func(t *testing.T) {
+		status := model.StatusDone
+		mockService.On("PatchTask", mock.Anything, task.ID, &model.TaskPatch{Status: &status}).
+			Return(nil, errcode.TaskBlocked.WithDetails("blocked_by: 任務 1 尚未完成")).Once()
+		delivery := NewDelivery(mockService, &config.ServerConfig{})
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("PATCH", "/tasks/2", bytes.NewBufferString(`{"status":"done"}`))
+		req.Header.Set("Content-Type", mergePatchContentType)
+		delivery.engine.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusConflict, w.Code)
+		expected, _ := json.Marshal(map[string]interface{}{
+			"code":    errcode.TaskBlocked.Code(),
+			"message": errcode.TaskBlocked.Message(),
+			"details": []string{"blocked_by: 任務 1 尚未完成"},
+		})
+		assert.JSONEq(t, string(expected), w.Body.String())
+		mockService.AssertExpectations(t)
+	})
+	t.Run("CreateTask", func(t *testing.T) {
+		mockService.On("CreateTask", mock.Anything, &model.Task{Name: "task2", BlockedBy: []uint32{1}}).Return(task, nil).Once()
+		delivery := NewDelivery(mockService, &config.ServerConfig{})
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/tasks", bytes.NewBufferString(`{"name":"task2","blocked_by":[1]}`))
+		delivery.engine.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusCreated, w.Code)
+		mockService.AssertExpectations(t)
+	})
+	t.Run("Topological", func(t *testing.T) {
+		query := &model.TaskQuery{Sort: model.SortByID, Limit: defaultTasksLimit}
+		mockService.On("GetTaskOrder", query).Return([]*model.Task{blocker, task}, nil).Once()
+		delivery := NewDelivery(mockService, &config.ServerConfig{})
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/tasks?order=topological", nil)
+		delivery.engine.ServeHTTP(w, req)
+
+		assert.Equal(t, 200, w.Code)
+		expected, _ := json.Marshal(map[string]interface{}{
+			"result": []*model.Task{blocker, task},
+		})
+		assert.JSONEq(t, string(expected), w.Body.String())
+		mockService.AssertExpectations(t)
+	})
+}
+
+func TestRecurrence(t *testing.T) {
+	mockService := new(mocks.Service)
+	task := &model.Task{ID: 1, Name: "task", Status: model.StatusTodo, Recurrence: "FREQ=WEEKLY"}
+	t.Run("CreateTask", func(t *testing.T) {
+		mockService.On("CreateTask", mock.Anything, &model.Task{Name: "task", Recurrence: "weekly"}).Return(task, nil).Once()
+		delivery := NewDelivery(mockService, &config.ServerConfig{})
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/tasks", bytes.NewBufferString(`{"name":"task","recurrence":"weekly"}`))
+		delivery.engine.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusCreated, w.Code)
+		assert.Contains(t, w.Body.String(), `"recurrence":"FREQ=WEEKLY"`)
+		mockService.AssertExpectations(t)
+	})
+	t.Run("UpdateTask", func(t *testing.T) {
+		status := model.StatusDone
+		mockService.On("UpdateTask", mock.Anything, task.ID, &model.Task{Name: "task", Status: status, Recurrence: "FREQ=DAILY;COUNT=2"}).Return(task, nil).Once()
+		delivery := NewDelivery(mockService, &config.ServerConfig{})
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("PUT", "/tasks/1", bytes.NewBufferString(`{"name":"task","status":"done","recurrence":"FREQ=DAILY;COUNT=2"}`))
+		delivery.engine.ServeHTTP(w, req)
+
+		assert.Equal(t, 200, w.Code)
+		mockService.AssertExpectations(t)
+	})
+	t.Run("PatchTask", func(t *testing.T) {
+		for body, rule := range map[string]string{`{"recurrence":"monthly"}`: "monthly", `{"recurrence":null}`: ""} {
+			mockService.On("PatchTask", mock.Anything, task.ID, &model.TaskPatch{Recurrence: &rule}).Return(task, nil).Once()
+			delivery := NewDelivery(mockService, &config.ServerConfig{})
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("PATCH", "/tasks/1", bytes.NewBufferString(body))
+			req.Header.Set("Content-Type", mergePatchContentType)
+			delivery.engine.ServeHTTP(w, req)
+			assert.Equal(t, 200, w.Code, body)
+		}
+		mockService.AssertExpectations(t)
+	})
+	t.Run("InvalidParams", func(t *testing.T) {
+		detail := "recurrence: 無效的重複規則 (unsupported FREQ \"HOURLY\")"
+		mockService.On("CreateTask", mock.Anything, &model.Task{Name: "task", Recurrence: "FREQ=HOURLY"}).Return(nil, errcode.InvalidParams.WithDetails(detail)).Once()
+		delivery := NewDelivery(mockService, &config.ServerConfig{})
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/tasks", bytes.NewBufferString(`{"name":"task","recurrence":"FREQ=HOURLY"}`))
+		delivery.engine.ServeHTTP(w, req)
+
+		assert.Equal(t, errcode.InvalidParams.StatusCode(), w.Code)
+		expected, _ := json.Marshal(map[string]interface{}{
+			"code":    errcode.InvalidParams.Code(),
+			"message": errcode.InvalidParams.Message(),
+			"details": []string{detail},
+		})
+		assert.JSONEq(t, string(expected), w.Body.String())
+
+		w = httptest.NewRecorder()
+		req, _ = http.NewRequest("POST", "/tasks", bytes.NewBufferString(fmt.Sprintf(`{"name":"task","recurrence":"%s"}`, strings.Repeat("a", 201))))
+		delivery.engine.ServeHTTP(w, req)
+		assert.Equal(t, errcode.InvalidParams.StatusCode(), w.Code)
+		mockService.AssertExpectations(t)
+	})
+}
+
+func TestInvalidTaskID(t *testing.T) {
+	mockService := new(mocks.Service)
+	delivery := NewDelivery(mockService, &config.ServerConfig{})
+	expected, _ := json.Marshal(map[string]interface{}{
+		"code":    errcode.InvalidParams.Code(),
+		"message": errcode.InvalidParams.Message(),
+		"details": []string{"id: 必須是 1 到 4294967295 之間的整數"},
+	})
+	routes := []struct {
+		method string
+		suffix string
+	}{
+		{"GET", ""},
+		{"PUT", ""},
+		{"PATCH", ""},
+		{"DELETE", ""},
+		{"POST", "/tags"},
+		{"DELETE", "/tags/home"},
+		{"GET", "/children"},
+		{"GET", "/dependencies"},
+		{"POST", "/dependencies"},
+		{"DELETE", "/dependencies/1"},
+	}
+	for _, route := range routes {
+		for _, id := range []string{"abc", "0", "-1", "1.5", "4294967296"} {
+			path := "/tasks/" + id + route.suffix
+			t.Run(route.method+" "+path, func(t *testing.T) {
+				w := httptest.NewRecorder()
+				req, _ := http.NewRequest(route.method, path, bytes.NewBufferString(`{"name":"task", "status":1, "tags":["home"], "blocked_by":[1]}`))
+				delivery.engine.ServeHTTP(w, req)
+
+				assert.Equal(t, errcode.InvalidParams.StatusCode(), w.Code)
+				assert.JSONEq(t, string(expected), w.Body.String())
+			})
+		}
+	}
+	mockService.AssertNotCalled(t, "GetTask")
+	mockService.AssertNotCalled(t, "GetTasks")
+	mockService.AssertNotCalled(t, "UpdateTask")
+	mockService.AssertNotCalled(t, "PatchTask")
+	mockService.AssertNotCalled(t, "DeleteTask")
+	mockService.AssertNotCalled(t, "AddTaskTags")
+	mockService.AssertNotCalled(t, "RemoveTaskTags")
+	mockService.AssertNotCalled(t, "GetTaskDependencies")
+	mockService.AssertNotCalled(t, "AddTaskBlockers")
+	mockService.AssertNotCalled(t, "RemoveTaskBlockers")
+}
+
+func TestWebhooks(t *testing.T) {
+	mockService := new(mocks.Service)
+	webhook := &model.Webhook{
+		ID:     1,
+		URL:    "https://example.com/hook",
+		Secret: "secret",
+		Active: true,
+	}
+	t.Run("GetWebhooks", func(t *testing.T) {
+		mockService.On("GetWebhooks").Return([]*model.Webhook{webhook}, nil).Once()
+		delivery := NewDelivery(mockService, &config.ServerConfig{})
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/webhooks", nil)
+		delivery.engine.ServeHTTP(w, req)
+
+		assert.Equal(t, 200, w.Code)
+		assert.NotContains(t, w.Body.String(), "secret")
+		assert.Contains(t, w.Body.String(), `"events":[]`)
+		mockService.AssertExpectations(t)
+	})
+	t.Run("GetWebhook", func(t *testing.T) {
+		mockService.On("GetWebhook", uint32(2)).Return(nil, errcode.RecordNotExists).Once()
+		delivery := NewDelivery(mockService, &config.ServerConfig{})
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/webhooks/2", nil)
+		delivery.engine.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusNotFound, w.Code)
+		mockService.AssertExpectations(t)
+	})
+	t.Run("CreateWebhook", func(t *testing.T) {
+		mockService.On("CreateWebhook", &model.Webhook{URL: webhook.URL, Events: []string{model.EventTaskCreated}, Active: true}).Return(webhook, nil).Once()
+		delivery := NewDelivery(mockService, &config.ServerConfig{})
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/webhooks", bytes.NewBufferString(`{"url":"https://example.com/hook","events":["task.created"]}`))
+		delivery.engine.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusCreated, w.Code)
+		assert.Contains(t, w.Body.String(), `"secret":"secret"`)
+		mockService.AssertExpectations(t)
+	})
+	t.Run("CreateWebhookInvalidParams", func(t *testing.T) {
+		mockService := new(mocks.Service)
+		for _, body := range []string{`{}`, `{"url":"hook"}`, `{"url":"https://example.com/hook","events":["task.moved"]}`, `{"url":"https://example.com/hook","active":"yes"}`} {
+			delivery := NewDelivery(mockService, &config.ServerConfig{})
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("POST", "/webhooks", bytes.NewBufferString(body))
+			delivery.engine.ServeHTTP(w, req)
+			assert.Equal(t, errcode.InvalidParams.StatusCode(), w.Code, body)
+		}
+		mockService.AssertNotCalled(t, "CreateWebhook", mock.Anything)
+	})
+	t.Run("UpdateWebhook", func(t *testing.T) {
+		mockService.On("UpdateWebhook", webhook.ID, &model.Webhook{URL: webhook.URL, Active: false}).Return(webhook, nil).Once()
+		delivery := NewDelivery(mockService, &config.ServerConfig{})
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("PUT", "/webhooks/1", bytes.NewBufferString(`{"url":"https://example.com/hook","active":false}`))
+		delivery.engine.ServeHTTP(w, req)
+
+		assert.Equal(t, 200, w.Code)
+		assert.NotContains(t, w.Body.String(), "secret")
+		mockService.AssertExpectations(t)
+	})
+	t.Run("DeleteWebhook", func(t *testing.T) {
+		mockService.On("DeleteWebhook", webhook.ID).Return(nil).Once()
+		delivery := NewDelivery(mockService, &config.ServerConfig{})
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("DELETE", "/webhooks/1", nil)
+		delivery.engine.ServeHTTP(w, req)
+
+		assert.Equal(t, 200, w.Code)
+		mockService.AssertExpectations(t)
+	})
+	t.Run("Deliveries", func(t *testing.T) {
+		deliveries := []*model.WebhookDelivery{{ID: 1, WebhookID: webhook.ID, Status: model.DeliveryDead, Attempts: 5}}
+		mockService.On("GetWebhookDeliveries", webhook.ID).Return(deliveries, nil).Once()
+		mockService.On("GetDeadLetters").Return(deliveries, nil).Once()
+		delivery := NewDelivery(mockService, &config.ServerConfig{})
+		expected, _ := json.Marshal(map[string]interface{}{
+			"result": deliveries,
+		})
+		for _, path := range []string{"/webhooks/1/deliveries", "/webhooks/dead-letters"} {
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", path, nil)
+			delivery.engine.ServeHTTP(w, req)
+			assert.Equal(t, 200, w.Code, path)
+			assert.JSONEq(t, string(expected), w.Body.String(), path)
+		}
+		mockService.AssertExpectations(t)
+	})
+	t.Run("InvalidWebhookID", func(t *testing.T) {
+		delivery := NewDelivery(mockService, &config.ServerConfig{})
+		for _, route := range []struct{ method, path string }{{"GET", "/webhooks/0"}, {"PUT", "/webhooks/abc"}, {"DELETE", "/webhooks/-1"}, {"GET", "/webhooks/4294967296/deliveries"}} {
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest(route.method, route.path, bytes.NewBufferString(`{"url":"https://example.com/hook"}`))
+			delivery.engine.ServeHTTP(w, req)
+			assert.Equal(t, errcode.InvalidParams.StatusCode(), w.Code, route.path)
+			assert.Contains(t, w.Body.String(), "wid: ")
+		}
+	})
+}
+
+func TestAPIKeys(t *testing.T) {
+	mockService := new(mocks.Service)
+	key := &model.APIKey{
+		ID:     1,
+		Name:   "ci",
+		Prefix: "tk_01234567",
+		Hash:   "0123456789abcdef",
+		Scopes: []string{"tasks:read"},
+	}
+	t.Run("GetAPIKeys", func(t *testing.T) {
+		mockService.On("GetAPIKeys").Return([]*model.APIKey{key}, nil).Once()
+		delivery := NewDelivery(mockService, &config.ServerConfig{})
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/admin/keys", nil)
+		delivery.engine.ServeHTTP(w, req)
+
+		assert.Equal(t, 200, w.Code)
+		assert.NotContains(t, w.Body.String(), key.Hash)
+		assert.Contains(t, w.Body.String(), `"prefix":"tk_01234567"`)
+		mockService.AssertExpectations(t)
+	})
+	t.Run("CreateAPIKey", func(t *testing.T) {
+		created := *key
+		created.Token = "tk_0123456789"
+		mockService.On("CreateAPIKey", &model.APIKey{Name: "ci", Scopes: []string{"tasks:read"}}).Return(&created, nil).Once()
+		delivery := NewDelivery(mockService, &config.ServerConfig{})
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/admin/keys", bytes.NewBufferString(`{"name":"ci","scopes":["tasks:read"]}`))
+		delivery.engine.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusCreated, w.Code)
+		assert.Contains(t, w.Body.String(), `"token":"tk_0123456789"`)
+		assert.NotContains(t, w.Body.String(), key.Hash)
+		mockService.AssertExpectations(t)
+	})
+	t.Run("CreateAPIKeyInvalidParams", func(t *testing.T) {
+		mockService := new(mocks.Service)
+		for _, body := range []string{`{"name":"ci"}`, `{"name":"ci","scopes":[]}`, `{"name":"ci","scopes":["tasks:delete"]}`, `{"scopes":["admin"]}`} {
+			delivery := NewDelivery(mockService, &config.ServerConfig{})
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("POST", "/admin/keys", bytes.NewBufferString(body))
+			delivery.engine.ServeHTTP(w, req)
+			assert.Equal(t, errcode.InvalidParams.StatusCode(), w.Code, body)
+		}
+		mockService.AssertNotCalled(t, "CreateAPIKey", mock.Anything)
+	})
+	t.Run("DeleteAPIKey", func(t *testing.T) {
+		mockService.On("DeleteAPIKey", uint32(1)).Return(nil).Once()
+		mockService.On("DeleteAPIKey", uint32(2)).Return(errcode.RecordNotExists).Once()
+		delivery := NewDelivery(mockService, &config.ServerConfig{})
+		for id, status := range map[string]int{"1": http.StatusOK, "2": http.StatusNotFound, "0": http.StatusBadRequest} {
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("DELETE", "/admin/keys/"+id, nil)
+			delivery.engine.ServeHTTP(w, req)
+			assert.Equal(t, status, w.Code, id)
+		}
+		mockService.AssertExpectations(t)
+	})
+}
+
+func TestTaskEvents(t *testing.T) {
+	projectID := uint32(1)
+	events := []*model.Event{
+		{Type: model.EventTaskCreated, Task: &model.Task{ID: 1, ProjectID: projectID, Name: "task1", Status: model.StatusTodo}},
+		{Type: model.EventTaskCreated, Task: &model.Task{ID: 2, Name: "task2", Status: model.StatusTodo}},
+		{Type: model.EventTaskUpdated, Task: &model.Task{ID: 1, ProjectID: projectID, Name: "task1", Status: model.StatusDone}},
+		{Type: model.EventTaskDeleted, Task: &model.Task{ID: 2, Name: "task2", Status: model.StatusTodo}},
+	}
+	// subscribe replays the events after lastID on a subscription that ends
+	// once they are read.
+	subscribe := func(lastID uint64) *event.Subscription {
+		bus := event.NewBus(10)
+		for _, e := range events {
+			bus.Publish(e)
+		}
+		sub := bus.Subscribe(lastID)
+		bus.Close()
+		return sub
+	}
+	frame := func(e *model.Event) string {
+		data, _ := json.Marshal(e)
+		return fmt.Sprintf("id:%d\nevent:%s\ndata:%s\n\n", e.ID, e.Type, data)
+	}
+	t.Run("Stream", func(t *testing.T) {
+		mockService := new(mocks.Service)
+		mockService.On("SubscribeEvents", uint64(0)).Return(subscribe(0)).Once()
+		delivery := NewDelivery(mockService, &config.ServerConfig{})
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/tasks/events", nil)
+		delivery.engine.ServeHTTP(w, req)
+
+		assert.Equal(t, 200, w.Code)
+		assert.Equal(t, "text/event-stream", w.Header().Get("Content-Type"))
+		assert.Equal(t, frame(events[0])+frame(events[1])+frame(events[2])+frame(events[3]), w.Body.String())
+		mockService.AssertExpectations(t)
+	})
+	t.Run("Filter", func(t *testing.T) {
+		mockService := new(mocks.Service)
+		mockService.On("SubscribeEvents", uint64(0)).Return(subscribe(0)).Once()
+		mockService.On("SubscribeEvents", uint64(0)).Return(subscribe(0)).Once()
+		delivery := NewDelivery(mockService, &config.ServerConfig{})
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/tasks/events?project_id=1", nil)
+		delivery.engine.ServeHTTP(w, req)
+		assert.Equal(t, frame(events[0])+frame(events[2]), w.Body.String())
+
+		w = httptest.NewRecorder()
+		req, _ = http.NewRequest("GET", "/tasks/events?project_id=0&status=todo", nil)
+		delivery.engine.ServeHTTP(w, req)
+		assert.Equal(t, frame(events[1])+frame(events[3]), w.Body.String())
+		mockService.AssertExpectations(t)
+	})
+	t.Run("LastEventID", func(t *testing.T) {
+		mockService := new(mocks.Service)
+		mockService.On("SubscribeEvents", uint64(2)).Return(subscribe(2)).Once()
+		mockService.On("SubscribeEvents", uint64(3)).Return(subscribe(3)).Once()
+		delivery := NewDelivery(mockService, &config.ServerConfig{})
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/tasks/events?last_event_id=2", nil)
+		delivery.engine.ServeHTTP(w, req)
+		assert.Equal(t, frame(events[2])+frame(events[3]), w.Body.String())
+
+		w = httptest.NewRecorder()
+		req, _ = http.NewRequest("GET", "/tasks/events?last_event_id=2", nil)
+		req.Header.Set("Last-Event-ID", "3")
+		delivery.engine.ServeHTTP(w, req)
+		assert.Equal(t, frame(events[3]), w.Body.String())
+		mockService.AssertExpectations(t)
+	})
+	t.Run("Shutdown", func(t *testing.T) {
+		bus := event.NewBus(10)
+		mockService := new(mocks.Service)
+		mockService.On("SubscribeEvents", uint64(0)).Return(bus.Subscribe(0)).Once()
+		delivery := NewDelivery(mockService, &config.ServerConfig{})
+		done := make(chan struct{})
+		go func() {
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", "/tasks/events", nil)
+			delivery.engine.ServeHTTP(w, req)
+			close(done)
+		}()
+		assert.NoError(t, delivery.Shutdown(context.Background()))
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatal("event stream still open after shutdown")
+		}
+	})
+	t.Run("InvalidParams", func(t *testing.T) {
+		mockService := new(mocks.Service)
+		delivery := NewDelivery(mockService, &config.ServerConfig{})
+		for _, query := range []string{"status=doing", "project_id=a", "project_id=-1", "last_event_id=-1"} {
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", "/tasks/events?"+query, nil)
+			delivery.engine.ServeHTTP(w, req)
+			assert.Equal(t, errcode.InvalidParams.StatusCode(), w.Code, query)
+		}
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/tasks/events", nil)
+		req.Header.Set("Last-Event-ID", "abc")
+		delivery.engine.ServeHTTP(w, req)
+		assert.Equal(t, errcode.InvalidParams.StatusCode(), w.Code)
+		mockService.AssertNotCalled(t, "SubscribeEvents", mock.Anything)
+	})
+}
+
+func TestGraphQL(t *testing.T) {
+	mockService := new(mocks.Service)
+	mockService.On("GetTask", uint32(1)).Return(&model.Task{ID: 1, Name: "task1", Status: model.StatusTodo}, nil).Once()
+	delivery := NewDelivery(mockService, &config.ServerConfig{})
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/graphql", bytes.NewBufferString(`{"query":"{ task(id: \"1\") { name status } }"}`))
+	req.Header.Set("Content-Type", "application/json")
+	delivery.engine.ServeHTTP(w, req)
+
+	assert.Equal(t, 200, w.Code)
+	assert.JSONEq(t, `{"data":{"task":{"name":"task1","status":"TODO"}}}`, w.Body.String())
+	mockService.AssertExpectations(t)
+}
@@ -0,0 +1,550 @@
+package delivery
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/gin-contrib/sse"
+	"github.com/gin-gonic/gin"
+	swaggerFiles "github.com/swaggo/files/v2"
+	"github.com/wagaru/task/internal/auth"
+	"github.com/wagaru/task/internal/errcode"
+	"github.com/wagaru/task/internal/model"
+)
+
+// docsPage is the Swagger UI entry page, pointed at /openapi.json. The rest
+// of the UI is served from swaggerFiles.
+//
+//go:embed docs.html
+var docsPage []byte
+
+// operation describes one route of buildRoute. Path parameters are looked up
+// by name, query parameters come from the form tags of query, and body and
+// result are reflected into the request body and the result envelope.
+type operation struct {
+	method  string
+	path    string
+	id      string
+	tag     string
+	summary string
+	query   interface{}
+	params  openapi3.Parameters
+	body    interface{}
+	// scope is what an API key needs for the route; routes without one are
+	// open.
+	scope string
+	// status is the status of a successful response, 200 when unset.
+	status int
+	// result is what the response carries in result; nil means an empty
+	// object is returned.
+	result interface{}
+	// requestBody and response replace the ones derived from body and
+	// result, for the routes that do not take or return plain JSON.
+	requestBody func(g *schemaGenerator) *openapi3.RequestBody
+	response    func(g *schemaGenerator) *openapi3.Response
+	// noErrors is set for the routes that do not answer with the Error
+	// schema.
+	noErrors bool
+}
+
+var operations = []operation{
+	{method: http.MethodGet, path: "/tasks", id: "GetTasks", tag: "tasks", summary: "列出任務", scope: auth.ScopeTasksRead, query: GetTasksRequest{}, response: taskListResponse},
+	{method: http.MethodGet, path: "/tasks/events", id: "GetTaskEvents", tag: "tasks", summary: "以 Server-Sent Events 接收任務事件", scope: auth.ScopeTasksRead, query: TaskEventsRequest{},
+		params:   openapi3.Parameters{{Value: openapi3.NewHeaderParameter("Last-Event-ID").WithSchema(openapi3.NewInt64Schema().WithMin(0))}},
+		response: eventStreamResponse},
+	{method: http.MethodGet, path: "/tasks/ws", id: "TaskSocket", tag: "tasks", summary: "以 WebSocket 收送任務事件與指令", scope: auth.ScopeTasksRead, status: http.StatusSwitchingProtocols,
+		response: func(g *schemaGenerator) *openapi3.Response {
+			return openapi3.NewResponse().WithDescription("升級為 WebSocket 連線")
+		}},
+	{method: http.MethodGet, path: "/tasks/{id}", id: "GetTask", tag: "tasks", summary: "取得任務", scope: auth.ScopeTasksRead, result: &model.Task{}},
+	{method: http.MethodPost, path: "/tasks", id: "CreateTask", tag: "tasks", summary: "新增任務", scope: auth.ScopeTasksWrite, body: CreateTaskRequest{}, status: http.StatusCreated, result: &model.Task{}},
+	{method: http.MethodPut, path: "/tasks/{id}", id: "UpdateTask", tag: "tasks", summary: "更新任務", scope: auth.ScopeTasksWrite, body: UpdateTaskRequest{}, result: &model.Task{}},
+	{method: http.MethodPatch, path: "/tasks/{id}", id: "PatchTask", tag: "tasks", summary: "部分更新任務", scope: auth.ScopeTasksWrite, requestBody: patchRequestBody, result: &model.Task{}},
+	{method: http.MethodDelete, path: "/tasks/{id}", id: "DeleteTask", tag: "tasks", summary: "刪除任務", scope: auth.ScopeTasksWrite},
+	{method: http.MethodGet, path: "/tasks/{id}/children", id: "GetTaskChildren", tag: "tasks", summary: "列出子任務", scope: auth.ScopeTasksRead, query: GetTasksRequest{}, response: taskListResponse},
+	{method: http.MethodPost, path: "/tasks/{id}/tags", id: "AddTaskTags", tag: "tasks", summary: "為任務加上標籤", scope: auth.ScopeTasksWrite, body: TaskTagsRequest{}, result: &model.Task{}},
+	{method: http.MethodDelete, path: "/tasks/{id}/tags/{tag}", id: "RemoveTaskTag", tag: "tasks", summary: "移除任務的標籤", scope: auth.ScopeTasksWrite, result: &model.Task{}},
+	{method: http.MethodGet, path: "/tasks/{id}/dependencies", id: "GetTaskDependencies", tag: "tasks", summary: "列出任務的前置與後續任務", scope: auth.ScopeTasksRead, result: &model.TaskDependencies{}},
+	{method: http.MethodPost, path: "/tasks/{id}/dependencies", id: "AddTaskBlockers", tag: "tasks", summary: "加上前置任務", scope: auth.ScopeTasksWrite, body: TaskBlockersRequest{}, result: &model.Task{}},
+	{method: http.MethodDelete, path: "/tasks/{id}/dependencies/{blocker}", id: "RemoveTaskBlocker", tag: "tasks", summary: "移除前置任務", scope: auth.ScopeTasksWrite, result: &model.Task{}},
+	{method: http.MethodGet, path: "/tags", id: "GetTags", tag: "tasks", summary: "列出標籤與使用次數", scope: auth.ScopeTasksRead, result: []*model.Tag{}},
+	{method: http.MethodGet, path: "/projects", id: "GetProjects", tag: "projects", summary: "列出專案", scope: auth.ScopeTasksRead, result: []*model.Project{}},
+	{method: http.MethodGet, path: "/projects/{pid}", id: "GetProject", tag: "projects", summary: "取得專案", scope: auth.ScopeTasksRead, result: &model.Project{}},
+	{method: http.MethodPost, path: "/projects", id: "CreateProject", tag: "projects", summary: "新增專案", scope: auth.ScopeTasksWrite, body: ProjectRequest{}, status: http.StatusCreated, result: &model.Project{}},
+	{method: http.MethodPut, path: "/projects/{pid}", id: "UpdateProject", tag: "projects", summary: "更新專案", scope: auth.ScopeTasksWrite, body: ProjectRequest{}, result: &model.Project{}},
+	{method: http.MethodDelete, path: "/projects/{pid}", id: "DeleteProject", tag: "projects", summary: "刪除專案", scope: auth.ScopeTasksWrite, query: DeleteProjectRequest{}},
+	{method: http.MethodGet, path: "/projects/{pid}/tasks", id: "GetProjectTasks", tag: "projects", summary: "列出專案中的任務", scope: auth.ScopeTasksRead, query: GetTasksRequest{}, response: taskListResponse},
+	{method: http.MethodPost, path: "/projects/{pid}/tasks", id: "CreateProjectTask", tag: "projects", summary: "在專案中新增任務", scope: auth.ScopeTasksWrite, body: CreateTaskRequest{}, status: http.StatusCreated, result: &model.Task{}},
+	{method: http.MethodGet, path: "/webhooks", id: "GetWebhooks", tag: "webhooks", summary: "列出 webhook", scope: auth.ScopeWebhooksRead, result: []*model.Webhook{}},
+	{method: http.MethodGet, path: "/webhooks/dead-letters", id: "GetDeadLetters", tag: "webhooks", summary: "列出送達失敗的事件", scope: auth.ScopeWebhooksRead, result: []*model.WebhookDelivery{}},
+	{method: http.MethodGet, path: "/webhooks/{wid}", id: "GetWebhook", tag: "webhooks", summary: "取得 webhook", scope: auth.ScopeWebhooksRead, result: &model.Webhook{}},
+	{method: http.MethodPost, path: "/webhooks", id: "CreateWebhook", tag: "webhooks", summary: "新增 webhook", scope: auth.ScopeWebhooksWrite, body: WebhookRequest{}, status: http.StatusCreated, result: &model.Webhook{}},
+	{method: http.MethodPut, path: "/webhooks/{wid}", id: "UpdateWebhook", tag: "webhooks", summary: "更新 webhook", scope: auth.ScopeWebhooksWrite, body: WebhookRequest{}, result: &model.Webhook{}},
+	{method: http.MethodDelete, path: "/webhooks/{wid}", id: "DeleteWebhook", tag: "webhooks", summary: "刪除 webhook", scope: auth.ScopeWebhooksWrite},
+	{method: http.MethodGet, path: "/webhooks/{wid}/deliveries", id: "GetWebhookDeliveries", tag: "webhooks", summary: "列出 webhook 的送達紀錄", scope: auth.ScopeWebhooksRead, result: []*model.WebhookDelivery{}},
+	{method: http.MethodGet, path: "/admin/keys", id: "GetAPIKeys", tag: "admin", summary: "列出 API 金鑰", scope: auth.ScopeAdmin, result: []*model.APIKey{}},
+	{method: http.MethodPost, path: "/admin/keys", id: "CreateAPIKey", tag: "admin", summary: "新增 API 金鑰，token 只在此時回傳", scope: auth.ScopeAdmin, body: APIKeyRequest{}, status: http.StatusCreated, result: &model.APIKey{}},
+	{method: http.MethodDelete, path: "/admin/keys/{kid}", id: "DeleteAPIKey", tag: "admin", summary: "撤銷 API 金鑰", scope: auth.ScopeAdmin},
+	{method: http.MethodPost, path: "/graphql", id: "GraphQL", tag: "graphql", summary: "執行 GraphQL 查詢、變更或訂閱", scope: auth.ScopeTasksRead, requestBody: graphQLRequestBody, response: graphQLResponse, noErrors: true},
+	{method: http.MethodGet, path: "/openapi.json", id: "OpenAPI", tag: "docs", summary: "取得本文件", noErrors: true,
+		response: func(g *schemaGenerator) *openapi3.Response {
+			return openapi3.NewResponse().WithDescription("OpenAPI 3 文件").WithJSONSchema(openapi3.NewObjectSchema())
+		}},
+	{method: http.MethodGet, path: "/docs/{file}", id: "Docs", tag: "docs", summary: "API 文件介面", noErrors: true,
+		response: func(g *schemaGenerator) *openapi3.Response {
+			return openapi3.NewResponse().WithDescription("Swagger UI 的頁面與檔案").
+				WithContent(openapi3.NewContentWithSchema(openapi3.NewStringSchema(), []string{"text/html"}))
+		}},
+}
+
+var (
+	pathParamPattern = regexp.MustCompile(`\{(\w+)\}`)
+	ginParamPattern  = regexp.MustCompile(`[:*](\w+)`)
+)
+
+// specPath converts the path of a gin route to the form of the spec.
+func specPath(path string) string {
+	return ginParamPattern.ReplaceAllString(path, "{$1}")
+}
+
+// newSpec builds the OpenAPI 3 document for operations.
+func newSpec() *openapi3.T {
+	g := &schemaGenerator{schemas: openapi3.Schemas{}}
+	g.schemas["Error"] = errorSchema().NewRef()
+	errorResponse := &openapi3.ResponseRef{
+		Ref:   "#/components/responses/Error",
+		Value: openapi3.NewResponse().WithDescription("錯誤，HTTP 狀態碼依 code 而定").WithJSONSchemaRef(openapi3.NewSchemaRef("#/components/schemas/Error", g.schemas["Error"].Value)),
+	}
+	pathParams := g.pathParams(TaskURI{}, BlockerURI{}, ProjectURI{}, WebhookURI{}, APIKeyURI{})
+	pathParams["tag"] = openapi3.NewStringSchema().NewRef()
+	pathParams["file"] = openapi3.NewStringSchema().NewRef()
+
+	spec := &openapi3.T{
+		OpenAPI: "3.0.3",
+		Info: &openapi3.Info{
+			Title:   "Task API",
+			Version: "1.0.0",
+		},
+		Paths: openapi3.NewPaths(),
+		Components: &openapi3.Components{
+			Schemas:   g.schemas,
+			Responses: openapi3.ResponseBodies{"Error": &openapi3.ResponseRef{Value: errorResponse.Value}},
+			SecuritySchemes: openapi3.SecuritySchemes{
+				"apiKey": &openapi3.SecuritySchemeRef{Value: openapi3.NewSecurityScheme().WithType("apiKey").WithIn("header").WithName(apiKeyHeader)},
+				"bearer": &openapi3.SecuritySchemeRef{Value: openapi3.NewSecurityScheme().WithType("http").WithScheme("bearer").WithBearerFormat("API key or JWT")},
+			},
+		},
+	}
+	for _, op := range operations {
+		operation := openapi3.NewOperation()
+		operation.OperationID = op.id
+		operation.Summary = op.summary
+		operation.Tags = []string{op.tag}
+		for _, match := range pathParamPattern.FindAllStringSubmatch(op.path, -1) {
+			schema, ok := pathParams[match[1]]
+			if !ok {
+				panic(fmt.Sprintf("openapi: no schema for path parameter %s of %s", match[1], op.path))
+			}
+			operation.AddParameter(openapi3.NewPathParameter(match[1]).WithSchema(schema.Value))
+		}
+		if op.query != nil {
+			operation.Parameters = append(operation.Parameters, g.queryParams(op.query)...)
+		}
+		operation.Parameters = append(operation.Parameters, op.params...)
+		switch {
+		case op.requestBody != nil:
+			operation.RequestBody = &openapi3.RequestBodyRef{Value: op.requestBody(g)}
+		case op.body != nil:
+			body := openapi3.NewRequestBody().WithRequired(true).WithJSONSchemaRef(g.schema(reflect.TypeOf(op.body), true))
+			operation.RequestBody = &openapi3.RequestBodyRef{Value: body}
+		}
+
+		var response *openapi3.Response
+		if op.response != nil {
+			response = op.response(g)
+		} else {
+			result := openapi3.NewObjectSchema()
+			result.MaxProps = openapi3.Ptr(uint64(0))
+			schema := result.NewRef()
+			if op.result != nil {
+				schema = g.envelope(g.schema(reflect.TypeOf(op.result), false)).NewRef()
+			}
+			response = openapi3.NewResponse().WithDescription("成功").WithJSONSchemaRef(schema)
+		}
+		status := op.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		operation.Responses = openapi3.NewResponsesWithCapacity(2)
+		operation.Responses.Set(strconv.Itoa(status), &openapi3.ResponseRef{Value: response})
+		if !op.noErrors {
+			operation.Responses.Set("default", errorResponse)
+		}
+		if op.scope != "" {
+			operation.Description = fmt.Sprintf("需要 %s 權限。", op.scope)
+			operation.Security = &openapi3.SecurityRequirements{{"apiKey": []string{}}, {"bearer": []string{}}}
+			operation.Responses.Set(strconv.Itoa(http.StatusUnauthorized), errorResponse)
+			operation.Responses.Set(strconv.Itoa(http.StatusForbidden), errorResponse)
+		}
+
+		item := spec.Paths.Value(op.path)
+		if item == nil {
+			item = &openapi3.PathItem{}
+			spec.Paths.Set(op.path, item)
+		}
+		item.SetOperation(op.method, operation)
+	}
+	return spec
+}
+
+// errorSchema is the body ToErrorResponse writes, with the codes errcode
+// knows about.
+func errorSchema() *openapi3.Schema {
+	codes := make([]int, 0, len(errcode.ErrorList))
+	for code := range errcode.ErrorList {
+		codes = append(codes, code)
+	}
+	sort.Ints(codes)
+	code := openapi3.NewIntegerSchema()
+	lines := make([]string, 0, len(codes))
+	for _, c := range codes {
+		code.Enum = append(code.Enum, float64(c))
+		lines = append(lines, fmt.Sprintf("- %d：%s", c, errcode.ErrorList[c]))
+	}
+	code.Description = strings.Join(lines, "\n")
+	schema := openapi3.NewObjectSchema()
+	schema.Properties = openapi3.Schemas{
+		"code":    code.NewRef(),
+		"message": openapi3.NewStringSchema().NewRef(),
+		"details": openapi3.NewArraySchema().WithItems(openapi3.NewStringSchema()).NewRef(),
+	}
+	schema.Required = []string{"code", "message"}
+	return schema
+}
+
+func taskListResponse(g *schemaGenerator) *openapi3.Response {
+	result := &openapi3.Schema{
+		Description: "tree=true 時為巢狀的 TaskNode",
+		AnyOf: openapi3.SchemaRefs{
+			g.schema(reflect.TypeOf([]*model.Task{}), false),
+			g.schema(reflect.TypeOf([]*model.TaskNode{}), false),
+		},
+	}
+	schema := g.envelope(result.NewRef())
+	cursor := openapi3.NewStringSchema()
+	cursor.Description = "還有下一頁時才有，帶入 cursor 取得下一頁"
+	schema.Properties["next_cursor"] = cursor.NewRef()
+	return openapi3.NewResponse().WithDescription("成功").WithJSONSchema(schema)
+}
+
+func eventStreamResponse(g *schemaGenerator) *openapi3.Response {
+	stream := openapi3.NewStringSchema()
+	stream.Description = "每個事件的 id 為事件編號，event 為事件類型，data 為 Event 的 JSON"
+	return openapi3.NewResponse().WithDescription("事件串流").
+		WithContent(openapi3.NewContentWithSchema(stream, []string{sse.ContentType}))
+}
+
+// patchRequestBody takes the merge patch fields of setPatchField, or a JSON
+// Patch document.
+func patchRequestBody(g *schemaGenerator) *openapi3.RequestBody {
+	priority := openapi3.NewIntegerSchema().WithMin(0).WithMax(float64(model.MaxPriority))
+	merge := openapi3.NewObjectSchema().
+		WithProperty("name", openapi3.NewStringSchema().WithMinLength(1)).
+		WithPropertyRef("status", g.status(true)).
+		WithProperty("description", openapi3.NewStringSchema().WithMaxLength(2000).WithNullable()).
+		WithProperty("priority", priority).
+		WithProperty("due_at", openapi3.NewDateTimeSchema().WithNullable()).
+		WithProperty("recurrence", openapi3.NewStringSchema().WithMaxLength(200).WithNullable()).
+		WithProperty("parent_id", openapi3.NewIntegerSchema().WithMin(0).WithMax(math.MaxUint32).WithNullable())
+	jsonPatch := openapi3.NewArraySchema()
+	jsonPatch.Items = g.schema(reflect.TypeOf(JSONPatchOperation{}), true)
+	content := openapi3.NewContentWithSchema(merge, []string{mergePatchContentType, "application/json"})
+	content[jsonPatchContentType] = openapi3.NewMediaType().WithSchema(jsonPatch)
+	return openapi3.NewRequestBody().WithRequired(true).WithContent(content)
+}
+
+func graphQLRequestBody(g *schemaGenerator) *openapi3.RequestBody {
+	schema := openapi3.NewObjectSchema().
+		WithProperty("query", openapi3.NewStringSchema()).
+		WithProperty("operationName", openapi3.NewStringSchema()).
+		WithProperty("variables", openapi3.NewObjectSchema().WithAnyAdditionalProperties())
+	schema.Required = []string{"query"}
+	return openapi3.NewRequestBody().WithRequired(true).WithJSONSchema(schema)
+}
+
+// graphQLResponse is the GraphQL response. Errors carry the errcode code
+// and details in their extensions instead of the Error schema.
+func graphQLResponse(g *schemaGenerator) *openapi3.Response {
+	extensions := openapi3.NewObjectSchema().
+		WithProperty("code", openapi3.NewIntegerSchema()).
+		WithProperty("details", openapi3.NewArraySchema().WithItems(openapi3.NewStringSchema()))
+	queryError := openapi3.NewObjectSchema().
+		WithProperty("message", openapi3.NewStringSchema()).
+		WithProperty("path", openapi3.NewArraySchema().WithItems(&openapi3.Schema{})).
+		WithProperty("extensions", extensions)
+	schema := openapi3.NewObjectSchema().
+		WithProperty("data", openapi3.NewObjectSchema().WithAnyAdditionalProperties().WithNullable()).
+		WithProperty("errors", openapi3.NewArraySchema().WithItems(queryError))
+	stream := openapi3.NewStringSchema()
+	stream.Description = "Accept: text/event-stream 時，訂閱的每個結果為一個 next 事件，結束時為 complete 事件"
+	content := openapi3.NewContentWithJSONSchema(schema)
+	content[sse.ContentType] = openapi3.NewMediaType().WithSchema(stream)
+	return openapi3.NewResponse().WithDescription("GraphQL 回應").WithContent(content)
+}
+
+var (
+	timeType   = reflect.TypeOf(time.Time{})
+	statusType = reflect.TypeOf(model.Status(""))
+	rawType    = reflect.TypeOf(json.RawMessage{})
+)
+
+// schemaGenerator reflects Go types into schemas. Named structs go to
+// schemas and are referenced, so recursive types such as model.TaskNode
+// work.
+type schemaGenerator struct {
+	schemas openapi3.Schemas
+}
+
+// schema reflects t. Request structs follow their binding tags, while the
+// fields of responses are required unless json omits them when empty.
+// Pointers, slices and maps may be null.
+func (g *schemaGenerator) schema(t reflect.Type, request bool) *openapi3.SchemaRef {
+	nullable := false
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+		nullable = true
+	}
+	switch t {
+	case statusType:
+		return g.status(request)
+	case rawType:
+		return (&openapi3.Schema{}).NewRef()
+	}
+	var schema *openapi3.Schema
+	switch t.Kind() {
+	case reflect.Struct:
+		if t != timeType {
+			return g.object(t, request)
+		}
+		schema = openapi3.NewDateTimeSchema()
+	case reflect.Bool:
+		schema = openapi3.NewBoolSchema()
+	case reflect.String:
+		schema = openapi3.NewStringSchema()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		schema = openapi3.NewIntegerSchema()
+	case reflect.Uint8:
+		schema = openapi3.NewIntegerSchema().WithMin(0).WithMax(math.MaxUint8)
+	case reflect.Uint16:
+		schema = openapi3.NewIntegerSchema().WithMin(0).WithMax(math.MaxUint16)
+	case reflect.Uint32:
+		schema = openapi3.NewIntegerSchema().WithMin(0).WithMax(math.MaxUint32)
+	case reflect.Uint, reflect.Uint64:
+		schema = openapi3.NewIntegerSchema().WithMin(0)
+	case reflect.Float32, reflect.Float64:
+		schema = openapi3.NewFloat64Schema()
+	case reflect.Slice, reflect.Array:
+		schema = openapi3.NewArraySchema()
+		schema.Items = g.schema(t.Elem(), request)
+		nullable = t.Kind() == reflect.Slice
+	case reflect.Map:
+		schema = openapi3.NewObjectSchema()
+		schema.AdditionalProperties = openapi3.AdditionalProperties{Schema: g.schema(t.Elem(), request)}
+		nullable = true
+	default:
+		schema = &openapi3.Schema{}
+	}
+	schema.Nullable = nullable
+	return schema.NewRef()
+}
+
+// status is a task status. Requests may also give the legacy 0 and 1, as a
+// number or a string.
+func (g *schemaGenerator) status(request bool) *openapi3.SchemaRef {
+	if _, ok := g.schemas["Status"]; !ok {
+		schema := openapi3.NewStringSchema()
+		for _, status := range model.Statuses {
+			schema.Enum = append(schema.Enum, string(status))
+		}
+		g.schemas["Status"] = schema.NewRef()
+	}
+	ref := openapi3.NewSchemaRef("#/components/schemas/Status", g.schemas["Status"].Value)
+	if !request {
+		return ref
+	}
+	return (&openapi3.Schema{
+		OneOf: openapi3.SchemaRefs{
+			ref,
+			openapi3.NewStringSchema().WithEnum("0", "1").NewRef(),
+			openapi3.NewIntegerSchema().WithEnum(float64(0), float64(1)).NewRef(),
+		},
+	}).NewRef()
+}
+
+func (g *schemaGenerator) object(t reflect.Type, request bool) *openapi3.SchemaRef {
+	name := t.Name()
+	if ref, ok := g.schemas[name]; ok {
+		return openapi3.NewSchemaRef("#/components/schemas/"+name, ref.Value)
+	}
+	schema := openapi3.NewObjectSchema()
+	g.schemas[name] = schema.NewRef()
+	fields := openapi3.NewObjectSchema()
+	var embedded openapi3.SchemaRefs
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.Anonymous {
+			embedded = append(embedded, g.schema(field.Type, request))
+			continue
+		}
+		name, options, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if !field.IsExported() || name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		prop := g.schema(field.Type, request)
+		if field.Type.Kind() == reflect.Ptr && prop.Ref != "" {
+			prop = (&openapi3.Schema{AllOf: openapi3.SchemaRefs{prop}, Nullable: true}).NewRef()
+		}
+		required := options != "omitempty"
+		if request {
+			required = applyBinding(prop, field.Tag.Get("binding"))
+		}
+		fields.WithPropertyRef(name, prop)
+		if required {
+			fields.Required = append(fields.Required, name)
+		}
+	}
+	if len(embedded) == 0 {
+		*schema = *fields
+	} else {
+		*schema = openapi3.Schema{AllOf: append(embedded, fields.NewRef())}
+	}
+	return openapi3.NewSchemaRef("#/components/schemas/"+name, schema)
+}
+
+// pathParams collects the uri fields of the structs the handlers bind path
+// parameters with.
+func (g *schemaGenerator) pathParams(uris ...interface{}) map[string]*openapi3.SchemaRef {
+	params := make(map[string]*openapi3.SchemaRef)
+	for _, uri := range uris {
+		t := reflect.TypeOf(uri)
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if name := field.Tag.Get("uri"); name != "" {
+				schema := g.schema(field.Type, true)
+				applyBinding(schema, field.Tag.Get("binding"))
+				params[name] = schema
+			}
+		}
+	}
+	return params
+}
+
+func (g *schemaGenerator) queryParams(query interface{}) openapi3.Parameters {
+	var params openapi3.Parameters
+	t := reflect.TypeOf(query)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := field.Tag.Get("form")
+		if name == "" {
+			continue
+		}
+		schema := g.schema(field.Type, true)
+		schema.Value.Nullable = false
+		param := openapi3.NewQueryParameter(name).WithRequired(applyBinding(schema, field.Tag.Get("binding")))
+		param.Schema = schema
+		params = append(params, &openapi3.ParameterRef{Value: param})
+	}
+	return params
+}
+
+// envelope wraps result the way the handlers respond.
+func (g *schemaGenerator) envelope(result *openapi3.SchemaRef) *openapi3.Schema {
+	schema := openapi3.NewObjectSchema().WithPropertyRef("result", result)
+	schema.Required = []string{"result"}
+	return schema
+}
+
+// applyBinding carries over the validator rules of a binding tag that have a
+// schema counterpart, and reports whether the field is required. Rules after
+// dive apply to the items.
+func applyBinding(ref *openapi3.SchemaRef, tag string) bool {
+	if tag == "" || ref.Ref != "" {
+		return false
+	}
+	schema := ref.Value
+	required := false
+	for _, rule := range strings.Split(tag, ",") {
+		name, param, _ := strings.Cut(rule, "=")
+		switch name {
+		case "dive":
+			if schema.Items == nil || schema.Items.Ref != "" {
+				return required
+			}
+			schema = schema.Items.Value
+		case "required":
+			if schema == ref.Value {
+				required = true
+				schema.Nullable = false
+			}
+			if schema.Type.Is(openapi3.TypeString) {
+				schema.MinLength = 1
+			}
+		case "min", "max":
+			n, err := strconv.ParseUint(param, 10, 64)
+			if err != nil {
+				continue
+			}
+			switch {
+			case schema.Type.Is(openapi3.TypeString) && name == "min":
+				schema.MinLength = n
+			case schema.Type.Is(openapi3.TypeString):
+				schema.MaxLength = &n
+			case schema.Type.Is(openapi3.TypeArray) && name == "min":
+				schema.MinItems = n
+			case schema.Type.Is(openapi3.TypeArray):
+				schema.MaxItems = &n
+			case name == "min":
+				schema.Min = openapi3.Ptr(float64(n))
+			default:
+				schema.Max = openapi3.Ptr(float64(n))
+			}
+		case "oneof":
+			for _, value := range strings.Fields(param) {
+				schema.Enum = append(schema.Enum, value)
+			}
+		case "url":
+			schema.Format = "uri"
+		case "excludesall":
+			chars := strings.ReplaceAll(param, "0x2C", ",")
+			schema.Pattern = "^[^" + regexp.QuoteMeta(chars) + "]*$"
+		}
+	}
+	return required
+}
+
+func (d *delivery) OpenAPI(c *gin.Context) {
+	c.JSON(http.StatusOK, d.spec)
+}
+
+// Docs serves Swagger UI under /docs/.
+func (d *delivery) Docs(c *gin.Context) {
+	switch c.Param("file") {
+	case "/", "/index.html":
+		c.Data(http.StatusOK, "text/html; charset=utf-8", docsPage)
+	default:
+		http.StripPrefix("/docs", http.FileServer(http.FS(swaggerFiles.FS))).ServeHTTP(c.Writer, c.Request)
+	}
+}
@@ -0,0 +1,109 @@
+package delivery
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/stretchr/testify/assert"
+	"github.com/wagaru/task/config"
+	"github.com/wagaru/task/internal/service/mocks"
+)
+
+func TestOpenAPI(t *testing.T) {
+	mockService := new(mocks.Service)
+	delivery := NewDelivery(mockService, &config.ServerConfig{})
+	t.Run("Valid", func(t *testing.T) {
+		assert.NoError(t, delivery.spec.Validate(context.Background()))
+	})
+	t.Run("Routes", func(t *testing.T) {
+		registered := make(map[string]bool)
+		for _, route := range delivery.engine.Routes() {
+			path := specPath(route.Path)
+			registered[route.Method+" "+path] = true
+			item := delivery.spec.Paths.Value(path)
+			if !assert.NotNil(t, item, "%s is missing from the spec", path) {
+				continue
+			}
+			assert.NotNil(t, item.GetOperation(route.Method), "%s %s is missing from the spec", route.Method, path)
+		}
+		for path, item := range delivery.spec.Paths.Map() {
+			for method := range item.Operations() {
+				assert.True(t, registered[method+" "+path], "%s %s is not a route", method, path)
+			}
+		}
+	})
+	t.Run("Schemas", func(t *testing.T) {
+		schemas := delivery.spec.Components.Schemas
+		create := schemas["CreateTaskRequest"].Value
+		assert.Equal(t, []string{"name"}, create.Required)
+		assert.Equal(t, uint64(1), create.Properties["name"].Value.MinLength)
+		assert.Equal(t, float64(5), *create.Properties["priority"].Value.Max)
+		assert.Equal(t, "^[^,]*$", create.Properties["tags"].Value.Items.Value.Pattern)
+		assert.Equal(t, []interface{}{"task.created", "task.updated", "task.deleted"}, schemas["WebhookRequest"].Value.Properties["events"].Value.Items.Value.Enum)
+		assert.Contains(t, schemas["Task"].Value.Required, "due_at")
+		assert.Equal(t, "date-time", schemas["Task"].Value.Properties["due_at"].Value.Format)
+		assert.NotContains(t, schemas["Task"].Value.Required, "tags")
+		assert.Equal(t, "#/components/schemas/Task", schemas["TaskNode"].Value.AllOf[0].Ref)
+		assert.ElementsMatch(t, []string{"code", "message"}, schemas["Error"].Value.Required)
+
+		params := delivery.spec.Paths.Value("/tasks").Get.Parameters
+		limit := params.GetByInAndName(openapi3.ParameterInQuery, "limit")
+		if assert.NotNil(t, limit) {
+			assert.Equal(t, float64(1000), *limit.Schema.Value.Max)
+		}
+		id := delivery.spec.Paths.Value("/tasks/{id}").Get.Parameters.GetByInAndName(openapi3.ParameterInPath, "id")
+		if assert.NotNil(t, id) {
+			assert.True(t, id.Required)
+			assert.Equal(t, float64(1), *id.Schema.Value.Min)
+		}
+	})
+	t.Run("Security", func(t *testing.T) {
+		assert.Contains(t, delivery.spec.Components.SecuritySchemes, "apiKey")
+		assert.Contains(t, delivery.spec.Components.SecuritySchemes, "bearer")
+		del := delivery.spec.Paths.Value("/tasks/{id}").Delete
+		if assert.NotNil(t, del.Security) {
+			assert.Len(t, *del.Security, 2)
+		}
+		assert.Equal(t, "需要 tasks:write 權限。", del.Description)
+		assert.NotNil(t, del.Responses.Status(http.StatusForbidden))
+		assert.Nil(t, delivery.spec.Paths.Value("/openapi.json").Get.Security)
+	})
+	t.Run("Serve", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/openapi.json", nil)
+		delivery.engine.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+		spec, err := openapi3.NewLoader().LoadFromData(w.Body.Bytes())
+		if assert.NoError(t, err) {
+			assert.NoError(t, spec.Validate(context.Background()))
+			assert.Equal(t, delivery.spec.Paths.Len(), spec.Paths.Len())
+		}
+		var doc map[string]interface{}
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &doc))
+		assert.Equal(t, "3.0.3", doc["openapi"])
+	})
+	t.Run("Docs", func(t *testing.T) {
+		for _, path := range []string{"/docs/", "/docs/index.html"} {
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", path, nil)
+			delivery.engine.ServeHTTP(w, req)
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.Contains(t, w.Body.String(), `url: "../openapi.json"`)
+		}
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/docs/swagger-ui-bundle.js", nil)
+		delivery.engine.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.True(t, strings.HasPrefix(w.Header().Get("Content-Type"), "text/javascript") || strings.HasPrefix(w.Header().Get("Content-Type"), "application/javascript"))
+
+		w = httptest.NewRecorder()
+		req, _ = http.NewRequest("GET", "/docs", nil)
+		delivery.engine.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusMovedPermanently, w.Code)
+	})
+}
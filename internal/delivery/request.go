@@ -1,10 +1,402 @@
 package delivery
 
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/wagaru/task/internal/errcode"
+	"github.com/wagaru/task/internal/model"
+)
+
+type TaskURI struct {
+	ID uint32 `uri:"id" binding:"required,min=1"`
+}
+
+// BlockerURI is the :blocker parameter of DELETE /tasks/:id/dependencies.
+type BlockerURI struct {
+	ID uint32 `uri:"blocker" binding:"required,min=1"`
+}
+
+type ProjectURI struct {
+	ID uint32 `uri:"pid" binding:"required,min=1"`
+}
+
+type WebhookURI struct {
+	ID uint32 `uri:"wid" binding:"required,min=1"`
+}
+
+type APIKeyURI struct {
+	ID uint32 `uri:"kid" binding:"required,min=1"`
+}
+
+type GetTasksRequest struct {
+	ProjectID *uint32 `form:"project_id"`
+	ParentID  *uint32 `form:"parent_id"`
+	Status    string  `form:"status"`
+	Name      string  `form:"name"`
+	Tags      string  `form:"tags"`
+	TagMatch  string  `form:"tag_match"`
+	Sort      string  `form:"sort"`
+	Limit     int     `form:"limit" binding:"omitempty,min=1,max=1000"`
+	Cursor    string  `form:"cursor"`
+	Tree      bool    `form:"tree"`
+	Order     string  `form:"order"`
+}
+
+const defaultTasksLimit = 100
+
+// orderTopological lists tasks after their blockers.
+const orderTopological = "topological"
+
+const statusDetail = "status: 只能是 todo、in_progress、blocked、done、cancelled，或舊版的 0、1"
+
+// Query converts the request into a model.TaskQuery. Sort takes the form
+// field[:asc|desc].
+func (r *GetTasksRequest) Query() (*model.TaskQuery, error) {
+	query := &model.TaskQuery{
+		ProjectID: r.ProjectID,
+		ParentID:  r.ParentID,
+		Name:      r.Name,
+		Sort:      model.SortByID,
+		Limit:     r.Limit,
+		Cursor:    r.Cursor,
+	}
+	if query.Limit == 0 {
+		query.Limit = defaultTasksLimit
+	}
+	if r.Tree && r.Cursor != "" {
+		return nil, errcode.InvalidParams.WithDetails("tree: 不能與 cursor 一起使用")
+	}
+	switch r.Order {
+	case "":
+	case orderTopological:
+		if r.Cursor != "" || r.Tree {
+			return nil, errcode.InvalidParams.WithDetails("order: 不能與 cursor 或 tree 一起使用")
+		}
+	default:
+		return nil, errcode.InvalidParams.WithDetails("order: 只能是 topological")
+	}
+	if r.Status != "" {
+		status, err := model.ParseStatus(r.Status)
+		if err != nil {
+			return nil, errcode.InvalidParams.WithDetails(statusDetail)
+		}
+		query.Status = &status
+	}
+	if r.Tags != "" {
+		tags := strings.Split(r.Tags, ",")
+		for _, tag := range tags {
+			if len([]rune(tag)) > model.MaxTagLength {
+				return nil, errcode.InvalidParams.WithDetails(fmt.Sprintf("tags: 每個標籤最長 %d 個字元", model.MaxTagLength))
+			}
+		}
+		query.Tags = model.NormalizeTags(tags)
+	}
+	switch r.TagMatch {
+	case "", "any":
+	case "all":
+		query.AllTags = true
+	default:
+		return nil, errcode.InvalidParams.WithDetails("tag_match: 只能是 any 或 all")
+	}
+	if r.Sort != "" {
+		field, direction, _ := strings.Cut(r.Sort, ":")
+		switch field {
+		case model.SortByID, model.SortByName, model.SortByCreatedAt:
+			query.Sort = field
+		default:
+			return nil, errcode.InvalidParams.WithDetails("sort: 只能依 id、name 或 created_at 排序")
+		}
+		switch direction {
+		case "", "asc":
+		case "desc":
+			query.Desc = true
+		default:
+			return nil, errcode.InvalidParams.WithDetails("sort: 排序方向只能是 asc 或 desc")
+		}
+	}
+	return query, nil
+}
+
+// TaskEventsRequest filters the event stream. LastEventID stands in for the
+// Last-Event-ID header, which EventSource cannot send on its first request.
+type TaskEventsRequest struct {
+	ProjectID   *uint32 `json:"project_id" form:"project_id"`
+	Status      string  `json:"status" form:"status"`
+	LastEventID uint64  `json:"last_event_id" form:"last_event_id"`
+}
+
+// Match returns whether an event is about a task the client asked for.
+func (r *TaskEventsRequest) Match() (func(*model.Event) bool, error) {
+	var status *model.Status
+	if r.Status != "" {
+		parsed, err := model.ParseStatus(r.Status)
+		if err != nil {
+			return nil, errcode.InvalidParams.WithDetails(statusDetail)
+		}
+		status = &parsed
+	}
+	return func(e *model.Event) bool {
+		if r.ProjectID != nil && e.Task.ProjectID != *r.ProjectID {
+			return false
+		}
+		return status == nil || e.Task.Status == *status
+	}, nil
+}
+
 type CreateTaskRequest struct {
-	Name string `json:"name" form:"name" binding:"required"`
+	ProjectID   uint32     `json:"project_id" form:"project_id"`
+	ParentID    uint32     `json:"parent_id" form:"parent_id"`
+	Name        string     `json:"name" form:"name" binding:"required"`
+	Description string     `json:"description" form:"description" binding:"max=2000"`
+	Priority    uint8      `json:"priority" form:"priority" binding:"max=5"`
+	DueAt       *time.Time `json:"due_at" form:"due_at" time_format:"2006-01-02T15:04:05Z07:00"`
+	Recurrence  string     `json:"recurrence" form:"recurrence" binding:"max=200"`
+	Tags        []string   `json:"tags" form:"tags" binding:"dive,max=32,excludesall=0x2C"`
+	BlockedBy   []uint32   `json:"blocked_by" form:"blocked_by" binding:"dive,min=1"`
+}
+
+func (r *CreateTaskRequest) Task() *model.Task {
+	return &model.Task{
+		ProjectID:   r.ProjectID,
+		ParentID:    r.ParentID,
+		Name:        r.Name,
+		Description: r.Description,
+		Priority:    r.Priority,
+		DueAt:       r.DueAt,
+		Recurrence:  r.Recurrence,
+		Tags:        r.Tags,
+		BlockedBy:   r.BlockedBy,
+	}
+}
+
+// TaskTagsRequest lists tags to attach to a task. Tags are compared
+// case-insensitively and may not contain commas, which separate them in the
+// tags query parameter.
+type TaskTagsRequest struct {
+	Tags []string `json:"tags" form:"tags" binding:"required,min=1,dive,required,max=32,excludesall=0x2C"`
+}
+
+// TaskBlockersRequest lists tasks that have to be finished before a task.
+type TaskBlockersRequest struct {
+	BlockedBy []uint32 `json:"blocked_by" form:"blocked_by" binding:"required,min=1,dive,min=1"`
 }
 
 type UpdateTaskRequest struct {
-	Name   string `json:"name" form:"name" binding:"required"`
-	Status *uint8 `json:"status" form:"status" binding:"required,oneof=0 1"`
+	Name        string        `json:"name" form:"name" binding:"required"`
+	Status      *model.Status `json:"status" form:"status" binding:"required"`
+	Description string        `json:"description" form:"description" binding:"max=2000"`
+	Priority    uint8         `json:"priority" form:"priority" binding:"max=5"`
+	DueAt       *time.Time    `json:"due_at" form:"due_at" time_format:"2006-01-02T15:04:05Z07:00"`
+	Recurrence  string        `json:"recurrence" form:"recurrence" binding:"max=200"`
+}
+
+func (r *UpdateTaskRequest) Task() *model.Task {
+	return &model.Task{
+		Name:        r.Name,
+		Status:      *r.Status,
+		Description: r.Description,
+		Priority:    r.Priority,
+		DueAt:       r.DueAt,
+		Recurrence:  r.Recurrence,
+	}
+}
+
+type ProjectRequest struct {
+	Name        string `json:"name" form:"name" binding:"required,max=200"`
+	Description string `json:"description" form:"description" binding:"max=2000"`
+}
+
+func (r *ProjectRequest) Project() *model.Project {
+	return &model.Project{
+		Name:        r.Name,
+		Description: r.Description,
+	}
+}
+
+// WebhookRequest subscribes URL to task events. Leaving out events
+// subscribes to all of them; active defaults to true.
+type WebhookRequest struct {
+	URL    string   `json:"url" form:"url" binding:"required,url,max=2000"`
+	Events []string `json:"events" form:"events" binding:"dive,oneof=task.created task.updated task.deleted"`
+	Secret string   `json:"secret" form:"secret" binding:"max=200"`
+	Active *bool    `json:"active" form:"active"`
+}
+
+func (r *WebhookRequest) Webhook() *model.Webhook {
+	webhook := &model.Webhook{
+		URL:    r.URL,
+		Events: r.Events,
+		Secret: r.Secret,
+		Active: true,
+	}
+	if r.Active != nil {
+		webhook.Active = *r.Active
+	}
+	return webhook
+}
+
+// APIKeyRequest creates a key with the given scopes; its token is only
+// returned in the response.
+type APIKeyRequest struct {
+	Name   string   `json:"name" form:"name" binding:"required,max=100"`
+	Scopes []string `json:"scopes" form:"scopes" binding:"required,min=1,dive,oneof=tasks:read tasks:write webhooks:read webhooks:write admin"`
+}
+
+func (r *APIKeyRequest) APIKey() *model.APIKey {
+	return &model.APIKey{
+		Name:   r.Name,
+		Scopes: r.Scopes,
+	}
+}
+
+type DeleteProjectRequest struct {
+	Cascade bool `form:"cascade"`
+}
+
+const (
+	mergePatchContentType = "application/merge-patch+json"
+	jsonPatchContentType  = "application/json-patch+json"
+)
+
+type JSONPatchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	From  string          `json:"from"`
+	Value json.RawMessage `json:"value"`
+}
+
+// parseMergePatch reads an RFC 7396 document. A null member removes the
+// field where the task can be without it: description and recurrence become
+// empty, due_at is cleared and parent_id moves the task to the top level.
+// Name, status and priority are required, so null is rejected for them like
+// any other invalid value.
+func parseMergePatch(body io.Reader) (*model.TaskPatch, error) {
+	var doc map[string]json.RawMessage
+	if err := json.NewDecoder(body).Decode(&doc); err != nil || doc == nil {
+		return nil, errcode.InvalidParams.WithDetails("body: 必須是 JSON 物件")
+	}
+	patch := &model.TaskPatch{}
+	var details []string
+	for field, value := range doc {
+		if detail := setPatchField(patch, field, value); detail != "" {
+			details = append(details, detail)
+		}
+	}
+	if len(details) > 0 {
+		sort.Strings(details)
+		return nil, errcode.InvalidParams.WithDetails(details...)
+	}
+	return patch, nil
+}
+
+// parseJSONPatch reads an RFC 6902 document. Operations are limited to the
+// top-level task fields; a "test" becomes a precondition on the stored task
+// unless an earlier operation already set the field.
+func parseJSONPatch(body io.Reader) (*model.TaskPatch, error) {
+	var ops []JSONPatchOperation
+	if err := json.NewDecoder(body).Decode(&ops); err != nil {
+		return nil, errcode.InvalidParams.WithDetails("body: 必須是 JSON Patch 陣列")
+	}
+	patch := &model.TaskPatch{}
+	expect := &model.TaskPatch{}
+	set := make(map[string]bool)
+	for i, op := range ops {
+		field, ok := patchPath(op.Path)
+		if !ok {
+			return nil, errcode.InvalidParams.WithDetails(fmt.Sprintf("%d.path: 不支援的路徑 %q", i, op.Path))
+		}
+		switch op.Op {
+		case "add", "replace":
+			if detail := setPatchField(patch, field, op.Value); detail != "" {
+				return nil, errcode.InvalidParams.WithDetails(fmt.Sprintf("%d.%s", i, detail))
+			}
+			set[field] = true
+		case "test":
+			if !set[field] {
+				if detail := setPatchField(expect, field, op.Value); detail != "" {
+					return nil, errcode.InvalidParams.WithDetails(fmt.Sprintf("%d.%s", i, detail))
+				}
+				continue
+			}
+			test := &model.TaskPatch{}
+			if detail := setPatchField(test, field, op.Value); detail != "" {
+				return nil, errcode.InvalidParams.WithDetails(fmt.Sprintf("%d.%s", i, detail))
+			}
+			var task model.Task
+			patch.Apply(&task)
+			if !test.Matches(&task) {
+				return nil, errcode.PatchTestFailed
+			}
+		default:
+			return nil, errcode.InvalidParams.WithDetails(fmt.Sprintf("%d.op: 不支援的操作 %q", i, op.Op))
+		}
+	}
+	if *expect != (model.TaskPatch{}) {
+		patch.Expect = expect
+	}
+	return patch, nil
+}
+
+func patchPath(path string) (string, bool) {
+	if !strings.HasPrefix(path, "/") || strings.Count(path, "/") != 1 {
+		return "", false
+	}
+	return strings.NewReplacer("~1", "/", "~0", "~").Replace(path[1:]), true
+}
+
+func setPatchField(patch *model.TaskPatch, field string, value json.RawMessage) string {
+	null := string(value) == "null"
+	switch field {
+	case "name":
+		var name string
+		if null || json.Unmarshal(value, &name) != nil || name == "" {
+			return "name: 必須是非空字串"
+		}
+		patch.Name = &name
+	case "status":
+		var status model.Status
+		if null || json.Unmarshal(value, &status) != nil {
+			return statusDetail
+		}
+		patch.Status = &status
+	case "description":
+		var description string
+		if !null && json.Unmarshal(value, &description) != nil || len(description) > 2000 {
+			return "description: 必須是長度不超過 2000 的字串"
+		}
+		patch.Description = &description
+	case "priority":
+		var priority uint8
+		if null || json.Unmarshal(value, &priority) != nil || priority > model.MaxPriority {
+			return fmt.Sprintf("priority: 必須是 0 到 %d 之間的整數", model.MaxPriority)
+		}
+		patch.Priority = &priority
+	case "due_at":
+		var dueAt time.Time
+		if !null && json.Unmarshal(value, &dueAt) != nil {
+			return "due_at: 必須是 RFC 3339 格式的時間"
+		}
+		patch.DueAt = &dueAt
+	case "recurrence":
+		var rule string
+		if !null && json.Unmarshal(value, &rule) != nil || len(rule) > 200 {
+			return "recurrence: 必須是長度不超過 200 的字串或 null"
+		}
+		patch.Recurrence = &rule
+	case "parent_id":
+		var parentID uint32
+		if !null && json.Unmarshal(value, &parentID) != nil {
+			return fmt.Sprintf("parent_id: 必須是 0 到 %d 之間的整數或 null", uint32(math.MaxUint32))
+		}
+		patch.ParentID = &parentID
+	default:
+		return fmt.Sprintf("%s: 不可修改的欄位", field)
+	}
+	return ""
 }
@@ -13,6 +13,13 @@ func (d *delivery) ToResponse(c *gin.Context, status int, data interface{}) {
 }
 
 func (d *delivery) ToErrorResponse(c *gin.Context, err error) {
+	status, data := errorBody(err)
+	c.JSON(status, data)
+}
+
+// errorBody is the payload ToErrorResponse writes for err, and its HTTP
+// status.
+func errorBody(err error) (int, gin.H) {
 	e, ok := err.(*errcode.Error)
 	if !ok {
 		e = errcode.UnknownError
@@ -22,5 +29,8 @@ func (d *delivery) ToErrorResponse(c *gin.Context, err error) {
 		"code":    e.Code(),
 		"message": e.Message(),
 	}
-	c.JSON(e.StatusCode(), data)
+	if details := e.Details(); len(details) > 0 {
+		data["details"] = details
+	}
+	return e.StatusCode(), data
 }
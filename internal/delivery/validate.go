@@ -0,0 +1,290 @@
+package delivery
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/wagaru/task/internal/errcode"
+)
+
+// validator checks requests, and when responses is set the responses too,
+// against the OpenAPI document, so that the handlers and the document cannot
+// drift apart unnoticed.
+type validator struct {
+	routes    map[string]*routers.Route
+	responses bool
+}
+
+func newValidator(spec *openapi3.T, responses bool) *validator {
+	v := &validator{
+		routes:    make(map[string]*routers.Route),
+		responses: responses,
+	}
+	for path, item := range spec.Paths.Map() {
+		for method, operation := range item.Operations() {
+			v.routes[method+" "+path] = &routers.Route{
+				Spec:      spec,
+				Path:      path,
+				PathItem:  item,
+				Method:    method,
+				Operation: operation,
+			}
+		}
+	}
+	return v
+}
+
+// Validate rejects a request that does not match its operation with
+// InvalidParams, one detail per field. A response that does not match is
+// only logged, since it has already been sent.
+func (d *delivery) Validate(c *gin.Context) {
+	route, ok := d.validator.routes[c.Request.Method+" "+specPath(c.FullPath())]
+	if !ok {
+		c.Next()
+		return
+	}
+	params := make(map[string]string, len(c.Params))
+	for _, param := range c.Params {
+		params[param.Key] = param.Value
+	}
+	input := &openapi3filter.RequestValidationInput{
+		Request:    jsonRequest(c, route.Operation),
+		PathParams: params,
+		Route:      route,
+		Options: &openapi3filter.Options{
+			MultiError:         true,
+			AuthenticationFunc: openapi3filter.NoopAuthenticationFunc,
+		},
+	}
+	err := openapi3filter.ValidateRequest(c.Request.Context(), input)
+	c.Request.Body = input.Request.Body
+	if err != nil {
+		d.ToErrorResponse(c, errcode.InvalidParams.WithDetails(requestDetails(err)...))
+		c.Abort()
+		return
+	}
+	if !d.validator.responses {
+		c.Next()
+		return
+	}
+
+	w := &responseRecorder{ResponseWriter: c.Writer}
+	c.Writer = w
+	c.Next()
+	if !w.recorded {
+		return
+	}
+	err = openapi3filter.ValidateResponse(c.Request.Context(), &openapi3filter.ResponseValidationInput{
+		RequestValidationInput: input,
+		Status:                 w.Status(),
+		Header:                 w.Header(),
+		Body:                   io.NopCloser(&w.body),
+		Options: &openapi3filter.Options{
+			MultiError:            true,
+			IncludeResponseStatus: true,
+		},
+	})
+	if err != nil {
+		log.Printf("openapi: response %d of %s %s does not match the spec: %v", w.Status(), route.Method, route.Path, err)
+	}
+}
+
+// jsonRequest is the request as ValidateRequest should see it. The handlers
+// bind JSON whatever the Content-Type says, so a body of a type the
+// operation does not list is checked as application/json.
+func jsonRequest(c *gin.Context, operation *openapi3.Operation) *http.Request {
+	if operation.RequestBody == nil || c.Request.Body == nil {
+		return c.Request
+	}
+	content := operation.RequestBody.Value.Content
+	if content.Get(c.ContentType()) != nil || content.Get(binding.MIMEJSON) == nil {
+		return c.Request
+	}
+	req := c.Request.Clone(c.Request.Context())
+	req.Header.Set("Content-Type", binding.MIMEJSON)
+	return req
+}
+
+// responseRecorder keeps a copy of a JSON response for Validate. Other
+// responses, such as event streams, are passed through.
+type responseRecorder struct {
+	gin.ResponseWriter
+	body     bytes.Buffer
+	recorded bool
+}
+
+func (w *responseRecorder) Write(b []byte) (int, error) {
+	if mediaType, _, _ := mime.ParseMediaType(w.Header().Get("Content-Type")); mediaType == binding.MIMEJSON {
+		w.recorded = true
+		w.body.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *responseRecorder) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// requestDetails turns the errors of ValidateRequest into details in the
+// form the handlers use, "field: expectation", sorted.
+func requestDetails(err error) []string {
+	var details []string
+	var collect func(err error, field string, schema *openapi3.Schema)
+	collect = func(err error, field string, schema *openapi3.Schema) {
+		var schemaErr *openapi3.SchemaError
+		var parseErr *openapi3filter.ParseError
+		switch e := err.(type) {
+		case openapi3.MultiError:
+			for _, err := range e {
+				collect(err, field, schema)
+			}
+		case *openapi3filter.RequestError:
+			switch {
+			case e.Parameter != nil:
+				field = e.Parameter.Name
+				if e.Parameter.Schema != nil {
+					schema = e.Parameter.Schema.Value
+				}
+			case e.RequestBody != nil && e.Err == nil:
+				details = append(details, "Content-Type: 只支援 "+choices(contentTypes(e.RequestBody)))
+				return
+			case e.RequestBody != nil:
+				field = "body"
+				schema = nil
+			}
+			collect(e.Err, field, schema)
+		case *openapi3.SchemaError:
+			if path := e.JSONPointer(); len(path) > 0 {
+				if field == "body" {
+					field = strings.Join(path, ".")
+				} else {
+					field += "." + strings.Join(path, ".")
+				}
+			}
+			switch e.SchemaField {
+			case "required":
+				details = append(details, field+": 必填")
+			case "additionalProperties":
+				details = append(details, field+": 不支援的欄位")
+			case "pattern":
+				details = append(details, fmt.Sprintf("%s: 必須符合 %s", field, e.Schema.Pattern))
+			default:
+				details = append(details, field+": "+expectation(e.Schema))
+			}
+		default:
+			switch {
+			case errors.As(err, &schemaErr):
+				collect(schemaErr, field, schema)
+			case errors.Is(err, openapi3filter.ErrInvalidRequired) && field == "body":
+				details = append(details, "body: 必填")
+			case errors.Is(err, openapi3filter.ErrInvalidRequired):
+				details = append(details, field+": 必填")
+			case errors.As(err, &parseErr) && schema != nil:
+				details = append(details, field+": "+expectation(schema))
+			case field == "body":
+				details = append(details, "body: 必須是 JSON")
+			default:
+				details = append(details, field+": "+err.Error())
+			}
+		}
+	}
+	collect(err, "", nil)
+	details = dedupe(details)
+	sort.Strings(details)
+	return details
+}
+
+// expectation describes the values schema accepts, worded like the details
+// of the handlers.
+func expectation(schema *openapi3.Schema) string {
+	var values []string
+	for _, sub := range append(schema.OneOf, schema.AnyOf...) {
+		for _, value := range sub.Value.Enum {
+			values = append(values, fmt.Sprint(value))
+		}
+	}
+	for _, value := range schema.Enum {
+		values = append(values, fmt.Sprint(value))
+	}
+	if len(values) > 0 {
+		return "只能是 " + choices(dedupe(values))
+	}
+	if schema.Nullable {
+		return valueKind(schema) + "或 null"
+	}
+	return valueKind(schema)
+}
+
+func valueKind(schema *openapi3.Schema) string {
+	switch {
+	case schema.Type.Is(openapi3.TypeBoolean):
+		return "只能是 true 或 false"
+	case schema.Type.Is(openapi3.TypeInteger) && schema.Min != nil && schema.Max != nil:
+		return fmt.Sprintf("必須是 %.0f 到 %.0f 之間的整數", *schema.Min, *schema.Max)
+	case schema.Type.Is(openapi3.TypeInteger) && schema.Min != nil:
+		return fmt.Sprintf("必須是不小於 %.0f 的整數", *schema.Min)
+	case schema.Type.Is(openapi3.TypeInteger):
+		return "必須是整數"
+	case schema.Type.Is(openapi3.TypeString) && schema.Format == "date-time":
+		return "必須是 RFC 3339 格式的時間"
+	case schema.Type.Is(openapi3.TypeString) && schema.Format == "uri":
+		return "必須是網址"
+	case schema.Type.Is(openapi3.TypeString) && schema.MaxLength != nil && schema.MinLength > 0:
+		return fmt.Sprintf("必須是長度 %d 到 %d 的字串", schema.MinLength, *schema.MaxLength)
+	case schema.Type.Is(openapi3.TypeString) && schema.MaxLength != nil:
+		return fmt.Sprintf("必須是長度不超過 %d 的字串", *schema.MaxLength)
+	case schema.Type.Is(openapi3.TypeString) && schema.MinLength > 0:
+		return "必須是非空字串"
+	case schema.Type.Is(openapi3.TypeString):
+		return "必須是字串"
+	case schema.Type.Is(openapi3.TypeArray) && schema.MinItems > 0:
+		return fmt.Sprintf("必須是至少 %d 項的陣列", schema.MinItems)
+	case schema.Type.Is(openapi3.TypeArray):
+		return "必須是陣列"
+	case schema.Type.Is(openapi3.TypeObject):
+		return "必須是 JSON 物件"
+	}
+	return "格式不符"
+}
+
+func contentTypes(body *openapi3.RequestBody) []string {
+	types := make([]string, 0, len(body.Content))
+	for contentType := range body.Content {
+		types = append(types, contentType)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// dedupe drops repeated values, keeping the first of each.
+func dedupe(values []string) []string {
+	seen := make(map[string]bool, len(values))
+	result := values[:0]
+	for _, value := range values {
+		if !seen[value] {
+			seen[value] = true
+			result = append(result, value)
+		}
+	}
+	return result
+}
+
+// choices lists values as "a、b 或 c".
+func choices(values []string) string {
+	if len(values) == 1 {
+		return values[0]
+	}
+	return strings.Join(values[:len(values)-1], "、") + " 或 " + values[len(values)-1]
+}
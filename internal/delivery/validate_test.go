@@ -0,0 +1,92 @@
+package delivery
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/wagaru/task/config"
+	"github.com/wagaru/task/internal/errcode"
+	"github.com/wagaru/task/internal/model"
+	"github.com/wagaru/task/internal/service/mocks"
+)
+
+func TestValidate(t *testing.T) {
+	mockService := new(mocks.Service)
+	t.Run("InvalidParams", func(t *testing.T) {
+		tests := []struct {
+			name    string
+			method  string
+			path    string
+			body    string
+			details []string
+		}{
+			{"Body", "POST", "/tasks", `{"name":"","priority":9,"due_at":"tomorrow","tags":["a,b"]}`, []string{"due_at: 必須是 RFC 3339 格式的時間或 null", "name: 必須是非空字串", "priority: 必須是 0 到 5 之間的整數", "tags.0: 必須符合 ^[^,]*$"}},
+			{"Required", "POST", "/projects", `{"description":"project"}`, []string{"name: 必填"}},
+			{"NotJSON", "POST", "/projects", `name=project`, []string{"body: 必須是 JSON"}},
+			{"Enum", "POST", "/webhooks", `{"url":"https://example.com","events":["task.moved"]}`, []string{"events.0: 只能是 task.created、task.updated 或 task.deleted"}},
+			{"Query", "GET", "/tasks?limit=1001&tree=maybe", "", []string{"limit: 必須是 1 到 1000 之間的整數", "tree: 只能是 true 或 false"}},
+			{"Path", "GET", "/projects/0", "", []string{"pid: 必須是 1 到 4294967295 之間的整數"}},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				delivery := NewDelivery(mockService, &config.ServerConfig{})
+				w := httptest.NewRecorder()
+				req, _ := http.NewRequest(tt.method, tt.path, bytes.NewBufferString(tt.body))
+				delivery.engine.ServeHTTP(w, req)
+
+				assert.Equal(t, errcode.InvalidParams.StatusCode(), w.Code)
+				expected, _ := json.Marshal(map[string]interface{}{
+					"code":    errcode.InvalidParams.Code(),
+					"message": errcode.InvalidParams.Message(),
+					"details": tt.details,
+				})
+				assert.JSONEq(t, string(expected), w.Body.String())
+			})
+		}
+		mockService.AssertNotCalled(t, "CreateTask")
+		mockService.AssertNotCalled(t, "CreateProject")
+		mockService.AssertNotCalled(t, "CreateWebhook")
+		mockService.AssertNotCalled(t, "GetTasks")
+		mockService.AssertNotCalled(t, "GetProject")
+	})
+	t.Run("ContentType", func(t *testing.T) {
+		// The body is checked as JSON and handed on whatever Content-Type says.
+		project := &model.Project{ID: 1, Name: "project"}
+		mockService.On("CreateProject", &model.Project{Name: "project"}).Return(project, nil).Once()
+		delivery := NewDelivery(mockService, &config.ServerConfig{})
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/projects", bytes.NewBufferString(`{"name":"project"}`))
+		req.Header.Set("Content-Type", "text/plain")
+		delivery.engine.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusCreated, w.Code)
+		mockService.AssertExpectations(t)
+	})
+	t.Run("Response", func(t *testing.T) {
+		var buf bytes.Buffer
+		defer log.SetOutput(log.Writer())
+		log.SetOutput(&buf)
+		task := &model.Task{ID: 1, Name: "task", Status: "doing"}
+		mockService.On("GetTask", uint32(1)).Return(task, nil)
+
+		delivery := NewDelivery(mockService, &config.ServerConfig{RunMode: gin.ReleaseMode})
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/tasks/1", nil)
+		delivery.engine.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Empty(t, buf.String())
+
+		delivery = NewDelivery(mockService, &config.ServerConfig{RunMode: gin.DebugMode})
+		w = httptest.NewRecorder()
+		delivery.engine.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, buf.String(), "openapi: response 200 of GET /tasks/{id} does not match the spec")
+		assert.Contains(t, buf.String(), `"/result/status"`)
+	})
+}
@@ -0,0 +1,343 @@
+package delivery
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/gorilla/websocket"
+	"github.com/wagaru/task/internal/auth"
+	"github.com/wagaru/task/internal/errcode"
+	"github.com/wagaru/task/internal/event"
+	"github.com/wagaru/task/internal/model"
+)
+
+const (
+	wsWriteWait  = 10 * time.Second
+	wsPongWait   = 60 * time.Second
+	wsPingPeriod = wsPongWait * 9 / 10
+	wsMaxMessage = 64 << 10
+	// wsSendBuffer is how many messages may wait for a client before it is
+	// dropped as too slow.
+	wsSendBuffer = 256
+)
+
+// Message types of the WebSocket protocol. Requests carry one of the
+// command types; replies are results or errors, and subscriptions push
+// events.
+const (
+	wsSubscribe   = "subscribe"
+	wsUnsubscribe = "unsubscribe"
+	wsCreate      = "create"
+	wsUpdate      = "update"
+	wsPatch       = "patch"
+	wsDelete      = "delete"
+
+	wsResult = "result"
+	wsError  = "error"
+	wsEvent  = "event"
+)
+
+var upgrader = websocket.Upgrader{}
+
+// wsRequest is a message from the client. Its ID comes back on the reply;
+// for a subscribe it also names the subscription, and for an unsubscribe
+// Subscription says which one to end. The filter fields of
+// TaskEventsRequest apply to subscribe.
+type wsRequest struct {
+	ID           string          `json:"id"`
+	Type         string          `json:"type"`
+	TaskID       uint32          `json:"task_id"`
+	Task         json.RawMessage `json:"task"`
+	Subscription string          `json:"subscription"`
+	TaskEventsRequest
+}
+
+// wsResponse is a message to the client. Error holds the same payload the
+// REST handlers answer with.
+type wsResponse struct {
+	ID           string       `json:"id,omitempty"`
+	Type         string       `json:"type"`
+	Result       interface{}  `json:"result,omitempty"`
+	Error        gin.H        `json:"error,omitempty"`
+	Subscription string       `json:"subscription,omitempty"`
+	Event        *model.Event `json:"event,omitempty"`
+}
+
+type wsClient struct {
+	d *delivery
+	// ctx is the context of the upgrade request, which carries the caller.
+	ctx  context.Context
+	conn *websocket.Conn
+	send chan *wsResponse
+
+	quit      chan struct{}
+	closeOnce sync.Once
+	closeCode int
+	closeText string
+
+	mu   sync.Mutex
+	subs map[string]*event.Subscription
+	wg   sync.WaitGroup
+}
+
+// TaskSocket upgrades to a WebSocket on which the client can subscribe to
+// task events and create, update or delete tasks.
+func (d *delivery) TaskSocket(c *gin.Context) {
+	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		// Upgrade has already answered the request.
+		return
+	}
+	client := &wsClient{
+		d:    d,
+		ctx:  c.Request.Context(),
+		conn: conn,
+		send: make(chan *wsResponse, wsSendBuffer),
+		quit: make(chan struct{}),
+		subs: make(map[string]*event.Subscription),
+	}
+	done := make(chan struct{})
+	go func() {
+		client.writeLoop()
+		close(done)
+	}()
+	client.readLoop()
+	client.stop(websocket.CloseNormalClosure, "")
+	client.unsubscribeAll()
+	<-done
+}
+
+func (c *wsClient) readLoop() {
+	c.conn.SetReadLimit(wsMaxMessage)
+	c.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	})
+	for {
+		_, message, err := c.conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		var req wsRequest
+		if err := json.Unmarshal(message, &req); err != nil {
+			c.reply(&req, nil, errcode.InvalidParams.WithDetails("message: 必須是符合格式的 JSON 物件"))
+			continue
+		}
+		if req.Type == wsSubscribe {
+			c.subscribe(&req)
+			continue
+		}
+		result, err := c.handle(&req)
+		c.reply(&req, result, err)
+	}
+}
+
+// writeLoop is the only writer on the connection. It closes the
+// connection when the client is stopped or the server shuts down.
+func (c *wsClient) writeLoop() {
+	ping := time.NewTicker(wsPingPeriod)
+	defer func() {
+		ping.Stop()
+		c.conn.Close()
+	}()
+	for {
+		select {
+		case msg := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if err := c.conn.WriteJSON(msg); err != nil {
+				c.stop(websocket.CloseAbnormalClosure, "")
+				return
+			}
+		case <-ping.C:
+			if err := c.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteWait)); err != nil {
+				c.stop(websocket.CloseAbnormalClosure, "")
+				return
+			}
+		case <-c.quit:
+			c.writeClose(c.closeCode, c.closeText)
+			return
+		case <-c.d.done:
+			c.stop(websocket.CloseGoingAway, "server shutting down")
+			c.writeClose(websocket.CloseGoingAway, "server shutting down")
+			return
+		}
+	}
+}
+
+func (c *wsClient) writeClose(code int, text string) {
+	if code == websocket.CloseAbnormalClosure {
+		return
+	}
+	msg := websocket.FormatCloseMessage(code, text)
+	c.conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(wsWriteWait))
+}
+
+// stop ends the connection with the given close code; only the first call
+// counts.
+func (c *wsClient) stop(code int, text string) {
+	c.closeOnce.Do(func() {
+		c.closeCode = code
+		c.closeText = text
+		close(c.quit)
+	})
+}
+
+// push queues msg without blocking. A client that has let its queue fill
+// up is dropped rather than allowed to hold up the events.
+func (c *wsClient) push(msg *wsResponse) {
+	select {
+	case <-c.quit:
+		return
+	default:
+	}
+	select {
+	case c.send <- msg:
+	default:
+		c.stop(websocket.CloseTryAgainLater, "client too slow")
+	}
+}
+
+func (c *wsClient) reply(req *wsRequest, result interface{}, err error) {
+	msg := &wsResponse{ID: req.ID, Type: wsResult, Result: result}
+	if err != nil {
+		_, msg.Error = errorBody(err)
+		msg.Type = wsError
+		msg.Result = nil
+	}
+	c.push(msg)
+}
+
+func (c *wsClient) handle(req *wsRequest) (interface{}, error) {
+	switch req.Type {
+	case wsCreate, wsUpdate, wsPatch, wsDelete:
+		if err := auth.Check(c.ctx, auth.ScopeTasksWrite); err != nil {
+			return nil, err
+		}
+	}
+	switch req.Type {
+	case wsUnsubscribe:
+		return nil, c.unsubscribe(req.Subscription)
+	case wsCreate:
+		var params CreateTaskRequest
+		if err := decodeTask(req.Task, &params); err != nil {
+			return nil, err
+		}
+		return c.d.svc.CreateTask(c.ctx, params.Task())
+	case wsUpdate:
+		var params UpdateTaskRequest
+		if err := checkTaskID(req.TaskID); err != nil {
+			return nil, err
+		}
+		if err := decodeTask(req.Task, &params); err != nil {
+			return nil, err
+		}
+		return c.d.svc.UpdateTask(c.ctx, req.TaskID, params.Task())
+	case wsPatch:
+		if err := checkTaskID(req.TaskID); err != nil {
+			return nil, err
+		}
+		patch, err := parseMergePatch(bytes.NewReader(req.Task))
+		if err != nil {
+			return nil, err
+		}
+		return c.d.svc.PatchTask(c.ctx, req.TaskID, patch)
+	case wsDelete:
+		if err := checkTaskID(req.TaskID); err != nil {
+			return nil, err
+		}
+		return nil, c.d.svc.DeleteTask(c.ctx, req.TaskID)
+	default:
+		return nil, errcode.InvalidParams.WithDetails(fmt.Sprintf("type: 不支援的訊息類型 %q", req.Type))
+	}
+}
+
+// subscribe replies before starting the subscription, so the client sees
+// the result ahead of any event.
+func (c *wsClient) subscribe(req *wsRequest) {
+	if req.ID == "" {
+		c.reply(req, nil, errcode.InvalidParams.WithDetails("id: subscribe 需要 id 作為訂閱的名稱"))
+		return
+	}
+	match, err := req.Match()
+	if err != nil {
+		c.reply(req, nil, err)
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.subs[req.ID]; ok {
+		c.reply(req, nil, errcode.DuplicateRecords.WithDetails(fmt.Sprintf("id: 已有名為 %q 的訂閱", req.ID)))
+		return
+	}
+	sub := c.d.svc.SubscribeEvents(req.LastEventID)
+	c.subs[req.ID] = sub
+	c.reply(req, nil, nil)
+	c.wg.Add(1)
+	go c.forward(req.ID, sub, match)
+}
+
+// forward pushes the matching events of one subscription. If the event bus
+// ends the subscription by itself, the client has fallen behind.
+func (c *wsClient) forward(id string, sub *event.Subscription, match func(*model.Event) bool) {
+	defer c.wg.Done()
+	for e := range sub.C {
+		if match(e) {
+			c.push(&wsResponse{Type: wsEvent, Subscription: id, Event: e})
+		}
+	}
+	c.mu.Lock()
+	_, active := c.subs[id]
+	c.mu.Unlock()
+	if active {
+		c.stop(websocket.CloseTryAgainLater, "client too slow")
+	}
+}
+
+func (c *wsClient) unsubscribe(id string) error {
+	c.mu.Lock()
+	sub, ok := c.subs[id]
+	delete(c.subs, id)
+	c.mu.Unlock()
+	if !ok {
+		return errcode.RecordNotExists.WithDetails(fmt.Sprintf("subscription: 沒有名為 %q 的訂閱", id))
+	}
+	sub.Close()
+	return nil
+}
+
+func (c *wsClient) unsubscribeAll() {
+	c.mu.Lock()
+	subs := c.subs
+	c.subs = make(map[string]*event.Subscription)
+	c.mu.Unlock()
+	for _, sub := range subs {
+		sub.Close()
+	}
+	c.wg.Wait()
+}
+
+// decodeTask reads the task member of a command the way ShouldBindJSON
+// reads a request body.
+func decodeTask(raw json.RawMessage, params interface{}) error {
+	if err := json.Unmarshal(raw, params); err != nil {
+		return errcode.InvalidParams
+	}
+	if err := binding.Validator.ValidateStruct(params); err != nil {
+		return errcode.InvalidParams
+	}
+	return nil
+}
+
+func checkTaskID(id uint32) error {
+	if id == 0 {
+		return errcode.InvalidParams.WithDetails(fmt.Sprintf("task_id: 必須是 1 到 %d 之間的整數", uint32(math.MaxUint32)))
+	}
+	return nil
+}
@@ -0,0 +1,221 @@
+package delivery
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"github.com/wagaru/task/config"
+	"github.com/wagaru/task/internal/errcode"
+	"github.com/wagaru/task/internal/event"
+	"github.com/wagaru/task/internal/model"
+	"github.com/wagaru/task/internal/service/mocks"
+)
+
+// dialSocket serves delivery on a test server and opens /tasks/ws on it.
+func dialSocket(t *testing.T, d *delivery) *websocket.Conn {
+	server := httptest.NewServer(d.engine)
+	t.Cleanup(server.Close)
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/tasks/ws", nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// roundTrip sends req and reads the next message as JSON.
+func roundTrip(t *testing.T, conn *websocket.Conn, req string) map[string]interface{} {
+	require.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte(req)))
+	return readMessage(t, conn)
+}
+
+func readMessage(t *testing.T, conn *websocket.Conn) map[string]interface{} {
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	var msg map[string]interface{}
+	require.NoError(t, conn.ReadJSON(&msg))
+	return msg
+}
+
+// toMap turns v into what it reads as on the wire.
+func toMap(v interface{}) map[string]interface{} {
+	data, _ := json.Marshal(v)
+	var m map[string]interface{}
+	json.Unmarshal(data, &m)
+	return m
+}
+
+func TestTaskSocket(t *testing.T) {
+	task := &model.Task{ID: 1, Name: "task1", Status: model.StatusTodo}
+	t.Run("Create", func(t *testing.T) {
+		mockService := new(mocks.Service)
+		mockService.On("CreateTask", mock.Anything, &model.Task{Name: "task1"}).Return(task, nil).Once()
+		conn := dialSocket(t, NewDelivery(mockService, &config.ServerConfig{}))
+
+		msg := roundTrip(t, conn, `{"id":"1","type":"create","task":{"name":"task1"}}`)
+		assert.Equal(t, map[string]interface{}{"id": "1", "type": "result", "result": toMap(task)}, msg)
+		mockService.AssertExpectations(t)
+	})
+	t.Run("Update", func(t *testing.T) {
+		mockService := new(mocks.Service)
+		mockService.On("UpdateTask", mock.Anything, uint32(1), &model.Task{Name: "task1", Status: model.StatusDone, Priority: 2}).Return(task, nil).Once()
+		conn := dialSocket(t, NewDelivery(mockService, &config.ServerConfig{}))
+
+		msg := roundTrip(t, conn, `{"id":"2","type":"update","task_id":1,"task":{"name":"task1","status":"done","priority":2}}`)
+		assert.Equal(t, map[string]interface{}{"id": "2", "type": "result", "result": toMap(task)}, msg)
+		mockService.AssertExpectations(t)
+	})
+	t.Run("Patch", func(t *testing.T) {
+		name := "task1"
+		mockService := new(mocks.Service)
+		mockService.On("PatchTask", mock.Anything, uint32(1), &model.TaskPatch{Name: &name}).Return(task, nil).Once()
+		conn := dialSocket(t, NewDelivery(mockService, &config.ServerConfig{}))
+
+		msg := roundTrip(t, conn, `{"id":"3","type":"patch","task_id":1,"task":{"name":"task1"}}`)
+		assert.Equal(t, map[string]interface{}{"id": "3", "type": "result", "result": toMap(task)}, msg)
+		mockService.AssertExpectations(t)
+	})
+	t.Run("Delete", func(t *testing.T) {
+		mockService := new(mocks.Service)
+		mockService.On("DeleteTask", mock.Anything, uint32(1)).Return(nil).Once()
+		mockService.On("DeleteTask", mock.Anything, uint32(2)).Return(errcode.RecordNotExists).Once()
+		conn := dialSocket(t, NewDelivery(mockService, &config.ServerConfig{}))
+
+		msg := roundTrip(t, conn, `{"id":"4","type":"delete","task_id":1}`)
+		assert.Equal(t, map[string]interface{}{"id": "4", "type": "result"}, msg)
+		msg = roundTrip(t, conn, `{"id":"5","type":"delete","task_id":2}`)
+		assert.Equal(t, map[string]interface{}{"id": "5", "type": "error", "error": toMap(gin.H{
+			"code":    errcode.RecordNotExists.Code(),
+			"message": errcode.RecordNotExists.Message(),
+		})}, msg)
+		mockService.AssertExpectations(t)
+	})
+	t.Run("InvalidParams", func(t *testing.T) {
+		tests := []struct {
+			name    string
+			req     string
+			details []string
+		}{
+			{"JSON", `{"id":`, []string{"message: 必須是符合格式的 JSON 物件"}},
+			{"Type", `{"id":"1","type":"list"}`, []string{`type: 不支援的訊息類型 "list"`}},
+			{"CreateValidation", `{"id":"1","type":"create","task":{"name":""}}`, nil},
+			{"CreateTask", `{"id":"1","type":"create"}`, nil},
+			{"UpdateTaskID", `{"id":"1","type":"update","task":{"name":"task1"}}`, []string{"task_id: 必須是 1 到 4294967295 之間的整數"}},
+			{"PatchFields", `{"id":"1","type":"patch","task_id":1,"task":{"priority":6}}`, []string{"priority: 必須是 0 到 5 之間的整數"}},
+			{"DeleteTaskID", `{"id":"1","type":"delete"}`, []string{"task_id: 必須是 1 到 4294967295 之間的整數"}},
+			{"SubscribeID", `{"type":"subscribe"}`, []string{"id: subscribe 需要 id 作為訂閱的名稱"}},
+			{"SubscribeStatus", `{"id":"1","type":"subscribe","status":"doing"}`, []string{"status: 只能是 todo、in_progress、blocked、done、cancelled，或舊版的 0、1"}},
+		}
+		mockService := new(mocks.Service)
+		conn := dialSocket(t, NewDelivery(mockService, &config.ServerConfig{}))
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				msg := roundTrip(t, conn, tt.req)
+				assert.Equal(t, "error", msg["type"])
+				expected := gin.H{
+					"code":    errcode.InvalidParams.Code(),
+					"message": errcode.InvalidParams.Message(),
+				}
+				if tt.details != nil {
+					expected["details"] = tt.details
+				}
+				assert.Equal(t, toMap(expected), msg["error"])
+			})
+		}
+		mockService.AssertNotCalled(t, "CreateTask", mock.Anything, mock.Anything)
+		mockService.AssertNotCalled(t, "SubscribeEvents", mock.Anything)
+	})
+	t.Run("Subscribe", func(t *testing.T) {
+		bus := event.NewBus(10)
+		mockService := new(mocks.Service)
+		mockService.On("SubscribeEvents", uint64(0)).Return(func(lastID uint64) *event.Subscription {
+			return bus.Subscribe(lastID)
+		}).Twice()
+		conn := dialSocket(t, NewDelivery(mockService, &config.ServerConfig{}))
+
+		msg := roundTrip(t, conn, `{"id":"all","type":"subscribe"}`)
+		assert.Equal(t, map[string]interface{}{"id": "all", "type": "result"}, msg)
+		msg = roundTrip(t, conn, `{"id":"project","type":"subscribe","project_id":1}`)
+		assert.Equal(t, map[string]interface{}{"id": "project", "type": "result"}, msg)
+		msg = roundTrip(t, conn, `{"id":"all","type":"subscribe"}`)
+		assert.Equal(t, "error", msg["type"])
+		assert.Equal(t, float64(errcode.DuplicateRecords.Code()), msg["error"].(map[string]interface{})["code"])
+
+		created := &model.Event{Type: model.EventTaskCreated, Task: &model.Task{ID: 2, Name: "task2", Status: model.StatusTodo}}
+		bus.Publish(created)
+		assert.Equal(t, map[string]interface{}{"type": "event", "subscription": "all", "event": toMap(created)}, readMessage(t, conn))
+
+		updated := &model.Event{Type: model.EventTaskUpdated, Task: &model.Task{ID: 1, ProjectID: 1, Name: "task1", Status: model.StatusDone}}
+		bus.Publish(updated)
+		got := []interface{}{readMessage(t, conn)["subscription"], readMessage(t, conn)["subscription"]}
+		assert.ElementsMatch(t, []interface{}{"all", "project"}, got)
+
+		msg = roundTrip(t, conn, `{"id":"5","type":"unsubscribe","subscription":"all"}`)
+		assert.Equal(t, map[string]interface{}{"id": "5", "type": "result"}, msg)
+		msg = roundTrip(t, conn, `{"id":"6","type":"unsubscribe","subscription":"all"}`)
+		assert.Equal(t, "error", msg["type"])
+		assert.Equal(t, float64(errcode.RecordNotExists.Code()), msg["error"].(map[string]interface{})["code"])
+
+		bus.Publish(updated)
+		msg = readMessage(t, conn)
+		assert.Equal(t, "project", msg["subscription"])
+		mockService.AssertExpectations(t)
+	})
+	t.Run("Replay", func(t *testing.T) {
+		bus := event.NewBus(10)
+		first := &model.Event{Type: model.EventTaskCreated, Task: task}
+		second := &model.Event{Type: model.EventTaskDeleted, Task: task}
+		bus.Publish(first)
+		bus.Publish(second)
+		mockService := new(mocks.Service)
+		mockService.On("SubscribeEvents", uint64(1)).Return(bus.Subscribe(1)).Once()
+		conn := dialSocket(t, NewDelivery(mockService, &config.ServerConfig{}))
+
+		msg := roundTrip(t, conn, `{"id":"s","type":"subscribe","last_event_id":1}`)
+		assert.Equal(t, map[string]interface{}{"id": "s", "type": "result"}, msg)
+		assert.Equal(t, map[string]interface{}{"type": "event", "subscription": "s", "event": toMap(second)}, readMessage(t, conn))
+		mockService.AssertExpectations(t)
+	})
+	t.Run("Dropped", func(t *testing.T) {
+		bus := event.NewBus(1)
+		mockService := new(mocks.Service)
+		mockService.On("SubscribeEvents", uint64(0)).Return(bus.Subscribe(0)).Once()
+		conn := dialSocket(t, NewDelivery(mockService, &config.ServerConfig{}))
+		roundTrip(t, conn, `{"id":"s","type":"subscribe"}`)
+
+		// Closing the bus ends the subscription the same way it does for a
+		// subscriber that has fallen behind.
+		bus.Close()
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		_, _, err := conn.ReadMessage()
+		assert.True(t, websocket.IsCloseError(err, websocket.CloseTryAgainLater), err)
+	})
+	t.Run("Push", func(t *testing.T) {
+		client := &wsClient{send: make(chan *wsResponse, 1), quit: make(chan struct{})}
+		client.push(&wsResponse{Type: wsEvent})
+		client.push(&wsResponse{Type: wsEvent})
+		select {
+		case <-client.quit:
+		default:
+			t.Fatal("full client not stopped")
+		}
+		assert.Equal(t, websocket.CloseTryAgainLater, client.closeCode)
+		assert.Len(t, client.send, 1)
+	})
+	t.Run("Shutdown", func(t *testing.T) {
+		mockService := new(mocks.Service)
+		delivery := NewDelivery(mockService, &config.ServerConfig{})
+		conn := dialSocket(t, delivery)
+		assert.NoError(t, delivery.Shutdown(context.Background()))
+
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		_, _, err := conn.ReadMessage()
+		assert.True(t, websocket.IsCloseError(err, websocket.CloseGoingAway), err)
+	})
+}
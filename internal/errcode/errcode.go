@@ -3,21 +3,32 @@ package errcode
 import (
 	"fmt"
 	"net/http"
+
+	"google.golang.org/grpc/codes"
 )
 
 var (
-	UnknownError     = NewError(10000, "伺服器錯誤")
-	InvalidParams    = NewError(10001, "輸入參數錯誤")
-	NotFound         = NewError(10002, "頁面不存在")
-	DuplicateRecords = NewError(10003, "已存在相同的記錄")
-	RecordNotExists  = NewError(10004, "記錄不存在")
+	UnknownError      = NewError(10000, "伺服器錯誤")
+	InvalidParams     = NewError(10001, "輸入參數錯誤")
+	NotFound          = NewError(10002, "頁面不存在")
+	DuplicateRecords  = NewError(10003, "已存在相同的記錄")
+	RecordNotExists   = NewError(10004, "記錄不存在")
+	PatchTestFailed   = NewError(10005, "記錄內容與預期不符")
+	IllegalTransition = NewError(10006, "不允許的狀態變更")
+	ProjectNotEmpty   = NewError(10007, "專案中仍有任務")
+	CycleDetected     = NewError(10008, "不允許形成循環的關聯")
+	TaskBlocked       = NewError(10009, "任務仍有未完成的前置任務")
+	Unauthorized      = NewError(10010, "未通過身分驗證")
+	Forbidden         = NewError(10011, "權限不足")
+	AuthUnavailable   = NewError(10012, "無法連線身分提供者")
 )
 
 var ErrorList = map[int]string{}
 
 type Error struct {
-	code int
-	msg  string
+	code    int
+	msg     string
+	details []string
 }
 
 func NewError(code int, msg string) *Error {
@@ -39,12 +50,35 @@ func (e *Error) Message() string {
 	return e.msg
 }
 
+func (e *Error) Details() []string {
+	return e.details
+}
+
+func (e *Error) WithDetails(details ...string) *Error {
+	newError := *e
+	newError.details = append([]string{}, details...)
+	return &newError
+}
+
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.code == e.code
+}
+
 func (e *Error) StatusCode() int {
 	switch e.code {
-	case InvalidParams.code, DuplicateRecords.code, RecordNotExists.code:
+	case InvalidParams.code, DuplicateRecords.code:
 		return http.StatusBadRequest
-	case NotFound.code:
+	case Unauthorized.code:
+		return http.StatusUnauthorized
+	case Forbidden.code:
+		return http.StatusForbidden
+	case NotFound.code, RecordNotExists.code:
 		return http.StatusNotFound
+	case PatchTestFailed.code, IllegalTransition.code, ProjectNotEmpty.code, CycleDetected.code, TaskBlocked.code:
+		return http.StatusConflict
+	case AuthUnavailable.code:
+		return http.StatusServiceUnavailable
 	case UnknownError.code:
 		fallthrough
 	default:
@@ -52,6 +86,32 @@ func (e *Error) StatusCode() int {
 	}
 }
 
+// GRPCCode is the gRPC counterpart of StatusCode.
+func (e *Error) GRPCCode() codes.Code {
+	switch e.code {
+	case InvalidParams.code:
+		return codes.InvalidArgument
+	case DuplicateRecords.code:
+		return codes.AlreadyExists
+	case Unauthorized.code:
+		return codes.Unauthenticated
+	case Forbidden.code:
+		return codes.PermissionDenied
+	case NotFound.code, RecordNotExists.code:
+		return codes.NotFound
+	case PatchTestFailed.code:
+		return codes.Aborted
+	case IllegalTransition.code, ProjectNotEmpty.code, CycleDetected.code, TaskBlocked.code:
+		return codes.FailedPrecondition
+	case AuthUnavailable.code:
+		return codes.Unavailable
+	case UnknownError.code:
+		fallthrough
+	default:
+		return codes.Internal
+	}
+}
+
 func (e *Error) Error() string {
 	return fmt.Sprintf("錯誤: %d, 錯誤訊息: %s", e.Code(), e.Message())
 }
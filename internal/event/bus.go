@@ -0,0 +1,120 @@
+package event
+
+import (
+	"sync"
+
+	"github.com/wagaru/task/internal/model"
+)
+
+const (
+	defaultBufferSize = 1000
+	// subscriberSlack is how far a subscriber may fall behind the live
+	// events, on top of its replay, before it is dropped.
+	subscriberSlack = 64
+)
+
+// Bus fans the events the service emits out to subscribers in process. It
+// numbers events and keeps the latest ones so a subscriber can resume after
+// the last one it saw.
+type Bus struct {
+	mu     sync.Mutex
+	seq    uint64
+	buffer []*model.Event
+	size   int
+	subs   map[*Subscription]bool
+	closed bool
+}
+
+// Subscription receives events on C, starting with those replayed. C is
+// closed when the subscriber falls too far behind, or the bus is closed;
+// either way the subscriber can resume with a new subscription.
+type Subscription struct {
+	C   <-chan *model.Event
+	c   chan *model.Event
+	bus *Bus
+}
+
+// NewBus keeps the latest size events for replay.
+func NewBus(size int) *Bus {
+	if size <= 0 {
+		size = defaultBufferSize
+	}
+	return &Bus{
+		size: size,
+		subs: make(map[*Subscription]bool),
+	}
+}
+
+// Publish numbers event and sends it to every subscriber without waiting
+// on any of them.
+func (b *Bus) Publish(event *model.Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return
+	}
+	b.seq++
+	event.ID = b.seq
+	if len(b.buffer) < b.size {
+		b.buffer = append(b.buffer, event)
+	} else {
+		copy(b.buffer, b.buffer[1:])
+		b.buffer[len(b.buffer)-1] = event
+	}
+	for sub := range b.subs {
+		select {
+		case sub.c <- event:
+		default:
+			b.drop(sub)
+		}
+	}
+}
+
+// Subscribe replays the buffered events after lastID, then follows new
+// ones. An ID beyond the latest one was handed out before a restart, so
+// everything buffered is replayed.
+func (b *Bus) Subscribe(lastID uint64) *Subscription {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	c := make(chan *model.Event, b.size+subscriberSlack)
+	sub := &Subscription{C: c, c: c, bus: b}
+	if b.closed {
+		close(c)
+		return sub
+	}
+	if lastID > b.seq {
+		lastID = 0
+	}
+	for _, event := range b.buffer {
+		if event.ID > lastID {
+			c <- event
+		}
+	}
+	b.subs[sub] = true
+	return sub
+}
+
+// Close ends every subscription; later ones are closed straight away.
+func (b *Bus) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	for sub := range b.subs {
+		b.drop(sub)
+	}
+}
+
+// Close stops the subscription. It is safe to call more than once.
+func (s *Subscription) Close() {
+	s.bus.mu.Lock()
+	defer s.bus.mu.Unlock()
+	s.bus.drop(s)
+}
+
+// drop must be called with the lock held.
+func (b *Bus) drop(sub *Subscription) {
+	if b.subs[sub] {
+		delete(b.subs, sub)
+		close(sub.c)
+	}
+}
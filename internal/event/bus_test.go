@@ -0,0 +1,96 @@
+package event
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wagaru/task/internal/model"
+)
+
+func publish(bus *Bus, n int) []*model.Event {
+	events := make([]*model.Event, n)
+	for i := range events {
+		events[i] = &model.Event{Type: model.EventTaskUpdated, Task: &model.Task{ID: uint32(i + 1)}}
+		bus.Publish(events[i])
+	}
+	return events
+}
+
+// received drains what is ready on sub without blocking.
+func received(sub *Subscription) ([]*model.Event, bool) {
+	var events []*model.Event
+	for {
+		select {
+		case event, ok := <-sub.C:
+			if !ok {
+				return events, false
+			}
+			events = append(events, event)
+		default:
+			return events, true
+		}
+	}
+}
+
+func TestBus(t *testing.T) {
+	t.Run("Live", func(t *testing.T) {
+		bus := NewBus(10)
+		sub := bus.Subscribe(0)
+		events := publish(bus, 3)
+		assert.Equal(t, uint64(1), events[0].ID)
+		assert.Equal(t, uint64(3), events[2].ID)
+		result, open := received(sub)
+		assert.True(t, open)
+		assert.Equal(t, events, result)
+	})
+	t.Run("Replay", func(t *testing.T) {
+		bus := NewBus(10)
+		events := publish(bus, 3)
+		sub := bus.Subscribe(1)
+		more := publish(bus, 1)
+		result, _ := received(sub)
+		assert.Equal(t, append(events[1:], more...), result)
+	})
+	t.Run("ReplayBounded", func(t *testing.T) {
+		bus := NewBus(2)
+		events := publish(bus, 5)
+		result, _ := received(bus.Subscribe(0))
+		assert.Equal(t, events[3:], result)
+	})
+	t.Run("UnknownID", func(t *testing.T) {
+		bus := NewBus(10)
+		events := publish(bus, 2)
+		result, _ := received(bus.Subscribe(99))
+		assert.Equal(t, events, result)
+	})
+	t.Run("SlowSubscriber", func(t *testing.T) {
+		bus := NewBus(1)
+		slow := bus.Subscribe(0)
+		publish(bus, 1+subscriberSlack)
+		fast := bus.Subscribe(0)
+		publish(bus, 1)
+		result, open := received(slow)
+		assert.False(t, open)
+		assert.Len(t, result, 1+subscriberSlack)
+		result, open = received(fast)
+		assert.True(t, open)
+		assert.Len(t, result, 2)
+	})
+	t.Run("Close", func(t *testing.T) {
+		bus := NewBus(10)
+		sub := bus.Subscribe(0)
+		closed := bus.Subscribe(0)
+		closed.Close()
+		closed.Close()
+		publish(bus, 1)
+		_, open := received(closed)
+		assert.False(t, open)
+
+		bus.Close()
+		result, open := received(sub)
+		assert.False(t, open)
+		assert.Len(t, result, 1)
+		_, open = received(bus.Subscribe(0))
+		assert.False(t, open)
+	})
+}
@@ -0,0 +1,131 @@
+// Package gql serves the task service over GraphQL, next to the REST API of
+// package delivery.
+package gql
+
+import (
+	_ "embed"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/gin-contrib/sse"
+	graphql "github.com/graph-gophers/graphql-go"
+	gqlerrors "github.com/graph-gophers/graphql-go/errors"
+	"github.com/wagaru/task/internal/errcode"
+	"github.com/wagaru/task/internal/service"
+)
+
+const (
+	// maxDepth leaves room for the introspection query of GraphiQL, the
+	// deepest one a client is expected to send; the schema itself nests
+	// three fields at most.
+	maxDepth = 15
+	// maxQueryLength bounds how much query text is parsed and validated.
+	maxQueryLength = 16 << 10
+)
+
+// Schema is the persisted schema the handler serves.
+//
+//go:embed schema.graphql
+var Schema string
+
+// Handler answers GraphQL requests posted as JSON. A request accepting
+// text/event-stream gets its results as Server-Sent Events instead: one
+// next event per result, then a complete event. Subscriptions need this.
+type Handler struct {
+	schema *graphql.Schema
+}
+
+type request struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+// NewHandler serves the schema over svc. Closing done ends the open
+// subscriptions.
+func NewHandler(svc service.Service, done <-chan struct{}) *Handler {
+	return &Handler{
+		schema: graphql.MustParseSchema(Schema, &resolver{svc: svc, done: done},
+			graphql.MaxDepth(maxDepth), graphql.MaxQueryLength(maxQueryLength)),
+	}
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var req request
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, &graphql.Response{
+			Errors: []*gqlerrors.QueryError{queryError(errcode.InvalidParams.WithDetails("body: 必須是 JSON 物件"))},
+		})
+		return
+	}
+	if !strings.Contains(r.Header.Get("Accept"), sse.ContentType) {
+		writeJSON(w, http.StatusOK, h.schema.Exec(r.Context(), req.Query, req.OperationName, req.Variables))
+		return
+	}
+	results, err := h.schema.Subscribe(r.Context(), req.Query, req.OperationName, req.Variables)
+	if err != nil {
+		// Sent down the stream like the errors of any other result.
+		failed := make(chan interface{}, 1)
+		failed <- &graphql.Response{Errors: []*gqlerrors.QueryError{queryError(err)}}
+		close(failed)
+		results = failed
+	}
+	w.Header().Set("Content-Type", sse.ContentType)
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+	for result := range results {
+		if err := sse.Encode(w, sse.Event{Event: "next", Data: result}); err != nil {
+			return
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+	sse.Encode(w, sse.Event{Event: "complete", Data: ""})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// resolverError carries an errcode.Error to the GraphQL response, with its
+// code and details as extensions.
+type resolverError struct {
+	e *errcode.Error
+}
+
+func (r *resolverError) Error() string {
+	return r.e.Message()
+}
+
+func (r *resolverError) Extensions() map[string]interface{} {
+	extensions := map[string]interface{}{"code": r.e.Code()}
+	if details := r.e.Details(); len(details) > 0 {
+		extensions["details"] = details
+	}
+	return extensions
+}
+
+func toError(err error) error {
+	e, ok := err.(*errcode.Error)
+	if !ok {
+		e = errcode.UnknownError
+	}
+	return &resolverError{e}
+}
+
+// queryError is toError for the places that need the extensions set up
+// front, which graphql-go only does itself for query and mutation fields.
+func queryError(err error) *gqlerrors.QueryError {
+	re := toError(err).(*resolverError)
+	return &gqlerrors.QueryError{
+		Err:           re,
+		Message:       re.Error(),
+		Extensions:    re.Extensions(),
+		ResolverError: re,
+	}
+}
@@ -0,0 +1,349 @@
+package gql
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	graphql "github.com/graph-gophers/graphql-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"github.com/wagaru/task/internal/auth"
+	"github.com/wagaru/task/internal/errcode"
+	"github.com/wagaru/task/internal/event"
+	"github.com/wagaru/task/internal/model"
+	"github.com/wagaru/task/internal/service/mocks"
+)
+
+// post sends a GraphQL request to h and returns the recorded response.
+func post(h *Handler, query string, variables map[string]interface{}, accept string) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(request{Query: query, Variables: variables})
+	req, _ := http.NewRequest("POST", "/graphql", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w
+}
+
+// errorJSON is the errors member expected for a failed field at path.
+func errorJSON(e *errcode.Error, path ...interface{}) []interface{} {
+	extensions := map[string]interface{}{"code": e.Code()}
+	if len(e.Details()) > 0 {
+		extensions["details"] = e.Details()
+	}
+	data, _ := json.Marshal([]interface{}{map[string]interface{}{
+		"message":    e.Message(),
+		"path":       path,
+		"extensions": extensions,
+	}})
+	var v []interface{}
+	json.Unmarshal(data, &v)
+	return v
+}
+
+func decode(t *testing.T, w *httptest.ResponseRecorder) map[string]interface{} {
+	var resp map[string]interface{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp), w.Body.String())
+	// Locations depend on the query text, which the tests do not care about.
+	if errs, ok := resp["errors"].([]interface{}); ok {
+		for _, e := range errs {
+			delete(e.(map[string]interface{}), "locations")
+		}
+	}
+	return resp
+}
+
+// TestSchema checks that the persisted schema parses and that every field
+// in it has a resolver.
+func TestSchema(t *testing.T) {
+	_, err := graphql.ParseSchema(Schema, &resolver{})
+	assert.NoError(t, err)
+}
+
+func TestQuery(t *testing.T) {
+	dueAt := time.Date(2022, 5, 1, 0, 0, 0, 0, time.UTC)
+	task := &model.Task{ID: 1, ProjectID: 2, Name: "task1", Status: model.StatusInProgress, Priority: 3, DueAt: &dueAt, Tags: []string{"home"}, CreatedBy: "alice"}
+	t.Run("Task", func(t *testing.T) {
+		mockService := new(mocks.Service)
+		mockService.On("GetTask", uint32(1)).Return(task, nil).Once()
+		h := NewHandler(mockService, nil)
+
+		w := post(h, `{ task(id: "1") { id projectId parentId name status priority dueAt tags blockedBy createdBy updatedBy } }`, nil, "")
+		assert.Equal(t, 200, w.Code)
+		assert.JSONEq(t, `{"data":{"task":{
+			"id":"1","projectId":"2","parentId":null,"name":"task1","status":"IN_PROGRESS",
+			"priority":3,"dueAt":"2022-05-01T00:00:00Z","tags":["home"],"blockedBy":[],
+			"createdBy":"alice","updatedBy":null
+		}}}`, w.Body.String())
+		mockService.AssertExpectations(t)
+	})
+	t.Run("TaskErrors", func(t *testing.T) {
+		mockService := new(mocks.Service)
+		mockService.On("GetTask", uint32(2)).Return(nil, errcode.RecordNotExists).Once()
+		mockService.On("GetTask", uint32(3)).Return(nil, fmt.Errorf("disk full")).Once()
+		h := NewHandler(mockService, nil)
+
+		resp := decode(t, post(h, `{ task(id: "2") { id } }`, nil, ""))
+		assert.Equal(t, map[string]interface{}{"task": nil}, resp["data"])
+		assert.Equal(t, errorJSON(errcode.RecordNotExists, "task"), resp["errors"])
+
+		resp = decode(t, post(h, `{ task(id: "3") { id } }`, nil, ""))
+		assert.Equal(t, errorJSON(errcode.UnknownError, "task"), resp["errors"])
+
+		resp = decode(t, post(h, `{ task(id: "abc") { id } }`, nil, ""))
+		assert.Equal(t, errorJSON(errcode.InvalidParams.WithDetails("id: 必須是 1 到 4294967295 之間的整數"), "task"), resp["errors"])
+		mockService.AssertExpectations(t)
+	})
+	t.Run("Tasks", func(t *testing.T) {
+		projectID := uint32(0)
+		status := model.StatusTodo
+		mockService := new(mocks.Service)
+		mockService.On("GetTasks", &model.TaskQuery{
+			ProjectID: &projectID,
+			Status:    &status,
+			Tags:      []string{"home", "urgent"},
+			AllTags:   true,
+			Sort:      model.SortByName,
+			Desc:      true,
+			Limit:     2,
+			Cursor:    "abc",
+		}).Return([]*model.Task{task}, "def", nil).Once()
+		h := NewHandler(mockService, nil)
+
+		w := post(h, `query($after: String) {
+			tasks(filter: {projectId: "0", status: TODO, tags: ["Urgent", "home"], allTags: true}, sort: NAME, desc: true, first: 2, after: $after) {
+				nodes { id } nextCursor
+			}
+		}`, map[string]interface{}{"after": "abc"}, "")
+		assert.JSONEq(t, `{"data":{"tasks":{"nodes":[{"id":"1"}],"nextCursor":"def"}}}`, w.Body.String())
+		mockService.AssertExpectations(t)
+	})
+	t.Run("TasksDefaults", func(t *testing.T) {
+		mockService := new(mocks.Service)
+		mockService.On("GetTasks", &model.TaskQuery{Sort: model.SortByID, Limit: 100}).Return(nil, "", nil).Once()
+		h := NewHandler(mockService, nil)
+
+		w := post(h, `{ tasks { nodes { id } nextCursor } }`, nil, "")
+		assert.JSONEq(t, `{"data":{"tasks":{"nodes":[],"nextCursor":null}}}`, w.Body.String())
+
+		resp := decode(t, post(h, `{ tasks(first: 1001) { nextCursor } }`, nil, ""))
+		assert.Equal(t, errorJSON(errcode.InvalidParams.WithDetails("first: 必須是 1 到 1000 之間的整數"), "tasks"), resp["errors"])
+		mockService.AssertExpectations(t)
+	})
+	t.Run("Limits", func(t *testing.T) {
+		h := NewHandler(new(mocks.Service), nil)
+		// introspect nests ofType as deep as the TypeRef fragment of GraphiQL
+		// when levels is 7.
+		introspect := func(levels int) string {
+			return `{ __schema { types { fields { type { ` + strings.Repeat(`ofType { `, levels) + `name` + strings.Repeat(` }`, levels+5)
+		}
+
+		resp := decode(t, post(h, introspect(7), nil, ""))
+		assert.Nil(t, resp["errors"])
+
+		resp = decode(t, post(h, introspect(maxDepth), nil, ""))
+		require.NotEmpty(t, resp["errors"])
+		assert.Contains(t, resp["errors"].([]interface{})[0].(map[string]interface{})["message"], "exceeds max depth")
+
+		resp = decode(t, post(h, `{ tasks { nextCursor } }`+strings.Repeat(" ", maxQueryLength), nil, ""))
+		require.NotEmpty(t, resp["errors"])
+		assert.Contains(t, resp["errors"].([]interface{})[0].(map[string]interface{})["message"], "exceeds the maximum allowed query length")
+	})
+	t.Run("InvalidBody", func(t *testing.T) {
+		h := NewHandler(new(mocks.Service), nil)
+		req, _ := http.NewRequest("POST", "/graphql", bytes.NewBufferString("query"))
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		assert.Equal(t, 400, w.Code)
+		assert.JSONEq(t, `{"errors":[{"message":"輸入參數錯誤","extensions":{"code":10001,"details":["body: 必須是 JSON 物件"]}}]}`, w.Body.String())
+	})
+}
+
+func TestMutation(t *testing.T) {
+	task := &model.Task{ID: 1, Name: "task1", Status: model.StatusTodo}
+	t.Run("CreateTask", func(t *testing.T) {
+		dueAt := time.Date(2022, 5, 1, 0, 0, 0, 0, time.UTC)
+		mockService := new(mocks.Service)
+		mockService.On("CreateTask", mock.Anything, &model.Task{
+			ProjectID: 2,
+			Name:      "task1",
+			Priority:  3,
+			DueAt:     &dueAt,
+			Tags:      []string{"home"},
+			BlockedBy: []uint32{4},
+		}).Return(task, nil).Once()
+		h := NewHandler(mockService, nil)
+
+		w := post(h, `mutation {
+			createTask(input: {projectId: "2", name: "task1", priority: 3, dueAt: "2022-05-01T00:00:00Z", tags: ["home"], blockedBy: ["4"]}) { id status }
+		}`, nil, "")
+		assert.JSONEq(t, `{"data":{"createTask":{"id":"1","status":"TODO"}}}`, w.Body.String())
+		mockService.AssertExpectations(t)
+	})
+	t.Run("CreateTaskInvalidParams", func(t *testing.T) {
+		mockService := new(mocks.Service)
+		h := NewHandler(mockService, nil)
+
+		resp := decode(t, post(h, `mutation { createTask(input: {name: "", priority: 6, tags: ["a,b"]}) { id } }`, nil, ""))
+		assert.Nil(t, resp["data"])
+		assert.Equal(t, errorJSON(errcode.InvalidParams.WithDetails(
+			"input.name: 必須是非空字串",
+			"input.priority: 必須是 0 到 5 之間的整數",
+			"input.tags: 每個標籤最長 32 個字元且不能包含逗號",
+		), "createTask"), resp["errors"])
+
+		resp = decode(t, post(h, `mutation { createTask(input: {name: "task1", blockedBy: ["0"]}) { id } }`, nil, ""))
+		assert.Equal(t, errorJSON(errcode.InvalidParams.WithDetails("input.blockedBy: 必須是 1 到 4294967295 之間的整數"), "createTask"), resp["errors"])
+		mockService.AssertNotCalled(t, "CreateTask", mock.Anything, mock.Anything)
+	})
+	t.Run("UpdateTask", func(t *testing.T) {
+		mockService := new(mocks.Service)
+		mockService.On("UpdateTask", mock.Anything, uint32(1), &model.Task{Name: "task1", Status: model.StatusDone}).Return(nil, errcode.TaskBlocked.WithDetails("blocked_by: 2")).Once()
+		h := NewHandler(mockService, nil)
+
+		resp := decode(t, post(h, `mutation { updateTask(id: "1", input: {name: "task1", status: DONE}) { id } }`, nil, ""))
+		assert.Equal(t, errorJSON(errcode.TaskBlocked.WithDetails("blocked_by: 2"), "updateTask"), resp["errors"])
+		mockService.AssertExpectations(t)
+	})
+	t.Run("PatchTask", func(t *testing.T) {
+		status := model.StatusDone
+		parentID := uint32(0)
+		mockService := new(mocks.Service)
+		mockService.On("PatchTask", mock.Anything, uint32(1), &model.TaskPatch{
+			Status:   &status,
+			DueAt:    &time.Time{},
+			ParentID: &parentID,
+		}).Return(task, nil).Once()
+		h := NewHandler(mockService, nil)
+
+		w := post(h, `mutation { patchTask(id: "1", input: {status: DONE, clearDueAt: true, parentId: "0"}) { id } }`, nil, "")
+		assert.JSONEq(t, `{"data":{"patchTask":{"id":"1"}}}`, w.Body.String())
+
+		resp := decode(t, post(h, `mutation { patchTask(id: "1", input: {dueAt: "2022-05-01T00:00:00Z", clearDueAt: true}) { id } }`, nil, ""))
+		assert.Equal(t, errorJSON(errcode.InvalidParams.WithDetails("input.clearDueAt: 不能與 dueAt 一起使用"), "patchTask"), resp["errors"])
+		mockService.AssertExpectations(t)
+	})
+	t.Run("DeleteTask", func(t *testing.T) {
+		mockService := new(mocks.Service)
+		mockService.On("DeleteTask", mock.Anything, uint32(1)).Return(nil).Once()
+		h := NewHandler(mockService, nil)
+
+		w := post(h, `mutation { deleteTask(id: "1") }`, nil, "")
+		assert.JSONEq(t, `{"data":{"deleteTask":"1"}}`, w.Body.String())
+		mockService.AssertExpectations(t)
+	})
+	t.Run("Forbidden", func(t *testing.T) {
+		mockService := new(mocks.Service)
+		mockService.On("GetTask", uint32(1)).Return(task, nil).Once()
+		h := NewHandler(mockService, nil)
+		ctx := auth.NewContext(context.Background(), &auth.Principal{Subject: "apikey:1", Scopes: []string{auth.ScopeTasksRead}})
+		exec := func(query string) map[string]interface{} {
+			body, _ := json.Marshal(request{Query: query})
+			req, _ := http.NewRequestWithContext(ctx, "POST", "/graphql", bytes.NewReader(body))
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+			return decode(t, w)
+		}
+
+		resp := exec(`mutation { deleteTask(id: "1") }`)
+		assert.Equal(t, errorJSON(errcode.Forbidden.WithDetails("需要 tasks:write 權限"), "deleteTask"), resp["errors"])
+		resp = exec(`query { task(id: "1") { id } }`)
+		assert.Equal(t, map[string]interface{}{"task": map[string]interface{}{"id": "1"}}, resp["data"])
+		mockService.AssertNotCalled(t, "DeleteTask", mock.Anything, mock.Anything)
+		mockService.AssertExpectations(t)
+	})
+}
+
+func TestSubscription(t *testing.T) {
+	events := []*model.Event{
+		{Type: model.EventTaskCreated, Task: &model.Task{ID: 1, ProjectID: 1, Name: "task1", Status: model.StatusTodo}},
+		{Type: model.EventTaskCreated, Task: &model.Task{ID: 2, Name: "task2", Status: model.StatusTodo}},
+		{Type: model.EventTaskUpdated, Task: &model.Task{ID: 1, ProjectID: 1, Name: "task1", Status: model.StatusDone}},
+	}
+	next := func(data string) string {
+		return fmt.Sprintf("event:next\ndata:%s\n\n", data)
+	}
+	const complete = "event:complete\ndata:\n\n"
+	t.Run("Replay", func(t *testing.T) {
+		bus := event.NewBus(10)
+		for _, e := range events {
+			bus.Publish(e)
+		}
+		mockService := new(mocks.Service)
+		mockService.On("SubscribeEvents", uint64(1)).Return(bus.Subscribe(1)).Once()
+		// The stream ends once the replayed events are sent.
+		bus.Close()
+		h := NewHandler(mockService, nil)
+
+		w := post(h, `subscription { taskChanged(projectId: "1", lastEventId: "1") { id type task { id status } } }`, nil, "text/event-stream")
+		assert.Equal(t, 200, w.Code)
+		assert.Equal(t, "text/event-stream", w.Header().Get("Content-Type"))
+		assert.Equal(t, next(`{"data":{"taskChanged":{"id":"3","type":"task.updated","task":{"id":"1","status":"DONE"}}}}`)+complete, w.Body.String())
+		mockService.AssertExpectations(t)
+	})
+	t.Run("Shutdown", func(t *testing.T) {
+		bus := event.NewBus(10)
+		subscribed := make(chan struct{})
+		mockService := new(mocks.Service)
+		mockService.On("SubscribeEvents", uint64(0)).Return(func(lastID uint64) *event.Subscription {
+			close(subscribed)
+			return bus.Subscribe(lastID)
+		}).Once()
+		done := make(chan struct{})
+		h := NewHandler(mockService, done)
+
+		finished := make(chan *httptest.ResponseRecorder)
+		go func() {
+			finished <- post(h, `subscription { taskChanged { id } }`, nil, "text/event-stream")
+		}()
+		<-subscribed
+		close(done)
+		select {
+		case w := <-finished:
+			assert.Equal(t, complete, w.Body.String())
+		case <-time.After(time.Second):
+			t.Fatal("subscription still open after shutdown")
+		}
+	})
+	t.Run("InvalidParams", func(t *testing.T) {
+		mockService := new(mocks.Service)
+		h := NewHandler(mockService, nil)
+
+		w := post(h, `subscription { taskChanged(lastEventId: "-1") { id } }`, nil, "text/event-stream")
+		assert.Equal(t, next(`{"errors":[{"message":"輸入參數錯誤","extensions":{"code":10001,"details":["lastEventId: 必須是非負整數"]}}]}`)+complete, w.Body.String())
+		mockService.AssertNotCalled(t, "SubscribeEvents", mock.Anything)
+	})
+	t.Run("Unsupported", func(t *testing.T) {
+		h := &Handler{schema: graphql.MustParseSchema(`type Query { ping: Boolean! }`, &pingResolver{})}
+
+		w := post(h, `subscription { ping }`, nil, "text/event-stream")
+		assert.Equal(t, 200, w.Code)
+		assert.Equal(t, next(`{"errors":[{"message":"伺服器錯誤","extensions":{"code":10000}}]}`)+complete, w.Body.String())
+	})
+	t.Run("Query", func(t *testing.T) {
+		mockService := new(mocks.Service)
+		mockService.On("GetTask", uint32(1)).Return(events[0].Task, nil).Once()
+		h := NewHandler(mockService, nil)
+
+		w := post(h, `{ task(id: "1") { id } }`, nil, "text/event-stream")
+		assert.Equal(t, next(`{"data":{"task":{"id":"1"}}}`)+complete, w.Body.String())
+		mockService.AssertExpectations(t)
+	})
+}
+
+// pingResolver serves a schema without subscriptions.
+type pingResolver struct{}
+
+func (*pingResolver) Ping() bool {
+	return true
+}
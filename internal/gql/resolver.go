@@ -0,0 +1,403 @@
+package gql
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	graphql "github.com/graph-gophers/graphql-go"
+	"github.com/wagaru/task/internal/auth"
+	"github.com/wagaru/task/internal/errcode"
+	"github.com/wagaru/task/internal/model"
+	"github.com/wagaru/task/internal/service"
+)
+
+const maxTasksLimit = 1000
+
+var sorts = map[string]string{
+	"ID":         model.SortByID,
+	"NAME":       model.SortByName,
+	"CREATED_AT": model.SortByCreatedAt,
+}
+
+// resolver is the root of the schema, with the fields of Query, Mutation
+// and Subscription.
+type resolver struct {
+	svc  service.Service
+	done <-chan struct{}
+}
+
+type taskFilter struct {
+	ProjectID *graphql.ID
+	ParentID  *graphql.ID
+	Status    *string
+	Name      *string
+	Tags      *[]string
+	AllTags   *bool
+}
+
+type createTaskInput struct {
+	ProjectID   *graphql.ID
+	ParentID    *graphql.ID
+	Name        string
+	Description *string
+	Priority    *int32
+	DueAt       *graphql.Time
+	Recurrence  *string
+	Tags        *[]string
+	BlockedBy   *[]graphql.ID
+}
+
+type updateTaskInput struct {
+	Name        string
+	Status      string
+	Description *string
+	Priority    *int32
+	DueAt       *graphql.Time
+	Recurrence  *string
+}
+
+type patchTaskInput struct {
+	Name        *string
+	Status      *string
+	Description *string
+	Priority    *int32
+	DueAt       *graphql.Time
+	ClearDueAt  *bool
+	Recurrence  *string
+	ParentID    *graphql.ID
+}
+
+func (r *resolver) Task(args struct{ ID graphql.ID }) (*taskResolver, error) {
+	id, err := parseID("id", args.ID, 1)
+	if err != nil {
+		return nil, toError(err)
+	}
+	task, err := r.svc.GetTask(id)
+	if err != nil {
+		return nil, toError(err)
+	}
+	return &taskResolver{task}, nil
+}
+
+func (r *resolver) Tasks(args struct {
+	Filter *taskFilter
+	Sort   string
+	Desc   bool
+	First  int32
+	After  *string
+}) (*taskPageResolver, error) {
+	if args.First < 1 || args.First > maxTasksLimit {
+		return nil, toError(errcode.InvalidParams.WithDetails(fmt.Sprintf("first: 必須是 1 到 %d 之間的整數", maxTasksLimit)))
+	}
+	query := &model.TaskQuery{
+		Sort:  sorts[args.Sort],
+		Desc:  args.Desc,
+		Limit: int(args.First),
+	}
+	if args.After != nil {
+		query.Cursor = *args.After
+	}
+	if f := args.Filter; f != nil {
+		var err error
+		if query.ProjectID, err = parseOptionalID("filter.projectId", f.ProjectID); err != nil {
+			return nil, toError(err)
+		}
+		if query.ParentID, err = parseOptionalID("filter.parentId", f.ParentID); err != nil {
+			return nil, toError(err)
+		}
+		if f.Status != nil {
+			status := toStatus(*f.Status)
+			query.Status = &status
+		}
+		if f.Name != nil {
+			query.Name = *f.Name
+		}
+		if f.Tags != nil {
+			for _, tag := range *f.Tags {
+				if len([]rune(tag)) > model.MaxTagLength {
+					return nil, toError(errcode.InvalidParams.WithDetails(fmt.Sprintf("filter.tags: 每個標籤最長 %d 個字元", model.MaxTagLength)))
+				}
+			}
+			query.Tags = model.NormalizeTags(*f.Tags)
+		}
+		query.AllTags = f.AllTags != nil && *f.AllTags
+	}
+	tasks, next, err := r.svc.GetTasks(query)
+	if err != nil {
+		return nil, toError(err)
+	}
+	return &taskPageResolver{tasks: tasks, next: next}, nil
+}
+
+func (r *resolver) CreateTask(ctx context.Context, args struct{ Input createTaskInput }) (*taskResolver, error) {
+	if err := auth.Check(ctx, auth.ScopeTasksWrite); err != nil {
+		return nil, toError(err)
+	}
+	in := args.Input
+	task := &model.Task{
+		Name:        in.Name,
+		Description: stringValue(in.Description),
+		Recurrence:  stringValue(in.Recurrence),
+		DueAt:       timeValue(in.DueAt),
+	}
+	projectID, err := parseOptionalID("input.projectId", in.ProjectID)
+	if err != nil {
+		return nil, toError(err)
+	}
+	parentID, err := parseOptionalID("input.parentId", in.ParentID)
+	if err != nil {
+		return nil, toError(err)
+	}
+	if projectID != nil {
+		task.ProjectID = *projectID
+	}
+	if parentID != nil {
+		task.ParentID = *parentID
+	}
+	if in.BlockedBy != nil {
+		for _, blocker := range *in.BlockedBy {
+			id, err := parseID("input.blockedBy", blocker, 1)
+			if err != nil {
+				return nil, toError(err)
+			}
+			task.BlockedBy = append(task.BlockedBy, id)
+		}
+	}
+	details := checkFields(&in.Name, in.Description, in.Priority, in.Recurrence)
+	if in.Priority != nil {
+		task.Priority = uint8(*in.Priority)
+	}
+	if in.Tags != nil {
+		for _, tag := range *in.Tags {
+			if len([]rune(tag)) > model.MaxTagLength || strings.Contains(tag, ",") {
+				details = append(details, fmt.Sprintf("input.tags: 每個標籤最長 %d 個字元且不能包含逗號", model.MaxTagLength))
+				break
+			}
+		}
+		task.Tags = *in.Tags
+	}
+	if len(details) > 0 {
+		return nil, toError(errcode.InvalidParams.WithDetails(details...))
+	}
+	created, err := r.svc.CreateTask(ctx, task)
+	if err != nil {
+		return nil, toError(err)
+	}
+	return &taskResolver{created}, nil
+}
+
+func (r *resolver) UpdateTask(ctx context.Context, args struct {
+	ID    graphql.ID
+	Input updateTaskInput
+}) (*taskResolver, error) {
+	if err := auth.Check(ctx, auth.ScopeTasksWrite); err != nil {
+		return nil, toError(err)
+	}
+	id, err := parseID("id", args.ID, 1)
+	if err != nil {
+		return nil, toError(err)
+	}
+	in := args.Input
+	if details := checkFields(&in.Name, in.Description, in.Priority, in.Recurrence); len(details) > 0 {
+		return nil, toError(errcode.InvalidParams.WithDetails(details...))
+	}
+	task := &model.Task{
+		Name:        in.Name,
+		Status:      toStatus(in.Status),
+		Description: stringValue(in.Description),
+		DueAt:       timeValue(in.DueAt),
+		Recurrence:  stringValue(in.Recurrence),
+	}
+	if in.Priority != nil {
+		task.Priority = uint8(*in.Priority)
+	}
+	updated, err := r.svc.UpdateTask(ctx, id, task)
+	if err != nil {
+		return nil, toError(err)
+	}
+	return &taskResolver{updated}, nil
+}
+
+func (r *resolver) PatchTask(ctx context.Context, args struct {
+	ID    graphql.ID
+	Input patchTaskInput
+}) (*taskResolver, error) {
+	if err := auth.Check(ctx, auth.ScopeTasksWrite); err != nil {
+		return nil, toError(err)
+	}
+	id, err := parseID("id", args.ID, 1)
+	if err != nil {
+		return nil, toError(err)
+	}
+	in := args.Input
+	var parentID *uint32
+	if in.ParentID != nil {
+		parent, err := parseID("input.parentId", *in.ParentID, 0)
+		if err != nil {
+			return nil, toError(err)
+		}
+		parentID = &parent
+	}
+	details := checkFields(in.Name, in.Description, in.Priority, in.Recurrence)
+	clearDueAt := in.ClearDueAt != nil && *in.ClearDueAt
+	if in.DueAt != nil && clearDueAt {
+		details = append(details, "input.clearDueAt: 不能與 dueAt 一起使用")
+	}
+	patch := &model.TaskPatch{
+		Name:        in.Name,
+		Description: in.Description,
+		Recurrence:  in.Recurrence,
+		DueAt:       timeValue(in.DueAt),
+		ParentID:    parentID,
+	}
+	if clearDueAt {
+		patch.DueAt = &time.Time{}
+	}
+	if in.Status != nil {
+		status := toStatus(*in.Status)
+		patch.Status = &status
+	}
+	if in.Priority != nil {
+		priority := uint8(*in.Priority)
+		patch.Priority = &priority
+	}
+	if len(details) > 0 {
+		return nil, toError(errcode.InvalidParams.WithDetails(details...))
+	}
+	patched, err := r.svc.PatchTask(ctx, id, patch)
+	if err != nil {
+		return nil, toError(err)
+	}
+	return &taskResolver{patched}, nil
+}
+
+func (r *resolver) DeleteTask(ctx context.Context, args struct{ ID graphql.ID }) (graphql.ID, error) {
+	if err := auth.Check(ctx, auth.ScopeTasksWrite); err != nil {
+		return "", toError(err)
+	}
+	id, err := parseID("id", args.ID, 1)
+	if err != nil {
+		return "", toError(err)
+	}
+	if err := r.svc.DeleteTask(ctx, id); err != nil {
+		return "", toError(err)
+	}
+	return args.ID, nil
+}
+
+// TaskChanged forwards the matching events of a bus subscription until the
+// request ends, the server shuts down or the bus drops the subscription.
+func (r *resolver) TaskChanged(ctx context.Context, args struct {
+	ProjectID   *graphql.ID
+	Status      *string
+	LastEventID *graphql.ID
+}) (<-chan *eventResolver, error) {
+	projectID, err := parseOptionalID("projectId", args.ProjectID)
+	if err != nil {
+		return nil, queryError(err)
+	}
+	var lastEventID uint64
+	if args.LastEventID != nil {
+		if lastEventID, err = strconv.ParseUint(string(*args.LastEventID), 10, 64); err != nil {
+			return nil, queryError(errcode.InvalidParams.WithDetails("lastEventId: 必須是非負整數"))
+		}
+	}
+	var status *model.Status
+	if args.Status != nil {
+		s := toStatus(*args.Status)
+		status = &s
+	}
+	sub := r.svc.SubscribeEvents(lastEventID)
+	c := make(chan *eventResolver)
+	go func() {
+		defer close(c)
+		defer sub.Close()
+		for {
+			select {
+			case e, ok := <-sub.C:
+				if !ok {
+					return
+				}
+				if projectID != nil && e.Task.ProjectID != *projectID {
+					continue
+				}
+				if status != nil && e.Task.Status != *status {
+					continue
+				}
+				select {
+				case c <- &eventResolver{e}:
+				case <-ctx.Done():
+					return
+				}
+			case <-ctx.Done():
+				return
+			case <-r.done:
+				return
+			}
+		}
+	}()
+	return c, nil
+}
+
+// checkFields applies the limits the REST API puts on the task fields. Nil
+// fields are not checked.
+func checkFields(name, description *string, priority *int32, recurrence *string) []string {
+	var details []string
+	if name != nil && *name == "" {
+		details = append(details, "input.name: 必須是非空字串")
+	}
+	if description != nil && len(*description) > 2000 {
+		details = append(details, "input.description: 必須是長度不超過 2000 的字串")
+	}
+	if priority != nil && (*priority < 0 || *priority > int32(model.MaxPriority)) {
+		details = append(details, fmt.Sprintf("input.priority: 必須是 0 到 %d 之間的整數", model.MaxPriority))
+	}
+	if recurrence != nil && len(*recurrence) > 200 {
+		details = append(details, "input.recurrence: 必須是長度不超過 200 的字串")
+	}
+	return details
+}
+
+// parseID reads an ID holding a task or project id of at least min.
+func parseID(field string, id graphql.ID, min uint64) (uint32, error) {
+	n, err := strconv.ParseUint(string(id), 10, 32)
+	if err != nil || n < min {
+		return 0, errcode.InvalidParams.WithDetails(fmt.Sprintf("%s: 必須是 %d 到 %d 之間的整數", field, min, uint32(math.MaxUint32)))
+	}
+	return uint32(n), nil
+}
+
+func parseOptionalID(field string, id *graphql.ID) (*uint32, error) {
+	if id == nil {
+		return nil, nil
+	}
+	n, err := parseID(field, *id, 0)
+	if err != nil {
+		return nil, err
+	}
+	return &n, nil
+}
+
+// toStatus converts a Status enum value, which the schema has already
+// validated.
+func toStatus(s string) model.Status {
+	return model.Status(strings.ToLower(s))
+}
+
+func stringValue(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+func timeValue(t *graphql.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	return &t.Time
+}
@@ -0,0 +1,154 @@
+package gql
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	graphql "github.com/graph-gophers/graphql-go"
+	"github.com/wagaru/task/internal/model"
+)
+
+type taskResolver struct {
+	task *model.Task
+}
+
+func (r *taskResolver) ID() graphql.ID {
+	return toID(r.task.ID)
+}
+
+func (r *taskResolver) ProjectID() *graphql.ID {
+	return optionalID(r.task.ProjectID)
+}
+
+func (r *taskResolver) ParentID() *graphql.ID {
+	return optionalID(r.task.ParentID)
+}
+
+func (r *taskResolver) Name() string {
+	return r.task.Name
+}
+
+func (r *taskResolver) Status() string {
+	return strings.ToUpper(string(r.task.Status))
+}
+
+func (r *taskResolver) Description() string {
+	return r.task.Description
+}
+
+func (r *taskResolver) Priority() int32 {
+	return int32(r.task.Priority)
+}
+
+func (r *taskResolver) DueAt() *graphql.Time {
+	return optionalTime(r.task.DueAt)
+}
+
+func (r *taskResolver) Recurrence() *string {
+	if r.task.Recurrence == "" {
+		return nil
+	}
+	return &r.task.Recurrence
+}
+
+func (r *taskResolver) Tags() []string {
+	if r.task.Tags == nil {
+		return []string{}
+	}
+	return r.task.Tags
+}
+
+func (r *taskResolver) BlockedBy() []graphql.ID {
+	ids := make([]graphql.ID, 0, len(r.task.BlockedBy))
+	for _, id := range r.task.BlockedBy {
+		ids = append(ids, toID(id))
+	}
+	return ids
+}
+
+func (r *taskResolver) CreatedAt() graphql.Time {
+	return graphql.Time{Time: r.task.CreatedAt}
+}
+
+func (r *taskResolver) UpdatedAt() graphql.Time {
+	return graphql.Time{Time: r.task.UpdatedAt}
+}
+
+func (r *taskResolver) CompletedAt() *graphql.Time {
+	return optionalTime(r.task.CompletedAt)
+}
+
+func (r *taskResolver) CreatedBy() *string {
+	if r.task.CreatedBy == "" {
+		return nil
+	}
+	return &r.task.CreatedBy
+}
+
+func (r *taskResolver) UpdatedBy() *string {
+	if r.task.UpdatedBy == "" {
+		return nil
+	}
+	return &r.task.UpdatedBy
+}
+
+type taskPageResolver struct {
+	tasks []*model.Task
+	next  string
+}
+
+func (r *taskPageResolver) Nodes() []*taskResolver {
+	nodes := make([]*taskResolver, 0, len(r.tasks))
+	for _, task := range r.tasks {
+		nodes = append(nodes, &taskResolver{task})
+	}
+	return nodes
+}
+
+func (r *taskPageResolver) NextCursor() *string {
+	if r.next == "" {
+		return nil
+	}
+	return &r.next
+}
+
+type eventResolver struct {
+	event *model.Event
+}
+
+func (r *eventResolver) ID() graphql.ID {
+	return graphql.ID(strconv.FormatUint(r.event.ID, 10))
+}
+
+func (r *eventResolver) Type() string {
+	return r.event.Type
+}
+
+func (r *eventResolver) Task() *taskResolver {
+	return &taskResolver{r.event.Task}
+}
+
+func (r *eventResolver) At() graphql.Time {
+	return graphql.Time{Time: r.event.At}
+}
+
+func toID(id uint32) graphql.ID {
+	return graphql.ID(strconv.FormatUint(uint64(id), 10))
+}
+
+// optionalID is null for the zero id, which means no project or parent.
+func optionalID(id uint32) *graphql.ID {
+	if id == 0 {
+		return nil
+	}
+	gid := toID(id)
+	return &gid
+}
+
+func optionalTime(t *time.Time) *graphql.Time {
+	if t == nil {
+		return nil
+	}
+	return &graphql.Time{Time: *t}
+}
@@ -0,0 +1,17 @@
+package model
+
+import "time"
+
+// APIKey lets whoever holds its token use the API within Scopes. Only Hash,
+// the SHA-256 of the token, is stored; Token is set on the key returned when
+// it is created and cannot be recovered afterwards. Prefix is the start of
+// the token, to tell keys apart.
+type APIKey struct {
+	ID        uint32    `json:"id"`
+	Name      string    `json:"name"`
+	Prefix    string    `json:"prefix"`
+	Hash      string    `json:"hash,omitempty"`
+	Token     string    `json:"token,omitempty"`
+	Scopes    []string  `json:"scopes"`
+	CreatedAt time.Time `json:"created_at"`
+}
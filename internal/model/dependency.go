@@ -0,0 +1,76 @@
+package model
+
+import "sort"
+
+// TaskDependencies lists the tasks that have to be finished before a task
+// (BlockedBy) and the tasks waiting on it (Blocking).
+type TaskDependencies struct {
+	BlockedBy []*Task `json:"blocked_by"`
+	Blocking  []*Task `json:"blocking"`
+}
+
+// NormalizeIDs drops zero and duplicate IDs. The result is sorted and never
+// shares memory with ids.
+func NormalizeIDs(ids []uint32) []uint32 {
+	seen := make(map[uint32]bool, len(ids))
+	var result []uint32
+	for _, id := range ids {
+		if id == 0 || seen[id] {
+			continue
+		}
+		seen[id] = true
+		result = append(result, id)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i] < result[j] })
+	return result
+}
+
+// IsBlockedBy reports whether id is among the task's blockers.
+func (t *Task) IsBlockedBy(id uint32) bool {
+	for _, blocker := range t.BlockedBy {
+		if blocker == id {
+			return true
+		}
+	}
+	return false
+}
+
+// TopologicalOrder orders tasks so that every task comes after its
+// blockers. Blockers that are not among tasks are ignored, and tasks that
+// are free to go keep their relative order. It reports false if the
+// dependencies among tasks form a cycle.
+func TopologicalOrder(tasks []*Task) ([]*Task, bool) {
+	index := make(map[uint32]int, len(tasks))
+	for i, task := range tasks {
+		index[task.ID] = i
+	}
+	waiting := make([]int, len(tasks))
+	blocking := make([][]int, len(tasks))
+	for i, task := range tasks {
+		for _, blocker := range task.BlockedBy {
+			if j, ok := index[blocker]; ok {
+				waiting[i]++
+				blocking[j] = append(blocking[j], i)
+			}
+		}
+	}
+	var ready []int
+	for i := range tasks {
+		if waiting[i] == 0 {
+			ready = append(ready, i)
+		}
+	}
+	result := make([]*Task, 0, len(tasks))
+	for len(ready) > 0 {
+		i := ready[0]
+		ready = ready[1:]
+		result = append(result, tasks[i])
+		for _, j := range blocking[i] {
+			if waiting[j]--; waiting[j] == 0 {
+				at := sort.SearchInts(ready, j)
+				ready = append(ready[:at], append([]int{j}, ready[at:]...)...)
+			}
+		}
+	}
+	return result, len(result) == len(tasks)
+}
@@ -0,0 +1,21 @@
+package model
+
+import "time"
+
+const (
+	EventTaskCreated = "task.created"
+	EventTaskUpdated = "task.updated"
+	EventTaskDeleted = "task.deleted"
+)
+
+// EventTypes lists every event the service emits.
+var EventTypes = []string{EventTaskCreated, EventTaskUpdated, EventTaskDeleted}
+
+// Event reports a change to a task. A deleted event carries the task as it
+// was before it went. IDs are handed out in order by the event bus.
+type Event struct {
+	ID   uint64    `json:"id,omitempty"`
+	Type string    `json:"type"`
+	Task *Task     `json:"task"`
+	At   time.Time `json:"at"`
+}
@@ -0,0 +1,13 @@
+package model
+
+import "time"
+
+// Project groups tasks. Task names only need to be unique within their
+// project; tasks with a zero ProjectID belong to no project.
+type Project struct {
+	ID          uint32    `json:"id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
@@ -0,0 +1,29 @@
+package model
+
+import "time"
+
+const (
+	SortByID        = "id"
+	SortByName      = "name"
+	SortByCreatedAt = "created_at"
+)
+
+// TaskQuery filters, orders and pages a task listing. The zero value lists
+// every task in ID order. ProjectID set to zero matches tasks outside any
+// project, ParentID set to zero matches top-level tasks. Tags matches tasks
+// carrying any of them, or all of them when AllTags is set. Blocker matches
+// the tasks waiting on that task, DueBefore those due before that time.
+type TaskQuery struct {
+	ProjectID *uint32
+	ParentID  *uint32
+	Blocker   *uint32
+	Status    *Status
+	DueBefore *time.Time
+	Name      string
+	Tags      []string
+	AllTags   bool
+	Sort      string
+	Desc      bool
+	Limit     int
+	Cursor    string
+}
@@ -0,0 +1,19 @@
+package model
+
+import "time"
+
+const (
+	ReminderUpcoming = "upcoming"
+	ReminderOverdue  = "overdue"
+)
+
+// Reminder is a notice about an open task's due date: upcoming when it is
+// fired ahead of the due time, overdue once the due time has passed. At is
+// the time it was scheduled for.
+type Reminder struct {
+	Kind   string    `json:"kind"`
+	TaskID uint32    `json:"task_id"`
+	Name   string    `json:"name"`
+	DueAt  time.Time `json:"due_at"`
+	At     time.Time `json:"at"`
+}
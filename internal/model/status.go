@@ -0,0 +1,56 @@
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+type Status string
+
+const (
+	StatusTodo       Status = "todo"
+	StatusInProgress Status = "in_progress"
+	StatusBlocked    Status = "blocked"
+	StatusDone       Status = "done"
+	StatusCancelled  Status = "cancelled"
+)
+
+var Statuses = []Status{StatusTodo, StatusInProgress, StatusBlocked, StatusDone, StatusCancelled}
+
+// ParseStatus accepts a status name, or the legacy 0 (todo) and 1 (done).
+func ParseStatus(s string) (Status, error) {
+	switch s {
+	case "0":
+		return StatusTodo, nil
+	case "1":
+		return StatusDone, nil
+	}
+	for _, status := range Statuses {
+		if Status(s) == status {
+			return status, nil
+		}
+	}
+	return "", fmt.Errorf("unknown status %q", s)
+}
+
+// Closed reports whether no more work is expected on a task in this status.
+func (s Status) Closed() bool {
+	return s == StatusDone || s == StatusCancelled
+}
+
+func (s *Status) UnmarshalJSON(b []byte) error {
+	var name string
+	if err := json.Unmarshal(b, &name); err != nil {
+		var legacy uint8
+		if err := json.Unmarshal(b, &legacy); err != nil {
+			return fmt.Errorf("status must be a string or 0/1: %s", b)
+		}
+		name = fmt.Sprint(legacy)
+	}
+	status, err := ParseStatus(name)
+	if err != nil {
+		return err
+	}
+	*s = status
+	return nil
+}
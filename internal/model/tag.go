@@ -0,0 +1,55 @@
+package model
+
+import (
+	"sort"
+	"strings"
+)
+
+const MaxTagLength = 32
+
+// Tag is a label together with the number of tasks carrying it.
+type Tag struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+// NormalizeTags trims and lower-cases tags, dropping empty and duplicate
+// ones. The result is sorted and never shares memory with tags.
+func NormalizeTags(tags []string) []string {
+	if len(tags) == 0 {
+		return nil
+	}
+	seen := make(map[string]bool, len(tags))
+	result := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		result = append(result, tag)
+	}
+	if len(result) == 0 {
+		return nil
+	}
+	sort.Strings(result)
+	return result
+}
+
+// HasTags reports whether task carries all of tags, or any of them when all
+// is false.
+func (t *Task) HasTags(tags []string, all bool) bool {
+	for _, tag := range tags {
+		found := false
+		for _, own := range t.Tags {
+			if own == tag {
+				found = true
+				break
+			}
+		}
+		if found != all {
+			return found
+		}
+	}
+	return all || len(tags) == 0
+}
@@ -1,7 +1,100 @@
 package model
 
+import "time"
+
+const MaxPriority uint8 = 5
+
 type Task struct {
-	ID     uint32 `json:"id"`
-	Name   string `json:"name"`
-	Status uint8  `json:"status"`
+	ID          uint32     `json:"id"`
+	ProjectID   uint32     `json:"project_id,omitempty"`
+	ParentID    uint32     `json:"parent_id,omitempty"`
+	Name        string     `json:"name"`
+	Status      Status     `json:"status"`
+	Description string     `json:"description"`
+	Priority    uint8      `json:"priority"`
+	DueAt       *time.Time `json:"due_at"`
+	Recurrence  string     `json:"recurrence,omitempty"`
+	Tags        []string   `json:"tags,omitempty"`
+	BlockedBy   []uint32   `json:"blocked_by,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+	CreatedBy   string     `json:"created_by,omitempty"`
+	UpdatedBy   string     `json:"updated_by,omitempty"`
+	CompletedAt *time.Time `json:"completed_at"`
+	RemindedAt  *time.Time `json:"reminded_at,omitempty"`
+	OverdueAt   *time.Time `json:"overdue_at,omitempty"`
+}
+
+// TaskPatch carries the fields of a partial update; nil fields are left
+// untouched. A DueAt pointing at the zero time clears the due date, and a
+// ParentID pointing at zero detaches the task from its parent and an empty
+// Recurrence stops the task from repeating. Expect
+// holds values the stored task must currently have for the patch to apply,
+// as with JSON Patch "test" operations.
+type TaskPatch struct {
+	Name        *string
+	Status      *Status
+	Description *string
+	Priority    *uint8
+	DueAt       *time.Time
+	Recurrence  *string
+	ParentID    *uint32
+	Expect      *TaskPatch
+}
+
+func (p *TaskPatch) Apply(task *Task) {
+	if p.Name != nil {
+		task.Name = *p.Name
+	}
+	if p.Status != nil {
+		task.Status = *p.Status
+	}
+	if p.Description != nil {
+		task.Description = *p.Description
+	}
+	if p.Priority != nil {
+		task.Priority = *p.Priority
+	}
+	if p.DueAt != nil {
+		task.DueAt = nil
+		if !p.DueAt.IsZero() {
+			dueAt := p.DueAt.UTC()
+			task.DueAt = &dueAt
+		}
+	}
+	if p.Recurrence != nil {
+		task.Recurrence = *p.Recurrence
+	}
+	if p.ParentID != nil {
+		task.ParentID = *p.ParentID
+	}
+}
+
+// Matches reports whether every field set in p has the same value in task.
+func (p *TaskPatch) Matches(task *Task) bool {
+	if p.Name != nil && *p.Name != task.Name {
+		return false
+	}
+	if p.Status != nil && *p.Status != task.Status {
+		return false
+	}
+	if p.Description != nil && *p.Description != task.Description {
+		return false
+	}
+	if p.Priority != nil && *p.Priority != task.Priority {
+		return false
+	}
+	if p.Recurrence != nil && *p.Recurrence != task.Recurrence {
+		return false
+	}
+	if p.ParentID != nil && *p.ParentID != task.ParentID {
+		return false
+	}
+	if p.DueAt != nil {
+		if p.DueAt.IsZero() {
+			return task.DueAt == nil
+		}
+		return task.DueAt != nil && task.DueAt.Equal(*p.DueAt)
+	}
+	return true
 }
@@ -0,0 +1,29 @@
+package model
+
+// TaskNode is a task together with its subtasks, as listed in tree mode.
+type TaskNode struct {
+	*Task
+	Children []*TaskNode `json:"children"`
+}
+
+// BuildTree nests tasks under their parents, keeping the order of tasks at
+// every level, and returns the nodes directly under root. With a zero root
+// a task whose parent is not among tasks is returned at the top level.
+func BuildTree(tasks []*Task, root uint32) []*TaskNode {
+	nodes := make(map[uint32]*TaskNode, len(tasks))
+	for _, task := range tasks {
+		nodes[task.ID] = &TaskNode{Task: task, Children: []*TaskNode{}}
+	}
+	result := []*TaskNode{}
+	for _, task := range tasks {
+		node := nodes[task.ID]
+		parent, ok := nodes[task.ParentID]
+		switch {
+		case task.ParentID == root || (root == 0 && !ok):
+			result = append(result, node)
+		case ok:
+			parent.Children = append(parent.Children, node)
+		}
+	}
+	return result
+}
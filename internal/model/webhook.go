@@ -0,0 +1,54 @@
+package model
+
+import "time"
+
+// Webhook subscribes URL to task events; with no Events it receives all of
+// them. Secret keys the HMAC-SHA256 signature sent with each delivery.
+type Webhook struct {
+	ID        uint32    `json:"id"`
+	URL       string    `json:"url"`
+	Events    []string  `json:"events"`
+	Secret    string    `json:"secret,omitempty"`
+	Active    bool      `json:"active"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Subscribes reports whether the webhook wants events of the given type.
+func (w *Webhook) Subscribes(eventType string) bool {
+	if !w.Active {
+		return false
+	}
+	if len(w.Events) == 0 {
+		return true
+	}
+	for _, event := range w.Events {
+		if event == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+const (
+	DeliveryPending   = "pending"
+	DeliverySucceeded = "succeeded"
+	// DeliveryDead marks a delivery that failed every attempt.
+	DeliveryDead = "dead"
+)
+
+// WebhookDelivery is the log entry for sending one event to one webhook.
+// StatusCode and Error describe the latest attempt.
+type WebhookDelivery struct {
+	ID         uint64     `json:"id"`
+	WebhookID  uint32     `json:"webhook_id"`
+	URL        string     `json:"url"`
+	Event      *Event     `json:"event"`
+	Status     string     `json:"status"`
+	Attempts   int        `json:"attempts"`
+	StatusCode int        `json:"status_code,omitempty"`
+	Error      string     `json:"error,omitempty"`
+	NextRetry  *time.Time `json:"next_retry,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+}
@@ -0,0 +1,440 @@
+// Package recurrence parses and expands task recurrence rules: the
+// shorthands daily, weekly, monthly and yearly, or a subset of RFC 5545
+// RRULE.
+//
+// Supported RRULE parts are FREQ (DAILY, WEEKLY, MONTHLY or YEARLY),
+// INTERVAL, COUNT, UNTIL, BYMONTH, BYMONTHDAY and BYDAY. BYDAY takes an
+// ordinal such as 1MO or -1FR only with FREQ=MONTHLY, BYMONTHDAY is not
+// allowed with FREQ=WEEKLY and BYDAY is not allowed with FREQ=YEARLY. Weeks
+// start on Monday.
+package recurrence
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Frequency string
+
+const (
+	Daily   Frequency = "DAILY"
+	Weekly  Frequency = "WEEKLY"
+	Monthly Frequency = "MONTHLY"
+	Yearly  Frequency = "YEARLY"
+)
+
+// WeekdayNum is a BYDAY entry. N is zero for every such weekday of the
+// period, or the position of the weekday within the month, counted from the
+// end when negative.
+type WeekdayNum struct {
+	N       int
+	Weekday time.Weekday
+}
+
+// Rule is a parsed recurrence rule. A zero Count or Until leaves the series
+// unbounded on that side.
+type Rule struct {
+	Freq       Frequency
+	Interval   int
+	Count      int
+	Until      time.Time
+	ByMonth    []time.Month
+	ByMonthDay []int
+	ByDay      []WeekdayNum
+}
+
+const untilLayout = "20060102T150405Z"
+
+// maxEmptyPeriods bounds the search for a rule that can never match, such
+// as the 30th of February.
+const maxEmptyPeriods = 10000
+
+var weekdays = map[string]time.Weekday{
+	"SU": time.Sunday,
+	"MO": time.Monday,
+	"TU": time.Tuesday,
+	"WE": time.Wednesday,
+	"TH": time.Thursday,
+	"FR": time.Friday,
+	"SA": time.Saturday,
+}
+
+var weekdayCodes = [...]string{"SU", "MO", "TU", "WE", "TH", "FR", "SA"}
+
+// Parse reads a shorthand or an RRULE, with or without the "RRULE:" prefix.
+// Part names and values are case-insensitive.
+func Parse(s string) (*Rule, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	switch s {
+	case "DAILY", "WEEKLY", "MONTHLY", "YEARLY":
+		return &Rule{Freq: Frequency(s), Interval: 1}, nil
+	case "":
+		return nil, errors.New("empty rule")
+	}
+	s = strings.TrimPrefix(s, "RRULE:")
+	rule := &Rule{Interval: 1}
+	seen := make(map[string]bool)
+	for _, part := range strings.Split(s, ";") {
+		name, value, ok := strings.Cut(part, "=")
+		if !ok || value == "" {
+			return nil, fmt.Errorf("invalid part %q", part)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("duplicate %s", name)
+		}
+		seen[name] = true
+		var err error
+		switch name {
+		case "FREQ":
+			switch Frequency(value) {
+			case Daily, Weekly, Monthly, Yearly:
+				rule.Freq = Frequency(value)
+			default:
+				err = fmt.Errorf("unsupported FREQ %q", value)
+			}
+		case "INTERVAL":
+			rule.Interval, err = parseInt(name, value, 1, 1000)
+		case "COUNT":
+			rule.Count, err = parseInt(name, value, 1, 10000)
+		case "UNTIL":
+			rule.Until, err = parseUntil(value)
+		case "BYMONTH":
+			err = eachValue(value, func(v string) error {
+				month, err := parseInt(name, v, 1, 12)
+				rule.ByMonth = append(rule.ByMonth, time.Month(month))
+				return err
+			})
+		case "BYMONTHDAY":
+			err = eachValue(value, func(v string) error {
+				day, err := parseInt(name, v, -31, 31)
+				if err == nil && day == 0 {
+					err = fmt.Errorf("BYMONTHDAY cannot be 0")
+				}
+				rule.ByMonthDay = append(rule.ByMonthDay, day)
+				return err
+			})
+		case "BYDAY":
+			err = eachValue(value, func(v string) error {
+				day, err := parseWeekdayNum(v)
+				rule.ByDay = append(rule.ByDay, day)
+				return err
+			})
+		default:
+			err = fmt.Errorf("unsupported part %s", name)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := rule.validate(); err != nil {
+		return nil, err
+	}
+	return rule, nil
+}
+
+func (r *Rule) validate() error {
+	if r.Freq == "" {
+		return errors.New("FREQ is required")
+	}
+	if r.Count > 0 && !r.Until.IsZero() {
+		return errors.New("COUNT and UNTIL cannot be used together")
+	}
+	if r.Freq == Weekly && len(r.ByMonthDay) > 0 {
+		return errors.New("BYMONTHDAY cannot be used with FREQ=WEEKLY")
+	}
+	if r.Freq == Yearly && len(r.ByDay) > 0 {
+		return errors.New("BYDAY cannot be used with FREQ=YEARLY")
+	}
+	for _, day := range r.ByDay {
+		if day.N != 0 && r.Freq != Monthly {
+			return errors.New("BYDAY ordinals need FREQ=MONTHLY")
+		}
+	}
+	return nil
+}
+
+func parseInt(name, value string, min, max int) (int, error) {
+	n, err := strconv.Atoi(value)
+	if err != nil || n < min || n > max {
+		return 0, fmt.Errorf("%s must be an integer between %d and %d", name, min, max)
+	}
+	return n, nil
+}
+
+// parseUntil accepts a UTC date-time, or a date meaning the end of that day.
+func parseUntil(value string) (time.Time, error) {
+	if t, err := time.Parse(untilLayout, value); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse("20060102", value); err == nil {
+		return t.Add(24*time.Hour - time.Second), nil
+	}
+	return time.Time{}, fmt.Errorf("UNTIL must look like 20060102 or 20060102T150405Z")
+}
+
+func parseWeekdayNum(value string) (WeekdayNum, error) {
+	if len(value) < 2 {
+		return WeekdayNum{}, fmt.Errorf("invalid BYDAY %q", value)
+	}
+	code, ordinal := value[len(value)-2:], value[:len(value)-2]
+	weekday, ok := weekdays[code]
+	if !ok {
+		return WeekdayNum{}, fmt.Errorf("invalid BYDAY %q", value)
+	}
+	day := WeekdayNum{Weekday: weekday}
+	if ordinal != "" {
+		n, err := strconv.Atoi(ordinal)
+		if err != nil || n == 0 || n < -5 || n > 5 {
+			return WeekdayNum{}, fmt.Errorf("invalid BYDAY %q", value)
+		}
+		day.N = n
+	}
+	return day, nil
+}
+
+func eachValue(value string, fn func(string) error) error {
+	for _, v := range strings.Split(value, ",") {
+		if err := fn(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// String formats the rule as an RRULE without the prefix, with its parts in
+// a fixed order.
+func (r *Rule) String() string {
+	parts := []string{"FREQ=" + string(r.Freq)}
+	if r.Interval > 1 {
+		parts = append(parts, fmt.Sprintf("INTERVAL=%d", r.Interval))
+	}
+	if len(r.ByMonth) > 0 {
+		values := make([]string, len(r.ByMonth))
+		for i, month := range r.ByMonth {
+			values[i] = strconv.Itoa(int(month))
+		}
+		parts = append(parts, "BYMONTH="+strings.Join(values, ","))
+	}
+	if len(r.ByMonthDay) > 0 {
+		values := make([]string, len(r.ByMonthDay))
+		for i, day := range r.ByMonthDay {
+			values[i] = strconv.Itoa(day)
+		}
+		parts = append(parts, "BYMONTHDAY="+strings.Join(values, ","))
+	}
+	if len(r.ByDay) > 0 {
+		values := make([]string, len(r.ByDay))
+		for i, day := range r.ByDay {
+			values[i] = weekdayCodes[day.Weekday]
+			if day.N != 0 {
+				values[i] = strconv.Itoa(day.N) + values[i]
+			}
+		}
+		parts = append(parts, "BYDAY="+strings.Join(values, ","))
+	}
+	if r.Count > 0 {
+		parts = append(parts, fmt.Sprintf("COUNT=%d", r.Count))
+	}
+	if !r.Until.IsZero() {
+		parts = append(parts, "UNTIL="+r.Until.UTC().Format(untilLayout))
+	}
+	return strings.Join(parts, ";")
+}
+
+// Next returns the first occurrence after t of the series starting at
+// start, and false once the series has ended.
+func (r *Rule) Next(start, t time.Time) (time.Time, bool) {
+	var next time.Time
+	found := false
+	r.each(start, func(occurrence time.Time) bool {
+		if occurrence.After(t) {
+			next, found = occurrence, true
+			return false
+		}
+		return true
+	})
+	return next, found
+}
+
+// Expand returns the first n occurrences of the series starting at start,
+// fewer if the series ends earlier. As in RFC 5545, start itself is always
+// the first occurrence.
+func (r *Rule) Expand(start time.Time, n int) []time.Time {
+	var result []time.Time
+	if n <= 0 {
+		return result
+	}
+	r.each(start, func(occurrence time.Time) bool {
+		result = append(result, occurrence)
+		return len(result) < n
+	})
+	return result
+}
+
+// each calls fn with the occurrences in order until it returns false or the
+// series ends.
+func (r *Rule) each(start time.Time, fn func(time.Time) bool) {
+	count := 0
+	yield := func(t time.Time) bool {
+		if !r.Until.IsZero() && t.After(r.Until) {
+			return false
+		}
+		count++
+		if !fn(t) {
+			return false
+		}
+		return r.Count == 0 || count < r.Count
+	}
+	if !yield(start) {
+		return
+	}
+	interval := r.Interval
+	if interval < 1 {
+		interval = 1
+	}
+	empty := 0
+	for period := 0; empty < maxEmptyPeriods; period += interval {
+		candidates := r.candidates(start, period)
+		if len(candidates) == 0 {
+			empty++
+			continue
+		}
+		empty = 0
+		for _, t := range candidates {
+			if !t.After(start) {
+				continue
+			}
+			if !yield(t) {
+				return
+			}
+		}
+	}
+}
+
+// candidates lists the matching times, in order, of the period that lies
+// offset periods after the one containing start.
+func (r *Rule) candidates(start time.Time, offset int) []time.Time {
+	y, m, d := start.Date()
+	var days []time.Time
+	switch r.Freq {
+	case Daily:
+		days = []time.Time{r.at(start, y, m, d+offset)}
+	case Weekly:
+		// Monday of the week containing start.
+		monday := d - (int(start.Weekday())+6)%7 + 7*offset
+		if len(r.ByDay) == 0 {
+			days = []time.Time{r.at(start, y, m, d+7*offset)}
+			break
+		}
+		for i := 0; i < 7; i++ {
+			days = append(days, r.at(start, y, m, monday+i))
+		}
+	case Monthly:
+		days = r.monthDays(start, y, m+time.Month(offset))
+	case Yearly:
+		months := r.ByMonth
+		if len(months) == 0 {
+			months = []time.Month{m}
+		}
+		for _, month := range months {
+			days = append(days, r.monthDays(start, y+offset, month)...)
+		}
+	}
+	result := days[:0]
+	for _, t := range days {
+		if r.matches(t) {
+			result = append(result, t)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Before(result[j]) })
+	// BYMONTHDAY=1,-31 names the same day in a long month.
+	unique := result[:0]
+	for i, t := range result {
+		if i == 0 || !t.Equal(result[i-1]) {
+			unique = append(unique, t)
+		}
+	}
+	return unique
+}
+
+// monthDays lists the days of a month the rule may fall on, before the
+// BYMONTH and BYDAY filters. Days that do not exist in the month, such as
+// the 31st of April, are skipped rather than moved.
+func (r *Rule) monthDays(start time.Time, year int, month time.Month) []time.Time {
+	first := r.at(start, year, month, 1)
+	year, month = first.Year(), first.Month()
+	last := time.Date(year, month+1, 0, 0, 0, 0, 0, time.UTC).Day()
+	var days []time.Time
+	switch {
+	case len(r.ByMonthDay) > 0:
+		for _, day := range r.ByMonthDay {
+			if day < 0 {
+				day = last + day + 1
+			}
+			if day >= 1 && day <= last {
+				days = append(days, r.at(start, year, month, day))
+			}
+		}
+	case len(r.ByDay) > 0:
+		for day := 1; day <= last; day++ {
+			days = append(days, r.at(start, year, month, day))
+		}
+	default:
+		if day := start.Day(); day <= last {
+			days = append(days, r.at(start, year, month, day))
+		}
+	}
+	return days
+}
+
+func (r *Rule) matches(t time.Time) bool {
+	if len(r.ByMonth) > 0 && !containsMonth(r.ByMonth, t.Month()) {
+		return false
+	}
+	if len(r.ByMonthDay) > 0 && r.Freq == Daily && !containsMonthDay(r.ByMonthDay, t) {
+		return false
+	}
+	if len(r.ByDay) == 0 {
+		return true
+	}
+	last := time.Date(t.Year(), t.Month()+1, 0, 0, 0, 0, 0, time.UTC).Day()
+	for _, day := range r.ByDay {
+		if day.Weekday != t.Weekday() {
+			continue
+		}
+		switch {
+		case day.N == 0,
+			day.N > 0 && (t.Day()-1)/7+1 == day.N,
+			day.N < 0 && (last-t.Day())/7+1 == -day.N:
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Rule) at(start time.Time, year int, month time.Month, day int) time.Time {
+	return time.Date(year, month, day, start.Hour(), start.Minute(), start.Second(), start.Nanosecond(), start.Location())
+}
+
+func containsMonth(months []time.Month, month time.Month) bool {
+	for _, m := range months {
+		if m == month {
+			return true
+		}
+	}
+	return false
+}
+
+func containsMonthDay(days []int, t time.Time) bool {
+	last := time.Date(t.Year(), t.Month()+1, 0, 0, 0, 0, 0, time.UTC).Day()
+	for _, day := range days {
+		if day == t.Day() || day < 0 && last+day+1 == t.Day() {
+			return true
+		}
+	}
+	return false
+}
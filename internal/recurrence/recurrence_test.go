@@ -0,0 +1,227 @@
+package recurrence
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func date(year int, month time.Month, day int) time.Time {
+	return time.Date(year, month, day, 9, 30, 0, 0, time.UTC)
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{"Daily", "daily", "FREQ=DAILY"},
+		{"Weekly", " Weekly ", "FREQ=WEEKLY"},
+		{"Monthly", "MONTHLY", "FREQ=MONTHLY"},
+		{"Yearly", "yearly", "FREQ=YEARLY"},
+		{"Prefix", "RRULE:FREQ=DAILY", "FREQ=DAILY"},
+		{"LowerCase", "freq=weekly;byday=mo,we", "FREQ=WEEKLY;BYDAY=MO,WE"},
+		{"IntervalOne", "FREQ=DAILY;INTERVAL=1", "FREQ=DAILY"},
+		{"Order", "COUNT=3;BYDAY=FR;INTERVAL=2;FREQ=WEEKLY", "FREQ=WEEKLY;INTERVAL=2;BYDAY=FR;COUNT=3"},
+		{"Ordinal", "FREQ=MONTHLY;BYDAY=+1MO,-1FR", "FREQ=MONTHLY;BYDAY=1MO,-1FR"},
+		{"MonthDay", "FREQ=MONTHLY;BYMONTHDAY=1,15,-1", "FREQ=MONTHLY;BYMONTHDAY=1,15,-1"},
+		{"ByMonth", "FREQ=YEARLY;BYMONTH=3,9;BYMONTHDAY=1", "FREQ=YEARLY;BYMONTH=3,9;BYMONTHDAY=1"},
+		{"UntilDateTime", "FREQ=DAILY;UNTIL=20220510T120000Z", "FREQ=DAILY;UNTIL=20220510T120000Z"},
+		{"UntilDate", "FREQ=DAILY;UNTIL=20220510", "FREQ=DAILY;UNTIL=20220510T235959Z"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule, err := Parse(tt.input)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, rule.String())
+			again, err := Parse(rule.String())
+			require.NoError(t, err)
+			assert.Equal(t, rule, again)
+		})
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{"Empty", ""},
+		{"Shorthand", "hourly"},
+		{"NoFreq", "INTERVAL=2"},
+		{"Freq", "FREQ=HOURLY"},
+		{"Part", "FREQ=DAILY;BYHOUR=9"},
+		{"Malformed", "FREQ=DAILY;COUNT"},
+		{"EmptyValue", "FREQ=DAILY;COUNT="},
+		{"Duplicate", "FREQ=DAILY;FREQ=WEEKLY"},
+		{"Interval", "FREQ=DAILY;INTERVAL=0"},
+		{"Count", "FREQ=DAILY;COUNT=-1"},
+		{"CountAndUntil", "FREQ=DAILY;COUNT=2;UNTIL=20220510"},
+		{"Until", "FREQ=DAILY;UNTIL=2022-05-10"},
+		{"ByMonth", "FREQ=YEARLY;BYMONTH=13"},
+		{"ByMonthDayZero", "FREQ=MONTHLY;BYMONTHDAY=0"},
+		{"ByMonthDayRange", "FREQ=MONTHLY;BYMONTHDAY=32"},
+		{"ByMonthDayWeekly", "FREQ=WEEKLY;BYMONTHDAY=1"},
+		{"ByDay", "FREQ=WEEKLY;BYDAY=XX"},
+		{"ByDayOrdinal", "FREQ=MONTHLY;BYDAY=6MO"},
+		{"ByDayOrdinalWeekly", "FREQ=WEEKLY;BYDAY=1MO"},
+		{"ByDayYearly", "FREQ=YEARLY;BYDAY=MO"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule, err := Parse(tt.input)
+			assert.Nil(t, rule)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestExpand(t *testing.T) {
+	tests := []struct {
+		name     string
+		rule     string
+		start    time.Time
+		n        int
+		expected []time.Time
+	}{
+		{
+			"Daily", "daily", date(2022, 5, 30), 4,
+			[]time.Time{date(2022, 5, 30), date(2022, 5, 31), date(2022, 6, 1), date(2022, 6, 2)},
+		},
+		{
+			"DailyInterval", "FREQ=DAILY;INTERVAL=3", date(2022, 12, 30), 3,
+			[]time.Time{date(2022, 12, 30), date(2023, 1, 2), date(2023, 1, 5)},
+		},
+		{
+			"Weekdays", "FREQ=DAILY;BYDAY=MO,TU,WE,TH,FR", date(2022, 5, 5), 4,
+			[]time.Time{date(2022, 5, 5), date(2022, 5, 6), date(2022, 5, 9), date(2022, 5, 10)},
+		},
+		{
+			"DailyByMonthDay", "FREQ=DAILY;BYMONTHDAY=1,-1", date(2022, 5, 1), 4,
+			[]time.Time{date(2022, 5, 1), date(2022, 5, 31), date(2022, 6, 1), date(2022, 6, 30)},
+		},
+		{
+			"Weekly", "weekly", date(2022, 5, 4), 3,
+			[]time.Time{date(2022, 5, 4), date(2022, 5, 11), date(2022, 5, 18)},
+		},
+		{
+			"WeeklyByDay", "FREQ=WEEKLY;BYDAY=MO,WE,FR", date(2022, 5, 4), 5,
+			[]time.Time{date(2022, 5, 4), date(2022, 5, 6), date(2022, 5, 9), date(2022, 5, 11), date(2022, 5, 13)},
+		},
+		{
+			// Weeks start on Monday, so the Sunday belongs to the first week.
+			"WeeklyIntervalByDay", "FREQ=WEEKLY;INTERVAL=2;BYDAY=TU,SU", date(2022, 5, 3), 5,
+			[]time.Time{date(2022, 5, 3), date(2022, 5, 8), date(2022, 5, 17), date(2022, 5, 22), date(2022, 5, 31)},
+		},
+		{
+			// DTSTART is the first occurrence even when the rule skips it.
+			"StartOffRule", "FREQ=WEEKLY;BYDAY=MO", date(2022, 5, 4), 3,
+			[]time.Time{date(2022, 5, 4), date(2022, 5, 9), date(2022, 5, 16)},
+		},
+		{
+			"Monthly", "monthly", date(2022, 1, 15), 3,
+			[]time.Time{date(2022, 1, 15), date(2022, 2, 15), date(2022, 3, 15)},
+		},
+		{
+			// Months without the 31st are skipped, not clamped.
+			"MonthlySkipsShortMonths", "monthly", date(2022, 1, 31), 4,
+			[]time.Time{date(2022, 1, 31), date(2022, 3, 31), date(2022, 5, 31), date(2022, 7, 31)},
+		},
+		{
+			"MonthlyLastDay", "FREQ=MONTHLY;BYMONTHDAY=-1", date(2022, 1, 31), 4,
+			[]time.Time{date(2022, 1, 31), date(2022, 2, 28), date(2022, 3, 31), date(2022, 4, 30)},
+		},
+		{
+			"MonthlyDuplicateDays", "FREQ=MONTHLY;BYMONTHDAY=1,-31", date(2022, 1, 1), 3,
+			[]time.Time{date(2022, 1, 1), date(2022, 2, 1), date(2022, 3, 1)},
+		},
+		{
+			"MonthlyFirstMonday", "FREQ=MONTHLY;BYDAY=1MO", date(2022, 5, 2), 3,
+			[]time.Time{date(2022, 5, 2), date(2022, 6, 6), date(2022, 7, 4)},
+		},
+		{
+			"MonthlyLastFriday", "FREQ=MONTHLY;INTERVAL=2;BYDAY=-1FR", date(2022, 4, 29), 3,
+			[]time.Time{date(2022, 4, 29), date(2022, 6, 24), date(2022, 8, 26)},
+		},
+		{
+			"MonthlyEveryTuesday", "FREQ=MONTHLY;BYDAY=TU;BYMONTH=2", date(2022, 2, 1), 5,
+			[]time.Time{date(2022, 2, 1), date(2022, 2, 8), date(2022, 2, 15), date(2022, 2, 22), date(2023, 2, 7)},
+		},
+		{
+			"FridayThe13th", "FREQ=MONTHLY;BYMONTHDAY=13;BYDAY=FR", date(2022, 5, 13), 3,
+			[]time.Time{date(2022, 5, 13), date(2023, 1, 13), date(2023, 10, 13)},
+		},
+		{
+			"Yearly", "yearly", date(2022, 3, 1), 3,
+			[]time.Time{date(2022, 3, 1), date(2023, 3, 1), date(2024, 3, 1)},
+		},
+		{
+			"YearlyLeapDay", "yearly", date(2020, 2, 29), 3,
+			[]time.Time{date(2020, 2, 29), date(2024, 2, 29), date(2028, 2, 29)},
+		},
+		{
+			"YearlyByMonth", "FREQ=YEARLY;INTERVAL=2;BYMONTH=1,7;BYMONTHDAY=1", date(2022, 1, 1), 4,
+			[]time.Time{date(2022, 1, 1), date(2022, 7, 1), date(2024, 1, 1), date(2024, 7, 1)},
+		},
+		{
+			"Count", "FREQ=DAILY;COUNT=3", date(2022, 5, 1), 10,
+			[]time.Time{date(2022, 5, 1), date(2022, 5, 2), date(2022, 5, 3)},
+		},
+		{
+			"Until", "FREQ=WEEKLY;UNTIL=20220515T093000Z", date(2022, 5, 1), 10,
+			[]time.Time{date(2022, 5, 1), date(2022, 5, 8), date(2022, 5, 15)},
+		},
+		{
+			"UntilDate", "FREQ=DAILY;UNTIL=20220502", date(2022, 5, 1), 10,
+			[]time.Time{date(2022, 5, 1), date(2022, 5, 2)},
+		},
+		{
+			"Never", "FREQ=YEARLY;BYMONTH=2;BYMONTHDAY=30", date(2022, 2, 1), 3,
+			[]time.Time{date(2022, 2, 1)},
+		},
+		{
+			"None", "daily", date(2022, 5, 1), 0,
+			nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule, err := Parse(tt.rule)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, rule.Expand(tt.start, tt.n))
+		})
+	}
+}
+
+func TestNext(t *testing.T) {
+	tests := []struct {
+		name     string
+		rule     string
+		start    time.Time
+		after    time.Time
+		expected time.Time
+		ok       bool
+	}{
+		{"AfterStart", "weekly", date(2022, 5, 2), date(2022, 5, 2), date(2022, 5, 9), true},
+		{"BeforeStart", "weekly", date(2022, 5, 2), date(2022, 4, 1), date(2022, 5, 2), true},
+		{"Between", "FREQ=MONTHLY;BYDAY=1MO", date(2022, 5, 2), date(2022, 8, 10), date(2022, 9, 5), true},
+		{"SameDayLater", "daily", date(2022, 5, 2), date(2022, 5, 2).Add(time.Hour), date(2022, 5, 3), true},
+		{"LastOfCount", "FREQ=DAILY;COUNT=2", date(2022, 5, 2), date(2022, 5, 2), date(2022, 5, 3), true},
+		{"CountEnded", "FREQ=DAILY;COUNT=2", date(2022, 5, 2), date(2022, 5, 3), time.Time{}, false},
+		{"SingleCount", "FREQ=DAILY;COUNT=1", date(2022, 5, 2), date(2022, 5, 2), time.Time{}, false},
+		{"UntilEnded", "FREQ=DAILY;UNTIL=20220503", date(2022, 5, 2), date(2022, 5, 3), time.Time{}, false},
+		{"Never", "FREQ=YEARLY;BYMONTH=2;BYMONTHDAY=30", date(2022, 2, 1), date(2022, 2, 1), time.Time{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule, err := Parse(tt.rule)
+			require.NoError(t, err)
+			next, ok := rule.Next(tt.start, tt.after)
+			assert.Equal(t, tt.ok, ok)
+			assert.Equal(t, tt.expected, next)
+		})
+	}
+}
@@ -0,0 +1,76 @@
+package repository
+
+import (
+	"sort"
+
+	"github.com/wagaru/task/internal/errcode"
+	"github.com/wagaru/task/internal/model"
+)
+
+func (in *inMem) GetAPIKeys() ([]*model.APIKey, error) {
+	in.mux.RLock()
+	defer in.mux.RUnlock()
+	keys := make([]*model.APIKey, 0, len(in.apiKeys))
+	for _, key := range in.apiKeys {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].ID < keys[j].ID
+	})
+	return keys, nil
+}
+
+func (in *inMem) GetAPIKeyByHash(hash string) (*model.APIKey, error) {
+	in.mux.RLock()
+	defer in.mux.RUnlock()
+	id, ok := in.keyHashes[hash]
+	if !ok {
+		return nil, errcode.RecordNotExists
+	}
+	return in.apiKeys[id], nil
+}
+
+func (in *inMem) CreateAPIKey(key *model.APIKey) (*model.APIKey, error) {
+	in.mux.Lock()
+	defer in.mux.Unlock()
+
+	if _, ok := in.keyHashes[key.Hash]; ok {
+		return nil, errcode.DuplicateRecords
+	}
+	created := *key
+	created.ID = in.kid + 1
+	if err := in.log(&record{Op: opPutAPIKey, APIKey: &created}); err != nil {
+		return nil, err
+	}
+
+	in.kid = created.ID
+	in.putAPIKey(&created)
+	return &created, nil
+}
+
+func (in *inMem) DeleteAPIKey(id uint32) error {
+	in.mux.Lock()
+	defer in.mux.Unlock()
+
+	if _, ok := in.apiKeys[id]; !ok {
+		return errcode.RecordNotExists
+	}
+	if err := in.log(&record{Op: opDeleteAPIKey, ID: id}); err != nil {
+		return err
+	}
+
+	in.removeAPIKey(id)
+	return nil
+}
+
+func (in *inMem) putAPIKey(key *model.APIKey) {
+	in.apiKeys[key.ID] = key
+	in.keyHashes[key.Hash] = key.ID
+}
+
+func (in *inMem) removeAPIKey(id uint32) {
+	if key, ok := in.apiKeys[id]; ok {
+		delete(in.keyHashes, key.Hash)
+		delete(in.apiKeys, id)
+	}
+}
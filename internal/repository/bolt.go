@@ -0,0 +1,612 @@
+package repository
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/wagaru/task/internal/errcode"
+	"github.com/wagaru/task/internal/model"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	taskBucket        = []byte("tasks")
+	nameBucket        = []byte("task_names")
+	projectBucket     = []byte("projects")
+	projectNameBucket = []byte("project_names")
+	webhookBucket     = []byte("webhooks")
+	apiKeyBucket      = []byte("api_keys")
+	apiKeyHashBucket  = []byte("api_key_hashes")
+	deadLetterBucket  = []byte("dead_letters")
+	metaBucket        = []byte("meta")
+
+	versionKey = []byte("version")
+)
+
+// boltVersion is bumped whenever the layout of existing buckets changes.
+// Version 1 prefixes task_names keys with the project ID.
+const boltVersion = 1
+
+type boltRepo struct {
+	db *bolt.DB
+}
+
+// NewBoltRepository stores tasks in an embedded bbolt file. Tasks are keyed
+// by big-endian ID so cursors iterate in ID order, and the name index lives
+// in its own bucket, updated in the same transaction as the task. Projects
+// are laid out the same way.
+func NewBoltRepository(path string) (Repository, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{taskBucket, nameBucket, projectBucket, projectNameBucket, webhookBucket, apiKeyBucket, apiKeyHashBucket, deadLetterBucket, metaBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return boltUpgrade(tx)
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &boltRepo{db: db}, nil
+}
+
+func (b *boltRepo) GetTasks(query *model.TaskQuery) ([]*model.Task, string, error) {
+	tasks := make([]*model.Task, 0)
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(taskBucket).ForEach(func(_, v []byte) error {
+			var task model.Task
+			if err := json.Unmarshal(v, &task); err != nil {
+				return err
+			}
+			tasks = append(tasks, &task)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return queryTasks(tasks, query)
+}
+
+func (b *boltRepo) GetTask(id uint32) (*model.Task, error) {
+	var task *model.Task
+	err := b.db.View(func(tx *bolt.Tx) error {
+		var err error
+		task, err = boltGetTask(tx, id)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return task, nil
+}
+
+func (b *boltRepo) CreateTask(task *model.Task) (*model.Task, error) {
+	created := *task
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		if err := boltCheckProject(tx, created.ProjectID); err != nil {
+			return err
+		}
+		if tx.Bucket(nameBucket).Get(boltNameKey(&created)) != nil {
+			return errcode.DuplicateRecords
+		}
+		seq, err := tx.Bucket(taskBucket).NextSequence()
+		if err != nil {
+			return err
+		}
+		created.ID = uint32(seq)
+		return boltPutTask(tx, &created)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &created, nil
+}
+
+func (b *boltRepo) PatchTask(id uint32, apply func(task *model.Task) error) (*model.Task, error) {
+	var task *model.Task
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		old, err := boltGetTask(tx, id)
+		if err != nil {
+			return err
+		}
+		updated := *old
+		if err := apply(&updated); err != nil {
+			return err
+		}
+		updated.ID = id
+		if err := boltCheckProject(tx, updated.ProjectID); err != nil {
+			return err
+		}
+		if cid := tx.Bucket(nameBucket).Get(boltNameKey(&updated)); cid != nil && boltID(cid) != id {
+			return errcode.DuplicateRecords
+		}
+		if err := tx.Bucket(nameBucket).Delete(boltNameKey(old)); err != nil {
+			return err
+		}
+		task = &updated
+		return boltPutTask(tx, task)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return task, nil
+}
+
+func (b *boltRepo) DeleteTask(id uint32) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		task, err := boltGetTask(tx, id)
+		if err != nil {
+			return err
+		}
+		return boltDeleteTask(tx, task)
+	})
+}
+
+func (b *boltRepo) GetTags() ([]*model.Tag, error) {
+	counts := make(map[string]int)
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(taskBucket).ForEach(func(_, v []byte) error {
+			var task model.Task
+			if err := json.Unmarshal(v, &task); err != nil {
+				return err
+			}
+			for _, tag := range task.Tags {
+				counts[tag]++
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	tags := make([]*model.Tag, 0, len(counts))
+	for name, count := range counts {
+		tags = append(tags, &model.Tag{Name: name, Count: count})
+	}
+	sort.Slice(tags, func(i, j int) bool {
+		return tags[i].Name < tags[j].Name
+	})
+	return tags, nil
+}
+
+func (b *boltRepo) GetProjects() ([]*model.Project, error) {
+	projects := make([]*model.Project, 0)
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(projectBucket).ForEach(func(_, v []byte) error {
+			var project model.Project
+			if err := json.Unmarshal(v, &project); err != nil {
+				return err
+			}
+			projects = append(projects, &project)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return projects, nil
+}
+
+func (b *boltRepo) GetProject(id uint32) (*model.Project, error) {
+	var project *model.Project
+	err := b.db.View(func(tx *bolt.Tx) error {
+		var err error
+		project, err = boltGetProject(tx, id)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return project, nil
+}
+
+func (b *boltRepo) CreateProject(project *model.Project) (*model.Project, error) {
+	created := *project
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket(projectNameBucket).Get([]byte(created.Name)) != nil {
+			return errcode.DuplicateRecords
+		}
+		seq, err := tx.Bucket(projectBucket).NextSequence()
+		if err != nil {
+			return err
+		}
+		created.ID = uint32(seq)
+		return boltPutProject(tx, &created)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &created, nil
+}
+
+func (b *boltRepo) PatchProject(id uint32, apply func(project *model.Project) error) (*model.Project, error) {
+	var project *model.Project
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		old, err := boltGetProject(tx, id)
+		if err != nil {
+			return err
+		}
+		updated := *old
+		if err := apply(&updated); err != nil {
+			return err
+		}
+		updated.ID = id
+		if cid := tx.Bucket(projectNameBucket).Get([]byte(updated.Name)); cid != nil && boltID(cid) != id {
+			return errcode.DuplicateRecords
+		}
+		if err := tx.Bucket(projectNameBucket).Delete([]byte(old.Name)); err != nil {
+			return err
+		}
+		project = &updated
+		return boltPutProject(tx, project)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return project, nil
+}
+
+func (b *boltRepo) DeleteProject(id uint32, cascade bool) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		project, err := boltGetProject(tx, id)
+		if err != nil {
+			return err
+		}
+		var tasks []*model.Task
+		err = tx.Bucket(taskBucket).ForEach(func(_, v []byte) error {
+			var task model.Task
+			if err := json.Unmarshal(v, &task); err != nil {
+				return err
+			}
+			if task.ProjectID == id {
+				tasks = append(tasks, &task)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if len(tasks) > 0 && !cascade {
+			return errcode.ProjectNotEmpty
+		}
+		for _, task := range tasks {
+			if err := boltDeleteTask(tx, task); err != nil {
+				return err
+			}
+		}
+		if err := tx.Bucket(projectNameBucket).Delete([]byte(project.Name)); err != nil {
+			return err
+		}
+		return tx.Bucket(projectBucket).Delete(boltKey(id))
+	})
+}
+
+func (b *boltRepo) GetWebhooks() ([]*model.Webhook, error) {
+	webhooks := make([]*model.Webhook, 0)
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(webhookBucket).ForEach(func(_, v []byte) error {
+			var webhook model.Webhook
+			if err := json.Unmarshal(v, &webhook); err != nil {
+				return err
+			}
+			webhooks = append(webhooks, &webhook)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return webhooks, nil
+}
+
+func (b *boltRepo) GetWebhook(id uint32) (*model.Webhook, error) {
+	var webhook *model.Webhook
+	err := b.db.View(func(tx *bolt.Tx) error {
+		var err error
+		webhook, err = boltGetWebhook(tx, id)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return webhook, nil
+}
+
+func (b *boltRepo) CreateWebhook(webhook *model.Webhook) (*model.Webhook, error) {
+	created := *webhook
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		seq, err := tx.Bucket(webhookBucket).NextSequence()
+		if err != nil {
+			return err
+		}
+		created.ID = uint32(seq)
+		return boltPutWebhook(tx, &created)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &created, nil
+}
+
+func (b *boltRepo) PatchWebhook(id uint32, apply func(webhook *model.Webhook) error) (*model.Webhook, error) {
+	var webhook *model.Webhook
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		var err error
+		webhook, err = boltGetWebhook(tx, id)
+		if err != nil {
+			return err
+		}
+		if err := apply(webhook); err != nil {
+			return err
+		}
+		webhook.ID = id
+		return boltPutWebhook(tx, webhook)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return webhook, nil
+}
+
+func (b *boltRepo) DeleteWebhook(id uint32) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket(webhookBucket).Get(boltKey(id)) == nil {
+			return errcode.RecordNotExists
+		}
+		return tx.Bucket(webhookBucket).Delete(boltKey(id))
+	})
+}
+
+// GetDeadLetters walks the bucket backwards, as dead letters are keyed by
+// big-endian ID.
+func (b *boltRepo) GetDeadLetters() ([]*model.WebhookDelivery, error) {
+	deliveries := make([]*model.WebhookDelivery, 0)
+	err := b.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(deadLetterBucket).Cursor()
+		for k, v := c.Last(); k != nil; k, v = c.Prev() {
+			var delivery model.WebhookDelivery
+			if err := json.Unmarshal(v, &delivery); err != nil {
+				return err
+			}
+			deliveries = append(deliveries, &delivery)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return deliveries, nil
+}
+
+func (b *boltRepo) PutDeadLetter(delivery *model.WebhookDelivery) error {
+	v, err := json.Marshal(delivery)
+	if err != nil {
+		return err
+	}
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, delivery.ID)
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(deadLetterBucket).Put(key, v)
+	})
+}
+
+func (b *boltRepo) GetAPIKeys() ([]*model.APIKey, error) {
+	keys := make([]*model.APIKey, 0)
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(apiKeyBucket).ForEach(func(_, v []byte) error {
+			var key model.APIKey
+			if err := json.Unmarshal(v, &key); err != nil {
+				return err
+			}
+			keys = append(keys, &key)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+func (b *boltRepo) GetAPIKeyByHash(hash string) (*model.APIKey, error) {
+	var key model.APIKey
+	err := b.db.View(func(tx *bolt.Tx) error {
+		id := tx.Bucket(apiKeyHashBucket).Get([]byte(hash))
+		if id == nil {
+			return errcode.RecordNotExists
+		}
+		return json.Unmarshal(tx.Bucket(apiKeyBucket).Get(id), &key)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+func (b *boltRepo) CreateAPIKey(key *model.APIKey) (*model.APIKey, error) {
+	created := *key
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket(apiKeyHashBucket).Get([]byte(key.Hash)) != nil {
+			return errcode.DuplicateRecords
+		}
+		seq, err := tx.Bucket(apiKeyBucket).NextSequence()
+		if err != nil {
+			return err
+		}
+		created.ID = uint32(seq)
+		v, err := json.Marshal(&created)
+		if err != nil {
+			return err
+		}
+		if err := tx.Bucket(apiKeyBucket).Put(boltKey(created.ID), v); err != nil {
+			return err
+		}
+		return tx.Bucket(apiKeyHashBucket).Put([]byte(created.Hash), boltKey(created.ID))
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &created, nil
+}
+
+func (b *boltRepo) DeleteAPIKey(id uint32) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		v := tx.Bucket(apiKeyBucket).Get(boltKey(id))
+		if v == nil {
+			return errcode.RecordNotExists
+		}
+		var key model.APIKey
+		if err := json.Unmarshal(v, &key); err != nil {
+			return err
+		}
+		if err := tx.Bucket(apiKeyHashBucket).Delete([]byte(key.Hash)); err != nil {
+			return err
+		}
+		return tx.Bucket(apiKeyBucket).Delete(boltKey(id))
+	})
+}
+
+func (b *boltRepo) Close() error {
+	return b.db.Close()
+}
+
+func boltGetTask(tx *bolt.Tx, id uint32) (*model.Task, error) {
+	v := tx.Bucket(taskBucket).Get(boltKey(id))
+	if v == nil {
+		return nil, errcode.RecordNotExists
+	}
+	var task model.Task
+	if err := json.Unmarshal(v, &task); err != nil {
+		return nil, err
+	}
+	return &task, nil
+}
+
+func boltPutTask(tx *bolt.Tx, task *model.Task) error {
+	v, err := json.Marshal(task)
+	if err != nil {
+		return err
+	}
+	key := boltKey(task.ID)
+	if err := tx.Bucket(taskBucket).Put(key, v); err != nil {
+		return err
+	}
+	return tx.Bucket(nameBucket).Put(boltNameKey(task), key)
+}
+
+func boltDeleteTask(tx *bolt.Tx, task *model.Task) error {
+	if err := tx.Bucket(nameBucket).Delete(boltNameKey(task)); err != nil {
+		return err
+	}
+	return tx.Bucket(taskBucket).Delete(boltKey(task.ID))
+}
+
+// boltNameKey scopes a task name to its project.
+func boltNameKey(task *model.Task) []byte {
+	return append(boltKey(task.ProjectID), task.Name...)
+}
+
+// boltUpgrade brings the buckets of an older file up to boltVersion.
+func boltUpgrade(tx *bolt.Tx) error {
+	meta := tx.Bucket(metaBucket)
+	version := 0
+	if v := meta.Get(versionKey); v != nil {
+		version = int(boltID(v))
+	}
+	if version < 1 {
+		// Rebuild the name index with project-scoped keys.
+		if err := tx.DeleteBucket(nameBucket); err != nil {
+			return err
+		}
+		names, err := tx.CreateBucket(nameBucket)
+		if err != nil {
+			return err
+		}
+		err = tx.Bucket(taskBucket).ForEach(func(k, v []byte) error {
+			var task model.Task
+			if err := json.Unmarshal(v, &task); err != nil {
+				return err
+			}
+			return names.Put(boltNameKey(&task), k)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return meta.Put(versionKey, boltKey(boltVersion))
+}
+
+func boltGetProject(tx *bolt.Tx, id uint32) (*model.Project, error) {
+	v := tx.Bucket(projectBucket).Get(boltKey(id))
+	if v == nil {
+		return nil, errcode.RecordNotExists
+	}
+	var project model.Project
+	if err := json.Unmarshal(v, &project); err != nil {
+		return nil, err
+	}
+	return &project, nil
+}
+
+func boltPutProject(tx *bolt.Tx, project *model.Project) error {
+	v, err := json.Marshal(project)
+	if err != nil {
+		return err
+	}
+	key := boltKey(project.ID)
+	if err := tx.Bucket(projectBucket).Put(key, v); err != nil {
+		return err
+	}
+	return tx.Bucket(projectNameBucket).Put([]byte(project.Name), key)
+}
+
+func boltGetWebhook(tx *bolt.Tx, id uint32) (*model.Webhook, error) {
+	v := tx.Bucket(webhookBucket).Get(boltKey(id))
+	if v == nil {
+		return nil, errcode.RecordNotExists
+	}
+	var webhook model.Webhook
+	if err := json.Unmarshal(v, &webhook); err != nil {
+		return nil, err
+	}
+	return &webhook, nil
+}
+
+func boltPutWebhook(tx *bolt.Tx, webhook *model.Webhook) error {
+	v, err := json.Marshal(webhook)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(webhookBucket).Put(boltKey(webhook.ID), v)
+}
+
+// boltCheckProject reports whether tasks may be placed in the project.
+func boltCheckProject(tx *bolt.Tx, id uint32) error {
+	if id != 0 && tx.Bucket(projectBucket).Get(boltKey(id)) == nil {
+		return errcode.RecordNotExists
+	}
+	return nil
+}
+
+func boltKey(id uint32) []byte {
+	key := make([]byte, 4)
+	binary.BigEndian.PutUint32(key, id)
+	return key
+}
+
+func boltID(key []byte) uint32 {
+	return binary.BigEndian.Uint32(key)
+}
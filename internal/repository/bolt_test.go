@@ -0,0 +1,111 @@
+package repository
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wagaru/task/internal/errcode"
+	"github.com/wagaru/task/internal/model"
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestBoltRepositoryReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "task.bolt")
+	repo, err := NewBoltRepository(path)
+	require.NoError(t, err)
+	for _, name := range []string{"task1", "task2", "task3"} {
+		_, err := repo.CreateTask(newTask(name))
+		require.NoError(t, err)
+	}
+	require.NoError(t, repo.DeleteTask(3))
+	require.NoError(t, repo.Close())
+
+	repo, err = NewBoltRepository(path)
+	require.NoError(t, err)
+	defer repo.Close()
+
+	tasks, _, err := repo.GetTasks(&model.TaskQuery{})
+	assert.NoError(t, err)
+	assert.Equal(t, []*model.Task{
+		{ID: 1, Name: "task1", Status: model.StatusTodo},
+		{ID: 2, Name: "task2", Status: model.StatusTodo},
+	}, tasks)
+	task, err := repo.CreateTask(newTask("task3"))
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(4), task.ID)
+}
+
+func TestBoltRepositoryNameIndex(t *testing.T) {
+	repo, err := NewBoltRepository(filepath.Join(t.TempDir(), "task.bolt"))
+	require.NoError(t, err)
+	defer repo.Close()
+
+	task1, err := repo.CreateTask(newTask("task1"))
+	require.NoError(t, err)
+	task2, err := repo.CreateTask(newTask("task2"))
+	require.NoError(t, err)
+
+	t.Run("DuplicateRecords", func(t *testing.T) {
+		result, err := repo.CreateTask(newTask(task1.Name))
+		assert.Nil(t, result)
+		assert.ErrorIs(t, err, errcode.DuplicateRecords)
+
+		result, err = updateTask(repo, task2.ID, task1.Name, model.StatusTodo)
+		assert.Nil(t, result)
+		assert.ErrorIs(t, err, errcode.DuplicateRecords)
+	})
+	t.Run("Rename", func(t *testing.T) {
+		_, err := updateTask(repo, task1.ID, "task1_rename", model.StatusDone)
+		assert.NoError(t, err)
+		result, err := repo.CreateTask(newTask(task1.Name))
+		assert.NoError(t, err)
+		assert.NotEqual(t, task1.ID, result.ID)
+	})
+	t.Run("Delete", func(t *testing.T) {
+		assert.NoError(t, repo.DeleteTask(task2.ID))
+		assert.ErrorIs(t, repo.DeleteTask(task2.ID), errcode.RecordNotExists)
+		_, err := repo.CreateTask(newTask(task2.Name))
+		assert.NoError(t, err)
+	})
+}
+
+func TestBoltRepositoryUpgrade(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "task.bolt")
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: time.Second})
+	require.NoError(t, err)
+	// The layout before projects: names are indexed without a project.
+	err = db.Update(func(tx *bolt.Tx) error {
+		tasks, err := tx.CreateBucket(taskBucket)
+		if err != nil {
+			return err
+		}
+		names, err := tx.CreateBucket(nameBucket)
+		if err != nil {
+			return err
+		}
+		if err := tasks.SetSequence(1); err != nil {
+			return err
+		}
+		if err := tasks.Put(boltKey(1), []byte(`{"id":1,"name":"task1","status":"todo"}`)); err != nil {
+			return err
+		}
+		return names.Put([]byte("task1"), boltKey(1))
+	})
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	repo, err := NewBoltRepository(path)
+	require.NoError(t, err)
+	defer repo.Close()
+
+	_, err = repo.CreateTask(newTask("task1"))
+	assert.ErrorIs(t, err, errcode.DuplicateRecords)
+	_, err = updateTask(repo, 1, "task1_rename", model.StatusTodo)
+	assert.NoError(t, err)
+	task, err := repo.CreateTask(newTask("task1"))
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(2), task.ID)
+}
@@ -0,0 +1,40 @@
+package repository_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/wagaru/task/internal/repository"
+	"github.com/wagaru/task/internal/repository/repositorytest"
+)
+
+func TestMemoryConformance(t *testing.T) {
+	repositorytest.Run(t, func(t *testing.T) repository.Repository {
+		return repository.NewRepository()
+	})
+}
+
+func TestFileConformance(t *testing.T) {
+	repositorytest.Run(t, func(t *testing.T) repository.Repository {
+		repo, err := repository.NewFileRepository(t.TempDir(), 0)
+		require.NoError(t, err)
+		return repo
+	})
+}
+
+func TestSQLConformance(t *testing.T) {
+	repositorytest.Run(t, func(t *testing.T) repository.Repository {
+		repo, err := repository.NewSQLRepository(filepath.Join(t.TempDir(), "task.db"))
+		require.NoError(t, err)
+		return repo
+	})
+}
+
+func TestBoltConformance(t *testing.T) {
+	repositorytest.Run(t, func(t *testing.T) repository.Repository {
+		repo, err := repository.NewBoltRepository(filepath.Join(t.TempDir(), "task.bolt"))
+		require.NoError(t, err)
+		return repo
+	})
+}
@@ -0,0 +1,336 @@
+package repository
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/wagaru/task/internal/model"
+)
+
+const (
+	snapshotFileName = "snapshot.json"
+	walFileName      = "wal.log"
+	lockFileName     = "lock"
+
+	opPut           = "put"
+	opDelete        = "delete"
+	opPutProject    = "put_project"
+	opDeleteProject = "delete_project"
+	opPutWebhook    = "put_webhook"
+	opDeleteWebhook = "delete_webhook"
+	opPutAPIKey     = "put_api_key"
+	opDeleteAPIKey  = "delete_api_key"
+	opPutDeadLetter = "put_dead_letter"
+)
+
+// record is a single line of the write-ahead log. Puts carry the whole task,
+// project, webhook, API key or dead letter so replaying a record is
+// idempotent. Deleting a project also deletes the tasks still in it.
+type record struct {
+	Op       string                 `json:"op"`
+	ID       uint32                 `json:"id,omitempty"`
+	Task     *model.Task            `json:"task,omitempty"`
+	Project  *model.Project         `json:"project,omitempty"`
+	Webhook  *model.Webhook         `json:"webhook,omitempty"`
+	APIKey   *model.APIKey          `json:"api_key,omitempty"`
+	Delivery *model.WebhookDelivery `json:"delivery,omitempty"`
+}
+
+type snapshot struct {
+	UID         uint32                   `json:"uid"`
+	Tasks       []*model.Task            `json:"tasks"`
+	PID         uint32                   `json:"pid"`
+	Projects    []*model.Project         `json:"projects"`
+	WID         uint32                   `json:"wid"`
+	Webhooks    []*model.Webhook         `json:"webhooks"`
+	KID         uint32                   `json:"kid"`
+	APIKeys     []*model.APIKey          `json:"api_keys"`
+	DeadLetters []*model.WebhookDelivery `json:"dead_letters"`
+}
+
+// ErrLocked is returned by NewFileRepository for a directory another process
+// has open, such as a running server when the keys command starts.
+var ErrLocked = errors.New("data directory is in use by another process")
+
+type fileRepo struct {
+	*inMem
+	dir     string
+	lock    *os.File
+	wal     *os.File
+	pending int
+	quit    chan struct{}
+	wg      sync.WaitGroup
+}
+
+// NewFileRepository keeps tasks in memory and appends every mutation to a
+// write-ahead log under dir. The log is replayed on start and folded into a
+// snapshot every compactInterval (never when it is zero). The directory is
+// locked until Close, as two processes appending to one log would lose each
+// other's writes.
+func NewFileRepository(dir string, compactInterval time.Duration) (Repository, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	lock, err := lockDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	repo := &fileRepo{
+		inMem: newInMem(),
+		dir:   dir,
+		lock:  lock,
+		quit:  make(chan struct{}),
+	}
+	if err := repo.loadSnapshot(); err != nil {
+		lock.Close()
+		return nil, err
+	}
+	if err := repo.replay(); err != nil {
+		if repo.wal != nil {
+			repo.wal.Close()
+		}
+		lock.Close()
+		return nil, err
+	}
+	repo.inMem.journal = repo.append
+
+	if compactInterval > 0 {
+		repo.wg.Add(1)
+		go repo.compactLoop(compactInterval)
+	}
+	return repo, nil
+}
+
+func (f *fileRepo) Close() error {
+	close(f.quit)
+	f.wg.Wait()
+
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	err := f.compact()
+	if cerr := f.wal.Close(); err == nil {
+		err = cerr
+	}
+	if cerr := f.lock.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func (f *fileRepo) loadSnapshot() error {
+	b, err := os.ReadFile(filepath.Join(f.dir, snapshotFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var snap snapshot
+	if err := json.Unmarshal(b, &snap); err != nil {
+		return fmt.Errorf("decode snapshot: %w", err)
+	}
+	for _, project := range snap.Projects {
+		f.putProject(project)
+	}
+	for _, task := range snap.Tasks {
+		f.put(task)
+	}
+	for _, webhook := range snap.Webhooks {
+		f.webhooks[webhook.ID] = webhook
+	}
+	for _, key := range snap.APIKeys {
+		f.putAPIKey(key)
+	}
+	for _, delivery := range snap.DeadLetters {
+		f.deadLetters[delivery.ID] = delivery
+	}
+	f.uid = snap.UID
+	f.pid = snap.PID
+	f.wid = snap.WID
+	f.kid = snap.KID
+	return nil
+}
+
+func (f *fileRepo) replay() error {
+	wal, err := os.OpenFile(filepath.Join(f.dir, walFileName), os.O_CREATE|os.O_RDWR|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	f.wal = wal
+
+	var offset int64
+	r := bufio.NewReader(wal)
+	for {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			if len(line) > 0 {
+				// A crash in the middle of an append leaves a torn last line.
+				log.Printf("repository: dropping %d bytes of incomplete wal record", len(line))
+				if err := wal.Truncate(offset); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		var rec record
+		if err := json.Unmarshal(line, &rec); err != nil {
+			return fmt.Errorf("decode wal record at offset %d: %w", offset, err)
+		}
+		f.apply(&rec)
+		offset += int64(len(line))
+		f.pending++
+	}
+}
+
+func (f *fileRepo) apply(rec *record) {
+	switch rec.Op {
+	case opPut:
+		f.put(rec.Task)
+		if rec.Task.ID > f.uid {
+			f.uid = rec.Task.ID
+		}
+	case opDelete:
+		f.remove(rec.ID)
+	case opPutProject:
+		f.putProject(rec.Project)
+		if rec.Project.ID > f.pid {
+			f.pid = rec.Project.ID
+		}
+	case opDeleteProject:
+		f.removeProject(rec.ID)
+	case opPutWebhook:
+		f.webhooks[rec.Webhook.ID] = rec.Webhook
+		if rec.Webhook.ID > f.wid {
+			f.wid = rec.Webhook.ID
+		}
+	case opDeleteWebhook:
+		delete(f.webhooks, rec.ID)
+	case opPutAPIKey:
+		f.putAPIKey(rec.APIKey)
+		if rec.APIKey.ID > f.kid {
+			f.kid = rec.APIKey.ID
+		}
+	case opDeleteAPIKey:
+		f.removeAPIKey(rec.ID)
+	case opPutDeadLetter:
+		f.deadLetters[rec.Delivery.ID] = rec.Delivery
+	}
+}
+
+// append is called by inMem with the write lock held, before the change is
+// applied to memory.
+func (f *fileRepo) append(rec *record) error {
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	if _, err := f.wal.Write(append(b, '\n')); err != nil {
+		return err
+	}
+	if err := f.wal.Sync(); err != nil {
+		return err
+	}
+	f.pending++
+	return nil
+}
+
+func (f *fileRepo) compactLoop(interval time.Duration) {
+	defer f.wg.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			f.mux.Lock()
+			if err := f.compact(); err != nil {
+				log.Printf("repository: compact error: %v", err)
+			}
+			f.mux.Unlock()
+		case <-f.quit:
+			return
+		}
+	}
+}
+
+// compact writes the current state to a new snapshot and empties the log.
+// The caller must hold the write lock.
+func (f *fileRepo) compact() error {
+	if f.pending == 0 {
+		return nil
+	}
+	snap := snapshot{
+		UID:         f.uid,
+		Tasks:       make([]*model.Task, 0, len(f.data)),
+		PID:         f.pid,
+		Projects:    make([]*model.Project, 0, len(f.projects)),
+		WID:         f.wid,
+		Webhooks:    make([]*model.Webhook, 0, len(f.webhooks)),
+		KID:         f.kid,
+		APIKeys:     make([]*model.APIKey, 0, len(f.apiKeys)),
+		DeadLetters: make([]*model.WebhookDelivery, 0, len(f.deadLetters)),
+	}
+	for _, task := range f.data {
+		snap.Tasks = append(snap.Tasks, task)
+	}
+	for _, project := range f.projects {
+		snap.Projects = append(snap.Projects, project)
+	}
+	for _, webhook := range f.webhooks {
+		snap.Webhooks = append(snap.Webhooks, webhook)
+	}
+	for _, key := range f.apiKeys {
+		snap.APIKeys = append(snap.APIKeys, key)
+	}
+	for _, delivery := range f.deadLetters {
+		snap.DeadLetters = append(snap.DeadLetters, delivery)
+	}
+	b, err := json.Marshal(snap)
+	if err != nil {
+		return err
+	}
+
+	tmp := filepath.Join(f.dir, snapshotFileName+".tmp")
+	if err := writeFileSync(tmp, b); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, filepath.Join(f.dir, snapshotFileName)); err != nil {
+		return err
+	}
+	// Replaying the old log on top of the new snapshot is harmless, so a
+	// crash before the truncate below loses nothing.
+	if err := f.wal.Truncate(0); err != nil {
+		return err
+	}
+	if err := f.wal.Sync(); err != nil {
+		return err
+	}
+	f.pending = 0
+	return nil
+}
+
+func writeFileSync(name string, b []byte) error {
+	file, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(b); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
@@ -0,0 +1,14 @@
+//go:build !unix
+
+package repository
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// lockDir only creates the lock file where flock is not available, leaving
+// it to the operator not to open dir twice.
+func lockDir(dir string) (*os.File, error) {
+	return os.OpenFile(filepath.Join(dir, lockFileName), os.O_CREATE|os.O_RDWR, 0o644)
+}
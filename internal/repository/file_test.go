@@ -0,0 +1,234 @@
+package repository
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wagaru/task/internal/errcode"
+	"github.com/wagaru/task/internal/model"
+)
+
+// crash abandons repo as a killed process would, without compacting. The
+// lock goes with the process.
+func crash(t *testing.T, repo Repository) {
+	require.NoError(t, repo.(*fileRepo).wal.Close())
+	require.NoError(t, repo.(*fileRepo).lock.Close())
+}
+
+func TestFileRepositoryReplay(t *testing.T) {
+	dir := t.TempDir()
+	repo, err := NewFileRepository(dir, 0)
+	require.NoError(t, err)
+
+	task1, err := repo.CreateTask(newTask("task1"))
+	require.NoError(t, err)
+	task2, err := repo.CreateTask(newTask("task2"))
+	require.NoError(t, err)
+	_, err = updateTask(repo, task1.ID, "task1_rename", model.StatusDone)
+	require.NoError(t, err)
+	require.NoError(t, repo.DeleteTask(task2.ID))
+	// Simulate a crash: the log is left behind without compacting.
+	crash(t, repo)
+
+	repo, err = NewFileRepository(dir, 0)
+	require.NoError(t, err)
+	defer repo.Close()
+
+	result, err := repo.GetTask(task1.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, &model.Task{ID: task1.ID, Name: "task1_rename", Status: model.StatusDone}, result)
+	_, err = repo.GetTask(task2.ID)
+	assert.ErrorIs(t, err, errcode.RecordNotExists)
+
+	task3, err := repo.CreateTask(newTask("task1"))
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(3), task3.ID)
+}
+
+func TestFileRepositoryReplayProjects(t *testing.T) {
+	dir := t.TempDir()
+	repo, err := NewFileRepository(dir, 0)
+	require.NoError(t, err)
+
+	project1, err := repo.CreateProject(&model.Project{Name: "project1"})
+	require.NoError(t, err)
+	project2, err := repo.CreateProject(&model.Project{Name: "project2"})
+	require.NoError(t, err)
+	for _, projectID := range []uint32{project1.ID, project2.ID} {
+		task := newTask("task")
+		task.ProjectID = projectID
+		_, err := repo.CreateTask(task)
+		require.NoError(t, err)
+	}
+	require.NoError(t, repo.DeleteProject(project2.ID, true))
+	crash(t, repo)
+
+	repo, err = NewFileRepository(dir, 0)
+	require.NoError(t, err)
+	projects, err := repo.GetProjects()
+	assert.NoError(t, err)
+	assert.Equal(t, []*model.Project{project1}, projects)
+	tasks, _, err := repo.GetTasks(&model.TaskQuery{})
+	assert.NoError(t, err)
+	assert.Equal(t, []*model.Task{{ID: 1, ProjectID: project1.ID, Name: "task", Status: model.StatusTodo}}, tasks)
+	require.NoError(t, repo.Close())
+
+	// Once more from the snapshot written on close.
+	repo, err = NewFileRepository(dir, 0)
+	require.NoError(t, err)
+	defer repo.Close()
+	project, err := repo.CreateProject(&model.Project{Name: "project2"})
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(3), project.ID)
+	_, err = repo.CreateTask(&model.Task{ProjectID: project1.ID, Name: "task", Status: model.StatusTodo})
+	assert.ErrorIs(t, err, errcode.DuplicateRecords)
+}
+
+func TestFileRepositoryReplayWebhooks(t *testing.T) {
+	dir := t.TempDir()
+	repo, err := NewFileRepository(dir, 0)
+	require.NoError(t, err)
+
+	webhook1, err := repo.CreateWebhook(&model.Webhook{URL: "http://example.com/1", Secret: "secret", Active: true})
+	require.NoError(t, err)
+	webhook2, err := repo.CreateWebhook(&model.Webhook{URL: "http://example.com/2"})
+	require.NoError(t, err)
+	require.NoError(t, repo.DeleteWebhook(webhook2.ID))
+	crash(t, repo)
+
+	repo, err = NewFileRepository(dir, 0)
+	require.NoError(t, err)
+	webhooks, err := repo.GetWebhooks()
+	assert.NoError(t, err)
+	assert.Equal(t, []*model.Webhook{webhook1}, webhooks)
+	require.NoError(t, repo.Close())
+
+	// Once more from the snapshot written on close.
+	repo, err = NewFileRepository(dir, 0)
+	require.NoError(t, err)
+	defer repo.Close()
+	webhooks, err = repo.GetWebhooks()
+	assert.NoError(t, err)
+	assert.Equal(t, []*model.Webhook{webhook1}, webhooks)
+	webhook, err := repo.CreateWebhook(&model.Webhook{URL: "http://example.com/3"})
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(3), webhook.ID)
+}
+
+func TestFileRepositoryReplayDeadLetters(t *testing.T) {
+	dir := t.TempDir()
+	repo, err := NewFileRepository(dir, 0)
+	require.NoError(t, err)
+
+	delivery := &model.WebhookDelivery{ID: 4, WebhookID: 1, URL: "http://example.com/1", Status: model.DeliveryDead, Attempts: 5}
+	require.NoError(t, repo.PutDeadLetter(delivery))
+	crash(t, repo)
+
+	repo, err = NewFileRepository(dir, 0)
+	require.NoError(t, err)
+	deliveries, err := repo.GetDeadLetters()
+	assert.NoError(t, err)
+	assert.Equal(t, []*model.WebhookDelivery{delivery}, deliveries)
+	require.NoError(t, repo.Close())
+
+	// Once more from the snapshot written on close.
+	repo, err = NewFileRepository(dir, 0)
+	require.NoError(t, err)
+	defer repo.Close()
+	deliveries, err = repo.GetDeadLetters()
+	assert.NoError(t, err)
+	assert.Equal(t, []*model.WebhookDelivery{delivery}, deliveries)
+}
+
+func TestFileRepositoryReplayAPIKeys(t *testing.T) {
+	dir := t.TempDir()
+	repo, err := NewFileRepository(dir, 0)
+	require.NoError(t, err)
+
+	key1, err := repo.CreateAPIKey(&model.APIKey{Name: "ci", Hash: "hash1", Scopes: []string{"tasks:read"}})
+	require.NoError(t, err)
+	key2, err := repo.CreateAPIKey(&model.APIKey{Name: "admin", Hash: "hash2", Scopes: []string{"admin"}})
+	require.NoError(t, err)
+	require.NoError(t, repo.DeleteAPIKey(key2.ID))
+	crash(t, repo)
+
+	repo, err = NewFileRepository(dir, 0)
+	require.NoError(t, err)
+	key, err := repo.GetAPIKeyByHash("hash1")
+	assert.NoError(t, err)
+	assert.Equal(t, key1, key)
+	require.NoError(t, repo.Close())
+
+	// Once more from the snapshot written on close.
+	repo, err = NewFileRepository(dir, 0)
+	require.NoError(t, err)
+	defer repo.Close()
+	keys, err := repo.GetAPIKeys()
+	assert.NoError(t, err)
+	assert.Equal(t, []*model.APIKey{key1}, keys)
+	_, err = repo.GetAPIKeyByHash("hash2")
+	assert.ErrorIs(t, err, errcode.RecordNotExists)
+	key, err = repo.CreateAPIKey(&model.APIKey{Name: "ci", Hash: "hash3"})
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(3), key.ID)
+}
+
+func TestFileRepositoryCompact(t *testing.T) {
+	dir := t.TempDir()
+	repo, err := NewFileRepository(dir, 0)
+	require.NoError(t, err)
+	for _, name := range []string{"task1", "task2", "task3"} {
+		_, err := repo.CreateTask(newTask(name))
+		require.NoError(t, err)
+	}
+	require.NoError(t, repo.DeleteTask(3))
+	require.NoError(t, repo.Close())
+
+	info, err := os.Stat(filepath.Join(dir, walFileName))
+	require.NoError(t, err)
+	assert.Zero(t, info.Size())
+
+	repo, err = NewFileRepository(dir, 0)
+	require.NoError(t, err)
+	defer repo.Close()
+	tasks, _, err := repo.GetTasks(&model.TaskQuery{})
+	assert.NoError(t, err)
+	assert.Len(t, tasks, 2)
+	task, err := repo.CreateTask(newTask("task4"))
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(4), task.ID)
+}
+
+func TestFileRepositoryTornRecord(t *testing.T) {
+	dir := t.TempDir()
+	wal := `{"op":"put","task":{"id":1,"name":"task1","status":0}}` + "\n" + `{"op":"put","task":{"id":2,"na`
+	require.NoError(t, os.WriteFile(filepath.Join(dir, walFileName), []byte(wal), 0o644))
+
+	repo, err := NewFileRepository(dir, 0)
+	require.NoError(t, err)
+	defer repo.Close()
+
+	tasks, _, err := repo.GetTasks(&model.TaskQuery{})
+	assert.NoError(t, err)
+	assert.Equal(t, []*model.Task{{ID: 1, Name: "task1", Status: model.StatusTodo}}, tasks)
+	task, err := repo.CreateTask(newTask("task2"))
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(2), task.ID)
+}
+
+func TestFileRepositoryLock(t *testing.T) {
+	dir := t.TempDir()
+	repo, err := NewFileRepository(dir, 0)
+	require.NoError(t, err)
+
+	_, err = NewFileRepository(dir, 0)
+	assert.ErrorIs(t, err, ErrLocked)
+
+	require.NoError(t, repo.Close())
+	repo, err = NewFileRepository(dir, 0)
+	require.NoError(t, err)
+	assert.NoError(t, repo.Close())
+}
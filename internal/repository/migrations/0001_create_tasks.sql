@@ -0,0 +1,7 @@
+CREATE TABLE tasks (
+	id     INTEGER PRIMARY KEY AUTOINCREMENT,
+	name   TEXT    NOT NULL,
+	status INTEGER NOT NULL DEFAULT 0
+);
+
+CREATE UNIQUE INDEX tasks_name_idx ON tasks (name);
@@ -0,0 +1,8 @@
+ALTER TABLE tasks ADD COLUMN description TEXT NOT NULL DEFAULT '';
+ALTER TABLE tasks ADD COLUMN priority INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE tasks ADD COLUMN due_at TEXT;
+ALTER TABLE tasks ADD COLUMN created_at TEXT NOT NULL DEFAULT '';
+ALTER TABLE tasks ADD COLUMN updated_at TEXT NOT NULL DEFAULT '';
+ALTER TABLE tasks ADD COLUMN completed_at TEXT;
+
+CREATE INDEX tasks_created_at_idx ON tasks (created_at, id);
@@ -0,0 +1,30 @@
+-- SQLite cannot change a column type in place, so rebuild the table with
+-- status stored by name. Legacy 0 maps to todo and 1 to done.
+CREATE TABLE tasks_new (
+	id           INTEGER PRIMARY KEY AUTOINCREMENT,
+	name         TEXT    NOT NULL,
+	status       TEXT    NOT NULL DEFAULT 'todo',
+	description  TEXT    NOT NULL DEFAULT '',
+	priority     INTEGER NOT NULL DEFAULT 0,
+	due_at       TEXT,
+	created_at   TEXT    NOT NULL DEFAULT '',
+	updated_at   TEXT    NOT NULL DEFAULT '',
+	completed_at TEXT
+);
+
+INSERT INTO tasks_new (id, name, status, description, priority, due_at, created_at, updated_at, completed_at)
+SELECT id, name, CASE status WHEN 1 THEN 'done' ELSE 'todo' END, description, priority, due_at, created_at, updated_at, completed_at
+FROM tasks;
+
+-- Keep AUTOINCREMENT from reusing the IDs of deleted tasks.
+UPDATE sqlite_sequence SET seq = (SELECT MAX(seq) FROM sqlite_sequence WHERE name IN ('tasks', 'tasks_new'))
+WHERE name = 'tasks_new';
+INSERT INTO sqlite_sequence (name, seq)
+SELECT 'tasks_new', seq FROM sqlite_sequence
+WHERE name = 'tasks' AND NOT EXISTS (SELECT 1 FROM sqlite_sequence WHERE name = 'tasks_new');
+
+DROP TABLE tasks;
+ALTER TABLE tasks_new RENAME TO tasks;
+
+CREATE UNIQUE INDEX tasks_name_idx ON tasks (name);
+CREATE INDEX tasks_created_at_idx ON tasks (created_at, id);
@@ -0,0 +1,7 @@
+CREATE TABLE task_tags (
+	task_id INTEGER NOT NULL REFERENCES tasks (id) ON DELETE CASCADE,
+	tag     TEXT    NOT NULL,
+	PRIMARY KEY (task_id, tag)
+);
+
+CREATE INDEX task_tags_tag_idx ON task_tags (tag, task_id);
@@ -0,0 +1,17 @@
+CREATE TABLE projects (
+	id          INTEGER PRIMARY KEY AUTOINCREMENT,
+	name        TEXT    NOT NULL,
+	description TEXT    NOT NULL DEFAULT '',
+	created_at  TEXT    NOT NULL DEFAULT '',
+	updated_at  TEXT    NOT NULL DEFAULT ''
+);
+
+CREATE UNIQUE INDEX projects_name_idx ON projects (name);
+
+-- Tasks outside any project keep a NULL project_id; names are unique per
+-- project, with those tasks sharing one namespace.
+ALTER TABLE tasks ADD COLUMN project_id INTEGER REFERENCES projects (id);
+
+DROP INDEX tasks_name_idx;
+CREATE UNIQUE INDEX tasks_name_idx ON tasks (IFNULL(project_id, 0), name);
+CREATE INDEX tasks_project_id_idx ON tasks (project_id);
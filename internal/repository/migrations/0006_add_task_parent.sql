@@ -0,0 +1,5 @@
+-- Zero marks a top-level task. The service keeps the hierarchy consistent,
+-- so there is no foreign key.
+ALTER TABLE tasks ADD COLUMN parent_id INTEGER NOT NULL DEFAULT 0;
+
+CREATE INDEX tasks_parent_id_idx ON tasks (parent_id);
@@ -0,0 +1,9 @@
+-- task_id is blocked by blocker_id. Like parent_id, the service removes
+-- edges to a deleted blocker, so only task_id has a foreign key.
+CREATE TABLE task_dependencies (
+	task_id    INTEGER NOT NULL REFERENCES tasks (id) ON DELETE CASCADE,
+	blocker_id INTEGER NOT NULL,
+	PRIMARY KEY (task_id, blocker_id)
+);
+
+CREATE INDEX task_dependencies_blocker_id_idx ON task_dependencies (blocker_id, task_id);
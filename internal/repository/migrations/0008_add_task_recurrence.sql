@@ -0,0 +1,2 @@
+-- An empty recurrence marks a task that does not repeat.
+ALTER TABLE tasks ADD COLUMN recurrence TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,6 @@
+-- Kept by the reminder scheduler: the time of the last reminder sent for
+-- the current due date, and when the task was found overdue.
+ALTER TABLE tasks ADD COLUMN reminded_at TEXT;
+ALTER TABLE tasks ADD COLUMN overdue_at TEXT;
+
+CREATE INDEX tasks_due_at_idx ON tasks (due_at);
@@ -0,0 +1,11 @@
+-- events holds a JSON array of event types; an empty array subscribes to
+-- every event.
+CREATE TABLE webhooks (
+	id         INTEGER PRIMARY KEY AUTOINCREMENT,
+	url        TEXT    NOT NULL,
+	events     TEXT    NOT NULL DEFAULT '[]',
+	secret     TEXT    NOT NULL DEFAULT '',
+	active     INTEGER NOT NULL DEFAULT 1,
+	created_at TEXT    NOT NULL DEFAULT '',
+	updated_at TEXT    NOT NULL DEFAULT ''
+);
@@ -0,0 +1,12 @@
+-- hash is the hex SHA-256 of the token; the token itself is never stored.
+-- scopes holds a JSON array.
+CREATE TABLE api_keys (
+	id         INTEGER PRIMARY KEY AUTOINCREMENT,
+	name       TEXT    NOT NULL,
+	prefix     TEXT    NOT NULL DEFAULT '',
+	hash       TEXT    NOT NULL,
+	scopes     TEXT    NOT NULL DEFAULT '[]',
+	created_at TEXT    NOT NULL DEFAULT ''
+);
+
+CREATE UNIQUE INDEX api_keys_hash_idx ON api_keys (hash);
@@ -0,0 +1,4 @@
+-- The subject of whoever created and last modified the task; empty when the
+-- server ran without authentication.
+ALTER TABLE tasks ADD COLUMN created_by TEXT NOT NULL DEFAULT '';
+ALTER TABLE tasks ADD COLUMN updated_by TEXT NOT NULL DEFAULT '';
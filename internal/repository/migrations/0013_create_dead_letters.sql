@@ -0,0 +1,7 @@
+-- delivery holds the whole model.WebhookDelivery as JSON. Dead letters
+-- outlive the webhook they were meant for, so webhook_id is no foreign key.
+CREATE TABLE dead_letters (
+	id         INTEGER PRIMARY KEY,
+	webhook_id INTEGER NOT NULL,
+	delivery   TEXT    NOT NULL
+);
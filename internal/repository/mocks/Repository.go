@@ -14,13 +14,73 @@ type Repository struct {
 	mock.Mock
 }
 
-// CreateTask provides a mock function with given fields: name
-func (_m *Repository) CreateTask(name string) (*model.Task, error) {
-	ret := _m.Called(name)
+// Close provides a mock function with given fields:
+func (_m *Repository) Close() error {
+	ret := _m.Called()
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func() error); ok {
+		r0 = rf()
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// CreateAPIKey provides a mock function with given fields: key
+func (_m *Repository) CreateAPIKey(key *model.APIKey) (*model.APIKey, error) {
+	ret := _m.Called(key)
+
+	var r0 *model.APIKey
+	if rf, ok := ret.Get(0).(func(*model.APIKey) *model.APIKey); ok {
+		r0 = rf(key)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.APIKey)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(*model.APIKey) error); ok {
+		r1 = rf(key)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// CreateProject provides a mock function with given fields: project
+func (_m *Repository) CreateProject(project *model.Project) (*model.Project, error) {
+	ret := _m.Called(project)
+
+	var r0 *model.Project
+	if rf, ok := ret.Get(0).(func(*model.Project) *model.Project); ok {
+		r0 = rf(project)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.Project)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(*model.Project) error); ok {
+		r1 = rf(project)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// CreateTask provides a mock function with given fields: task
+func (_m *Repository) CreateTask(task *model.Task) (*model.Task, error) {
+	ret := _m.Called(task)
 
 	var r0 *model.Task
-	if rf, ok := ret.Get(0).(func(string) *model.Task); ok {
-		r0 = rf(name)
+	if rf, ok := ret.Get(0).(func(*model.Task) *model.Task); ok {
+		r0 = rf(task)
 	} else {
 		if ret.Get(0) != nil {
 			r0 = ret.Get(0).(*model.Task)
@@ -28,8 +88,8 @@ func (_m *Repository) CreateTask(name string) (*model.Task, error) {
 	}
 
 	var r1 error
-	if rf, ok := ret.Get(1).(func(string) error); ok {
-		r1 = rf(name)
+	if rf, ok := ret.Get(1).(func(*model.Task) error); ok {
+		r1 = rf(task)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -37,6 +97,57 @@ func (_m *Repository) CreateTask(name string) (*model.Task, error) {
 	return r0, r1
 }
 
+// CreateWebhook provides a mock function with given fields: webhook
+func (_m *Repository) CreateWebhook(webhook *model.Webhook) (*model.Webhook, error) {
+	ret := _m.Called(webhook)
+
+	var r0 *model.Webhook
+	if rf, ok := ret.Get(0).(func(*model.Webhook) *model.Webhook); ok {
+		r0 = rf(webhook)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.Webhook)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(*model.Webhook) error); ok {
+		r1 = rf(webhook)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// DeleteAPIKey provides a mock function with given fields: id
+func (_m *Repository) DeleteAPIKey(id uint32) error {
+	ret := _m.Called(id)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(uint32) error); ok {
+		r0 = rf(id)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// DeleteProject provides a mock function with given fields: id, cascade
+func (_m *Repository) DeleteProject(id uint32, cascade bool) error {
+	ret := _m.Called(id, cascade)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(uint32, bool) error); ok {
+		r0 = rf(id, cascade)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // DeleteTask provides a mock function with given fields: id
 func (_m *Repository) DeleteTask(id uint32) error {
 	ret := _m.Called(id)
@@ -51,6 +162,158 @@ func (_m *Repository) DeleteTask(id uint32) error {
 	return r0
 }
 
+// DeleteWebhook provides a mock function with given fields: id
+func (_m *Repository) DeleteWebhook(id uint32) error {
+	ret := _m.Called(id)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(uint32) error); ok {
+		r0 = rf(id)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// GetAPIKeyByHash provides a mock function with given fields: hash
+func (_m *Repository) GetAPIKeyByHash(hash string) (*model.APIKey, error) {
+	ret := _m.Called(hash)
+
+	var r0 *model.APIKey
+	if rf, ok := ret.Get(0).(func(string) *model.APIKey); ok {
+		r0 = rf(hash)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.APIKey)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(string) error); ok {
+		r1 = rf(hash)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetAPIKeys provides a mock function with given fields:
+func (_m *Repository) GetAPIKeys() ([]*model.APIKey, error) {
+	ret := _m.Called()
+
+	var r0 []*model.APIKey
+	if rf, ok := ret.Get(0).(func() []*model.APIKey); ok {
+		r0 = rf()
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]*model.APIKey)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func() error); ok {
+		r1 = rf()
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetDeadLetters provides a mock function with given fields:
+func (_m *Repository) GetDeadLetters() ([]*model.WebhookDelivery, error) {
+	ret := _m.Called()
+
+	var r0 []*model.WebhookDelivery
+	if rf, ok := ret.Get(0).(func() []*model.WebhookDelivery); ok {
+		r0 = rf()
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]*model.WebhookDelivery)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func() error); ok {
+		r1 = rf()
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetProject provides a mock function with given fields: id
+func (_m *Repository) GetProject(id uint32) (*model.Project, error) {
+	ret := _m.Called(id)
+
+	var r0 *model.Project
+	if rf, ok := ret.Get(0).(func(uint32) *model.Project); ok {
+		r0 = rf(id)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.Project)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(uint32) error); ok {
+		r1 = rf(id)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetProjects provides a mock function with given fields:
+func (_m *Repository) GetProjects() ([]*model.Project, error) {
+	ret := _m.Called()
+
+	var r0 []*model.Project
+	if rf, ok := ret.Get(0).(func() []*model.Project); ok {
+		r0 = rf()
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]*model.Project)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func() error); ok {
+		r1 = rf()
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetTags provides a mock function with given fields:
+func (_m *Repository) GetTags() ([]*model.Tag, error) {
+	ret := _m.Called()
+
+	var r0 []*model.Tag
+	if rf, ok := ret.Get(0).(func() []*model.Tag); ok {
+		r0 = rf()
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]*model.Tag)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func() error); ok {
+		r1 = rf()
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // GetTask provides a mock function with given fields: id
 func (_m *Repository) GetTask(id uint32) (*model.Task, error) {
 	ret := _m.Called(id)
@@ -74,19 +337,72 @@ func (_m *Repository) GetTask(id uint32) (*model.Task, error) {
 	return r0, r1
 }
 
-// GetTasks provides a mock function with given fields:
-func (_m *Repository) GetTasks() ([]*model.Task, error) {
-	ret := _m.Called()
+// GetTasks provides a mock function with given fields: query
+func (_m *Repository) GetTasks(query *model.TaskQuery) ([]*model.Task, string, error) {
+	ret := _m.Called(query)
 
 	var r0 []*model.Task
-	if rf, ok := ret.Get(0).(func() []*model.Task); ok {
-		r0 = rf()
+	if rf, ok := ret.Get(0).(func(*model.TaskQuery) []*model.Task); ok {
+		r0 = rf(query)
 	} else {
 		if ret.Get(0) != nil {
 			r0 = ret.Get(0).([]*model.Task)
 		}
 	}
 
+	var r1 string
+	if rf, ok := ret.Get(1).(func(*model.TaskQuery) string); ok {
+		r1 = rf(query)
+	} else {
+		r1 = ret.Get(1).(string)
+	}
+
+	var r2 error
+	if rf, ok := ret.Get(2).(func(*model.TaskQuery) error); ok {
+		r2 = rf(query)
+	} else {
+		r2 = ret.Error(2)
+	}
+
+	return r0, r1, r2
+}
+
+// GetWebhook provides a mock function with given fields: id
+func (_m *Repository) GetWebhook(id uint32) (*model.Webhook, error) {
+	ret := _m.Called(id)
+
+	var r0 *model.Webhook
+	if rf, ok := ret.Get(0).(func(uint32) *model.Webhook); ok {
+		r0 = rf(id)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.Webhook)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(uint32) error); ok {
+		r1 = rf(id)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetWebhooks provides a mock function with given fields:
+func (_m *Repository) GetWebhooks() ([]*model.Webhook, error) {
+	ret := _m.Called()
+
+	var r0 []*model.Webhook
+	if rf, ok := ret.Get(0).(func() []*model.Webhook); ok {
+		r0 = rf()
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]*model.Webhook)
+		}
+	}
+
 	var r1 error
 	if rf, ok := ret.Get(1).(func() error); ok {
 		r1 = rf()
@@ -97,13 +413,36 @@ func (_m *Repository) GetTasks() ([]*model.Task, error) {
 	return r0, r1
 }
 
-// UpdateTask provides a mock function with given fields: id, name, status
-func (_m *Repository) UpdateTask(id uint32, name string, status uint8) (*model.Task, error) {
-	ret := _m.Called(id, name, status)
+// PatchProject provides a mock function with given fields: id, apply
+func (_m *Repository) PatchProject(id uint32, apply func(*model.Project) error) (*model.Project, error) {
+	ret := _m.Called(id, apply)
+
+	var r0 *model.Project
+	if rf, ok := ret.Get(0).(func(uint32, func(*model.Project) error) *model.Project); ok {
+		r0 = rf(id, apply)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.Project)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(uint32, func(*model.Project) error) error); ok {
+		r1 = rf(id, apply)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// PatchTask provides a mock function with given fields: id, apply
+func (_m *Repository) PatchTask(id uint32, apply func(*model.Task) error) (*model.Task, error) {
+	ret := _m.Called(id, apply)
 
 	var r0 *model.Task
-	if rf, ok := ret.Get(0).(func(uint32, string, uint8) *model.Task); ok {
-		r0 = rf(id, name, status)
+	if rf, ok := ret.Get(0).(func(uint32, func(*model.Task) error) *model.Task); ok {
+		r0 = rf(id, apply)
 	} else {
 		if ret.Get(0) != nil {
 			r0 = ret.Get(0).(*model.Task)
@@ -111,8 +450,8 @@ func (_m *Repository) UpdateTask(id uint32, name string, status uint8) (*model.T
 	}
 
 	var r1 error
-	if rf, ok := ret.Get(1).(func(uint32, string, uint8) error); ok {
-		r1 = rf(id, name, status)
+	if rf, ok := ret.Get(1).(func(uint32, func(*model.Task) error) error); ok {
+		r1 = rf(id, apply)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -120,6 +459,43 @@ func (_m *Repository) UpdateTask(id uint32, name string, status uint8) (*model.T
 	return r0, r1
 }
 
+// PatchWebhook provides a mock function with given fields: id, apply
+func (_m *Repository) PatchWebhook(id uint32, apply func(*model.Webhook) error) (*model.Webhook, error) {
+	ret := _m.Called(id, apply)
+
+	var r0 *model.Webhook
+	if rf, ok := ret.Get(0).(func(uint32, func(*model.Webhook) error) *model.Webhook); ok {
+		r0 = rf(id, apply)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.Webhook)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(uint32, func(*model.Webhook) error) error); ok {
+		r1 = rf(id, apply)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// PutDeadLetter provides a mock function with given fields: delivery
+func (_m *Repository) PutDeadLetter(delivery *model.WebhookDelivery) error {
+	ret := _m.Called(delivery)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(*model.WebhookDelivery) error); ok {
+		r0 = rf(delivery)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // NewRepository creates a new instance of Repository. It also registers a cleanup function to assert the mocks expectations.
 func NewRepository(t testing.TB) *Repository {
 	mock := &Repository{}
@@ -0,0 +1,120 @@
+package repository
+
+import (
+	"sort"
+
+	"github.com/wagaru/task/internal/errcode"
+	"github.com/wagaru/task/internal/model"
+)
+
+func (in *inMem) GetProjects() ([]*model.Project, error) {
+	in.mux.RLock()
+	defer in.mux.RUnlock()
+	projects := make([]*model.Project, 0, len(in.projects))
+	for _, project := range in.projects {
+		projects = append(projects, project)
+	}
+	sort.Slice(projects, func(i, j int) bool {
+		return projects[i].ID < projects[j].ID
+	})
+	return projects, nil
+}
+
+func (in *inMem) GetProject(id uint32) (*model.Project, error) {
+	in.mux.RLock()
+	defer in.mux.RUnlock()
+	project, ok := in.projects[id]
+	if !ok {
+		return nil, errcode.RecordNotExists
+	}
+	return project, nil
+}
+
+func (in *inMem) CreateProject(project *model.Project) (*model.Project, error) {
+	in.mux.Lock()
+	defer in.mux.Unlock()
+
+	if _, ok := in.projectNames[project.Name]; ok {
+		return nil, errcode.DuplicateRecords
+	}
+	created := *project
+	created.ID = in.pid + 1
+	if err := in.log(&record{Op: opPutProject, Project: &created}); err != nil {
+		return nil, err
+	}
+
+	in.pid = created.ID
+	in.putProject(&created)
+	return &created, nil
+}
+
+func (in *inMem) PatchProject(id uint32, apply func(project *model.Project) error) (*model.Project, error) {
+	in.mux.Lock()
+	defer in.mux.Unlock()
+
+	project, ok := in.projects[id]
+	if !ok {
+		return nil, errcode.RecordNotExists
+	}
+	updated := *project
+	if err := apply(&updated); err != nil {
+		return nil, err
+	}
+	updated.ID = id
+
+	if cid, ok := in.projectNames[updated.Name]; ok && cid != id {
+		return nil, errcode.DuplicateRecords
+	}
+	if err := in.log(&record{Op: opPutProject, Project: &updated}); err != nil {
+		return nil, err
+	}
+
+	in.putProject(&updated)
+	return &updated, nil
+}
+
+func (in *inMem) DeleteProject(id uint32, cascade bool) error {
+	in.mux.Lock()
+	defer in.mux.Unlock()
+
+	if _, ok := in.projects[id]; !ok {
+		return errcode.RecordNotExists
+	}
+	if len(in.projectTasks[id]) > 0 && !cascade {
+		return errcode.ProjectNotEmpty
+	}
+	if err := in.log(&record{Op: opDeleteProject, ID: id}); err != nil {
+		return err
+	}
+
+	in.removeProject(id)
+	return nil
+}
+
+// checkProject reports whether tasks may be placed in the project.
+func (in *inMem) checkProject(id uint32) error {
+	if _, ok := in.projects[id]; id != 0 && !ok {
+		return errcode.RecordNotExists
+	}
+	return nil
+}
+
+func (in *inMem) putProject(project *model.Project) {
+	if old, ok := in.projects[project.ID]; ok {
+		delete(in.projectNames, old.Name)
+	}
+	in.projects[project.ID] = project
+	in.projectNames[project.Name] = project.ID
+}
+
+// removeProject deletes the project along with any tasks left in it.
+func (in *inMem) removeProject(id uint32) {
+	for taskID := range in.projectTasks[id] {
+		in.remove(taskID)
+	}
+	delete(in.projectTasks, id)
+	if project, ok := in.projects[id]; ok {
+		delete(in.projectNames, project.Name)
+		delete(in.projects, id)
+	}
+}
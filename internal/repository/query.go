@@ -0,0 +1,156 @@
+package repository
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/wagaru/task/internal/errcode"
+	"github.com/wagaru/task/internal/model"
+)
+
+// cursor marks the last task of a page. It records the sort it was issued
+// for so it cannot be replayed against a different ordering.
+type cursor struct {
+	Sort      string     `json:"s"`
+	Desc      bool       `json:"d,omitempty"`
+	Name      string     `json:"n,omitempty"`
+	CreatedAt *time.Time `json:"c,omitempty"`
+	ID        uint32     `json:"i"`
+}
+
+func (c *cursor) task() *model.Task {
+	task := &model.Task{ID: c.ID, Name: c.Name}
+	if c.CreatedAt != nil {
+		task.CreatedAt = *c.CreatedAt
+	}
+	return task
+}
+
+func sortField(query *model.TaskQuery) string {
+	if query.Sort == "" {
+		return model.SortByID
+	}
+	return query.Sort
+}
+
+func encodeCursor(query *model.TaskQuery, task *model.Task) string {
+	c := cursor{Sort: sortField(query), Desc: query.Desc, ID: task.ID}
+	switch c.Sort {
+	case model.SortByName:
+		c.Name = task.Name
+	case model.SortByCreatedAt:
+		c.CreatedAt = &task.CreatedAt
+	}
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeCursor(query *model.TaskQuery) (*cursor, error) {
+	if query.Cursor == "" {
+		return nil, nil
+	}
+	invalid := errcode.InvalidParams.WithDetails("cursor: 無效的分頁游標")
+	b, err := base64.RawURLEncoding.DecodeString(query.Cursor)
+	if err != nil {
+		return nil, invalid
+	}
+	var c cursor
+	if err := json.Unmarshal(b, &c); err != nil {
+		return nil, invalid
+	}
+	if c.Sort != sortField(query) || c.Desc != query.Desc {
+		return nil, invalid
+	}
+	if c.Sort == model.SortByCreatedAt && c.CreatedAt == nil {
+		return nil, invalid
+	}
+	return &c, nil
+}
+
+// compareTasks orders a before b (negative), after (positive) according to
+// the query's sort field, falling back to ID so the order is total.
+func compareTasks(query *model.TaskQuery, a, b *model.Task) int {
+	cmp := 0
+	switch sortField(query) {
+	case model.SortByName:
+		cmp = strings.Compare(a.Name, b.Name)
+	case model.SortByCreatedAt:
+		cmp = a.CreatedAt.Compare(b.CreatedAt)
+	}
+	if cmp == 0 {
+		switch {
+		case a.ID < b.ID:
+			cmp = -1
+		case a.ID > b.ID:
+			cmp = 1
+		}
+	}
+	if query.Desc {
+		return -cmp
+	}
+	return cmp
+}
+
+func matchTask(query *model.TaskQuery, task *model.Task) bool {
+	if query.ProjectID != nil && task.ProjectID != *query.ProjectID {
+		return false
+	}
+	if query.ParentID != nil && task.ParentID != *query.ParentID {
+		return false
+	}
+	if query.Blocker != nil && !task.IsBlockedBy(*query.Blocker) {
+		return false
+	}
+	if query.DueBefore != nil && (task.DueAt == nil || !task.DueAt.Before(*query.DueBefore)) {
+		return false
+	}
+	if query.Status != nil && task.Status != *query.Status {
+		return false
+	}
+	if query.Name != "" && !strings.Contains(strings.ToLower(task.Name), strings.ToLower(query.Name)) {
+		return false
+	}
+	if len(query.Tags) > 0 && !task.HasTags(query.Tags, query.AllTags) {
+		return false
+	}
+	return true
+}
+
+// queryTasks applies a query to a full set of tasks for the backends that
+// cannot push it down to storage.
+func queryTasks(tasks []*model.Task, query *model.TaskQuery) ([]*model.Task, string, error) {
+	if query == nil {
+		query = &model.TaskQuery{}
+	}
+	after, err := decodeCursor(query)
+	if err != nil {
+		return nil, "", err
+	}
+	var last *model.Task
+	if after != nil {
+		last = after.task()
+	}
+
+	result := make([]*model.Task, 0, len(tasks))
+	for _, task := range tasks {
+		if !matchTask(query, task) {
+			continue
+		}
+		if last != nil && compareTasks(query, task, last) <= 0 {
+			continue
+		}
+		result = append(result, task)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return compareTasks(query, result[i], result[j]) < 0
+	})
+
+	if query.Limit > 0 && len(result) > query.Limit {
+		result = result[:query.Limit]
+		return result, encodeCursor(query, result[len(result)-1]), nil
+	}
+	return result, "", nil
+}
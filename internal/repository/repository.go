@@ -1,44 +1,133 @@
 package repository
 
 import (
+	"fmt"
+	"path/filepath"
+	"sort"
 	"sync"
-	"sync/atomic"
 
+	"github.com/wagaru/task/config"
 	"github.com/wagaru/task/internal/errcode"
 	"github.com/wagaru/task/internal/model"
 )
 
-var UID uint32 = 0
-
 type inMem struct {
-	mux   sync.RWMutex
-	data  map[uint32]*model.Task
-	cache map[string]uint32
+	mux          sync.RWMutex
+	uid          uint32
+	data         map[uint32]*model.Task
+	cache        map[taskName]uint32
+	tags         map[string]map[uint32]bool
+	pid          uint32
+	projects     map[uint32]*model.Project
+	projectNames map[string]uint32
+	projectTasks map[uint32]map[uint32]bool
+	wid          uint32
+	webhooks     map[uint32]*model.Webhook
+	kid          uint32
+	apiKeys      map[uint32]*model.APIKey
+	keyHashes    map[string]uint32
+	deadLetters  map[uint64]*model.WebhookDelivery
+	journal      func(rec *record) error
+}
+
+// taskName is the key task names are unique under.
+type taskName struct {
+	project uint32
+	name    string
+}
+
+func nameOf(task *model.Task) taskName {
+	return taskName{project: task.ProjectID, name: task.Name}
 }
 
 type Repository interface {
-	GetTasks() ([]*model.Task, error)
+	GetTasks(query *model.TaskQuery) ([]*model.Task, string, error)
 	GetTask(id uint32) (*model.Task, error)
-	CreateTask(name string) (*model.Task, error)
-	UpdateTask(id uint32, name string, status uint8) (*model.Task, error)
+	CreateTask(task *model.Task) (*model.Task, error)
+	PatchTask(id uint32, apply func(task *model.Task) error) (*model.Task, error)
 	DeleteTask(id uint32) error
+	GetTags() ([]*model.Tag, error)
+	GetProjects() ([]*model.Project, error)
+	GetProject(id uint32) (*model.Project, error)
+	CreateProject(project *model.Project) (*model.Project, error)
+	PatchProject(id uint32, apply func(project *model.Project) error) (*model.Project, error)
+	// DeleteProject fails with errcode.ProjectNotEmpty while the project
+	// has tasks, unless cascade is set, in which case they go with it.
+	DeleteProject(id uint32, cascade bool) error
+	GetWebhooks() ([]*model.Webhook, error)
+	GetWebhook(id uint32) (*model.Webhook, error)
+	CreateWebhook(webhook *model.Webhook) (*model.Webhook, error)
+	PatchWebhook(id uint32, apply func(webhook *model.Webhook) error) (*model.Webhook, error)
+	DeleteWebhook(id uint32) error
+	// GetDeadLetters lists the webhook deliveries that were given up on,
+	// newest first.
+	GetDeadLetters() ([]*model.WebhookDelivery, error)
+	// PutDeadLetter stores delivery under its own ID, replacing any dead
+	// letter with that ID.
+	PutDeadLetter(delivery *model.WebhookDelivery) error
+	GetAPIKeys() ([]*model.APIKey, error)
+	// GetAPIKeyByHash finds the key whose token hashes to hash.
+	GetAPIKeyByHash(hash string) (*model.APIKey, error)
+	CreateAPIKey(key *model.APIKey) (*model.APIKey, error)
+	DeleteAPIKey(id uint32) error
+	Close() error
+}
+
+func New(conf *config.RepositoryConfig) (Repository, error) {
+	if conf == nil {
+		return NewRepository(), nil
+	}
+	switch conf.Driver {
+	case "", "memory":
+		return NewRepository(), nil
+	case "file":
+		return NewFileRepository(conf.Path, conf.CompactInterval)
+	case "sqlite":
+		return NewSQLRepository(filepath.Join(conf.Path, "task.db"))
+	case "bolt":
+		return NewBoltRepository(filepath.Join(conf.Path, "task.bolt"))
+	default:
+		return nil, fmt.Errorf("unknown repository driver %q", conf.Driver)
+	}
 }
 
 func NewRepository() Repository {
+	return newInMem()
+}
+
+func newInMem() *inMem {
 	return &inMem{
-		data:  make(map[uint32]*model.Task),
-		cache: make(map[string]uint32),
+		data:         make(map[uint32]*model.Task),
+		cache:        make(map[taskName]uint32),
+		tags:         make(map[string]map[uint32]bool),
+		projects:     make(map[uint32]*model.Project),
+		projectNames: make(map[string]uint32),
+		projectTasks: make(map[uint32]map[uint32]bool),
+		webhooks:     make(map[uint32]*model.Webhook),
+		apiKeys:      make(map[uint32]*model.APIKey),
+		keyHashes:    make(map[string]uint32),
+		deadLetters:  make(map[uint64]*model.WebhookDelivery),
 	}
 }
 
-func (in *inMem) GetTasks() ([]*model.Task, error) {
+func (in *inMem) GetTasks(query *model.TaskQuery) ([]*model.Task, string, error) {
 	in.mux.RLock()
 	defer in.mux.RUnlock()
+	if query != nil && len(query.Tags) > 0 {
+		return queryTasks(in.tagged(query.Tags, query.AllTags), query)
+	}
+	if query != nil && query.ProjectID != nil {
+		tasks := make([]*model.Task, 0, len(in.projectTasks[*query.ProjectID]))
+		for id := range in.projectTasks[*query.ProjectID] {
+			tasks = append(tasks, in.data[id])
+		}
+		return queryTasks(tasks, query)
+	}
 	tasks := make([]*model.Task, 0, len(in.data))
 	for _, task := range in.data {
 		tasks = append(tasks, task)
 	}
-	return tasks, nil
+	return queryTasks(tasks, query)
 }
 
 func (in *inMem) GetTask(id uint32) (*model.Task, error) {
@@ -51,25 +140,28 @@ func (in *inMem) GetTask(id uint32) (*model.Task, error) {
 	return task, nil
 }
 
-func (in *inMem) CreateTask(name string) (*model.Task, error) {
+func (in *inMem) CreateTask(task *model.Task) (*model.Task, error) {
 	in.mux.Lock()
 	defer in.mux.Unlock()
 
-	if _, ok := in.cache[name]; ok {
+	if err := in.checkProject(task.ProjectID); err != nil {
+		return nil, err
+	}
+	if _, ok := in.cache[nameOf(task)]; ok {
 		return nil, errcode.DuplicateRecords
 	}
-	task := &model.Task{
-		Name:   name,
-		ID:     atomic.AddUint32(&UID, 1),
-		Status: 0,
+	created := *task
+	created.ID = in.uid + 1
+	if err := in.log(&record{Op: opPut, Task: &created}); err != nil {
+		return nil, err
 	}
 
-	in.data[task.ID] = task
-	in.cache[task.Name] = task.ID
-	return task, nil
+	in.uid = created.ID
+	in.put(&created)
+	return &created, nil
 }
 
-func (in *inMem) UpdateTask(id uint32, name string, status uint8) (*model.Task, error) {
+func (in *inMem) PatchTask(id uint32, apply func(task *model.Task) error) (*model.Task, error) {
 	in.mux.Lock()
 	defer in.mux.Unlock()
 
@@ -77,28 +169,118 @@ func (in *inMem) UpdateTask(id uint32, name string, status uint8) (*model.Task,
 	if !ok {
 		return nil, errcode.RecordNotExists
 	}
+	updated := *task
+	if err := apply(&updated); err != nil {
+		return nil, err
+	}
+	updated.ID = id
 
-	if cid, ok := in.cache[name]; ok && cid != id {
+	if err := in.checkProject(updated.ProjectID); err != nil {
+		return nil, err
+	}
+	if cid, ok := in.cache[nameOf(&updated)]; ok && cid != id {
 		return nil, errcode.DuplicateRecords
 	}
-	task.Name = name
-	task.Status = status
+	if err := in.log(&record{Op: opPut, Task: &updated}); err != nil {
+		return nil, err
+	}
 
-	in.data[task.ID] = task
-	in.cache[task.Name] = task.ID
-	return task, nil
+	in.put(&updated)
+	return &updated, nil
 }
 
 func (in *inMem) DeleteTask(id uint32) error {
 	in.mux.Lock()
 	defer in.mux.Unlock()
 
-	task, ok := in.data[id]
-	if !ok {
+	if _, ok := in.data[id]; !ok {
 		return errcode.RecordNotExists
 	}
+	if err := in.log(&record{Op: opDelete, ID: id}); err != nil {
+		return err
+	}
 
-	delete(in.data, id)
-	delete(in.cache, task.Name)
+	in.remove(id)
 	return nil
 }
+
+func (in *inMem) GetTags() ([]*model.Tag, error) {
+	in.mux.RLock()
+	defer in.mux.RUnlock()
+	tags := make([]*model.Tag, 0, len(in.tags))
+	for name, ids := range in.tags {
+		tags = append(tags, &model.Tag{Name: name, Count: len(ids)})
+	}
+	sort.Slice(tags, func(i, j int) bool {
+		return tags[i].Name < tags[j].Name
+	})
+	return tags, nil
+}
+
+func (in *inMem) Close() error {
+	return nil
+}
+
+func (in *inMem) log(rec *record) error {
+	if in.journal == nil {
+		return nil
+	}
+	return in.journal(rec)
+}
+
+func (in *inMem) put(task *model.Task) {
+	if old, ok := in.data[task.ID]; ok {
+		delete(in.cache, nameOf(old))
+		delete(in.projectTasks[old.ProjectID], old.ID)
+		in.untag(old)
+	}
+	in.data[task.ID] = task
+	in.cache[nameOf(task)] = task.ID
+	if in.projectTasks[task.ProjectID] == nil {
+		in.projectTasks[task.ProjectID] = make(map[uint32]bool)
+	}
+	in.projectTasks[task.ProjectID][task.ID] = true
+	for _, tag := range task.Tags {
+		if in.tags[tag] == nil {
+			in.tags[tag] = make(map[uint32]bool)
+		}
+		in.tags[tag][task.ID] = true
+	}
+}
+
+func (in *inMem) remove(id uint32) {
+	if task, ok := in.data[id]; ok {
+		delete(in.cache, nameOf(task))
+		delete(in.projectTasks[task.ProjectID], id)
+		in.untag(task)
+		delete(in.data, id)
+	}
+}
+
+func (in *inMem) untag(task *model.Task) {
+	for _, tag := range task.Tags {
+		delete(in.tags[tag], task.ID)
+		if len(in.tags[tag]) == 0 {
+			delete(in.tags, tag)
+		}
+	}
+}
+
+// tagged looks tasks up in the tag index: those carrying any of tags, or
+// only those carrying all of them.
+func (in *inMem) tagged(tags []string, all bool) []*model.Task {
+	counts := make(map[uint32]int)
+	for _, tag := range tags {
+		for id := range in.tags[tag] {
+			counts[id]++
+		}
+	}
+	tasks := make([]*model.Task, 0, len(counts))
+	for id, n := range counts {
+		if all && n < len(tags) {
+			continue
+		}
+		tasks = append(tasks, in.data[id])
+	}
+	return tasks
+}
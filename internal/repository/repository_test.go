@@ -10,27 +10,28 @@ import (
 
 func TestGetTasks(t *testing.T) {
 	mockInMem := &inMem{
-		data:  make(map[uint32]*model.Task),
-		cache: make(map[string]uint32),
+		data:         make(map[uint32]*model.Task),
+		cache:        make(map[taskName]uint32),
+		projectTasks: make(map[uint32]map[uint32]bool),
 	}
 	tasks := []*model.Task{
 		{
 			ID:     1,
 			Name:   "task1",
-			Status: 0,
+			Status: model.StatusTodo,
 		},
 		{
 			ID:     2,
 			Name:   "task2",
-			Status: 1,
+			Status: model.StatusDone,
 		},
 	}
 	for _, task := range tasks {
 		mockInMem.data[task.ID] = task
-		mockInMem.cache[task.Name] = task.ID
+		mockInMem.cache[nameOf(task)] = task.ID
 	}
 
-	result, err := mockInMem.GetTasks()
+	result, _, err := mockInMem.GetTasks(&model.TaskQuery{})
 	assert.NoError(t, err)
 	assert.Equal(t, result, tasks)
 }
@@ -40,11 +41,12 @@ func TestGetTask(t *testing.T) {
 		task := &model.Task{
 			ID:     1,
 			Name:   "task1",
-			Status: 0,
+			Status: model.StatusTodo,
 		}
 		mockInMem := &inMem{
-			data:  map[uint32]*model.Task{task.ID: task},
-			cache: map[string]uint32{task.Name: task.ID},
+			data:         map[uint32]*model.Task{task.ID: task},
+			cache:        map[taskName]uint32{nameOf(task): task.ID},
+			projectTasks: make(map[uint32]map[uint32]bool),
 		}
 		result, err := mockInMem.GetTask(task.ID)
 		assert.NoError(t, err)
@@ -54,11 +56,12 @@ func TestGetTask(t *testing.T) {
 		task := &model.Task{
 			ID:     1,
 			Name:   "task1",
-			Status: 0,
+			Status: model.StatusTodo,
 		}
 		mockInMem := &inMem{
-			data:  map[uint32]*model.Task{task.ID: task},
-			cache: map[string]uint32{task.Name: task.ID},
+			data:         map[uint32]*model.Task{task.ID: task},
+			cache:        map[taskName]uint32{nameOf(task): task.ID},
+			projectTasks: make(map[uint32]map[uint32]bool),
 		}
 		result, err := mockInMem.GetTask(uint32(99999))
 		assert.Nil(t, result)
@@ -69,29 +72,31 @@ func TestGetTask(t *testing.T) {
 func TestCreateTask(t *testing.T) {
 	t.Run("Success", func(t *testing.T) {
 		mockInMem := &inMem{
-			data:  make(map[uint32]*model.Task),
-			cache: make(map[string]uint32),
+			data:         make(map[uint32]*model.Task),
+			cache:        make(map[taskName]uint32),
+			projectTasks: make(map[uint32]map[uint32]bool),
 		}
 		taskName := "newTask"
-		result, err := mockInMem.CreateTask(taskName)
+		result, err := mockInMem.CreateTask(newTask(taskName))
 		assert.Nil(t, err)
 		assert.Equal(t, result, &model.Task{
 			ID:     1,
 			Name:   taskName,
-			Status: 0,
+			Status: model.StatusTodo,
 		})
 	})
 	t.Run("DuplicateRecords", func(t *testing.T) {
 		task := &model.Task{
 			ID:     1,
 			Name:   "name_exists",
-			Status: 0,
+			Status: model.StatusTodo,
 		}
 		mockInMem := &inMem{
-			data:  map[uint32]*model.Task{task.ID: task},
-			cache: map[string]uint32{task.Name: task.ID},
+			data:         map[uint32]*model.Task{task.ID: task},
+			cache:        map[taskName]uint32{nameOf(task): task.ID},
+			projectTasks: make(map[uint32]map[uint32]bool),
 		}
-		result, err := mockInMem.CreateTask(task.Name)
+		result, err := mockInMem.CreateTask(newTask(task.Name))
 		assert.Nil(t, result)
 		assert.ErrorIs(t, err, errcode.DuplicateRecords)
 	})
@@ -102,14 +107,15 @@ func TestUpdateTask(t *testing.T) {
 		task := &model.Task{
 			ID:     1,
 			Name:   "task1",
-			Status: 0,
+			Status: model.StatusTodo,
 		}
 		mockInMem := &inMem{
-			data:  map[uint32]*model.Task{task.ID: task},
-			cache: map[string]uint32{task.Name: task.ID},
+			data:         map[uint32]*model.Task{task.ID: task},
+			cache:        map[taskName]uint32{nameOf(task): task.ID},
+			projectTasks: make(map[uint32]map[uint32]bool),
 		}
 		updatedName := "task1_rename"
-		result, err := mockInMem.UpdateTask(task.ID, updatedName, task.Status)
+		result, err := updateTask(mockInMem, task.ID, updatedName, task.Status)
 		assert.NoError(t, err)
 		assert.Equal(t, result, &model.Task{
 			ID:     task.ID,
@@ -121,38 +127,40 @@ func TestUpdateTask(t *testing.T) {
 		task := &model.Task{
 			ID:     1,
 			Name:   "task1",
-			Status: 0,
+			Status: model.StatusTodo,
 		}
 		mockInMem := &inMem{
-			data:  map[uint32]*model.Task{task.ID: task},
-			cache: map[string]uint32{task.Name: task.ID},
+			data:         map[uint32]*model.Task{task.ID: task},
+			cache:        map[taskName]uint32{nameOf(task): task.ID},
+			projectTasks: make(map[uint32]map[uint32]bool),
 		}
-		result, err := mockInMem.UpdateTask(uint32(9999), "", 0)
+		result, err := updateTask(mockInMem, uint32(9999), "", model.StatusTodo)
 		assert.Nil(t, result)
 		assert.ErrorIs(t, err, errcode.RecordNotExists)
 	})
 	t.Run("DuplicateRecords", func(t *testing.T) {
 		mockInMem := &inMem{
-			data:  make(map[uint32]*model.Task),
-			cache: make(map[string]uint32),
+			data:         make(map[uint32]*model.Task),
+			cache:        make(map[taskName]uint32),
+			projectTasks: make(map[uint32]map[uint32]bool),
 		}
 		tasks := []*model.Task{
 			{
 				ID:     1,
 				Name:   "task1",
-				Status: 0,
+				Status: model.StatusTodo,
 			},
 			{
 				ID:     2,
 				Name:   "task2",
-				Status: 1,
+				Status: model.StatusDone,
 			},
 		}
 		for _, task := range tasks {
 			mockInMem.data[task.ID] = task
-			mockInMem.cache[task.Name] = task.ID
+			mockInMem.cache[nameOf(task)] = task.ID
 		}
-		result, err := mockInMem.UpdateTask(tasks[0].ID, tasks[1].Name, tasks[0].Status)
+		result, err := updateTask(mockInMem, tasks[0].ID, tasks[1].Name, tasks[0].Status)
 		assert.Nil(t, result)
 		assert.ErrorIs(t, err, errcode.DuplicateRecords)
 	})
@@ -163,11 +171,12 @@ func TestDeleteTask(t *testing.T) {
 		task := &model.Task{
 			ID:     1,
 			Name:   "task1",
-			Status: 0,
+			Status: model.StatusTodo,
 		}
 		mockInMem := &inMem{
-			data:  map[uint32]*model.Task{task.ID: task},
-			cache: map[string]uint32{task.Name: task.ID},
+			data:         map[uint32]*model.Task{task.ID: task},
+			cache:        map[taskName]uint32{nameOf(task): task.ID},
+			projectTasks: make(map[uint32]map[uint32]bool),
 		}
 		err := mockInMem.DeleteTask(task.ID)
 		assert.NoError(t, err)
@@ -176,10 +185,23 @@ func TestDeleteTask(t *testing.T) {
 	})
 	t.Run("RecordNotExists", func(t *testing.T) {
 		mockInMem := &inMem{
-			data:  make(map[uint32]*model.Task),
-			cache: make(map[string]uint32),
+			data:         make(map[uint32]*model.Task),
+			cache:        make(map[taskName]uint32),
+			projectTasks: make(map[uint32]map[uint32]bool),
 		}
 		err := mockInMem.DeleteTask(uint32(9999))
 		assert.ErrorIs(t, err, errcode.RecordNotExists)
 	})
 }
+
+func updateTask(repo Repository, id uint32, name string, status model.Status) (*model.Task, error) {
+	return repo.PatchTask(id, func(task *model.Task) error {
+		task.Name = name
+		task.Status = status
+		return nil
+	})
+}
+
+func newTask(name string) *model.Task {
+	return &model.Task{Name: name, Status: model.StatusTodo}
+}
@@ -0,0 +1,852 @@
+// Package repositorytest holds the behavioural contract every
+// repository.Repository backend must satisfy.
+package repositorytest
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wagaru/task/internal/errcode"
+	"github.com/wagaru/task/internal/model"
+	"github.com/wagaru/task/internal/repository"
+)
+
+// Factory returns an empty repository. It should register any cleanup it
+// needs on t; Run closes the repository itself.
+type Factory func(t *testing.T) repository.Repository
+
+// Run exercises a backend only through the Repository interface.
+func Run(t *testing.T, factory Factory) {
+	newRepo := func(t *testing.T) repository.Repository {
+		repo := factory(t)
+		t.Cleanup(func() {
+			assert.NoError(t, repo.Close())
+		})
+		return repo
+	}
+
+	t.Run("CreateAndGet", func(t *testing.T) { testCreateAndGet(t, newRepo(t)) })
+	t.Run("GetTasks", func(t *testing.T) { testGetTasks(t, newRepo(t)) })
+	t.Run("Fields", func(t *testing.T) { testFields(t, newRepo(t)) })
+	t.Run("Query", func(t *testing.T) { testQuery(t, newRepo(t)) })
+	t.Run("DueBefore", func(t *testing.T) { testDueBefore(t, newRepo(t)) })
+	t.Run("Paginate", func(t *testing.T) { testPaginate(t, newRepo(t)) })
+	t.Run("SortCreatedAt", func(t *testing.T) { testSortCreatedAt(t, newRepo(t)) })
+	t.Run("UniqueName", func(t *testing.T) { testUniqueName(t, newRepo(t)) })
+	t.Run("Rename", func(t *testing.T) { testRename(t, newRepo(t)) })
+	t.Run("Patch", func(t *testing.T) { testPatch(t, newRepo(t)) })
+	t.Run("RecordNotExists", func(t *testing.T) { testRecordNotExists(t, newRepo(t)) })
+	t.Run("Delete", func(t *testing.T) { testDelete(t, newRepo(t)) })
+	t.Run("Tags", func(t *testing.T) { testTags(t, newRepo(t)) })
+	t.Run("Parent", func(t *testing.T) { testParent(t, newRepo(t)) })
+	t.Run("Dependencies", func(t *testing.T) { testDependencies(t, newRepo(t)) })
+	t.Run("Projects", func(t *testing.T) { testProjects(t, newRepo(t)) })
+	t.Run("ProjectTasks", func(t *testing.T) { testProjectTasks(t, newRepo(t)) })
+	t.Run("DeleteProject", func(t *testing.T) { testDeleteProject(t, newRepo(t)) })
+	t.Run("Webhooks", func(t *testing.T) { testWebhooks(t, newRepo(t)) })
+	t.Run("APIKeys", func(t *testing.T) { testAPIKeys(t, newRepo(t)) })
+	t.Run("DeadLetters", func(t *testing.T) { testDeadLetters(t, newRepo(t)) })
+	t.Run("MonotonicID", func(t *testing.T) { testMonotonicID(t, newRepo(t)) })
+	t.Run("ConcurrentCreate", func(t *testing.T) { testConcurrentCreate(t, newRepo(t)) })
+}
+
+func testCreateAndGet(t *testing.T, repo repository.Repository) {
+	task, err := repo.CreateTask(newTask("task"))
+	require.NoError(t, err)
+	assert.NotZero(t, task.ID)
+	assert.Equal(t, "task", task.Name)
+	assert.Equal(t, model.StatusTodo, task.Status)
+
+	result, err := repo.GetTask(task.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, task, result)
+}
+
+func testFields(t *testing.T, repo repository.Repository) {
+	createdAt := time.Date(2022, 5, 1, 8, 0, 0, 123456789, time.UTC)
+	dueAt := createdAt.Add(48 * time.Hour)
+	input := &model.Task{
+		Name:        "task",
+		Status:      model.StatusTodo,
+		Description: "description",
+		Priority:    3,
+		DueAt:       &dueAt,
+		Recurrence:  "FREQ=WEEKLY;BYDAY=MO",
+		CreatedAt:   createdAt,
+		UpdatedAt:   createdAt,
+		CreatedBy:   "alice",
+		UpdatedBy:   "alice",
+	}
+	task, err := repo.CreateTask(input)
+	require.NoError(t, err)
+	expected := *input
+	expected.ID = task.ID
+	assert.Equal(t, &expected, task)
+
+	result, err := repo.GetTask(task.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, &expected, result)
+
+	completedAt := createdAt.Add(time.Hour)
+	result, err = repo.PatchTask(task.ID, func(task *model.Task) error {
+		task.Status = model.StatusDone
+		task.DueAt = nil
+		task.Recurrence = ""
+		task.RemindedAt = &dueAt
+		task.OverdueAt = &completedAt
+		task.UpdatedAt = completedAt
+		task.UpdatedBy = "bob"
+		task.CompletedAt = &completedAt
+		return nil
+	})
+	assert.NoError(t, err)
+	expected.Status = model.StatusDone
+	expected.DueAt = nil
+	expected.Recurrence = ""
+	expected.RemindedAt = &dueAt
+	expected.OverdueAt = &completedAt
+	expected.UpdatedAt = completedAt
+	expected.UpdatedBy = "bob"
+	expected.CompletedAt = &completedAt
+	assert.Equal(t, &expected, result)
+
+	result, err = repo.GetTask(task.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, &expected, result)
+}
+
+func testGetTasks(t *testing.T, repo repository.Repository) {
+	tasks, _, err := repo.GetTasks(&model.TaskQuery{})
+	assert.NoError(t, err)
+	assert.Empty(t, tasks)
+
+	expected := make([]*model.Task, 0, 3)
+	for i := 1; i <= 3; i++ {
+		task, err := repo.CreateTask(newTask(fmt.Sprintf("task%d", i)))
+		require.NoError(t, err)
+		expected = append(expected, task)
+	}
+	tasks, _, err = repo.GetTasks(&model.TaskQuery{})
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, expected, tasks)
+}
+
+func testQuery(t *testing.T, repo repository.Repository) {
+	var all []*model.Task
+	for _, name := range []string{"Bravo", "alpha", "charlie", "alpha2", "Été"} {
+		task, err := repo.CreateTask(newTask(name))
+		require.NoError(t, err)
+		all = append(all, task)
+	}
+	done, err := update(repo, all[2].ID, all[2].Name, model.StatusDone)
+	require.NoError(t, err)
+	all[2] = done
+
+	status := model.StatusDone
+	tests := []struct {
+		name     string
+		query    *model.TaskQuery
+		expected []*model.Task
+	}{
+		{"Default", &model.TaskQuery{}, all},
+		{"IDDesc", &model.TaskQuery{Desc: true}, []*model.Task{all[4], all[3], all[2], all[1], all[0]}},
+		{"Status", &model.TaskQuery{Status: &status}, []*model.Task{all[2]}},
+		{"Name", &model.TaskQuery{Name: "ALPHA"}, []*model.Task{all[1], all[3]}},
+		{"NameUnicode", &model.TaskQuery{Name: "éTÉ"}, []*model.Task{all[4]}},
+		{"SortName", &model.TaskQuery{Sort: model.SortByName}, []*model.Task{all[0], all[1], all[3], all[2], all[4]}},
+		{"SortNameDesc", &model.TaskQuery{Sort: model.SortByName, Desc: true}, []*model.Task{all[4], all[2], all[3], all[1], all[0]}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tasks, next, err := repo.GetTasks(tt.query)
+			assert.NoError(t, err)
+			assert.Empty(t, next)
+			assert.Equal(t, tt.expected, tasks)
+		})
+	}
+}
+
+func testDueBefore(t *testing.T, repo repository.Repository) {
+	now := time.Date(2022, 5, 1, 8, 0, 0, 0, time.UTC)
+	at := func(offset time.Duration) *time.Time {
+		dueAt := now.Add(offset)
+		return &dueAt
+	}
+	var all []*model.Task
+	for i, dueAt := range []*time.Time{at(time.Hour), at(-time.Hour), at(0), at(2 * time.Hour), nil} {
+		task := newTask(fmt.Sprintf("task%d", i))
+		task.DueAt = dueAt
+		created, err := repo.CreateTask(task)
+		require.NoError(t, err)
+		all = append(all, created)
+	}
+
+	tests := []struct {
+		name     string
+		before   time.Time
+		expected []*model.Task
+	}{
+		{"Exclusive", now, []*model.Task{all[1]}},
+		{"Later", now.Add(time.Hour + time.Nanosecond), []*model.Task{all[0], all[1], all[2]}},
+		{"None", now.Add(-2 * time.Hour), []*model.Task{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tasks, _, err := repo.GetTasks(&model.TaskQuery{DueBefore: &tt.before})
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, tasks)
+		})
+	}
+}
+
+func testPaginate(t *testing.T, repo repository.Repository) {
+	var all []*model.Task
+	for i := 0; i < 5; i++ {
+		task, err := repo.CreateTask(newTask(fmt.Sprintf("task%d", 4-i)))
+		require.NoError(t, err)
+		all = append(all, task)
+	}
+	for _, query := range []*model.TaskQuery{
+		{Limit: 2},
+		{Limit: 2, Desc: true},
+		{Limit: 3, Sort: model.SortByName},
+		{Limit: 1, Sort: model.SortByName, Desc: true},
+	} {
+		expected, _, err := repo.GetTasks(&model.TaskQuery{Sort: query.Sort, Desc: query.Desc})
+		require.NoError(t, err)
+
+		var pages []*model.Task
+		for i := 0; ; i++ {
+			require.Less(t, i, len(all), "pagination does not terminate")
+			tasks, next, err := repo.GetTasks(query)
+			require.NoError(t, err)
+			assert.LessOrEqual(t, len(tasks), query.Limit)
+			pages = append(pages, tasks...)
+			if next == "" {
+				break
+			}
+			query.Cursor = next
+		}
+		assert.Equal(t, expected, pages)
+	}
+
+	_, _, err := repo.GetTasks(&model.TaskQuery{Cursor: "invalid"})
+	assert.ErrorIs(t, err, errcode.InvalidParams)
+
+	_, next, err := repo.GetTasks(&model.TaskQuery{Limit: 1})
+	require.NoError(t, err)
+	_, _, err = repo.GetTasks(&model.TaskQuery{Limit: 1, Sort: model.SortByName, Cursor: next})
+	assert.ErrorIs(t, err, errcode.InvalidParams)
+}
+
+func testSortCreatedAt(t *testing.T, repo repository.Repository) {
+	base := time.Date(2022, 5, 1, 8, 0, 0, 0, time.UTC)
+	var all []*model.Task
+	for i, offset := range []time.Duration{time.Hour, 100 * time.Millisecond, 0, time.Hour} {
+		task, err := repo.CreateTask(&model.Task{Name: fmt.Sprintf("task%d", i), Status: model.StatusTodo, CreatedAt: base.Add(offset)})
+		require.NoError(t, err)
+		all = append(all, task)
+	}
+	expected := []*model.Task{all[2], all[1], all[0], all[3]}
+
+	query := &model.TaskQuery{Sort: model.SortByCreatedAt, Limit: 1}
+	var pages []*model.Task
+	for i := 0; ; i++ {
+		require.Less(t, i, len(all), "pagination does not terminate")
+		tasks, next, err := repo.GetTasks(query)
+		require.NoError(t, err)
+		pages = append(pages, tasks...)
+		if next == "" {
+			break
+		}
+		query.Cursor = next
+	}
+	assert.Equal(t, expected, pages)
+
+	tasks, _, err := repo.GetTasks(&model.TaskQuery{Sort: model.SortByCreatedAt, Desc: true})
+	assert.NoError(t, err)
+	assert.Equal(t, []*model.Task{all[3], all[0], all[1], all[2]}, tasks)
+}
+
+func testUniqueName(t *testing.T, repo repository.Repository) {
+	task1, err := repo.CreateTask(newTask("task1"))
+	require.NoError(t, err)
+	task2, err := repo.CreateTask(newTask("task2"))
+	require.NoError(t, err)
+
+	result, err := repo.CreateTask(newTask(task1.Name))
+	assert.Nil(t, result)
+	assert.ErrorIs(t, err, errcode.DuplicateRecords)
+
+	result, err = update(repo, task2.ID, task1.Name, model.StatusTodo)
+	assert.Nil(t, result)
+	assert.ErrorIs(t, err, errcode.DuplicateRecords)
+
+	result, err = repo.GetTask(task2.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, task2, result)
+
+	result, err = update(repo, task1.ID, task1.Name, model.StatusDone)
+	assert.NoError(t, err)
+	assert.Equal(t, &model.Task{ID: task1.ID, Name: task1.Name, Status: model.StatusDone}, result)
+}
+
+func testRename(t *testing.T, repo repository.Repository) {
+	task, err := repo.CreateTask(newTask("task"))
+	require.NoError(t, err)
+
+	result, err := update(repo, task.ID, "task_rename", model.StatusDone)
+	assert.NoError(t, err)
+	assert.Equal(t, &model.Task{ID: task.ID, Name: "task_rename", Status: model.StatusDone}, result)
+
+	result, err = repo.GetTask(task.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, "task_rename", result.Name)
+
+	_, err = repo.CreateTask(newTask("task_rename"))
+	assert.ErrorIs(t, err, errcode.DuplicateRecords)
+
+	result, err = repo.CreateTask(newTask("task"))
+	assert.NoError(t, err)
+	assert.NotEqual(t, task.ID, result.ID)
+}
+
+func testPatch(t *testing.T, repo repository.Repository) {
+	task1, err := repo.CreateTask(newTask("task1"))
+	require.NoError(t, err)
+	task2, err := repo.CreateTask(newTask("task2"))
+	require.NoError(t, err)
+
+	result, err := repo.PatchTask(task1.ID, func(task *model.Task) error {
+		task.Status = model.StatusDone
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, &model.Task{ID: task1.ID, Name: task1.Name, Status: model.StatusDone}, result)
+
+	result, err = repo.PatchTask(task1.ID, func(task *model.Task) error {
+		task.Name = task2.Name
+		return nil
+	})
+	assert.Nil(t, result)
+	assert.ErrorIs(t, err, errcode.DuplicateRecords)
+
+	result, err = repo.PatchTask(task1.ID, func(task *model.Task) error {
+		task.Name = "changed"
+		return errcode.PatchTestFailed
+	})
+	assert.Nil(t, result)
+	assert.ErrorIs(t, err, errcode.PatchTestFailed)
+
+	result, err = repo.PatchTask(task1.ID, func(task *model.Task) error {
+		task.ID = task2.ID
+		task.Name = "task1_rename"
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, &model.Task{ID: task1.ID, Name: "task1_rename", Status: model.StatusDone}, result)
+
+	result, err = repo.GetTask(task2.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, task2, result)
+	_, err = repo.CreateTask(newTask(task1.Name))
+	assert.NoError(t, err)
+}
+
+func testRecordNotExists(t *testing.T, repo repository.Repository) {
+	result, err := repo.GetTask(9999)
+	assert.Nil(t, result)
+	assert.ErrorIs(t, err, errcode.RecordNotExists)
+
+	result, err = update(repo, 9999, "task", model.StatusTodo)
+	assert.Nil(t, result)
+	assert.ErrorIs(t, err, errcode.RecordNotExists)
+
+	result, err = repo.PatchTask(9999, func(task *model.Task) error { return nil })
+	assert.Nil(t, result)
+	assert.ErrorIs(t, err, errcode.RecordNotExists)
+
+	assert.ErrorIs(t, repo.DeleteTask(9999), errcode.RecordNotExists)
+}
+
+func testDelete(t *testing.T, repo repository.Repository) {
+	task, err := repo.CreateTask(newTask("task"))
+	require.NoError(t, err)
+
+	assert.NoError(t, repo.DeleteTask(task.ID))
+	_, err = repo.GetTask(task.ID)
+	assert.ErrorIs(t, err, errcode.RecordNotExists)
+	assert.ErrorIs(t, repo.DeleteTask(task.ID), errcode.RecordNotExists)
+
+	tasks, _, err := repo.GetTasks(&model.TaskQuery{})
+	assert.NoError(t, err)
+	assert.Empty(t, tasks)
+
+	_, err = repo.CreateTask(newTask(task.Name))
+	assert.NoError(t, err)
+}
+
+func testTags(t *testing.T, repo repository.Repository) {
+	var all []*model.Task
+	for i, tags := range [][]string{{"home", "urgent"}, {"work"}, {"urgent", "work"}, nil} {
+		task := newTask(fmt.Sprintf("task%d", i))
+		task.Tags = tags
+		created, err := repo.CreateTask(task)
+		require.NoError(t, err)
+		assert.Equal(t, tags, created.Tags)
+		all = append(all, created)
+	}
+
+	tests := []struct {
+		name     string
+		query    *model.TaskQuery
+		expected []*model.Task
+	}{
+		{"Any", &model.TaskQuery{Tags: []string{"home", "work"}}, all[:3]},
+		{"All", &model.TaskQuery{Tags: []string{"urgent", "work"}, AllTags: true}, []*model.Task{all[2]}},
+		{"Unknown", &model.TaskQuery{Tags: []string{"other"}}, []*model.Task{}},
+		{"Status", &model.TaskQuery{Tags: []string{"urgent"}, Status: &all[0].Status, Desc: true}, []*model.Task{all[2], all[0]}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tasks, _, err := repo.GetTasks(tt.query)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, tasks)
+		})
+	}
+
+	tags, err := repo.GetTags()
+	assert.NoError(t, err)
+	assert.Equal(t, []*model.Tag{{Name: "home", Count: 1}, {Name: "urgent", Count: 2}, {Name: "work", Count: 2}}, tags)
+
+	patched, err := repo.PatchTask(all[0].ID, func(task *model.Task) error {
+		task.Tags = []string{"home"}
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"home"}, patched.Tags)
+	require.NoError(t, repo.DeleteTask(all[1].ID))
+
+	tags, err = repo.GetTags()
+	assert.NoError(t, err)
+	assert.Equal(t, []*model.Tag{{Name: "home", Count: 1}, {Name: "urgent", Count: 1}, {Name: "work", Count: 1}}, tags)
+	tasks, _, err := repo.GetTasks(&model.TaskQuery{Tags: []string{"urgent"}})
+	assert.NoError(t, err)
+	assert.Equal(t, []*model.Task{all[2]}, tasks)
+}
+
+func testParent(t *testing.T, repo repository.Repository) {
+	parent, err := repo.CreateTask(newTask("parent"))
+	require.NoError(t, err)
+	var children []*model.Task
+	for _, name := range []string{"child1", "child2"} {
+		task := newTask(name)
+		task.ParentID = parent.ID
+		child, err := repo.CreateTask(task)
+		require.NoError(t, err)
+		assert.Equal(t, parent.ID, child.ParentID)
+		children = append(children, child)
+	}
+
+	tasks, _, err := repo.GetTasks(&model.TaskQuery{ParentID: &parent.ID})
+	assert.NoError(t, err)
+	assert.Equal(t, children, tasks)
+	top := uint32(0)
+	tasks, _, err = repo.GetTasks(&model.TaskQuery{ParentID: &top})
+	assert.NoError(t, err)
+	assert.Equal(t, []*model.Task{parent}, tasks)
+
+	detached, err := repo.PatchTask(children[0].ID, func(task *model.Task) error {
+		task.ParentID = 0
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Zero(t, detached.ParentID)
+	tasks, _, err = repo.GetTasks(&model.TaskQuery{ParentID: &parent.ID})
+	assert.NoError(t, err)
+	assert.Equal(t, children[1:], tasks)
+}
+
+func testDependencies(t *testing.T, repo repository.Repository) {
+	blocker, err := repo.CreateTask(newTask("blocker"))
+	require.NoError(t, err)
+	other, err := repo.CreateTask(newTask("other"))
+	require.NoError(t, err)
+	task := newTask("task")
+	task.BlockedBy = []uint32{blocker.ID, other.ID}
+	blocked, err := repo.CreateTask(task)
+	require.NoError(t, err)
+	assert.Equal(t, []uint32{blocker.ID, other.ID}, blocked.BlockedBy)
+
+	got, err := repo.GetTask(blocked.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, blocked, got)
+	tasks, _, err := repo.GetTasks(&model.TaskQuery{Blocker: &blocker.ID})
+	assert.NoError(t, err)
+	assert.Equal(t, []*model.Task{blocked}, tasks)
+
+	patched, err := repo.PatchTask(blocked.ID, func(task *model.Task) error {
+		task.BlockedBy = []uint32{other.ID}
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []uint32{other.ID}, patched.BlockedBy)
+	tasks, _, err = repo.GetTasks(&model.TaskQuery{Blocker: &blocker.ID})
+	assert.NoError(t, err)
+	assert.Equal(t, []*model.Task{}, tasks)
+	tasks, _, err = repo.GetTasks(&model.TaskQuery{Blocker: &other.ID})
+	assert.NoError(t, err)
+	assert.Equal(t, []*model.Task{patched}, tasks)
+
+	patched, err = repo.PatchTask(blocked.ID, func(task *model.Task) error {
+		task.BlockedBy = nil
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Nil(t, patched.BlockedBy)
+	got, err = repo.GetTask(blocked.ID)
+	assert.NoError(t, err)
+	assert.Nil(t, got.BlockedBy)
+}
+
+func testProjects(t *testing.T, repo repository.Repository) {
+	projects, err := repo.GetProjects()
+	assert.NoError(t, err)
+	assert.Empty(t, projects)
+
+	createdAt := time.Date(2022, 5, 1, 8, 0, 0, 0, time.UTC)
+	project1, err := repo.CreateProject(&model.Project{Name: "project1", Description: "description", CreatedAt: createdAt, UpdatedAt: createdAt})
+	require.NoError(t, err)
+	assert.NotZero(t, project1.ID)
+	project2, err := repo.CreateProject(&model.Project{Name: "project2"})
+	require.NoError(t, err)
+	assert.Greater(t, project2.ID, project1.ID)
+
+	result, err := repo.GetProject(project1.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, project1, result)
+	projects, err = repo.GetProjects()
+	assert.NoError(t, err)
+	assert.Equal(t, []*model.Project{project1, project2}, projects)
+
+	_, err = repo.CreateProject(&model.Project{Name: project1.Name})
+	assert.ErrorIs(t, err, errcode.DuplicateRecords)
+	_, err = repo.PatchProject(project2.ID, func(project *model.Project) error {
+		project.Name = project1.Name
+		return nil
+	})
+	assert.ErrorIs(t, err, errcode.DuplicateRecords)
+
+	renamed, err := repo.PatchProject(project1.ID, func(project *model.Project) error {
+		project.Name = "project1_rename"
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "project1_rename", renamed.Name)
+	assert.Equal(t, project1.Description, renamed.Description)
+	_, err = repo.CreateProject(&model.Project{Name: project1.Name})
+	assert.NoError(t, err)
+
+	_, err = repo.GetProject(9999)
+	assert.ErrorIs(t, err, errcode.RecordNotExists)
+	_, err = repo.PatchProject(9999, func(project *model.Project) error { return nil })
+	assert.ErrorIs(t, err, errcode.RecordNotExists)
+	assert.ErrorIs(t, repo.DeleteProject(9999, true), errcode.RecordNotExists)
+}
+
+func testProjectTasks(t *testing.T, repo repository.Repository) {
+	project1, err := repo.CreateProject(&model.Project{Name: "project1"})
+	require.NoError(t, err)
+	project2, err := repo.CreateProject(&model.Project{Name: "project2"})
+	require.NoError(t, err)
+
+	var all []*model.Task
+	for _, projectID := range []uint32{0, project1.ID, project2.ID} {
+		task := newTask("task")
+		task.ProjectID = projectID
+		created, err := repo.CreateTask(task)
+		require.NoError(t, err, "project %d", projectID)
+		assert.Equal(t, projectID, created.ProjectID)
+		all = append(all, created)
+	}
+	task := newTask("task")
+	task.ProjectID = project1.ID
+	_, err = repo.CreateTask(task)
+	assert.ErrorIs(t, err, errcode.DuplicateRecords)
+	task.ProjectID = 9999
+	_, err = repo.CreateTask(task)
+	assert.ErrorIs(t, err, errcode.RecordNotExists)
+
+	for i, projectID := range []uint32{0, project1.ID, project2.ID} {
+		tasks, _, err := repo.GetTasks(&model.TaskQuery{ProjectID: &projectID})
+		assert.NoError(t, err)
+		assert.Equal(t, []*model.Task{all[i]}, tasks)
+	}
+
+	_, err = repo.PatchTask(all[1].ID, func(task *model.Task) error {
+		task.ProjectID = project2.ID
+		return nil
+	})
+	assert.ErrorIs(t, err, errcode.DuplicateRecords)
+	_, err = repo.PatchTask(all[1].ID, func(task *model.Task) error {
+		task.ProjectID = 9999
+		return nil
+	})
+	assert.ErrorIs(t, err, errcode.RecordNotExists)
+	moved, err := repo.PatchTask(all[1].ID, func(task *model.Task) error {
+		task.Name = "moved"
+		task.ProjectID = project2.ID
+		return nil
+	})
+	assert.NoError(t, err)
+	tasks, _, err := repo.GetTasks(&model.TaskQuery{ProjectID: &project2.ID})
+	assert.NoError(t, err)
+	assert.Equal(t, []*model.Task{moved, all[2]}, tasks)
+	tasks, _, err = repo.GetTasks(&model.TaskQuery{ProjectID: &project1.ID})
+	assert.NoError(t, err)
+	assert.Empty(t, tasks)
+}
+
+func testDeleteProject(t *testing.T, repo repository.Repository) {
+	project, err := repo.CreateProject(&model.Project{Name: "project"})
+	require.NoError(t, err)
+	task := newTask("task")
+	task.ProjectID = project.ID
+	task.Tags = []string{"home"}
+	inProject, err := repo.CreateTask(task)
+	require.NoError(t, err)
+	outside, err := repo.CreateTask(newTask("task"))
+	require.NoError(t, err)
+
+	assert.ErrorIs(t, repo.DeleteProject(project.ID, false), errcode.ProjectNotEmpty)
+	_, err = repo.GetProject(project.ID)
+	assert.NoError(t, err)
+
+	assert.NoError(t, repo.DeleteProject(project.ID, true))
+	_, err = repo.GetProject(project.ID)
+	assert.ErrorIs(t, err, errcode.RecordNotExists)
+	_, err = repo.GetTask(inProject.ID)
+	assert.ErrorIs(t, err, errcode.RecordNotExists)
+	tasks, _, err := repo.GetTasks(&model.TaskQuery{})
+	assert.NoError(t, err)
+	assert.Equal(t, []*model.Task{outside}, tasks)
+	tags, err := repo.GetTags()
+	assert.NoError(t, err)
+	assert.Empty(t, tags)
+
+	empty, err := repo.CreateProject(&model.Project{Name: "project"})
+	require.NoError(t, err)
+	assert.NotEqual(t, project.ID, empty.ID)
+	assert.NoError(t, repo.DeleteProject(empty.ID, false))
+}
+
+func testWebhooks(t *testing.T, repo repository.Repository) {
+	webhooks, err := repo.GetWebhooks()
+	assert.NoError(t, err)
+	assert.Empty(t, webhooks)
+
+	createdAt := time.Date(2022, 5, 1, 8, 0, 0, 0, time.UTC)
+	webhook1, err := repo.CreateWebhook(&model.Webhook{
+		URL:       "http://example.com/hook",
+		Events:    []string{model.EventTaskCreated, model.EventTaskDeleted},
+		Secret:    "secret",
+		Active:    true,
+		CreatedAt: createdAt,
+		UpdatedAt: createdAt,
+	})
+	require.NoError(t, err)
+	assert.NotZero(t, webhook1.ID)
+	webhook2, err := repo.CreateWebhook(&model.Webhook{URL: "http://example.com/hook"})
+	require.NoError(t, err)
+	assert.Greater(t, webhook2.ID, webhook1.ID)
+
+	result, err := repo.GetWebhook(webhook1.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, webhook1, result)
+	webhooks, err = repo.GetWebhooks()
+	assert.NoError(t, err)
+	assert.Equal(t, []*model.Webhook{webhook1, webhook2}, webhooks)
+
+	patched, err := repo.PatchWebhook(webhook1.ID, func(webhook *model.Webhook) error {
+		webhook.Events = nil
+		webhook.Active = false
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Nil(t, patched.Events)
+	assert.False(t, patched.Active)
+	assert.Equal(t, webhook1.Secret, patched.Secret)
+	result, err = repo.GetWebhook(webhook1.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, patched, result)
+
+	assert.NoError(t, repo.DeleteWebhook(webhook1.ID))
+	webhooks, err = repo.GetWebhooks()
+	assert.NoError(t, err)
+	assert.Equal(t, []*model.Webhook{webhook2}, webhooks)
+	webhook3, err := repo.CreateWebhook(&model.Webhook{URL: "http://example.com/hook"})
+	require.NoError(t, err)
+	assert.Greater(t, webhook3.ID, webhook2.ID)
+
+	_, err = repo.GetWebhook(webhook1.ID)
+	assert.ErrorIs(t, err, errcode.RecordNotExists)
+	_, err = repo.PatchWebhook(webhook1.ID, func(webhook *model.Webhook) error { return nil })
+	assert.ErrorIs(t, err, errcode.RecordNotExists)
+	assert.ErrorIs(t, repo.DeleteWebhook(webhook1.ID), errcode.RecordNotExists)
+}
+
+func testAPIKeys(t *testing.T, repo repository.Repository) {
+	keys, err := repo.GetAPIKeys()
+	assert.NoError(t, err)
+	assert.Empty(t, keys)
+
+	createdAt := time.Date(2022, 5, 1, 8, 0, 0, 0, time.UTC)
+	key1, err := repo.CreateAPIKey(&model.APIKey{
+		Name:      "ci",
+		Prefix:    "tk_0123",
+		Hash:      "hash1",
+		Scopes:    []string{"tasks:read", "tasks:write"},
+		CreatedAt: createdAt,
+	})
+	require.NoError(t, err)
+	assert.NotZero(t, key1.ID)
+	key2, err := repo.CreateAPIKey(&model.APIKey{Name: "ci", Hash: "hash2", Scopes: []string{"admin"}})
+	require.NoError(t, err)
+	assert.Greater(t, key2.ID, key1.ID)
+	_, err = repo.CreateAPIKey(&model.APIKey{Name: "other", Hash: "hash1", Scopes: []string{"admin"}})
+	assert.ErrorIs(t, err, errcode.DuplicateRecords)
+
+	result, err := repo.GetAPIKeyByHash("hash1")
+	assert.NoError(t, err)
+	assert.Equal(t, key1, result)
+	keys, err = repo.GetAPIKeys()
+	assert.NoError(t, err)
+	assert.Equal(t, []*model.APIKey{key1, key2}, keys)
+
+	assert.NoError(t, repo.DeleteAPIKey(key1.ID))
+	_, err = repo.GetAPIKeyByHash("hash1")
+	assert.ErrorIs(t, err, errcode.RecordNotExists)
+	keys, err = repo.GetAPIKeys()
+	assert.NoError(t, err)
+	assert.Equal(t, []*model.APIKey{key2}, keys)
+	assert.ErrorIs(t, repo.DeleteAPIKey(key1.ID), errcode.RecordNotExists)
+
+	// The hash of a deleted key is free again.
+	key3, err := repo.CreateAPIKey(&model.APIKey{Name: "ci", Hash: "hash1", Scopes: []string{"tasks:read"}})
+	require.NoError(t, err)
+	assert.Greater(t, key3.ID, key2.ID)
+}
+
+func testDeadLetters(t *testing.T, repo repository.Repository) {
+	deliveries, err := repo.GetDeadLetters()
+	assert.NoError(t, err)
+	assert.Empty(t, deliveries)
+
+	at := time.Date(2022, 5, 1, 8, 0, 0, 0, time.UTC)
+	delivery := func(id uint64, webhookID uint32) *model.WebhookDelivery {
+		return &model.WebhookDelivery{
+			ID:        id,
+			WebhookID: webhookID,
+			URL:       "http://example.com/hook",
+			Event: &model.Event{
+				ID:   id,
+				Type: model.EventTaskCreated,
+				Task: &model.Task{ID: 1, Name: "task1", Status: model.StatusTodo, CreatedAt: at, UpdatedAt: at},
+				At:   at,
+			},
+			Status:     model.DeliveryDead,
+			Attempts:   5,
+			StatusCode: 500,
+			Error:      "unexpected status 500",
+			CreatedAt:  at,
+			UpdatedAt:  at.Add(time.Minute),
+		}
+	}
+	delivery3, delivery7, delivery5 := delivery(3, 1), delivery(7, 2), delivery(5, 1)
+	for _, d := range []*model.WebhookDelivery{delivery3, delivery7, delivery5} {
+		require.NoError(t, repo.PutDeadLetter(d))
+	}
+	deliveries, err = repo.GetDeadLetters()
+	assert.NoError(t, err)
+	assert.Equal(t, []*model.WebhookDelivery{delivery7, delivery5, delivery3}, deliveries)
+
+	replaced := delivery(5, 1)
+	replaced.Error = "dispatcher shut down"
+	require.NoError(t, repo.PutDeadLetter(replaced))
+	deliveries, err = repo.GetDeadLetters()
+	assert.NoError(t, err)
+	assert.Equal(t, []*model.WebhookDelivery{delivery7, replaced, delivery3}, deliveries)
+}
+
+func testMonotonicID(t *testing.T, repo repository.Repository) {
+	var last uint32
+	for i := 0; i < 5; i++ {
+		task, err := repo.CreateTask(newTask(fmt.Sprintf("task%d", i)))
+		require.NoError(t, err)
+		assert.Greater(t, task.ID, last)
+		last = task.ID
+		if i%2 == 0 {
+			// IDs of deleted tasks must not be handed out again.
+			require.NoError(t, repo.DeleteTask(task.ID))
+		}
+	}
+}
+
+func testConcurrentCreate(t *testing.T, repo repository.Repository) {
+	const n = 20
+	var wg sync.WaitGroup
+	ids := make(chan uint32, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			task, err := repo.CreateTask(newTask(fmt.Sprintf("task%d", i)))
+			if assert.NoError(t, err) {
+				ids <- task.ID
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(ids)
+	seen := make(map[uint32]bool, n)
+	for id := range ids {
+		assert.False(t, seen[id], "duplicate id %d", id)
+		seen[id] = true
+	}
+	assert.Len(t, seen, n)
+
+	var created int32
+	var mux sync.Mutex
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := repo.CreateTask(newTask("same"))
+			if err == nil {
+				mux.Lock()
+				created++
+				mux.Unlock()
+				return
+			}
+			assert.ErrorIs(t, err, errcode.DuplicateRecords)
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), created)
+}
+
+func update(repo repository.Repository, id uint32, name string, status model.Status) (*model.Task, error) {
+	return repo.PatchTask(id, func(task *model.Task) error {
+		task.Name = name
+		task.Status = status
+		return nil
+	})
+}
+
+func newTask(name string) *model.Task {
+	return &model.Task{Name: name, Status: model.StatusTodo}
+}
@@ -0,0 +1,775 @@
+package repository
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/wagaru/task/internal/errcode"
+	"github.com/wagaru/task/internal/model"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+const taskColumns = `id, project_id, parent_id, name, status, description, priority, due_at, recurrence, created_at, updated_at, created_by, updated_by, completed_at, reminded_at, overdue_at`
+
+const projectColumns = `id, name, description, created_at, updated_at`
+
+const webhookColumns = `id, url, events, secret, active, created_at, updated_at`
+
+const apiKeyColumns = `id, name, prefix, hash, scopes, created_at`
+
+// selectTasks reads taskColumns followed by the task's tags and blockers as
+// sorted JSON arrays.
+const selectTasks = `SELECT ` + taskColumns + `,
+	(SELECT json_group_array(tag) FROM (SELECT tag FROM task_tags WHERE task_id = tasks.id ORDER BY tag)),
+	(SELECT json_group_array(blocker_id) FROM (SELECT blocker_id FROM task_dependencies WHERE task_id = tasks.id ORDER BY blocker_id))
+	FROM tasks`
+
+// sqlTimeLayout is RFC 3339 with a fixed-width fraction, so the stored text
+// sorts chronologically.
+const sqlTimeLayout = "2006-01-02T15:04:05.000000000Z07:00"
+
+// The lower of SQLite only folds ASCII, so names are matched with
+// fold_lower, which folds as strings.ToLower does for the other backends.
+func init() {
+	sqlite.MustRegisterDeterministicScalarFunction("fold_lower", 1, func(_ *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+		switch v := args[0].(type) {
+		case string:
+			return strings.ToLower(v), nil
+		case []byte:
+			return strings.ToLower(string(v)), nil
+		}
+		return args[0], nil
+	})
+}
+
+type sqlRepo struct {
+	db *sql.DB
+}
+
+// NewSQLRepository opens (or creates) the SQLite database at path and
+// upgrades its schema to the latest migration.
+func NewSQLRepository(path string) (Repository, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	dsn := fmt.Sprintf("file:%s?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)", path)
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, err
+	}
+	// SQLite allows a single writer; serialising on one connection avoids
+	// SQLITE_BUSY between our own goroutines.
+	db.SetMaxOpenConns(1)
+	if err := migrate(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &sqlRepo{db: db}, nil
+}
+
+func (s *sqlRepo) GetTasks(query *model.TaskQuery) ([]*model.Task, string, error) {
+	if query == nil {
+		query = &model.TaskQuery{}
+	}
+	after, err := decodeCursor(query)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var where []string
+	var args []interface{}
+	if query.ProjectID != nil {
+		where = append(where, "IFNULL(project_id, 0) = ?")
+		args = append(args, *query.ProjectID)
+	}
+	if query.ParentID != nil {
+		where = append(where, "parent_id = ?")
+		args = append(args, *query.ParentID)
+	}
+	if query.Blocker != nil {
+		where = append(where, "id IN (SELECT task_id FROM task_dependencies WHERE blocker_id = ?)")
+		args = append(args, *query.Blocker)
+	}
+	if query.DueBefore != nil {
+		where = append(where, "due_at < ?")
+		args = append(args, formatTime(*query.DueBefore))
+	}
+	if query.Status != nil {
+		where = append(where, "status = ?")
+		args = append(args, *query.Status)
+	}
+	if query.Name != "" {
+		where = append(where, "instr(fold_lower(name), fold_lower(?)) > 0")
+		args = append(args, query.Name)
+	}
+	if len(query.Tags) > 0 {
+		tagged := "SELECT task_id FROM task_tags WHERE tag IN (" + placeholders(len(query.Tags)) + ")"
+		for _, tag := range query.Tags {
+			args = append(args, tag)
+		}
+		if query.AllTags {
+			tagged += " GROUP BY task_id HAVING COUNT(*) = ?"
+			args = append(args, len(query.Tags))
+		}
+		where = append(where, "id IN ("+tagged+")")
+	}
+	op, direction := ">", "ASC"
+	if query.Desc {
+		op, direction = "<", "DESC"
+	}
+	orderBy := "id " + direction
+	var column string
+	var value interface{}
+	switch sortField(query) {
+	case model.SortByName:
+		column = "name"
+		if after != nil {
+			value = after.Name
+		}
+	case model.SortByCreatedAt:
+		column = "created_at"
+		if after != nil {
+			value = formatTime(*after.CreatedAt)
+		}
+	}
+	if column != "" {
+		orderBy = column + " " + direction + ", " + orderBy
+		if after != nil {
+			where = append(where, fmt.Sprintf("(%[1]s %[2]s ? OR (%[1]s = ? AND id %[2]s ?))", column, op))
+			args = append(args, value, value, after.ID)
+		}
+	} else if after != nil {
+		where = append(where, "id "+op+" ?")
+		args = append(args, after.ID)
+	}
+
+	stmt := selectTasks
+	if len(where) > 0 {
+		stmt += " WHERE " + strings.Join(where, " AND ")
+	}
+	stmt += " ORDER BY " + orderBy
+	if query.Limit > 0 {
+		stmt += " LIMIT ?"
+		args = append(args, query.Limit+1)
+	}
+
+	rows, err := s.db.Query(stmt, args...)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+	tasks := make([]*model.Task, 0)
+	for rows.Next() {
+		task, err := scanTask(rows)
+		if err != nil {
+			return nil, "", err
+		}
+		tasks = append(tasks, task)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", err
+	}
+	if query.Limit > 0 && len(tasks) > query.Limit {
+		tasks = tasks[:query.Limit]
+		return tasks, encodeCursor(query, tasks[len(tasks)-1]), nil
+	}
+	return tasks, "", nil
+}
+
+func (s *sqlRepo) GetTask(id uint32) (*model.Task, error) {
+	row := s.db.QueryRow(selectTasks+` WHERE id = ?`, id)
+	task, err := scanTask(row)
+	return task, sqlError(err)
+}
+
+func (s *sqlRepo) CreateTask(task *model.Task) (*model.Task, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var id uint32
+	err = tx.QueryRow(`INSERT INTO tasks (project_id, parent_id, name, status, description, priority, due_at, recurrence, created_at, updated_at, created_by, updated_by, completed_at, reminded_at, overdue_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?) RETURNING id`, taskValues(task)...).Scan(&id)
+	if err != nil {
+		return nil, sqlError(err)
+	}
+	if err := insertTags(tx, id, task.Tags); err != nil {
+		return nil, err
+	}
+	if err := insertBlockers(tx, id, task.BlockedBy); err != nil {
+		return nil, err
+	}
+	created, err := scanTask(tx.QueryRow(selectTasks+` WHERE id = ?`, id))
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return created, nil
+}
+
+func (s *sqlRepo) PatchTask(id uint32, apply func(task *model.Task) error) (*model.Task, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	task, err := scanTask(tx.QueryRow(selectTasks+` WHERE id = ?`, id))
+	if err != nil {
+		return nil, sqlError(err)
+	}
+	tags, blockers := task.Tags, task.BlockedBy
+	if err := apply(task); err != nil {
+		return nil, err
+	}
+	task.ID = id
+	_, err = tx.Exec(`UPDATE tasks SET project_id = ?, parent_id = ?, name = ?, status = ?, description = ?, priority = ?, due_at = ?, recurrence = ?, created_at = ?, updated_at = ?, created_by = ?, updated_by = ?, completed_at = ?, reminded_at = ?, overdue_at = ?
+		WHERE id = ?`, append(taskValues(task), id)...)
+	if err != nil {
+		return nil, sqlError(err)
+	}
+	if !slices.Equal(tags, task.Tags) {
+		if _, err := tx.Exec(`DELETE FROM task_tags WHERE task_id = ?`, id); err != nil {
+			return nil, err
+		}
+		if err := insertTags(tx, id, task.Tags); err != nil {
+			return nil, err
+		}
+	}
+	if !slices.Equal(blockers, task.BlockedBy) {
+		if _, err := tx.Exec(`DELETE FROM task_dependencies WHERE task_id = ?`, id); err != nil {
+			return nil, err
+		}
+		if err := insertBlockers(tx, id, task.BlockedBy); err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return task, nil
+}
+
+func (s *sqlRepo) DeleteTask(id uint32) error {
+	result, err := s.db.Exec(`DELETE FROM tasks WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return errcode.RecordNotExists
+	}
+	return nil
+}
+
+func (s *sqlRepo) GetTags() ([]*model.Tag, error) {
+	rows, err := s.db.Query(`SELECT tag, COUNT(*) FROM task_tags GROUP BY tag ORDER BY tag`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	tags := make([]*model.Tag, 0)
+	for rows.Next() {
+		var tag model.Tag
+		if err := rows.Scan(&tag.Name, &tag.Count); err != nil {
+			return nil, err
+		}
+		tags = append(tags, &tag)
+	}
+	return tags, rows.Err()
+}
+
+func (s *sqlRepo) Close() error {
+	return s.db.Close()
+}
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanTask(row scanner) (*model.Task, error) {
+	var task model.Task
+	var projectID sql.NullInt64
+	var dueAt, completedAt, remindedAt, overdueAt sql.NullString
+	var createdAt, updatedAt, tags, blockers string
+	err := row.Scan(&task.ID, &projectID, &task.ParentID, &task.Name, &task.Status, &task.Description, &task.Priority,
+		&dueAt, &task.Recurrence, &createdAt, &updatedAt, &task.CreatedBy, &task.UpdatedBy, &completedAt, &remindedAt, &overdueAt, &tags, &blockers)
+	if err != nil {
+		return nil, err
+	}
+	task.ProjectID = uint32(projectID.Int64)
+	if err := json.Unmarshal([]byte(tags), &task.Tags); err != nil {
+		return nil, err
+	}
+	if len(task.Tags) == 0 {
+		task.Tags = nil
+	}
+	if err := json.Unmarshal([]byte(blockers), &task.BlockedBy); err != nil {
+		return nil, err
+	}
+	if len(task.BlockedBy) == 0 {
+		task.BlockedBy = nil
+	}
+	if task.DueAt, err = parseNullTime(dueAt); err != nil {
+		return nil, err
+	}
+	if task.CompletedAt, err = parseNullTime(completedAt); err != nil {
+		return nil, err
+	}
+	if task.RemindedAt, err = parseNullTime(remindedAt); err != nil {
+		return nil, err
+	}
+	if task.OverdueAt, err = parseNullTime(overdueAt); err != nil {
+		return nil, err
+	}
+	if task.CreatedAt, err = parseTime(createdAt); err != nil {
+		return nil, err
+	}
+	if task.UpdatedAt, err = parseTime(updatedAt); err != nil {
+		return nil, err
+	}
+	return &task, nil
+}
+
+// taskValues lists every column but id, in taskColumns order.
+func taskValues(task *model.Task) []interface{} {
+	var projectID interface{}
+	if task.ProjectID != 0 {
+		projectID = task.ProjectID
+	}
+	return []interface{}{
+		projectID,
+		task.ParentID,
+		task.Name,
+		task.Status,
+		task.Description,
+		task.Priority,
+		formatNullTime(task.DueAt),
+		task.Recurrence,
+		formatTime(task.CreatedAt),
+		formatTime(task.UpdatedAt),
+		task.CreatedBy,
+		task.UpdatedBy,
+		formatNullTime(task.CompletedAt),
+		formatNullTime(task.RemindedAt),
+		formatNullTime(task.OverdueAt),
+	}
+}
+
+func (s *sqlRepo) GetProjects() ([]*model.Project, error) {
+	rows, err := s.db.Query(`SELECT ` + projectColumns + ` FROM projects ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	projects := make([]*model.Project, 0)
+	for rows.Next() {
+		project, err := scanProject(rows)
+		if err != nil {
+			return nil, err
+		}
+		projects = append(projects, project)
+	}
+	return projects, rows.Err()
+}
+
+func (s *sqlRepo) GetProject(id uint32) (*model.Project, error) {
+	project, err := scanProject(s.db.QueryRow(`SELECT `+projectColumns+` FROM projects WHERE id = ?`, id))
+	return project, sqlError(err)
+}
+
+func (s *sqlRepo) CreateProject(project *model.Project) (*model.Project, error) {
+	row := s.db.QueryRow(`INSERT INTO projects (name, description, created_at, updated_at)
+		VALUES (?, ?, ?, ?) RETURNING `+projectColumns, projectValues(project)...)
+	created, err := scanProject(row)
+	return created, sqlError(err)
+}
+
+func (s *sqlRepo) PatchProject(id uint32, apply func(project *model.Project) error) (*model.Project, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	project, err := scanProject(tx.QueryRow(`SELECT `+projectColumns+` FROM projects WHERE id = ?`, id))
+	if err != nil {
+		return nil, sqlError(err)
+	}
+	if err := apply(project); err != nil {
+		return nil, err
+	}
+	project.ID = id
+	_, err = tx.Exec(`UPDATE projects SET name = ?, description = ?, created_at = ?, updated_at = ?
+		WHERE id = ?`, append(projectValues(project), id)...)
+	if err != nil {
+		return nil, sqlError(err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return project, nil
+}
+
+func (s *sqlRepo) DeleteProject(id uint32, cascade bool) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	var tasks int
+	err = tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM projects WHERE id = ?), (SELECT COUNT(*) FROM tasks WHERE project_id = ?)`, id, id).
+		Scan(&exists, &tasks)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return errcode.RecordNotExists
+	}
+	if tasks > 0 && !cascade {
+		return errcode.ProjectNotEmpty
+	}
+	if _, err := tx.Exec(`DELETE FROM tasks WHERE project_id = ?`, id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM projects WHERE id = ?`, id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func scanProject(row scanner) (*model.Project, error) {
+	var project model.Project
+	var createdAt, updatedAt string
+	if err := row.Scan(&project.ID, &project.Name, &project.Description, &createdAt, &updatedAt); err != nil {
+		return nil, err
+	}
+	var err error
+	if project.CreatedAt, err = parseTime(createdAt); err != nil {
+		return nil, err
+	}
+	if project.UpdatedAt, err = parseTime(updatedAt); err != nil {
+		return nil, err
+	}
+	return &project, nil
+}
+
+// projectValues lists every column but id, in projectColumns order.
+func projectValues(project *model.Project) []interface{} {
+	return []interface{}{
+		project.Name,
+		project.Description,
+		formatTime(project.CreatedAt),
+		formatTime(project.UpdatedAt),
+	}
+}
+
+func (s *sqlRepo) GetWebhooks() ([]*model.Webhook, error) {
+	rows, err := s.db.Query(`SELECT ` + webhookColumns + ` FROM webhooks ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	webhooks := make([]*model.Webhook, 0)
+	for rows.Next() {
+		webhook, err := scanWebhook(rows)
+		if err != nil {
+			return nil, err
+		}
+		webhooks = append(webhooks, webhook)
+	}
+	return webhooks, rows.Err()
+}
+
+func (s *sqlRepo) GetWebhook(id uint32) (*model.Webhook, error) {
+	webhook, err := scanWebhook(s.db.QueryRow(`SELECT `+webhookColumns+` FROM webhooks WHERE id = ?`, id))
+	return webhook, sqlError(err)
+}
+
+func (s *sqlRepo) CreateWebhook(webhook *model.Webhook) (*model.Webhook, error) {
+	values, err := webhookValues(webhook)
+	if err != nil {
+		return nil, err
+	}
+	row := s.db.QueryRow(`INSERT INTO webhooks (url, events, secret, active, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?) RETURNING `+webhookColumns, values...)
+	created, err := scanWebhook(row)
+	return created, sqlError(err)
+}
+
+func (s *sqlRepo) PatchWebhook(id uint32, apply func(webhook *model.Webhook) error) (*model.Webhook, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	webhook, err := scanWebhook(tx.QueryRow(`SELECT `+webhookColumns+` FROM webhooks WHERE id = ?`, id))
+	if err != nil {
+		return nil, sqlError(err)
+	}
+	if err := apply(webhook); err != nil {
+		return nil, err
+	}
+	webhook.ID = id
+	values, err := webhookValues(webhook)
+	if err != nil {
+		return nil, err
+	}
+	_, err = tx.Exec(`UPDATE webhooks SET url = ?, events = ?, secret = ?, active = ?, created_at = ?, updated_at = ?
+		WHERE id = ?`, append(values, id)...)
+	if err != nil {
+		return nil, sqlError(err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return webhook, nil
+}
+
+func (s *sqlRepo) DeleteWebhook(id uint32) error {
+	result, err := s.db.Exec(`DELETE FROM webhooks WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return errcode.RecordNotExists
+	}
+	return nil
+}
+
+func scanWebhook(row scanner) (*model.Webhook, error) {
+	var webhook model.Webhook
+	var events, createdAt, updatedAt string
+	if err := row.Scan(&webhook.ID, &webhook.URL, &events, &webhook.Secret, &webhook.Active, &createdAt, &updatedAt); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(events), &webhook.Events); err != nil {
+		return nil, err
+	}
+	if len(webhook.Events) == 0 {
+		webhook.Events = nil
+	}
+	var err error
+	if webhook.CreatedAt, err = parseTime(createdAt); err != nil {
+		return nil, err
+	}
+	if webhook.UpdatedAt, err = parseTime(updatedAt); err != nil {
+		return nil, err
+	}
+	return &webhook, nil
+}
+
+// webhookValues lists every column but id, in webhookColumns order.
+func webhookValues(webhook *model.Webhook) ([]interface{}, error) {
+	events := webhook.Events
+	if events == nil {
+		events = []string{}
+	}
+	b, err := json.Marshal(events)
+	if err != nil {
+		return nil, err
+	}
+	return []interface{}{
+		webhook.URL,
+		string(b),
+		webhook.Secret,
+		webhook.Active,
+		formatTime(webhook.CreatedAt),
+		formatTime(webhook.UpdatedAt),
+	}, nil
+}
+
+func (s *sqlRepo) GetDeadLetters() ([]*model.WebhookDelivery, error) {
+	rows, err := s.db.Query(`SELECT delivery FROM dead_letters ORDER BY id DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	deliveries := make([]*model.WebhookDelivery, 0)
+	for rows.Next() {
+		var b string
+		if err := rows.Scan(&b); err != nil {
+			return nil, err
+		}
+		var delivery model.WebhookDelivery
+		if err := json.Unmarshal([]byte(b), &delivery); err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, &delivery)
+	}
+	return deliveries, rows.Err()
+}
+
+func (s *sqlRepo) PutDeadLetter(delivery *model.WebhookDelivery) error {
+	b, err := json.Marshal(delivery)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(`INSERT OR REPLACE INTO dead_letters (id, webhook_id, delivery) VALUES (?, ?, ?)`,
+		delivery.ID, delivery.WebhookID, string(b))
+	return err
+}
+
+func (s *sqlRepo) GetAPIKeys() ([]*model.APIKey, error) {
+	rows, err := s.db.Query(`SELECT ` + apiKeyColumns + ` FROM api_keys ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	keys := make([]*model.APIKey, 0)
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+func (s *sqlRepo) GetAPIKeyByHash(hash string) (*model.APIKey, error) {
+	key, err := scanAPIKey(s.db.QueryRow(`SELECT `+apiKeyColumns+` FROM api_keys WHERE hash = ?`, hash))
+	return key, sqlError(err)
+}
+
+func (s *sqlRepo) CreateAPIKey(key *model.APIKey) (*model.APIKey, error) {
+	scopes, err := json.Marshal(key.Scopes)
+	if err != nil {
+		return nil, err
+	}
+	row := s.db.QueryRow(`INSERT INTO api_keys (name, prefix, hash, scopes, created_at)
+		VALUES (?, ?, ?, ?, ?) RETURNING `+apiKeyColumns,
+		key.Name, key.Prefix, key.Hash, string(scopes), formatTime(key.CreatedAt))
+	created, err := scanAPIKey(row)
+	return created, sqlError(err)
+}
+
+func (s *sqlRepo) DeleteAPIKey(id uint32) error {
+	result, err := s.db.Exec(`DELETE FROM api_keys WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return errcode.RecordNotExists
+	}
+	return nil
+}
+
+func scanAPIKey(row scanner) (*model.APIKey, error) {
+	var key model.APIKey
+	var scopes, createdAt string
+	if err := row.Scan(&key.ID, &key.Name, &key.Prefix, &key.Hash, &scopes, &createdAt); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(scopes), &key.Scopes); err != nil {
+		return nil, err
+	}
+	var err error
+	if key.CreatedAt, err = parseTime(createdAt); err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+func insertTags(tx *sql.Tx, id uint32, tags []string) error {
+	for _, tag := range tags {
+		if _, err := tx.Exec(`INSERT INTO task_tags (task_id, tag) VALUES (?, ?)`, id, tag); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func insertBlockers(tx *sql.Tx, id uint32, blockers []uint32) error {
+	for _, blocker := range blockers {
+		if _, err := tx.Exec(`INSERT INTO task_dependencies (task_id, blocker_id) VALUES (?, ?)`, id, blocker); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(sqlTimeLayout)
+}
+
+func formatNullTime(t *time.Time) interface{} {
+	if t == nil {
+		return nil
+	}
+	return formatTime(*t)
+}
+
+func parseTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339Nano, s)
+}
+
+func parseNullTime(s sql.NullString) (*time.Time, error) {
+	if !s.Valid {
+		return nil, nil
+	}
+	t, err := parseTime(s.String)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// sqlError maps driver errors onto the errcode values the other backends
+// return.
+func sqlError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		return errcode.RecordNotExists
+	}
+	var serr *sqlite.Error
+	if errors.As(err, &serr) {
+		switch serr.Code() {
+		case sqlite3.SQLITE_CONSTRAINT_UNIQUE:
+			return errcode.DuplicateRecords
+		case sqlite3.SQLITE_CONSTRAINT_FOREIGNKEY:
+			// A task referring to a project that does not exist.
+			return errcode.RecordNotExists
+		}
+	}
+	return err
+}
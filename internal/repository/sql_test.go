@@ -0,0 +1,109 @@
+package repository
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wagaru/task/internal/errcode"
+	"github.com/wagaru/task/internal/model"
+)
+
+func TestSQLRepositoryMigrate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "task.db")
+	repo, err := NewSQLRepository(path)
+	require.NoError(t, err)
+	_, err = repo.CreateTask(newTask("task1"))
+	require.NoError(t, err)
+	require.NoError(t, repo.Close())
+
+	repo, err = NewSQLRepository(path)
+	require.NoError(t, err)
+	defer repo.Close()
+
+	migrations, err := loadMigrations()
+	require.NoError(t, err)
+	var version, count int
+	db := repo.(*sqlRepo).db
+	require.NoError(t, db.QueryRow(`SELECT MAX(version), COUNT(*) FROM schema_migrations`).Scan(&version, &count))
+	assert.Equal(t, migrations[len(migrations)-1].version, version)
+	assert.Equal(t, len(migrations), count)
+
+	tasks, _, err := repo.GetTasks(&model.TaskQuery{})
+	assert.NoError(t, err)
+	assert.Equal(t, []*model.Task{{ID: 1, Name: "task1", Status: model.StatusTodo}}, tasks)
+}
+
+func TestSQLRepositoryMigrateStatus(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "task.db")
+	db, err := sql.Open("sqlite", path)
+	require.NoError(t, err)
+	_, err = db.Exec(`CREATE TABLE schema_migrations (version INTEGER PRIMARY KEY, applied_at TIMESTAMP NOT NULL)`)
+	require.NoError(t, err)
+	migrations, err := loadMigrations()
+	require.NoError(t, err)
+	for _, m := range migrations[:2] {
+		require.NoError(t, applyMigration(db, m))
+	}
+	_, err = db.Exec(`INSERT INTO tasks (name, status) VALUES ('task1', 0), ('task2', 1), ('task3', 0)`)
+	require.NoError(t, err)
+	_, err = db.Exec(`DELETE FROM tasks WHERE name = 'task3'`)
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	repo, err := NewSQLRepository(path)
+	require.NoError(t, err)
+	defer repo.Close()
+
+	tasks, _, err := repo.GetTasks(&model.TaskQuery{})
+	assert.NoError(t, err)
+	assert.Equal(t, []*model.Task{
+		{ID: 1, Name: "task1", Status: model.StatusTodo},
+		{ID: 2, Name: "task2", Status: model.StatusDone},
+	}, tasks)
+	task, err := repo.CreateTask(newTask("task4"))
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(4), task.ID)
+}
+
+func TestSQLRepositoryErrors(t *testing.T) {
+	repo, err := NewSQLRepository(filepath.Join(t.TempDir(), "task.db"))
+	require.NoError(t, err)
+	defer repo.Close()
+
+	task1, err := repo.CreateTask(newTask("task1"))
+	require.NoError(t, err)
+	task2, err := repo.CreateTask(newTask("task2"))
+	require.NoError(t, err)
+
+	t.Run("DuplicateRecords", func(t *testing.T) {
+		result, err := repo.CreateTask(newTask(task1.Name))
+		assert.Nil(t, result)
+		assert.ErrorIs(t, err, errcode.DuplicateRecords)
+
+		result, err = updateTask(repo, task2.ID, task1.Name, model.StatusTodo)
+		assert.Nil(t, result)
+		assert.ErrorIs(t, err, errcode.DuplicateRecords)
+	})
+	t.Run("RecordNotExists", func(t *testing.T) {
+		result, err := repo.GetTask(9999)
+		assert.Nil(t, result)
+		assert.ErrorIs(t, err, errcode.RecordNotExists)
+
+		result, err = updateTask(repo, 9999, "task", model.StatusTodo)
+		assert.Nil(t, result)
+		assert.ErrorIs(t, err, errcode.RecordNotExists)
+
+		assert.ErrorIs(t, repo.DeleteTask(9999), errcode.RecordNotExists)
+	})
+	t.Run("Success", func(t *testing.T) {
+		result, err := updateTask(repo, task1.ID, "task1_rename", model.StatusDone)
+		assert.NoError(t, err)
+		assert.Equal(t, &model.Task{ID: task1.ID, Name: "task1_rename", Status: model.StatusDone}, result)
+		assert.NoError(t, repo.DeleteTask(task2.ID))
+		_, err = repo.GetTask(task2.ID)
+		assert.ErrorIs(t, err, errcode.RecordNotExists)
+	})
+}
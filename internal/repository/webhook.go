@@ -0,0 +1,108 @@
+package repository
+
+import (
+	"sort"
+
+	"github.com/wagaru/task/internal/errcode"
+	"github.com/wagaru/task/internal/model"
+)
+
+func (in *inMem) GetWebhooks() ([]*model.Webhook, error) {
+	in.mux.RLock()
+	defer in.mux.RUnlock()
+	webhooks := make([]*model.Webhook, 0, len(in.webhooks))
+	for _, webhook := range in.webhooks {
+		webhooks = append(webhooks, webhook)
+	}
+	sort.Slice(webhooks, func(i, j int) bool {
+		return webhooks[i].ID < webhooks[j].ID
+	})
+	return webhooks, nil
+}
+
+func (in *inMem) GetWebhook(id uint32) (*model.Webhook, error) {
+	in.mux.RLock()
+	defer in.mux.RUnlock()
+	webhook, ok := in.webhooks[id]
+	if !ok {
+		return nil, errcode.RecordNotExists
+	}
+	return webhook, nil
+}
+
+func (in *inMem) CreateWebhook(webhook *model.Webhook) (*model.Webhook, error) {
+	in.mux.Lock()
+	defer in.mux.Unlock()
+
+	created := *webhook
+	created.ID = in.wid + 1
+	if err := in.log(&record{Op: opPutWebhook, Webhook: &created}); err != nil {
+		return nil, err
+	}
+
+	in.wid = created.ID
+	in.webhooks[created.ID] = &created
+	return &created, nil
+}
+
+func (in *inMem) PatchWebhook(id uint32, apply func(webhook *model.Webhook) error) (*model.Webhook, error) {
+	in.mux.Lock()
+	defer in.mux.Unlock()
+
+	webhook, ok := in.webhooks[id]
+	if !ok {
+		return nil, errcode.RecordNotExists
+	}
+	updated := *webhook
+	if err := apply(&updated); err != nil {
+		return nil, err
+	}
+	updated.ID = id
+	if err := in.log(&record{Op: opPutWebhook, Webhook: &updated}); err != nil {
+		return nil, err
+	}
+
+	in.webhooks[id] = &updated
+	return &updated, nil
+}
+
+func (in *inMem) DeleteWebhook(id uint32) error {
+	in.mux.Lock()
+	defer in.mux.Unlock()
+
+	if _, ok := in.webhooks[id]; !ok {
+		return errcode.RecordNotExists
+	}
+	if err := in.log(&record{Op: opDeleteWebhook, ID: id}); err != nil {
+		return err
+	}
+
+	delete(in.webhooks, id)
+	return nil
+}
+
+func (in *inMem) GetDeadLetters() ([]*model.WebhookDelivery, error) {
+	in.mux.RLock()
+	defer in.mux.RUnlock()
+	deliveries := make([]*model.WebhookDelivery, 0, len(in.deadLetters))
+	for _, delivery := range in.deadLetters {
+		deliveries = append(deliveries, delivery)
+	}
+	sort.Slice(deliveries, func(i, j int) bool {
+		return deliveries[i].ID > deliveries[j].ID
+	})
+	return deliveries, nil
+}
+
+func (in *inMem) PutDeadLetter(delivery *model.WebhookDelivery) error {
+	in.mux.Lock()
+	defer in.mux.Unlock()
+
+	stored := *delivery
+	if err := in.log(&record{Op: opPutDeadLetter, Delivery: &stored}); err != nil {
+		return err
+	}
+
+	in.deadLetters[stored.ID] = &stored
+	return nil
+}
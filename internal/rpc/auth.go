@@ -0,0 +1,100 @@
+package rpc
+
+import (
+	"context"
+	"strings"
+
+	"github.com/wagaru/task/internal/auth"
+	"github.com/wagaru/task/internal/errcode"
+	"github.com/wagaru/task/internal/rpc/taskpb"
+	"github.com/wagaru/task/internal/service"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// apiKeyMetadata is the X-API-Key header of the REST API as gRPC metadata,
+// whose keys are lower case.
+const apiKeyMetadata = "x-api-key"
+
+// methodScopes maps each method to the scope it requires, like the routes
+// of the REST API.
+var methodScopes = map[string]string{
+	taskpb.TaskService_ListTasks_FullMethodName:  auth.ScopeTasksRead,
+	taskpb.TaskService_GetTask_FullMethodName:    auth.ScopeTasksRead,
+	taskpb.TaskService_CreateTask_FullMethodName: auth.ScopeTasksWrite,
+	taskpb.TaskService_UpdateTask_FullMethodName: auth.ScopeTasksWrite,
+	taskpb.TaskService_PatchTask_FullMethodName:  auth.ScopeTasksWrite,
+	taskpb.TaskService_DeleteTask_FullMethodName: auth.ScopeTasksWrite,
+	taskpb.TaskService_WatchTasks_FullMethodName: auth.ScopeTasksRead,
+}
+
+func (s *Server) unaryAuth(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	ctx, err := s.authorize(ctx, info.FullMethod)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return handler(ctx, req)
+}
+
+func (s *Server) streamAuth(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, err := s.authorize(stream.Context(), info.FullMethod)
+	if err != nil {
+		return toStatus(err)
+	}
+	return handler(srv, &authStream{ServerStream: stream, ctx: ctx})
+}
+
+// authorize lets a call through when its metadata carries an API key, or a
+// JWT of the identity provider, with the scope of method, and returns ctx
+// with the caller on it. Methods without a scope are refused.
+func (s *Server) authorize(ctx context.Context, method string) (context.Context, error) {
+	scope, ok := methodScopes[method]
+	if !ok {
+		return nil, errcode.Forbidden
+	}
+	token := metadataToken(ctx)
+	if token == "" {
+		return nil, errcode.Unauthorized.WithDetails("authorization: 需要 Bearer 權杖或 " + apiKeyMetadata + " metadata")
+	}
+	var principal *auth.Principal
+	var err error
+	if s.jwt == nil || strings.HasPrefix(token, service.APIKeyPrefix) {
+		principal, err = s.svc.Authenticate(token)
+	} else {
+		principal, err = s.jwt.Verify(ctx, token)
+	}
+	if err != nil {
+		return nil, err
+	}
+	ctx = auth.NewContext(ctx, principal)
+	if err := auth.Check(ctx, scope); err != nil {
+		return nil, err
+	}
+	return ctx, nil
+}
+
+func metadataToken(ctx context.Context) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if values := md.Get(apiKeyMetadata); len(values) > 0 && values[0] != "" {
+		return values[0]
+	}
+	values := md.Get("authorization")
+	if len(values) == 0 {
+		return ""
+	}
+	scheme, token, ok := strings.Cut(values[0], " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return ""
+	}
+	return strings.TrimSpace(token)
+}
+
+// authStream is a stream whose context carries the caller.
+type authStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *authStream) Context() context.Context {
+	return s.ctx
+}
@@ -0,0 +1,89 @@
+package rpc
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"github.com/wagaru/task/config"
+	"github.com/wagaru/task/internal/auth"
+	"github.com/wagaru/task/internal/errcode"
+	"github.com/wagaru/task/internal/event"
+	"github.com/wagaru/task/internal/model"
+	"github.com/wagaru/task/internal/rpc/taskpb"
+	"github.com/wagaru/task/internal/service/mocks"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestAuth(t *testing.T) {
+	reader := &auth.Principal{Subject: "apikey:1", Scopes: []string{auth.ScopeTasksRead}}
+	writer := &auth.Principal{Subject: "apikey:2", Scopes: []string{auth.ScopeTasksWrite}}
+	task := &model.Task{ID: 1, Name: "task1", Status: model.StatusTodo}
+	withKey := func(key string) context.Context {
+		return metadata.AppendToOutgoingContext(context.Background(), "x-api-key", key)
+	}
+	newClient := func(mockService *mocks.Service) taskpb.TaskServiceClient {
+		mockService.On("Authenticate", "tk_reader").Return(reader, nil)
+		mockService.On("Authenticate", "tk_writer").Return(writer, nil)
+		mockService.On("Authenticate", "tk_revoked").Return(nil, errcode.Unauthorized)
+		return dial(t, NewServer(mockService, &config.GRPCConfig{}, WithAuth(&config.AuthConfig{Enabled: true})))
+	}
+
+	t.Run("Errors", func(t *testing.T) {
+		mockService := new(mocks.Service)
+		client := newClient(mockService)
+		req := &taskpb.CreateTaskRequest{Name: "task1"}
+
+		_, err := client.CreateTask(context.Background(), req)
+		assertError(t, err, errcode.Unauthorized.WithDetails("authorization: 需要 Bearer 權杖或 x-api-key metadata"))
+		assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+		_, err = client.CreateTask(withKey("tk_revoked"), req)
+		assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+		_, err = client.CreateTask(withKey("tk_reader"), req)
+		assertError(t, err, errcode.Forbidden.WithDetails("需要 tasks:write 權限"))
+		mockService.AssertNotCalled(t, "CreateTask", mock.Anything, mock.Anything)
+	})
+	t.Run("Allowed", func(t *testing.T) {
+		mockService := new(mocks.Service)
+		byWriter := mock.MatchedBy(func(ctx context.Context) bool {
+			p, ok := auth.FromContext(ctx)
+			return ok && p.Subject == writer.Subject
+		})
+		mockService.On("CreateTask", byWriter, &model.Task{Name: "task1"}).Return(task, nil).Once()
+		mockService.On("GetTask", uint32(1)).Return(task, nil).Once()
+		client := newClient(mockService)
+
+		ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer tk_writer")
+		_, err := client.CreateTask(ctx, &taskpb.CreateTaskRequest{Name: "task1"})
+		assert.NoError(t, err)
+		_, err = client.GetTask(withKey("tk_reader"), &taskpb.GetTaskRequest{Id: 1})
+		assert.NoError(t, err)
+		mockService.AssertNumberOfCalls(t, "CreateTask", 1)
+		mockService.AssertNumberOfCalls(t, "GetTask", 1)
+	})
+	t.Run("Stream", func(t *testing.T) {
+		bus := event.NewBus(10)
+		bus.Publish(&model.Event{Type: model.EventTaskCreated, Task: task})
+		mockService := new(mocks.Service)
+		mockService.On("SubscribeEvents", uint64(0)).Return(bus.Subscribe(0)).Once()
+		client := newClient(mockService)
+
+		stream, err := client.WatchTasks(context.Background(), &taskpb.WatchTasksRequest{})
+		require.NoError(t, err)
+		_, err = stream.Recv()
+		assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+		stream, err = client.WatchTasks(withKey("tk_reader"), &taskpb.WatchTasksRequest{})
+		require.NoError(t, err)
+		e, err := stream.Recv()
+		require.NoError(t, err)
+		assert.Equal(t, uint32(1), e.Task.Id)
+		mockService.AssertNumberOfCalls(t, "SubscribeEvents", 1)
+	})
+}
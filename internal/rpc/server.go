@@ -0,0 +1,124 @@
+// Package rpc serves the task service over gRPC, next to the REST API of
+// package delivery.
+package rpc
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+
+	"github.com/wagaru/task/config"
+	"github.com/wagaru/task/internal/auth"
+	"github.com/wagaru/task/internal/errcode"
+	"github.com/wagaru/task/internal/rpc/taskpb"
+	"github.com/wagaru/task/internal/service"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+type Server struct {
+	taskpb.UnimplementedTaskServiceServer
+
+	svc    service.Service
+	config *config.GRPCConfig
+	server *grpc.Server
+	// done is closed when the server starts shutting down, to end the
+	// WatchTasks streams GracefulStop would otherwise wait for.
+	done     chan struct{}
+	doneOnce sync.Once
+
+	authRequired bool
+	jwt          *auth.JWTVerifier
+}
+
+type Option func(*Server)
+
+// WithAuth has every call require an API key when conf enables it, as the
+// REST API does.
+func WithAuth(conf *config.AuthConfig) Option {
+	return func(s *Server) { s.authRequired = conf != nil && conf.Enabled }
+}
+
+// WithJWT has calls accept the bearer tokens verifier accepts besides API
+// keys. A nil verifier leaves JWTs off.
+func WithJWT(verifier *auth.JWTVerifier) Option {
+	return func(s *Server) { s.jwt = verifier }
+}
+
+func NewServer(svc service.Service, conf *config.GRPCConfig, opts ...Option) *Server {
+	if conf == nil {
+		conf = &config.GRPCConfig{}
+	}
+	s := &Server{
+		svc:    svc,
+		config: conf,
+		done:   make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	var serverOpts []grpc.ServerOption
+	if s.authRequired {
+		serverOpts = append(serverOpts, grpc.UnaryInterceptor(s.unaryAuth), grpc.StreamInterceptor(s.streamAuth))
+	}
+	s.server = grpc.NewServer(serverOpts...)
+	taskpb.RegisterTaskServiceServer(s.server, s)
+	return s
+}
+
+// Run listens on the configured port. It returns nil at once when no port
+// is configured.
+func (s *Server) Run() error {
+	if s.config.Port == "" {
+		return nil
+	}
+	lis, err := net.Listen("tcp", fmt.Sprintf(":%s", s.config.Port))
+	if err != nil {
+		return err
+	}
+	log.Printf("start the gRPC server in %s", lis.Addr())
+	return s.Serve(lis)
+}
+
+func (s *Server) Serve(lis net.Listener) error {
+	return s.server.Serve(lis)
+}
+
+// Shutdown ends the open streams and waits for the other calls to finish,
+// or stops the server outright once ctx is done.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.doneOnce.Do(func() { close(s.done) })
+	stopped := make(chan struct{})
+	go func() {
+		s.server.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		return nil
+	case <-ctx.Done():
+		s.server.Stop()
+		return ctx.Err()
+	}
+}
+
+// toStatus converts err into a gRPC status carrying the same payload the
+// REST handlers answer with.
+func toStatus(err error) error {
+	e, ok := err.(*errcode.Error)
+	if !ok {
+		e = errcode.UnknownError
+	}
+	st := status.New(e.GRPCCode(), e.Message())
+	detailed, derr := st.WithDetails(&taskpb.Error{
+		Code:    int32(e.Code()),
+		Message: e.Message(),
+		Details: e.Details(),
+	})
+	if derr != nil {
+		return st.Err()
+	}
+	return detailed.Err()
+}
@@ -0,0 +1,357 @@
+package rpc
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"github.com/wagaru/task/config"
+	"github.com/wagaru/task/internal/errcode"
+	"github.com/wagaru/task/internal/event"
+	"github.com/wagaru/task/internal/model"
+	"github.com/wagaru/task/internal/rpc/taskpb"
+	"github.com/wagaru/task/internal/service/mocks"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// dial serves s over an in-memory listener and returns a client of it.
+func dial(t *testing.T, s *Server) taskpb.TaskServiceClient {
+	lis := bufconn.Listen(1 << 20)
+	go s.Serve(lis)
+	t.Cleanup(func() { s.server.Stop() })
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return taskpb.NewTaskServiceClient(conn)
+}
+
+// assertError checks the gRPC code and the Error detail of err.
+func assertError(t *testing.T, err error, e *errcode.Error) {
+	st, ok := status.FromError(err)
+	require.True(t, ok, err)
+	assert.Equal(t, e.GRPCCode(), st.Code())
+	assert.Equal(t, e.Message(), st.Message())
+	require.Len(t, st.Details(), 1)
+	assert.True(t, proto.Equal(&taskpb.Error{
+		Code:    int32(e.Code()),
+		Message: e.Message(),
+		Details: e.Details(),
+	}, st.Details()[0].(*taskpb.Error)), st.Details()[0])
+}
+
+func TestTasks(t *testing.T) {
+	ctx := context.Background()
+	dueAt := time.Date(2022, 5, 1, 0, 0, 0, 0, time.UTC)
+	now := time.Date(2022, 4, 1, 0, 0, 0, 0, time.UTC)
+	task := &model.Task{
+		ID:        1,
+		ProjectID: 2,
+		Name:      "task1",
+		Status:    model.StatusInProgress,
+		Priority:  3,
+		DueAt:     &dueAt,
+		Tags:      []string{"home"},
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	pb := &taskpb.Task{
+		Id:        1,
+		ProjectId: 2,
+		Name:      "task1",
+		Status:    taskpb.Status_STATUS_IN_PROGRESS,
+		Priority:  3,
+		DueAt:     timestamppb.New(dueAt),
+		Tags:      []string{"home"},
+		CreatedAt: timestamppb.New(now),
+		UpdatedAt: timestamppb.New(now),
+	}
+	t.Run("ListTasks", func(t *testing.T) {
+		projectID := uint32(2)
+		status := model.StatusInProgress
+		mockService := new(mocks.Service)
+		mockService.On("GetTasks", &model.TaskQuery{
+			ProjectID: &projectID,
+			Status:    &status,
+			Tags:      []string{"home"},
+			Sort:      model.SortByName,
+			Desc:      true,
+			Limit:     defaultTasksLimit,
+		}).Return([]*model.Task{task}, "next", nil).Once()
+		client := dial(t, NewServer(mockService, &config.GRPCConfig{}))
+
+		resp, err := client.ListTasks(ctx, &taskpb.ListTasksRequest{
+			ProjectId: &projectID,
+			Status:    taskpb.Status_STATUS_IN_PROGRESS,
+			Tags:      []string{"Home"},
+			Sort:      "name:desc",
+		})
+		require.NoError(t, err)
+		assert.True(t, proto.Equal(&taskpb.ListTasksResponse{Tasks: []*taskpb.Task{pb}, NextCursor: "next"}, resp), resp)
+		mockService.AssertExpectations(t)
+	})
+	t.Run("ListTasksInvalidParams", func(t *testing.T) {
+		mockService := new(mocks.Service)
+		client := dial(t, NewServer(mockService, &config.GRPCConfig{}))
+		for _, req := range []*taskpb.ListTasksRequest{
+			{Limit: 1001},
+			{Sort: "priority"},
+			{Sort: "name:up"},
+			{Status: taskpb.Status(9)},
+		} {
+			_, err := client.ListTasks(ctx, req)
+			assert.Equal(t, codes.InvalidArgument, status.Code(err), req)
+		}
+		mockService.AssertNotCalled(t, "GetTasks", mock.Anything)
+	})
+	t.Run("GetTask", func(t *testing.T) {
+		mockService := new(mocks.Service)
+		mockService.On("GetTask", uint32(1)).Return(task, nil).Once()
+		mockService.On("GetTask", uint32(2)).Return(nil, errcode.RecordNotExists).Once()
+		client := dial(t, NewServer(mockService, &config.GRPCConfig{}))
+
+		resp, err := client.GetTask(ctx, &taskpb.GetTaskRequest{Id: 1})
+		require.NoError(t, err)
+		assert.True(t, proto.Equal(pb, resp), resp)
+
+		_, err = client.GetTask(ctx, &taskpb.GetTaskRequest{Id: 2})
+		assertError(t, err, errcode.RecordNotExists)
+
+		_, err = client.GetTask(ctx, &taskpb.GetTaskRequest{})
+		assertError(t, err, errcode.InvalidParams.WithDetails("id: 必須是 1 到 4294967295 之間的整數"))
+		mockService.AssertExpectations(t)
+	})
+	t.Run("CreateTask", func(t *testing.T) {
+		mockService := new(mocks.Service)
+		mockService.On("CreateTask", mock.Anything, &model.Task{
+			ProjectID: 2,
+			Name:      "task1",
+			Priority:  3,
+			DueAt:     &dueAt,
+			Tags:      []string{"home"},
+			BlockedBy: []uint32{5},
+		}).Return(task, nil).Once()
+		client := dial(t, NewServer(mockService, &config.GRPCConfig{}))
+
+		resp, err := client.CreateTask(ctx, &taskpb.CreateTaskRequest{
+			ProjectId: 2,
+			Name:      "task1",
+			Priority:  3,
+			DueAt:     timestamppb.New(dueAt),
+			Tags:      []string{"home"},
+			BlockedBy: []uint32{5},
+		})
+		require.NoError(t, err)
+		assert.True(t, proto.Equal(pb, resp), resp)
+		mockService.AssertExpectations(t)
+	})
+	t.Run("CreateTaskInvalidParams", func(t *testing.T) {
+		mockService := new(mocks.Service)
+		client := dial(t, NewServer(mockService, &config.GRPCConfig{}))
+
+		_, err := client.CreateTask(ctx, &taskpb.CreateTaskRequest{Priority: 6, Tags: []string{"a,b"}, BlockedBy: []uint32{0}})
+		assertError(t, err, errcode.InvalidParams.WithDetails(
+			"name: 必須是非空字串",
+			"priority: 必須是 0 到 5 之間的整數",
+			"tags: 每個標籤最長 32 個字元且不能包含逗號",
+			"blocked_by: 必須是 1 到 4294967295 之間的整數",
+		))
+		mockService.AssertNotCalled(t, "CreateTask", mock.Anything, mock.Anything)
+	})
+	t.Run("UpdateTask", func(t *testing.T) {
+		mockService := new(mocks.Service)
+		mockService.On("UpdateTask", mock.Anything, uint32(1), &model.Task{Name: "task1", Status: model.StatusDone}).Return(nil, errcode.TaskBlocked).Once()
+		client := dial(t, NewServer(mockService, &config.GRPCConfig{}))
+
+		_, err := client.UpdateTask(ctx, &taskpb.UpdateTaskRequest{Id: 1, Name: "task1", Status: taskpb.Status_STATUS_DONE})
+		assertError(t, err, errcode.TaskBlocked)
+
+		_, err = client.UpdateTask(ctx, &taskpb.UpdateTaskRequest{Id: 1, Name: "task1"})
+		assertError(t, err, errcode.InvalidParams.WithDetails(statusDetail))
+		mockService.AssertExpectations(t)
+	})
+	t.Run("PatchTask", func(t *testing.T) {
+		name := "task1"
+		parentID := uint32(0)
+		status := model.StatusDone
+		mockService := new(mocks.Service)
+		mockService.On("PatchTask", mock.Anything, uint32(1), &model.TaskPatch{
+			Name:     &name,
+			Status:   &status,
+			DueAt:    &time.Time{},
+			ParentID: &parentID,
+		}).Return(task, nil).Once()
+		client := dial(t, NewServer(mockService, &config.GRPCConfig{}))
+
+		resp, err := client.PatchTask(ctx, &taskpb.PatchTaskRequest{
+			Id:         1,
+			Name:       &name,
+			Status:     taskpb.Status_STATUS_DONE,
+			ClearDueAt: true,
+			ParentId:   &parentID,
+		})
+		require.NoError(t, err)
+		assert.True(t, proto.Equal(pb, resp), resp)
+
+		empty := ""
+		_, err = client.PatchTask(ctx, &taskpb.PatchTaskRequest{Id: 1, Name: &empty, DueAt: timestamppb.New(dueAt), ClearDueAt: true})
+		assertError(t, err, errcode.InvalidParams.WithDetails("name: 必須是非空字串", "clear_due_at: 不能與 due_at 一起使用"))
+		mockService.AssertExpectations(t)
+	})
+	t.Run("DeleteTask", func(t *testing.T) {
+		mockService := new(mocks.Service)
+		mockService.On("DeleteTask", mock.Anything, uint32(1)).Return(nil).Once()
+		mockService.On("DeleteTask", mock.Anything, uint32(2)).Return(errcode.RecordNotExists).Once()
+		client := dial(t, NewServer(mockService, &config.GRPCConfig{}))
+
+		_, err := client.DeleteTask(ctx, &taskpb.DeleteTaskRequest{Id: 1})
+		assert.NoError(t, err)
+		_, err = client.DeleteTask(ctx, &taskpb.DeleteTaskRequest{Id: 2})
+		assertError(t, err, errcode.RecordNotExists)
+		mockService.AssertExpectations(t)
+	})
+}
+
+func TestErrors(t *testing.T) {
+	tests := []struct {
+		err  error
+		code codes.Code
+	}{
+		{errcode.InvalidParams, codes.InvalidArgument},
+		{errcode.DuplicateRecords, codes.AlreadyExists},
+		{errcode.RecordNotExists, codes.NotFound},
+		{errcode.PatchTestFailed, codes.Aborted},
+		{errcode.IllegalTransition, codes.FailedPrecondition},
+		{errcode.ProjectNotEmpty, codes.FailedPrecondition},
+		{errcode.CycleDetected, codes.FailedPrecondition},
+		{errcode.TaskBlocked, codes.FailedPrecondition},
+		{errcode.Unauthorized, codes.Unauthenticated},
+		{errcode.Forbidden, codes.PermissionDenied},
+		{errcode.UnknownError, codes.Internal},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.code, status.Code(toStatus(tt.err)), tt.err)
+	}
+	t.Run("Unknown", func(t *testing.T) {
+		assertError(t, toStatus(assert.AnError), errcode.UnknownError)
+	})
+}
+
+func TestWatchTasks(t *testing.T) {
+	projectID := uint32(1)
+	events := []*model.Event{
+		{Type: model.EventTaskCreated, Task: &model.Task{ID: 1, ProjectID: projectID, Name: "task1", Status: model.StatusTodo}},
+		{Type: model.EventTaskCreated, Task: &model.Task{ID: 2, Name: "task2", Status: model.StatusTodo}},
+		{Type: model.EventTaskUpdated, Task: &model.Task{ID: 1, ProjectID: projectID, Name: "task1", Status: model.StatusDone}},
+	}
+	// receive reads n events off the stream.
+	receive := func(t *testing.T, stream grpc.ServerStreamingClient[taskpb.TaskEvent], n int) []uint64 {
+		var ids []uint64
+		for i := 0; i < n; i++ {
+			e, err := stream.Recv()
+			require.NoError(t, err)
+			ids = append(ids, e.Id)
+		}
+		return ids
+	}
+	t.Run("Filter", func(t *testing.T) {
+		bus := event.NewBus(10)
+		for _, e := range events {
+			bus.Publish(e)
+		}
+		mockService := new(mocks.Service)
+		mockService.On("SubscribeEvents", uint64(0)).Return(func(lastID uint64) *event.Subscription {
+			return bus.Subscribe(lastID)
+		}).Twice()
+		client := dial(t, NewServer(mockService, &config.GRPCConfig{}))
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		stream, err := client.WatchTasks(ctx, &taskpb.WatchTasksRequest{ProjectId: &projectID})
+		require.NoError(t, err)
+		assert.Equal(t, []uint64{1, 3}, receive(t, stream, 2))
+
+		stream, err = client.WatchTasks(ctx, &taskpb.WatchTasksRequest{Status: taskpb.Status_STATUS_TODO})
+		require.NoError(t, err)
+		assert.Equal(t, []uint64{1, 2}, receive(t, stream, 2))
+
+		bus.Publish(&model.Event{Type: model.EventTaskDeleted, Task: &model.Task{ID: 2, Name: "task2", Status: model.StatusTodo}})
+		e, err := stream.Recv()
+		require.NoError(t, err)
+		assert.Equal(t, "task.deleted", e.Type)
+		assert.Equal(t, uint32(2), e.Task.Id)
+		mockService.AssertExpectations(t)
+	})
+	t.Run("LastEventID", func(t *testing.T) {
+		bus := event.NewBus(10)
+		for _, e := range events {
+			bus.Publish(e)
+		}
+		mockService := new(mocks.Service)
+		mockService.On("SubscribeEvents", uint64(1)).Return(bus.Subscribe(1)).Once()
+		client := dial(t, NewServer(mockService, &config.GRPCConfig{}))
+
+		stream, err := client.WatchTasks(context.Background(), &taskpb.WatchTasksRequest{LastEventId: 1})
+		require.NoError(t, err)
+		assert.Equal(t, []uint64{2, 3}, receive(t, stream, 2))
+		mockService.AssertExpectations(t)
+	})
+	t.Run("Dropped", func(t *testing.T) {
+		bus := event.NewBus(10)
+		mockService := new(mocks.Service)
+		mockService.On("SubscribeEvents", uint64(0)).Return(bus.Subscribe(0)).Once()
+		client := dial(t, NewServer(mockService, &config.GRPCConfig{}))
+
+		stream, err := client.WatchTasks(context.Background(), &taskpb.WatchTasksRequest{})
+		require.NoError(t, err)
+		// Closing the bus ends the subscription the same way it does for a
+		// subscriber that has fallen behind.
+		bus.Close()
+		_, err = stream.Recv()
+		assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	})
+	t.Run("InvalidParams", func(t *testing.T) {
+		mockService := new(mocks.Service)
+		client := dial(t, NewServer(mockService, &config.GRPCConfig{}))
+
+		stream, err := client.WatchTasks(context.Background(), &taskpb.WatchTasksRequest{Status: taskpb.Status(9)})
+		require.NoError(t, err)
+		_, err = stream.Recv()
+		assertError(t, err, errcode.InvalidParams.WithDetails(statusDetail))
+		mockService.AssertNotCalled(t, "SubscribeEvents", mock.Anything)
+	})
+	t.Run("Shutdown", func(t *testing.T) {
+		bus := event.NewBus(10)
+		mockService := new(mocks.Service)
+		mockService.On("SubscribeEvents", uint64(0)).Return(bus.Subscribe(0)).Once()
+		server := NewServer(mockService, &config.GRPCConfig{})
+		client := dial(t, server)
+
+		stream, err := client.WatchTasks(context.Background(), &taskpb.WatchTasksRequest{})
+		require.NoError(t, err)
+		// Wait for the stream to be served before shutting down.
+		bus.Publish(events[0])
+		_, err = stream.Recv()
+		require.NoError(t, err)
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		assert.NoError(t, server.Shutdown(ctx))
+		_, err = stream.Recv()
+		assert.Equal(t, codes.Unavailable, status.Code(err))
+	})
+}
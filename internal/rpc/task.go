@@ -0,0 +1,345 @@
+package rpc
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/wagaru/task/internal/errcode"
+	"github.com/wagaru/task/internal/model"
+	"github.com/wagaru/task/internal/rpc/taskpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const (
+	defaultTasksLimit = 100
+	maxTasksLimit     = 1000
+)
+
+var statuses = map[taskpb.Status]model.Status{
+	taskpb.Status_STATUS_TODO:        model.StatusTodo,
+	taskpb.Status_STATUS_IN_PROGRESS: model.StatusInProgress,
+	taskpb.Status_STATUS_BLOCKED:     model.StatusBlocked,
+	taskpb.Status_STATUS_DONE:        model.StatusDone,
+	taskpb.Status_STATUS_CANCELLED:   model.StatusCancelled,
+}
+
+func (s *Server) ListTasks(ctx context.Context, req *taskpb.ListTasksRequest) (*taskpb.ListTasksResponse, error) {
+	query, err := listQuery(req)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	tasks, next, err := s.svc.GetTasks(query)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	resp := &taskpb.ListTasksResponse{NextCursor: next}
+	for _, task := range tasks {
+		resp.Tasks = append(resp.Tasks, toTask(task))
+	}
+	return resp, nil
+}
+
+func (s *Server) GetTask(ctx context.Context, req *taskpb.GetTaskRequest) (*taskpb.Task, error) {
+	if err := checkID(req.Id); err != nil {
+		return nil, toStatus(err)
+	}
+	task, err := s.svc.GetTask(req.Id)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return toTask(task), nil
+}
+
+func (s *Server) CreateTask(ctx context.Context, req *taskpb.CreateTaskRequest) (*taskpb.Task, error) {
+	details := checkFields(&req.Name, &req.Description, &req.Priority, &req.Recurrence, req.DueAt)
+	for _, tag := range req.Tags {
+		if len([]rune(tag)) > model.MaxTagLength || strings.Contains(tag, ",") {
+			details = append(details, fmt.Sprintf("tags: 每個標籤最長 %d 個字元且不能包含逗號", model.MaxTagLength))
+			break
+		}
+	}
+	for _, id := range req.BlockedBy {
+		if id == 0 {
+			details = append(details, fmt.Sprintf("blocked_by: 必須是 1 到 %d 之間的整數", uint32(math.MaxUint32)))
+			break
+		}
+	}
+	if len(details) > 0 {
+		return nil, toStatus(errcode.InvalidParams.WithDetails(details...))
+	}
+	task, err := s.svc.CreateTask(ctx, &model.Task{
+		ProjectID:   req.ProjectId,
+		ParentID:    req.ParentId,
+		Name:        req.Name,
+		Description: req.Description,
+		Priority:    uint8(req.Priority),
+		DueAt:       fromTimestamp(req.DueAt),
+		Recurrence:  req.Recurrence,
+		Tags:        req.Tags,
+		BlockedBy:   req.BlockedBy,
+	})
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return toTask(task), nil
+}
+
+func (s *Server) UpdateTask(ctx context.Context, req *taskpb.UpdateTaskRequest) (*taskpb.Task, error) {
+	if err := checkID(req.Id); err != nil {
+		return nil, toStatus(err)
+	}
+	details := checkFields(&req.Name, &req.Description, &req.Priority, &req.Recurrence, req.DueAt)
+	taskStatus, ok := statuses[req.Status]
+	if !ok {
+		details = append(details, statusDetail)
+	}
+	if len(details) > 0 {
+		return nil, toStatus(errcode.InvalidParams.WithDetails(details...))
+	}
+	task, err := s.svc.UpdateTask(ctx, req.Id, &model.Task{
+		Name:        req.Name,
+		Status:      taskStatus,
+		Description: req.Description,
+		Priority:    uint8(req.Priority),
+		DueAt:       fromTimestamp(req.DueAt),
+		Recurrence:  req.Recurrence,
+	})
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return toTask(task), nil
+}
+
+func (s *Server) PatchTask(ctx context.Context, req *taskpb.PatchTaskRequest) (*taskpb.Task, error) {
+	if err := checkID(req.Id); err != nil {
+		return nil, toStatus(err)
+	}
+	patch, err := taskPatch(req)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	task, err := s.svc.PatchTask(ctx, req.Id, patch)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return toTask(task), nil
+}
+
+func (s *Server) DeleteTask(ctx context.Context, req *taskpb.DeleteTaskRequest) (*emptypb.Empty, error) {
+	if err := checkID(req.Id); err != nil {
+		return nil, toStatus(err)
+	}
+	if err := s.svc.DeleteTask(ctx, req.Id); err != nil {
+		return nil, toStatus(err)
+	}
+	return &emptypb.Empty{}, nil
+}
+
+// WatchTasks sends the matching task events until the client goes away or
+// the server shuts down. If the event bus ends the subscription by itself,
+// the client has fallen behind.
+func (s *Server) WatchTasks(req *taskpb.WatchTasksRequest, stream grpc.ServerStreamingServer[taskpb.TaskEvent]) error {
+	var want *model.Status
+	if req.Status != taskpb.Status_STATUS_UNSPECIFIED {
+		taskStatus, ok := statuses[req.Status]
+		if !ok {
+			return toStatus(errcode.InvalidParams.WithDetails(statusDetail))
+		}
+		want = &taskStatus
+	}
+	sub := s.svc.SubscribeEvents(req.LastEventId)
+	defer sub.Close()
+	ctx := stream.Context()
+	for {
+		select {
+		case e, ok := <-sub.C:
+			if !ok {
+				return status.Error(codes.ResourceExhausted, "接收事件太慢，請帶上最後的事件 id 重新連線")
+			}
+			if req.ProjectId != nil && e.Task.ProjectID != *req.ProjectId {
+				continue
+			}
+			if want != nil && e.Task.Status != *want {
+				continue
+			}
+			if err := stream.Send(toEvent(e)); err != nil {
+				return err
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-s.done:
+			return status.Error(codes.Unavailable, "伺服器正在關閉")
+		}
+	}
+}
+
+const statusDetail = "status: 只能是 STATUS_TODO、STATUS_IN_PROGRESS、STATUS_BLOCKED、STATUS_DONE 或 STATUS_CANCELLED"
+
+func checkID(id uint32) error {
+	if id == 0 {
+		return errcode.InvalidParams.WithDetails(fmt.Sprintf("id: 必須是 1 到 %d 之間的整數", uint32(math.MaxUint32)))
+	}
+	return nil
+}
+
+// checkFields applies the limits the REST API puts on the task fields. Nil
+// fields are not checked.
+func checkFields(name, description *string, priority *uint32, recurrence *string, dueAt *timestamppb.Timestamp) []string {
+	var details []string
+	if name != nil && *name == "" {
+		details = append(details, "name: 必須是非空字串")
+	}
+	if description != nil && len(*description) > 2000 {
+		details = append(details, "description: 必須是長度不超過 2000 的字串")
+	}
+	if priority != nil && *priority > uint32(model.MaxPriority) {
+		details = append(details, fmt.Sprintf("priority: 必須是 0 到 %d 之間的整數", model.MaxPriority))
+	}
+	if recurrence != nil && len(*recurrence) > 200 {
+		details = append(details, "recurrence: 必須是長度不超過 200 的字串")
+	}
+	if dueAt != nil && dueAt.CheckValid() != nil {
+		details = append(details, "due_at: 必須是有效的時間")
+	}
+	return details
+}
+
+func taskPatch(req *taskpb.PatchTaskRequest) (*model.TaskPatch, error) {
+	details := checkFields(req.Name, req.Description, req.Priority, req.Recurrence, req.DueAt)
+	if req.DueAt != nil && req.ClearDueAt {
+		details = append(details, "clear_due_at: 不能與 due_at 一起使用")
+	}
+	patch := &model.TaskPatch{
+		Name:        req.Name,
+		Description: req.Description,
+		Recurrence:  req.Recurrence,
+		ParentID:    req.ParentId,
+	}
+	if req.Status != taskpb.Status_STATUS_UNSPECIFIED {
+		if taskStatus, ok := statuses[req.Status]; ok {
+			patch.Status = &taskStatus
+		} else {
+			details = append(details, statusDetail)
+		}
+	}
+	if req.Priority != nil {
+		priority := uint8(*req.Priority)
+		patch.Priority = &priority
+	}
+	if req.DueAt != nil {
+		patch.DueAt = fromTimestamp(req.DueAt)
+	}
+	if req.ClearDueAt {
+		patch.DueAt = &time.Time{}
+	}
+	if len(details) > 0 {
+		return nil, errcode.InvalidParams.WithDetails(details...)
+	}
+	return patch, nil
+}
+
+// listQuery converts the request like the query string of GET /tasks.
+func listQuery(req *taskpb.ListTasksRequest) (*model.TaskQuery, error) {
+	query := &model.TaskQuery{
+		ProjectID: req.ProjectId,
+		ParentID:  req.ParentId,
+		Name:      req.Name,
+		AllTags:   req.AllTags,
+		Sort:      model.SortByID,
+		Limit:     int(req.Limit),
+		Cursor:    req.Cursor,
+	}
+	if req.Limit > maxTasksLimit {
+		return nil, errcode.InvalidParams.WithDetails(fmt.Sprintf("limit: 必須是 0 到 %d 之間的整數", maxTasksLimit))
+	}
+	if query.Limit == 0 {
+		query.Limit = defaultTasksLimit
+	}
+	if req.Status != taskpb.Status_STATUS_UNSPECIFIED {
+		taskStatus, ok := statuses[req.Status]
+		if !ok {
+			return nil, errcode.InvalidParams.WithDetails(statusDetail)
+		}
+		query.Status = &taskStatus
+	}
+	for _, tag := range req.Tags {
+		if len([]rune(tag)) > model.MaxTagLength {
+			return nil, errcode.InvalidParams.WithDetails(fmt.Sprintf("tags: 每個標籤最長 %d 個字元", model.MaxTagLength))
+		}
+	}
+	query.Tags = model.NormalizeTags(req.Tags)
+	if req.Sort != "" {
+		field, direction, _ := strings.Cut(req.Sort, ":")
+		switch field {
+		case model.SortByID, model.SortByName, model.SortByCreatedAt:
+			query.Sort = field
+		default:
+			return nil, errcode.InvalidParams.WithDetails("sort: 只能依 id、name 或 created_at 排序")
+		}
+		switch direction {
+		case "", "asc":
+		case "desc":
+			query.Desc = true
+		default:
+			return nil, errcode.InvalidParams.WithDetails("sort: 排序方向只能是 asc 或 desc")
+		}
+	}
+	return query, nil
+}
+
+func toTask(task *model.Task) *taskpb.Task {
+	pb := &taskpb.Task{
+		Id:          task.ID,
+		ProjectId:   task.ProjectID,
+		ParentId:    task.ParentID,
+		Name:        task.Name,
+		Description: task.Description,
+		Priority:    uint32(task.Priority),
+		DueAt:       toTimestamp(task.DueAt),
+		Recurrence:  task.Recurrence,
+		Tags:        task.Tags,
+		BlockedBy:   task.BlockedBy,
+		CreatedAt:   timestamppb.New(task.CreatedAt),
+		UpdatedAt:   timestamppb.New(task.UpdatedAt),
+		CompletedAt: toTimestamp(task.CompletedAt),
+		CreatedBy:   task.CreatedBy,
+		UpdatedBy:   task.UpdatedBy,
+	}
+	for pbStatus, taskStatus := range statuses {
+		if taskStatus == task.Status {
+			pb.Status = pbStatus
+		}
+	}
+	return pb
+}
+
+func toEvent(e *model.Event) *taskpb.TaskEvent {
+	return &taskpb.TaskEvent{
+		Id:   e.ID,
+		Type: e.Type,
+		Task: toTask(e.Task),
+		At:   timestamppb.New(e.At),
+	}
+}
+
+func toTimestamp(t *time.Time) *timestamppb.Timestamp {
+	if t == nil {
+		return nil
+	}
+	return timestamppb.New(*t)
+}
+
+func fromTimestamp(ts *timestamppb.Timestamp) *time.Time {
+	if ts == nil {
+		return nil
+	}
+	t := ts.AsTime()
+	return &t
+}
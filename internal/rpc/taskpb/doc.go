@@ -0,0 +1,5 @@
+// Package taskpb holds the protobuf messages and gRPC stubs of the task
+// service, generated from task.proto.
+package taskpb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative task.proto
@@ -0,0 +1,205 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/wagaru/task/config"
+	"github.com/wagaru/task/internal/errcode"
+	"github.com/wagaru/task/internal/model"
+	"github.com/wagaru/task/internal/service"
+)
+
+const defaultPollInterval = time.Minute
+
+// Clock lets tests control the time the scheduler sees.
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// Notifier publishes fired reminders. A reminder whose Notify fails is not
+// recorded and is tried again on the next tick.
+type Notifier interface {
+	Notify(ctx context.Context, reminder *model.Reminder) error
+}
+
+// LogNotifier writes reminders to the standard logger.
+type LogNotifier struct{}
+
+func (LogNotifier) Notify(ctx context.Context, reminder *model.Reminder) error {
+	log.Printf("reminder: task %d %q is %s, due at %s", reminder.TaskID, reminder.Name, reminder.Kind, reminder.DueAt.Format(time.RFC3339))
+	return nil
+}
+
+type Option func(*Scheduler)
+
+func WithClock(clock Clock) Option {
+	return func(s *Scheduler) { s.clock = clock }
+}
+
+func WithNotifier(notifier Notifier) Option {
+	return func(s *Scheduler) { s.notifier = notifier }
+}
+
+// Scheduler sends a reminder at each configured offset before a task is due,
+// and marks it overdue once the due time has passed. What has been sent is
+// stored on the task, so a restarted scheduler carries on where it stopped.
+type Scheduler struct {
+	svc      service.Service
+	offsets  []time.Duration
+	poll     time.Duration
+	clock    Clock
+	notifier Notifier
+
+	ctx     context.Context
+	cancel  context.CancelFunc
+	mu      sync.Mutex
+	running bool
+	done    chan struct{}
+}
+
+func New(svc service.Service, conf *config.ReminderConfig, opts ...Option) *Scheduler {
+	s := &Scheduler{
+		svc:      svc,
+		poll:     defaultPollInterval,
+		clock:    realClock{},
+		notifier: LogNotifier{},
+		done:     make(chan struct{}),
+	}
+	if conf != nil {
+		for _, offset := range conf.Offsets {
+			if offset > 0 {
+				s.offsets = append(s.offsets, offset)
+			}
+		}
+		if conf.PollInterval > 0 {
+			s.poll = conf.PollInterval
+		}
+	}
+	// Earliest reminder first.
+	sort.Slice(s.offsets, func(i, j int) bool { return s.offsets[i] > s.offsets[j] })
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	return s
+}
+
+// Run blocks until Shutdown is called.
+func (s *Scheduler) Run() error {
+	s.mu.Lock()
+	if s.running || s.ctx.Err() != nil {
+		s.mu.Unlock()
+		return errors.New("scheduler: already run")
+	}
+	s.running = true
+	s.mu.Unlock()
+	defer close(s.done)
+
+	for {
+		wait := s.tick()
+		select {
+		case <-s.ctx.Done():
+			return nil
+		case <-s.clock.After(wait):
+		}
+	}
+}
+
+// Shutdown stops the scheduler and waits for the current tick to finish or
+// ctx to expire.
+func (s *Scheduler) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	running := s.running
+	s.cancel()
+	s.mu.Unlock()
+	if !running {
+		return nil
+	}
+	select {
+	case <-s.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// tick fires every reminder that is due and returns how long to sleep until
+// the next one.
+func (s *Scheduler) tick() time.Duration {
+	now := s.clock.Now()
+	wait := s.poll
+	var horizon time.Duration
+	if len(s.offsets) > 0 {
+		horizon = s.offsets[0]
+	}
+	tasks, err := s.svc.GetDueTasks(now.Add(horizon + s.poll))
+	if err != nil {
+		log.Printf("scheduler.GetDueTasks err:%v", err)
+		return wait
+	}
+	for _, task := range tasks {
+		if s.ctx.Err() != nil {
+			return wait
+		}
+		reminder, next := s.pending(task, now)
+		if reminder != nil {
+			s.fire(reminder)
+		}
+		if !next.IsZero() && next.Sub(now) < wait {
+			wait = next.Sub(now)
+		}
+	}
+	return wait
+}
+
+// pending returns the reminder to send for task now, if any, and when the
+// following one is due.
+func (s *Scheduler) pending(task *model.Task, now time.Time) (*model.Reminder, time.Time) {
+	if task.DueAt == nil || task.OverdueAt != nil {
+		return nil, time.Time{}
+	}
+	dueAt := *task.DueAt
+	reminder := &model.Reminder{TaskID: task.ID, Name: task.Name, DueAt: dueAt, At: now}
+	if !now.Before(dueAt) {
+		reminder.Kind = model.ReminderOverdue
+		return reminder, time.Time{}
+	}
+	// Only the latest reminder that has passed is sent, so a scheduler that
+	// was down does not send a burst of stale ones.
+	var last time.Time
+	next := dueAt
+	for _, offset := range s.offsets {
+		at := dueAt.Add(-offset)
+		if at.After(now) {
+			next = at
+			break
+		}
+		last = at
+	}
+	if last.IsZero() || task.RemindedAt != nil && !last.After(*task.RemindedAt) {
+		return nil, next
+	}
+	reminder.Kind = model.ReminderUpcoming
+	return reminder, next
+}
+
+func (s *Scheduler) fire(reminder *model.Reminder) {
+	if err := s.notifier.Notify(s.ctx, reminder); err != nil {
+		log.Printf("scheduler.Notify task %d err:%v", reminder.TaskID, err)
+		return
+	}
+	if _, err := s.svc.FireReminder(reminder); err != nil && !errors.Is(err, errcode.PatchTestFailed) && !errors.Is(err, errcode.RecordNotExists) {
+		log.Printf("scheduler.FireReminder task %d err:%v", reminder.TaskID, err)
+	}
+}
@@ -0,0 +1,139 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/wagaru/task/config"
+	"github.com/wagaru/task/internal/errcode"
+	"github.com/wagaru/task/internal/model"
+	"github.com/wagaru/task/internal/service/mocks"
+)
+
+var testNow = time.Date(2022, 5, 1, 8, 0, 0, 0, time.UTC)
+
+type fakeClock struct {
+	now   time.Time
+	waits chan time.Duration
+	fire  chan time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: testNow, waits: make(chan time.Duration, 10), fire: make(chan time.Time)}
+}
+
+func (c *fakeClock) Now() time.Time { return c.now }
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.waits <- d
+	return c.fire
+}
+
+type recordNotifier struct {
+	reminders []*model.Reminder
+	err       error
+}
+
+func (n *recordNotifier) Notify(ctx context.Context, reminder *model.Reminder) error {
+	n.reminders = append(n.reminders, reminder)
+	return n.err
+}
+
+var testConfig = &config.ReminderConfig{Offsets: []time.Duration{time.Hour, 24 * time.Hour}, PollInterval: time.Minute}
+
+func at(d time.Duration) *time.Time {
+	t := testNow.Add(d)
+	return &t
+}
+
+func TestTick(t *testing.T) {
+	horizon := testNow.Add(24*time.Hour + time.Minute)
+	t.Run("Upcoming", func(t *testing.T) {
+		task := &model.Task{ID: 1, Name: "task", DueAt: at(30 * time.Minute)}
+		reminder := &model.Reminder{Kind: model.ReminderUpcoming, TaskID: 1, Name: "task", DueAt: *task.DueAt, At: testNow}
+		mockSvc := new(mocks.Service)
+		mockSvc.On("GetDueTasks", horizon).Return([]*model.Task{task}, nil).Once()
+		mockSvc.On("FireReminder", reminder).Return(task, nil).Once()
+		notifier := &recordNotifier{}
+		s := New(mockSvc, testConfig, WithClock(newFakeClock()), WithNotifier(notifier))
+		assert.Equal(t, time.Minute, s.tick())
+		assert.Equal(t, []*model.Reminder{reminder}, notifier.reminders)
+		mockSvc.AssertExpectations(t)
+	})
+	t.Run("AlreadyReminded", func(t *testing.T) {
+		task := &model.Task{ID: 1, Name: "task", DueAt: at(40 * time.Second), RemindedAt: at(-time.Hour / 2)}
+		mockSvc := new(mocks.Service)
+		mockSvc.On("GetDueTasks", horizon).Return([]*model.Task{task}, nil).Once()
+		notifier := &recordNotifier{}
+		s := New(mockSvc, testConfig, WithClock(newFakeClock()), WithNotifier(notifier))
+		assert.Equal(t, 40*time.Second, s.tick())
+		assert.Empty(t, notifier.reminders)
+		mockSvc.AssertNotCalled(t, "FireReminder", mock.Anything)
+	})
+	t.Run("NextOffset", func(t *testing.T) {
+		task := &model.Task{ID: 1, Name: "task", DueAt: at(time.Hour + 20*time.Second), RemindedAt: at(-time.Hour)}
+		mockSvc := new(mocks.Service)
+		mockSvc.On("GetDueTasks", horizon).Return([]*model.Task{task}, nil).Once()
+		notifier := &recordNotifier{}
+		s := New(mockSvc, testConfig, WithClock(newFakeClock()), WithNotifier(notifier))
+		assert.Equal(t, 20*time.Second, s.tick())
+		assert.Empty(t, notifier.reminders)
+	})
+	t.Run("Overdue", func(t *testing.T) {
+		overdue := &model.Task{ID: 1, Name: "late", DueAt: at(-time.Minute), RemindedAt: at(-2 * time.Hour)}
+		marked := &model.Task{ID: 2, Name: "marked", DueAt: at(-time.Hour), OverdueAt: at(-time.Hour)}
+		reminder := &model.Reminder{Kind: model.ReminderOverdue, TaskID: 1, Name: "late", DueAt: *overdue.DueAt, At: testNow}
+		mockSvc := new(mocks.Service)
+		mockSvc.On("GetDueTasks", horizon).Return([]*model.Task{overdue, marked}, nil).Once()
+		mockSvc.On("FireReminder", reminder).Return(nil, errcode.PatchTestFailed).Once()
+		notifier := &recordNotifier{}
+		s := New(mockSvc, testConfig, WithClock(newFakeClock()), WithNotifier(notifier))
+		assert.Equal(t, time.Minute, s.tick())
+		assert.Equal(t, []*model.Reminder{reminder}, notifier.reminders)
+		mockSvc.AssertExpectations(t)
+	})
+	t.Run("NotifyFailed", func(t *testing.T) {
+		task := &model.Task{ID: 1, Name: "task", DueAt: at(-time.Minute)}
+		mockSvc := new(mocks.Service)
+		mockSvc.On("GetDueTasks", horizon).Return([]*model.Task{task}, nil).Once()
+		notifier := &recordNotifier{err: errors.New("unavailable")}
+		s := New(mockSvc, testConfig, WithClock(newFakeClock()), WithNotifier(notifier))
+		s.tick()
+		assert.Len(t, notifier.reminders, 1)
+		mockSvc.AssertNotCalled(t, "FireReminder", mock.Anything)
+	})
+	t.Run("NoOffsets", func(t *testing.T) {
+		task := &model.Task{ID: 1, Name: "task", DueAt: at(30 * time.Second)}
+		mockSvc := new(mocks.Service)
+		mockSvc.On("GetDueTasks", testNow.Add(defaultPollInterval)).Return([]*model.Task{task}, nil).Once()
+		notifier := &recordNotifier{}
+		s := New(mockSvc, nil, WithClock(newFakeClock()), WithNotifier(notifier))
+		assert.Equal(t, 30*time.Second, s.tick())
+		assert.Empty(t, notifier.reminders)
+	})
+}
+
+func TestRunShutdown(t *testing.T) {
+	mockSvc := new(mocks.Service)
+	mockSvc.On("GetDueTasks", mock.Anything).Return(nil, nil).Twice()
+	clock := newFakeClock()
+	s := New(mockSvc, testConfig, WithClock(clock), WithNotifier(&recordNotifier{}))
+	result := make(chan error)
+	go func() { result <- s.Run() }()
+
+	assert.Equal(t, time.Minute, <-clock.waits)
+	clock.now = clock.now.Add(time.Minute)
+	clock.fire <- clock.now
+	assert.Equal(t, time.Minute, <-clock.waits)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	assert.NoError(t, s.Shutdown(ctx))
+	assert.NoError(t, <-result)
+	assert.Error(t, s.Run())
+	mockSvc.AssertExpectations(t)
+}
@@ -0,0 +1,109 @@
+package service
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/wagaru/task/internal/auth"
+	"github.com/wagaru/task/internal/errcode"
+	"github.com/wagaru/task/internal/model"
+)
+
+const (
+	// APIKeyPrefix starts every token, so that they are recognizable in
+	// configuration files and logs.
+	APIKeyPrefix = "tk_"
+	apiKeyBytes  = 32
+	// apiKeyShown is how much of a token is kept as the prefix of its key.
+	apiKeyShown = len(APIKeyPrefix) + 8
+)
+
+func (s *service) GetAPIKeys() ([]*model.APIKey, error) {
+	return s.repo.GetAPIKeys()
+}
+
+// CreateAPIKey generates the token of a key. Only its hash is stored, so the
+// returned key is the only place it appears.
+func (s *service) CreateAPIKey(key *model.APIKey) (*model.APIKey, error) {
+	scopes, err := checkScopes(key.Scopes)
+	if err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(key.Name) == "" {
+		return nil, errcode.InvalidParams.WithDetails("name: 必填")
+	}
+	b := make([]byte, apiKeyBytes)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	token := APIKeyPrefix + hex.EncodeToString(b)
+	created, err := s.repo.CreateAPIKey(&model.APIKey{
+		Name:      key.Name,
+		Prefix:    token[:apiKeyShown],
+		Hash:      hashToken(token),
+		Scopes:    scopes,
+		CreatedAt: s.timestamp(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	// A copy, since the repository may hand out the key it keeps.
+	result := *created
+	result.Token = token
+	return &result, nil
+}
+
+func (s *service) DeleteAPIKey(id uint32) error {
+	return s.repo.DeleteAPIKey(id)
+}
+
+// Authenticate looks up the key of token, failing with errcode.Unauthorized
+// when there is none.
+func (s *service) Authenticate(token string) (*auth.Principal, error) {
+	key, err := s.repo.GetAPIKeyByHash(hashToken(token))
+	if errors.Is(err, errcode.RecordNotExists) {
+		return nil, errcode.Unauthorized
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &auth.Principal{
+		Subject: fmt.Sprintf("apikey:%d", key.ID),
+		Scopes:  key.Scopes,
+	}, nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// checkScopes returns scopes sorted and without duplicates, requiring at
+// least one.
+func checkScopes(scopes []string) ([]string, error) {
+	if len(scopes) == 0 {
+		return nil, errcode.InvalidParams.WithDetails("scopes: 至少需要一項權限")
+	}
+	known := make(map[string]bool)
+	for _, scope := range auth.Scopes {
+		known[scope] = true
+	}
+	seen := make(map[string]bool)
+	var result []string
+	for _, scope := range scopes {
+		if !known[scope] {
+			return nil, errcode.InvalidParams.WithDetails(fmt.Sprintf("scopes: 不支援的權限 %q", scope))
+		}
+		if !seen[scope] {
+			seen[scope] = true
+			result = append(result, scope)
+		}
+	}
+	sort.Strings(result)
+	return result, nil
+}
@@ -0,0 +1,207 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/wagaru/task/internal/errcode"
+	"github.com/wagaru/task/internal/model"
+)
+
+// GetTaskOrder lists every task matching the query in an order that puts
+// each task after its blockers. Limit and Cursor are ignored; the query's
+// sort decides between tasks that do not depend on each other.
+func (s *service) GetTaskOrder(query *model.TaskQuery) ([]*model.Task, error) {
+	if err := s.checkQuery(query); err != nil {
+		return nil, err
+	}
+	all := *query
+	all.Limit = 0
+	all.Cursor = ""
+	tasks, _, err := s.repo.GetTasks(&all)
+	if err != nil {
+		return nil, err
+	}
+	ordered, ok := model.TopologicalOrder(tasks)
+	if !ok {
+		return nil, errcode.CycleDetected.WithDetails("blocked_by: 任務之間的依賴形成循環")
+	}
+	return ordered, nil
+}
+
+func (s *service) GetTaskDependencies(id uint32) (*model.TaskDependencies, error) {
+	task, err := s.repo.GetTask(id)
+	if err != nil {
+		return nil, err
+	}
+	deps := &model.TaskDependencies{BlockedBy: []*model.Task{}}
+	for _, blocker := range task.BlockedBy {
+		blocking, err := s.repo.GetTask(blocker)
+		if errors.Is(err, errcode.RecordNotExists) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		deps.BlockedBy = append(deps.BlockedBy, blocking)
+	}
+	deps.Blocking, _, err = s.repo.GetTasks(&model.TaskQuery{Blocker: &id})
+	if err != nil {
+		return nil, err
+	}
+	return deps, nil
+}
+
+// AddTaskBlockers refuses blockers that would form a cycle and, for a task
+// that is done, blockers that are still open.
+func (s *service) AddTaskBlockers(ctx context.Context, id uint32, blockers []uint32) (*model.Task, error) {
+	s.graph.Lock()
+	defer s.graph.Unlock()
+	task, err := s.repo.GetTask(id)
+	if err != nil {
+		return nil, err
+	}
+	blockers = model.NormalizeIDs(blockers)
+	if err := s.checkBlockers(id, task.ProjectID, blockers); err != nil {
+		return nil, err
+	}
+	if task.Status == model.StatusDone {
+		if err := s.checkOpenBlockers(&model.Task{BlockedBy: blockers}); err != nil {
+			return nil, err
+		}
+	}
+	return s.patchTask(id, func(task *model.Task) error {
+		task.BlockedBy = model.NormalizeIDs(append(append([]uint32{}, task.BlockedBy...), blockers...))
+		s.touch(ctx, task, task.Status)
+		return nil
+	})
+}
+
+func (s *service) RemoveTaskBlockers(ctx context.Context, id uint32, blockers []uint32) (*model.Task, error) {
+	remove := make(map[uint32]bool)
+	for _, blocker := range blockers {
+		remove[blocker] = true
+	}
+	return s.patchTask(id, func(task *model.Task) error {
+		var kept []uint32
+		for _, blocker := range task.BlockedBy {
+			if !remove[blocker] {
+				kept = append(kept, blocker)
+			}
+		}
+		task.BlockedBy = kept
+		s.touch(ctx, task, task.Status)
+		return nil
+	})
+}
+
+// unblock drops a task about to be deleted from the blockers of every task
+// waiting on it.
+func (s *service) unblock(ctx context.Context, id uint32) error {
+	blocked, _, err := s.repo.GetTasks(&model.TaskQuery{Blocker: &id})
+	if err != nil {
+		return err
+	}
+	for _, task := range blocked {
+		if _, err := s.RemoveTaskBlockers(ctx, task.ID, []uint32{id}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkBlockers verifies that blockers may block task id (zero for a task not
+// created yet) in the given project: each has to exist, belong to the same
+// project and not already wait on the task, directly or not.
+func (s *service) checkBlockers(id, projectID uint32, blockers []uint32) error {
+	for _, blocker := range blockers {
+		if blocker == id {
+			return errcode.CycleDetected.WithDetails("blocked_by: 任務不能阻擋自己")
+		}
+		task, err := s.repo.GetTask(blocker)
+		if errors.Is(err, errcode.RecordNotExists) {
+			return errcode.RecordNotExists.WithDetails(fmt.Sprintf("blocked_by: 任務 %d 不存在", blocker))
+		}
+		if err != nil {
+			return err
+		}
+		if task.ProjectID != projectID {
+			return errcode.InvalidParams.WithDetails("blocked_by: 前置任務必須在同一個專案中")
+		}
+		if id == 0 {
+			continue
+		}
+		waits, err := s.waitsOn(task, id)
+		if err != nil {
+			return err
+		}
+		if waits {
+			return errcode.CycleDetected.WithDetails(fmt.Sprintf("blocked_by: 任務 %d 已直接或間接依賴此任務", blocker))
+		}
+	}
+	return nil
+}
+
+// waitsOn reports whether task is blocked by id, directly or through other
+// blockers.
+func (s *service) waitsOn(task *model.Task, id uint32) (bool, error) {
+	visited := map[uint32]bool{task.ID: true}
+	pending := append([]uint32{}, task.BlockedBy...)
+	for len(pending) > 0 {
+		next := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		if next == id {
+			return true, nil
+		}
+		if visited[next] {
+			continue
+		}
+		visited[next] = true
+		blocker, err := s.repo.GetTask(next)
+		if errors.Is(err, errcode.RecordNotExists) {
+			continue
+		}
+		if err != nil {
+			return false, err
+		}
+		pending = append(pending, blocker.BlockedBy...)
+	}
+	return false, nil
+}
+
+// checkCompletable refuses to mark task id done while any of its blockers
+// is open. A task that already is done may stay so.
+func (s *service) checkCompletable(id uint32) error {
+	task, err := s.repo.GetTask(id)
+	if err != nil {
+		return err
+	}
+	if task.Status == model.StatusDone {
+		return nil
+	}
+	return s.checkOpenBlockers(task)
+}
+
+// checkOpenBlockers fails with errcode.TaskBlocked, listing the blockers of
+// task that are neither done nor cancelled.
+func (s *service) checkOpenBlockers(task *model.Task) error {
+	var open []string
+	for _, id := range task.BlockedBy {
+		blocker, err := s.repo.GetTask(id)
+		if errors.Is(err, errcode.RecordNotExists) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if !blocker.Status.Closed() {
+			open = append(open, fmt.Sprint(id))
+		}
+	}
+	if len(open) > 0 {
+		return errcode.TaskBlocked.WithDetails(fmt.Sprintf("blocked_by: 任務 %s 尚未完成", strings.Join(open, "、")))
+	}
+	return nil
+}
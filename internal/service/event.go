@@ -0,0 +1,55 @@
+package service
+
+import (
+	"github.com/wagaru/task/internal/event"
+	"github.com/wagaru/task/internal/model"
+)
+
+// createTask, patchTask and deleteTask write through to the repository and
+// emit the matching event before the next write can commit.
+func (s *service) createTask(task *model.Task) (*model.Task, error) {
+	s.writes.Lock()
+	defer s.writes.Unlock()
+	created, err := s.repo.CreateTask(task)
+	if err != nil {
+		return nil, err
+	}
+	s.emit(model.EventTaskCreated, created)
+	return created, nil
+}
+
+func (s *service) patchTask(id uint32, apply func(task *model.Task) error) (*model.Task, error) {
+	s.writes.Lock()
+	defer s.writes.Unlock()
+	updated, err := s.repo.PatchTask(id, apply)
+	if err != nil {
+		return nil, err
+	}
+	s.emit(model.EventTaskUpdated, updated)
+	return updated, nil
+}
+
+func (s *service) deleteTask(task *model.Task) error {
+	s.writes.Lock()
+	defer s.writes.Unlock()
+	if err := s.repo.DeleteTask(task.ID); err != nil {
+		return err
+	}
+	s.emit(model.EventTaskDeleted, task)
+	return nil
+}
+
+// emit publishes on the event bus first, so webhooks see the event's ID.
+func (s *service) emit(eventType string, task *model.Task) {
+	e := &model.Event{Type: eventType, Task: task, At: s.timestamp()}
+	s.events.Publish(e)
+	if s.webhooks != nil {
+		s.webhooks.Publish(e)
+	}
+}
+
+// SubscribeEvents follows the task events emitted after lastEventID; see
+// event.Bus.Subscribe.
+func (s *service) SubscribeEvents(lastEventID uint64) *event.Subscription {
+	return s.events.Subscribe(lastEventID)
+}
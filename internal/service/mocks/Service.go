@@ -3,10 +3,19 @@
 package mocks
 
 import (
+	context "context"
+
+	auth "github.com/wagaru/task/internal/auth"
+
+	event "github.com/wagaru/task/internal/event"
+
 	mock "github.com/stretchr/testify/mock"
+
 	model "github.com/wagaru/task/internal/model"
 
 	testing "testing"
+
+	time "time"
 )
 
 // Service is an autogenerated mock type for the Service type
@@ -14,22 +23,68 @@ type Service struct {
 	mock.Mock
 }
 
-// CreateTask provides a mock function with given fields: name
-func (_m *Service) CreateTask(name string) (*model.Task, error) {
-	ret := _m.Called(name)
+// AddTaskBlockers provides a mock function with given fields: ctx, id, blockers
+func (_m *Service) AddTaskBlockers(ctx context.Context, id uint32, blockers []uint32) (*model.Task, error) {
+	ret := _m.Called(ctx, id, blockers)
+
+	var r0 *model.Task
+	if rf, ok := ret.Get(0).(func(context.Context, uint32, []uint32) *model.Task); ok {
+		r0 = rf(ctx, id, blockers)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.Task)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, uint32, []uint32) error); ok {
+		r1 = rf(ctx, id, blockers)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// AddTaskTags provides a mock function with given fields: ctx, id, tags
+func (_m *Service) AddTaskTags(ctx context.Context, id uint32, tags []string) (*model.Task, error) {
+	ret := _m.Called(ctx, id, tags)
 
 	var r0 *model.Task
-	if rf, ok := ret.Get(0).(func(string) *model.Task); ok {
-		r0 = rf(name)
+	if rf, ok := ret.Get(0).(func(context.Context, uint32, []string) *model.Task); ok {
+		r0 = rf(ctx, id, tags)
 	} else {
 		if ret.Get(0) != nil {
 			r0 = ret.Get(0).(*model.Task)
 		}
 	}
 
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, uint32, []string) error); ok {
+		r1 = rf(ctx, id, tags)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// Authenticate provides a mock function with given fields: token
+func (_m *Service) Authenticate(token string) (*auth.Principal, error) {
+	ret := _m.Called(token)
+
+	var r0 *auth.Principal
+	if rf, ok := ret.Get(0).(func(string) *auth.Principal); ok {
+		r0 = rf(token)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*auth.Principal)
+		}
+	}
+
 	var r1 error
 	if rf, ok := ret.Get(1).(func(string) error); ok {
-		r1 = rf(name)
+		r1 = rf(token)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -37,13 +92,105 @@ func (_m *Service) CreateTask(name string) (*model.Task, error) {
 	return r0, r1
 }
 
-// DeleteTask provides a mock function with given fields: _a0
-func (_m *Service) DeleteTask(_a0 uint32) error {
-	ret := _m.Called(_a0)
+// CreateAPIKey provides a mock function with given fields: key
+func (_m *Service) CreateAPIKey(key *model.APIKey) (*model.APIKey, error) {
+	ret := _m.Called(key)
+
+	var r0 *model.APIKey
+	if rf, ok := ret.Get(0).(func(*model.APIKey) *model.APIKey); ok {
+		r0 = rf(key)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.APIKey)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(*model.APIKey) error); ok {
+		r1 = rf(key)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// CreateProject provides a mock function with given fields: project
+func (_m *Service) CreateProject(project *model.Project) (*model.Project, error) {
+	ret := _m.Called(project)
+
+	var r0 *model.Project
+	if rf, ok := ret.Get(0).(func(*model.Project) *model.Project); ok {
+		r0 = rf(project)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.Project)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(*model.Project) error); ok {
+		r1 = rf(project)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// CreateTask provides a mock function with given fields: ctx, task
+func (_m *Service) CreateTask(ctx context.Context, task *model.Task) (*model.Task, error) {
+	ret := _m.Called(ctx, task)
+
+	var r0 *model.Task
+	if rf, ok := ret.Get(0).(func(context.Context, *model.Task) *model.Task); ok {
+		r0 = rf(ctx, task)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.Task)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, *model.Task) error); ok {
+		r1 = rf(ctx, task)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// CreateWebhook provides a mock function with given fields: webhook
+func (_m *Service) CreateWebhook(webhook *model.Webhook) (*model.Webhook, error) {
+	ret := _m.Called(webhook)
+
+	var r0 *model.Webhook
+	if rf, ok := ret.Get(0).(func(*model.Webhook) *model.Webhook); ok {
+		r0 = rf(webhook)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.Webhook)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(*model.Webhook) error); ok {
+		r1 = rf(webhook)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// DeleteAPIKey provides a mock function with given fields: id
+func (_m *Service) DeleteAPIKey(id uint32) error {
+	ret := _m.Called(id)
 
 	var r0 error
 	if rf, ok := ret.Get(0).(func(uint32) error); ok {
-		r0 = rf(_a0)
+		r0 = rf(id)
 	} else {
 		r0 = ret.Error(0)
 	}
@@ -51,6 +198,209 @@ func (_m *Service) DeleteTask(_a0 uint32) error {
 	return r0
 }
 
+// DeleteProject provides a mock function with given fields: id, cascade
+func (_m *Service) DeleteProject(id uint32, cascade bool) error {
+	ret := _m.Called(id, cascade)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(uint32, bool) error); ok {
+		r0 = rf(id, cascade)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// DeleteTask provides a mock function with given fields: ctx, id
+func (_m *Service) DeleteTask(ctx context.Context, id uint32) error {
+	ret := _m.Called(ctx, id)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, uint32) error); ok {
+		r0 = rf(ctx, id)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// DeleteWebhook provides a mock function with given fields: id
+func (_m *Service) DeleteWebhook(id uint32) error {
+	ret := _m.Called(id)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(uint32) error); ok {
+		r0 = rf(id)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// FireReminder provides a mock function with given fields: reminder
+func (_m *Service) FireReminder(reminder *model.Reminder) (*model.Task, error) {
+	ret := _m.Called(reminder)
+
+	var r0 *model.Task
+	if rf, ok := ret.Get(0).(func(*model.Reminder) *model.Task); ok {
+		r0 = rf(reminder)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.Task)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(*model.Reminder) error); ok {
+		r1 = rf(reminder)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetAPIKeys provides a mock function with given fields:
+func (_m *Service) GetAPIKeys() ([]*model.APIKey, error) {
+	ret := _m.Called()
+
+	var r0 []*model.APIKey
+	if rf, ok := ret.Get(0).(func() []*model.APIKey); ok {
+		r0 = rf()
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]*model.APIKey)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func() error); ok {
+		r1 = rf()
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetDeadLetters provides a mock function with given fields:
+func (_m *Service) GetDeadLetters() ([]*model.WebhookDelivery, error) {
+	ret := _m.Called()
+
+	var r0 []*model.WebhookDelivery
+	if rf, ok := ret.Get(0).(func() []*model.WebhookDelivery); ok {
+		r0 = rf()
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]*model.WebhookDelivery)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func() error); ok {
+		r1 = rf()
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetDueTasks provides a mock function with given fields: before
+func (_m *Service) GetDueTasks(before time.Time) ([]*model.Task, error) {
+	ret := _m.Called(before)
+
+	var r0 []*model.Task
+	if rf, ok := ret.Get(0).(func(time.Time) []*model.Task); ok {
+		r0 = rf(before)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]*model.Task)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(time.Time) error); ok {
+		r1 = rf(before)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetProject provides a mock function with given fields: id
+func (_m *Service) GetProject(id uint32) (*model.Project, error) {
+	ret := _m.Called(id)
+
+	var r0 *model.Project
+	if rf, ok := ret.Get(0).(func(uint32) *model.Project); ok {
+		r0 = rf(id)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.Project)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(uint32) error); ok {
+		r1 = rf(id)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetProjects provides a mock function with given fields:
+func (_m *Service) GetProjects() ([]*model.Project, error) {
+	ret := _m.Called()
+
+	var r0 []*model.Project
+	if rf, ok := ret.Get(0).(func() []*model.Project); ok {
+		r0 = rf()
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]*model.Project)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func() error); ok {
+		r1 = rf()
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetTags provides a mock function with given fields:
+func (_m *Service) GetTags() ([]*model.Tag, error) {
+	ret := _m.Called()
+
+	var r0 []*model.Tag
+	if rf, ok := ret.Get(0).(func() []*model.Tag); ok {
+		r0 = rf()
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]*model.Tag)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func() error); ok {
+		r1 = rf()
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // GetTask provides a mock function with given fields: id
 func (_m *Service) GetTask(id uint32) (*model.Task, error) {
 	ret := _m.Called(id)
@@ -74,19 +424,164 @@ func (_m *Service) GetTask(id uint32) (*model.Task, error) {
 	return r0, r1
 }
 
-// GetTasks provides a mock function with given fields:
-func (_m *Service) GetTasks() ([]*model.Task, error) {
-	ret := _m.Called()
+// GetTaskDependencies provides a mock function with given fields: id
+func (_m *Service) GetTaskDependencies(id uint32) (*model.TaskDependencies, error) {
+	ret := _m.Called(id)
+
+	var r0 *model.TaskDependencies
+	if rf, ok := ret.Get(0).(func(uint32) *model.TaskDependencies); ok {
+		r0 = rf(id)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.TaskDependencies)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(uint32) error); ok {
+		r1 = rf(id)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetTaskOrder provides a mock function with given fields: query
+func (_m *Service) GetTaskOrder(query *model.TaskQuery) ([]*model.Task, error) {
+	ret := _m.Called(query)
 
 	var r0 []*model.Task
-	if rf, ok := ret.Get(0).(func() []*model.Task); ok {
-		r0 = rf()
+	if rf, ok := ret.Get(0).(func(*model.TaskQuery) []*model.Task); ok {
+		r0 = rf(query)
 	} else {
 		if ret.Get(0) != nil {
 			r0 = ret.Get(0).([]*model.Task)
 		}
 	}
 
+	var r1 error
+	if rf, ok := ret.Get(1).(func(*model.TaskQuery) error); ok {
+		r1 = rf(query)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetTaskTree provides a mock function with given fields: query
+func (_m *Service) GetTaskTree(query *model.TaskQuery) ([]*model.TaskNode, error) {
+	ret := _m.Called(query)
+
+	var r0 []*model.TaskNode
+	if rf, ok := ret.Get(0).(func(*model.TaskQuery) []*model.TaskNode); ok {
+		r0 = rf(query)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]*model.TaskNode)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(*model.TaskQuery) error); ok {
+		r1 = rf(query)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetTasks provides a mock function with given fields: query
+func (_m *Service) GetTasks(query *model.TaskQuery) ([]*model.Task, string, error) {
+	ret := _m.Called(query)
+
+	var r0 []*model.Task
+	if rf, ok := ret.Get(0).(func(*model.TaskQuery) []*model.Task); ok {
+		r0 = rf(query)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]*model.Task)
+		}
+	}
+
+	var r1 string
+	if rf, ok := ret.Get(1).(func(*model.TaskQuery) string); ok {
+		r1 = rf(query)
+	} else {
+		r1 = ret.Get(1).(string)
+	}
+
+	var r2 error
+	if rf, ok := ret.Get(2).(func(*model.TaskQuery) error); ok {
+		r2 = rf(query)
+	} else {
+		r2 = ret.Error(2)
+	}
+
+	return r0, r1, r2
+}
+
+// GetWebhook provides a mock function with given fields: id
+func (_m *Service) GetWebhook(id uint32) (*model.Webhook, error) {
+	ret := _m.Called(id)
+
+	var r0 *model.Webhook
+	if rf, ok := ret.Get(0).(func(uint32) *model.Webhook); ok {
+		r0 = rf(id)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.Webhook)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(uint32) error); ok {
+		r1 = rf(id)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetWebhookDeliveries provides a mock function with given fields: id
+func (_m *Service) GetWebhookDeliveries(id uint32) ([]*model.WebhookDelivery, error) {
+	ret := _m.Called(id)
+
+	var r0 []*model.WebhookDelivery
+	if rf, ok := ret.Get(0).(func(uint32) []*model.WebhookDelivery); ok {
+		r0 = rf(id)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]*model.WebhookDelivery)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(uint32) error); ok {
+		r1 = rf(id)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetWebhooks provides a mock function with given fields:
+func (_m *Service) GetWebhooks() ([]*model.Webhook, error) {
+	ret := _m.Called()
+
+	var r0 []*model.Webhook
+	if rf, ok := ret.Get(0).(func() []*model.Webhook); ok {
+		r0 = rf()
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]*model.Webhook)
+		}
+	}
+
 	var r1 error
 	if rf, ok := ret.Get(1).(func() error); ok {
 		r1 = rf()
@@ -97,13 +592,121 @@ func (_m *Service) GetTasks() ([]*model.Task, error) {
 	return r0, r1
 }
 
-// UpdateTask provides a mock function with given fields: id, name, status
-func (_m *Service) UpdateTask(id uint32, name string, status uint8) (*model.Task, error) {
-	ret := _m.Called(id, name, status)
+// PatchTask provides a mock function with given fields: ctx, id, patch
+func (_m *Service) PatchTask(ctx context.Context, id uint32, patch *model.TaskPatch) (*model.Task, error) {
+	ret := _m.Called(ctx, id, patch)
+
+	var r0 *model.Task
+	if rf, ok := ret.Get(0).(func(context.Context, uint32, *model.TaskPatch) *model.Task); ok {
+		r0 = rf(ctx, id, patch)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.Task)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, uint32, *model.TaskPatch) error); ok {
+		r1 = rf(ctx, id, patch)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// RemoveTaskBlockers provides a mock function with given fields: ctx, id, blockers
+func (_m *Service) RemoveTaskBlockers(ctx context.Context, id uint32, blockers []uint32) (*model.Task, error) {
+	ret := _m.Called(ctx, id, blockers)
+
+	var r0 *model.Task
+	if rf, ok := ret.Get(0).(func(context.Context, uint32, []uint32) *model.Task); ok {
+		r0 = rf(ctx, id, blockers)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.Task)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, uint32, []uint32) error); ok {
+		r1 = rf(ctx, id, blockers)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// RemoveTaskTags provides a mock function with given fields: ctx, id, tags
+func (_m *Service) RemoveTaskTags(ctx context.Context, id uint32, tags []string) (*model.Task, error) {
+	ret := _m.Called(ctx, id, tags)
+
+	var r0 *model.Task
+	if rf, ok := ret.Get(0).(func(context.Context, uint32, []string) *model.Task); ok {
+		r0 = rf(ctx, id, tags)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.Task)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, uint32, []string) error); ok {
+		r1 = rf(ctx, id, tags)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// SubscribeEvents provides a mock function with given fields: lastEventID
+func (_m *Service) SubscribeEvents(lastEventID uint64) *event.Subscription {
+	ret := _m.Called(lastEventID)
+
+	var r0 *event.Subscription
+	if rf, ok := ret.Get(0).(func(uint64) *event.Subscription); ok {
+		r0 = rf(lastEventID)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*event.Subscription)
+		}
+	}
+
+	return r0
+}
+
+// UpdateProject provides a mock function with given fields: id, project
+func (_m *Service) UpdateProject(id uint32, project *model.Project) (*model.Project, error) {
+	ret := _m.Called(id, project)
+
+	var r0 *model.Project
+	if rf, ok := ret.Get(0).(func(uint32, *model.Project) *model.Project); ok {
+		r0 = rf(id, project)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.Project)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(uint32, *model.Project) error); ok {
+		r1 = rf(id, project)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// UpdateTask provides a mock function with given fields: ctx, id, task
+func (_m *Service) UpdateTask(ctx context.Context, id uint32, task *model.Task) (*model.Task, error) {
+	ret := _m.Called(ctx, id, task)
 
 	var r0 *model.Task
-	if rf, ok := ret.Get(0).(func(uint32, string, uint8) *model.Task); ok {
-		r0 = rf(id, name, status)
+	if rf, ok := ret.Get(0).(func(context.Context, uint32, *model.Task) *model.Task); ok {
+		r0 = rf(ctx, id, task)
 	} else {
 		if ret.Get(0) != nil {
 			r0 = ret.Get(0).(*model.Task)
@@ -111,8 +714,31 @@ func (_m *Service) UpdateTask(id uint32, name string, status uint8) (*model.Task
 	}
 
 	var r1 error
-	if rf, ok := ret.Get(1).(func(uint32, string, uint8) error); ok {
-		r1 = rf(id, name, status)
+	if rf, ok := ret.Get(1).(func(context.Context, uint32, *model.Task) error); ok {
+		r1 = rf(ctx, id, task)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// UpdateWebhook provides a mock function with given fields: id, webhook
+func (_m *Service) UpdateWebhook(id uint32, webhook *model.Webhook) (*model.Webhook, error) {
+	ret := _m.Called(id, webhook)
+
+	var r0 *model.Webhook
+	if rf, ok := ret.Get(0).(func(uint32, *model.Webhook) *model.Webhook); ok {
+		r0 = rf(id, webhook)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.Webhook)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(uint32, *model.Webhook) error); ok {
+		r1 = rf(id, webhook)
 	} else {
 		r1 = ret.Error(1)
 	}
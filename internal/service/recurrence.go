@@ -0,0 +1,140 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"time"
+
+	"github.com/wagaru/task/internal/errcode"
+	"github.com/wagaru/task/internal/model"
+	"github.com/wagaru/task/internal/recurrence"
+)
+
+// maxOccurrenceNames bounds the names tried for a new occurrence before
+// giving up on DuplicateRecords.
+const maxOccurrenceNames = 100
+
+// occurrenceSuffix matches the date, and counter, spawnNext appends to the
+// name of an occurrence.
+var occurrenceSuffix = regexp.MustCompile(` \(\d{4}-\d{2}-\d{2}\)( #\d+)?$`)
+
+// normalizeRecurrence validates a recurrence rule and returns it in its
+// canonical RRULE form. An empty rule stays empty.
+func normalizeRecurrence(rule string) (string, error) {
+	if rule == "" {
+		return "", nil
+	}
+	parsed, err := recurrence.Parse(rule)
+	if err != nil {
+		return "", errcode.InvalidParams.WithDetails(fmt.Sprintf("recurrence: 無效的重複規則 (%v)", err))
+	}
+	return parsed.String(), nil
+}
+
+// normalizePatch returns patch with its recurrence rules, if any, in
+// canonical form. patch itself is left alone.
+func normalizePatch(patch *model.TaskPatch) (*model.TaskPatch, error) {
+	if patch.Recurrence == nil && (patch.Expect == nil || patch.Expect.Recurrence == nil) {
+		return patch, nil
+	}
+	normalized := *patch
+	if patch.Recurrence != nil {
+		rule, err := normalizeRecurrence(*patch.Recurrence)
+		if err != nil {
+			return nil, err
+		}
+		normalized.Recurrence = &rule
+	}
+	if patch.Expect != nil && patch.Expect.Recurrence != nil {
+		expect, err := normalizePatch(patch.Expect)
+		if err != nil {
+			return nil, err
+		}
+		normalized.Expect = expect
+	}
+	return &normalized, nil
+}
+
+// takeRecurrence moves the rule off a recurring task that has just become
+// done and returns it, so reopening and completing the task again does not
+// spawn a second occurrence.
+func takeRecurrence(task *model.Task, previous model.Status) string {
+	if task.Status != model.StatusDone || previous == model.StatusDone {
+		return ""
+	}
+	rule := task.Recurrence
+	task.Recurrence = ""
+	return rule
+}
+
+// spawnNext creates the occurrence following a completed task, due at the
+// next time of rule after the task's due date, or after its completion if it
+// had none. The rule, and the date in the name, follow the configured time
+// zone. The completed task is already saved, so failures are only logged.
+func (s *service) spawnNext(ctx context.Context, task *model.Task, rule string) *model.Task {
+	if rule == "" {
+		return nil
+	}
+	parsed, err := recurrence.Parse(rule)
+	if err != nil {
+		log.Printf("service: recurrence of task %d: %v", task.ID, err)
+		return nil
+	}
+	start := s.timestamp()
+	if task.DueAt != nil {
+		start = *task.DueAt
+	} else if task.CompletedAt != nil {
+		start = *task.CompletedAt
+	}
+	start = start.In(s.location)
+	local, ok := parsed.Next(start, start)
+	if !ok {
+		return nil
+	}
+	dueAt := local.UTC()
+	if parsed.Count > 0 {
+		parsed.Count--
+	}
+	now := s.timestamp()
+	next := &model.Task{
+		ProjectID:   task.ProjectID,
+		ParentID:    task.ParentID,
+		Status:      model.StatusTodo,
+		Description: task.Description,
+		Priority:    task.Priority,
+		DueAt:       &dueAt,
+		Recurrence:  parsed.String(),
+		Tags:        append([]string(nil), task.Tags...),
+		CreatedAt:   now,
+		UpdatedAt:   now,
+		CreatedBy:   subject(ctx),
+		UpdatedBy:   subject(ctx),
+	}
+	base := occurrenceSuffix.ReplaceAllString(task.Name, "")
+	for i := 1; i <= maxOccurrenceNames; i++ {
+		next.Name = occurrenceName(base, local, i)
+		created, err := s.createTask(next)
+		if err == nil {
+			return created
+		}
+		if !errors.Is(err, errcode.DuplicateRecords) {
+			log.Printf("service: next occurrence of task %d: %v", task.ID, err)
+			return nil
+		}
+	}
+	log.Printf("service: next occurrence of task %d: no free name", task.ID)
+	return nil
+}
+
+// occurrenceName names the nth candidate for an occurrence due at dueAt,
+// such as "water plants (2022-05-08)" or "water plants (2022-05-08) #2".
+func occurrenceName(base string, dueAt time.Time, n int) string {
+	name := fmt.Sprintf("%s (%s)", base, dueAt.Format("2006-01-02"))
+	if n > 1 {
+		name += fmt.Sprintf(" #%d", n)
+	}
+	return name
+}
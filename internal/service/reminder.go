@@ -0,0 +1,53 @@
+package service
+
+import (
+	"time"
+
+	"github.com/wagaru/task/internal/errcode"
+	"github.com/wagaru/task/internal/model"
+)
+
+// GetDueTasks lists the open tasks due before the given time, for the
+// reminder scheduler.
+func (s *service) GetDueTasks(before time.Time) ([]*model.Task, error) {
+	tasks, _, err := s.repo.GetTasks(&model.TaskQuery{DueBefore: &before})
+	if err != nil {
+		return nil, err
+	}
+	open := make([]*model.Task, 0, len(tasks))
+	for _, task := range tasks {
+		if !task.Status.Closed() {
+			open = append(open, task)
+		}
+	}
+	return open, nil
+}
+
+// FireReminder records on the task that reminder has been sent, so it is
+// not sent again. It fails with errcode.PatchTestFailed if the task has been
+// closed or given another due date since. UpdatedAt is left alone and no
+// event is emitted: this is bookkeeping rather than a change to the task.
+func (s *service) FireReminder(reminder *model.Reminder) (*model.Task, error) {
+	return s.repo.PatchTask(reminder.TaskID, func(task *model.Task) error {
+		if task.Status.Closed() || task.DueAt == nil || !task.DueAt.Equal(reminder.DueAt) {
+			return errcode.PatchTestFailed
+		}
+		at := reminder.At
+		if reminder.Kind == model.ReminderOverdue {
+			task.OverdueAt = &at
+		} else {
+			task.RemindedAt = &at
+		}
+		return nil
+	})
+}
+
+// resetReminders forgets the reminders sent for a task whose due date has
+// moved from dueAt.
+func resetReminders(task *model.Task, dueAt *time.Time) {
+	if task.DueAt == nil && dueAt == nil || task.DueAt != nil && dueAt != nil && task.DueAt.Equal(*dueAt) {
+		return
+	}
+	task.RemindedAt = nil
+	task.OverdueAt = nil
+}
@@ -1,44 +1,432 @@
 package service
 
 import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/wagaru/task/config"
+	"github.com/wagaru/task/internal/auth"
+	"github.com/wagaru/task/internal/errcode"
+	"github.com/wagaru/task/internal/event"
 	"github.com/wagaru/task/internal/model"
 	"github.com/wagaru/task/internal/repository"
 )
 
 type service struct {
-	repo repository.Repository
+	repo               repository.Repository
+	transitions        map[model.Status]map[model.Status]bool
+	autoCompleteParent bool
+	location           *time.Location
+	events             *event.Bus
+	webhooks           Webhooks
+	now                func() time.Time
+	// graph serializes the task writes of the API, so that two of them
+	// cannot pass their checks of blockers, parents or completion on the
+	// same state and together break it.
+	graph sync.Mutex
+	// writes holds each task write together with its event, so that events
+	// are numbered in the order their writes committed.
+	writes sync.Mutex
 }
 
 type Service interface {
-	GetTasks() ([]*model.Task, error)
+	GetTasks(query *model.TaskQuery) ([]*model.Task, string, error)
+	GetTaskTree(query *model.TaskQuery) ([]*model.TaskNode, error)
+	GetTaskOrder(query *model.TaskQuery) ([]*model.Task, error)
+	GetDueTasks(before time.Time) ([]*model.Task, error)
+	FireReminder(reminder *model.Reminder) (*model.Task, error)
 	GetTask(id uint32) (*model.Task, error)
-	CreateTask(name string) (*model.Task, error)
-	UpdateTask(id uint32, name string, status uint8) (*model.Task, error)
-	DeleteTask(uint32) error
+	CreateTask(ctx context.Context, task *model.Task) (*model.Task, error)
+	UpdateTask(ctx context.Context, id uint32, task *model.Task) (*model.Task, error)
+	PatchTask(ctx context.Context, id uint32, patch *model.TaskPatch) (*model.Task, error)
+	DeleteTask(ctx context.Context, id uint32) error
+	GetTags() ([]*model.Tag, error)
+	AddTaskTags(ctx context.Context, id uint32, tags []string) (*model.Task, error)
+	RemoveTaskTags(ctx context.Context, id uint32, tags []string) (*model.Task, error)
+	GetTaskDependencies(id uint32) (*model.TaskDependencies, error)
+	AddTaskBlockers(ctx context.Context, id uint32, blockers []uint32) (*model.Task, error)
+	RemoveTaskBlockers(ctx context.Context, id uint32, blockers []uint32) (*model.Task, error)
+	GetProjects() ([]*model.Project, error)
+	GetProject(id uint32) (*model.Project, error)
+	CreateProject(project *model.Project) (*model.Project, error)
+	UpdateProject(id uint32, project *model.Project) (*model.Project, error)
+	DeleteProject(id uint32, cascade bool) error
+	GetWebhooks() ([]*model.Webhook, error)
+	GetWebhook(id uint32) (*model.Webhook, error)
+	CreateWebhook(webhook *model.Webhook) (*model.Webhook, error)
+	UpdateWebhook(id uint32, webhook *model.Webhook) (*model.Webhook, error)
+	DeleteWebhook(id uint32) error
+	GetWebhookDeliveries(id uint32) ([]*model.WebhookDelivery, error)
+	GetDeadLetters() ([]*model.WebhookDelivery, error)
+	GetAPIKeys() ([]*model.APIKey, error)
+	CreateAPIKey(key *model.APIKey) (*model.APIKey, error)
+	DeleteAPIKey(id uint32) error
+	Authenticate(token string) (*auth.Principal, error)
+	SubscribeEvents(lastEventID uint64) *event.Subscription
 }
 
-func NewService(repo repository.Repository) Service {
-	return &service{
-		repo: repo,
+type Option func(*service)
+
+// WithEvents publishes task events on bus instead of a bus of the default
+// size.
+func WithEvents(bus *event.Bus) Option {
+	return func(s *service) { s.events = bus }
+}
+
+// WithWebhooks has the service publish task events to webhooks.
+func WithWebhooks(webhooks Webhooks) Option {
+	return func(s *service) { s.webhooks = webhooks }
+}
+
+// NewService fails when conf names a status or time zone that does not
+// exist.
+func NewService(repo repository.Repository, conf *config.TaskConfig, opts ...Option) (Service, error) {
+	svc := &service{
+		repo:     repo,
+		location: time.UTC,
+		now:      time.Now,
+	}
+	for _, opt := range opts {
+		opt(svc)
+	}
+	if svc.events == nil {
+		svc.events = event.NewBus(0)
+	}
+	if conf != nil {
+		svc.autoCompleteParent = conf.AutoCompleteParent
+		location, err := time.LoadLocation(conf.TimeZone)
+		if err != nil {
+			return nil, fmt.Errorf("Task.TimeZone: %w", err)
+		}
+		svc.location = location
 	}
+	if conf != nil && len(conf.Transitions) > 0 {
+		svc.transitions = make(map[model.Status]map[model.Status]bool)
+		for from, tos := range conf.Transitions {
+			status, err := model.ParseStatus(from)
+			if err != nil {
+				return nil, fmt.Errorf("Task.Transitions: %w", err)
+			}
+			allowed := make(map[model.Status]bool)
+			for _, to := range tos {
+				next, err := model.ParseStatus(to)
+				if err != nil {
+					return nil, fmt.Errorf("Task.Transitions.%s: %w", from, err)
+				}
+				allowed[next] = true
+			}
+			svc.transitions[status] = allowed
+		}
+	}
+	return svc, nil
 }
 
-func (s *service) GetTasks() ([]*model.Task, error) {
-	return s.repo.GetTasks()
+// GetTasks lists tasks; filtering by a project or parent that does not exist
+// is reported as such rather than as an empty page.
+func (s *service) GetTasks(query *model.TaskQuery) ([]*model.Task, string, error) {
+	if err := s.checkQuery(query); err != nil {
+		return nil, "", err
+	}
+	return s.repo.GetTasks(query)
 }
 
 func (s *service) GetTask(id uint32) (*model.Task, error) {
 	return s.repo.GetTask(id)
 }
 
-func (s *service) CreateTask(name string) (*model.Task, error) {
-	return s.repo.CreateTask(name)
+func (s *service) CreateTask(ctx context.Context, task *model.Task) (*model.Task, error) {
+	s.graph.Lock()
+	defer s.graph.Unlock()
+	created := *task
+	now := s.timestamp()
+	created.CreatedAt = now
+	created.UpdatedAt = now
+	created.CreatedBy = subject(ctx)
+	created.UpdatedBy = created.CreatedBy
+	created.DueAt = utcTime(task.DueAt)
+	created.Tags = model.NormalizeTags(task.Tags)
+	created.BlockedBy = model.NormalizeIDs(task.BlockedBy)
+	created.CompletedAt = nil
+	created.RemindedAt = nil
+	created.OverdueAt = nil
+	rule, err := normalizeRecurrence(task.Recurrence)
+	if err != nil {
+		return nil, err
+	}
+	created.Recurrence = rule
+	if created.Status == "" {
+		created.Status = model.StatusTodo
+	}
+	if created.Status == model.StatusDone {
+		created.CompletedAt = &now
+	}
+	if err := s.checkParent(0, created.ProjectID, created.ParentID); err != nil {
+		return nil, err
+	}
+	if err := s.checkBlockers(0, created.ProjectID, created.BlockedBy); err != nil {
+		return nil, err
+	}
+	if created.Status == model.StatusDone {
+		if err := s.checkOpenBlockers(&created); err != nil {
+			return nil, err
+		}
+	}
+	return s.createTask(&created)
+}
+
+// UpdateTask replaces every writable field of the task. Tags, blockers and
+// the parent are managed separately and left as they are. Completing a
+// recurring task creates its next occurrence.
+func (s *service) UpdateTask(ctx context.Context, id uint32, task *model.Task) (*model.Task, error) {
+	s.graph.Lock()
+	defer s.graph.Unlock()
+	rule, err := normalizeRecurrence(task.Recurrence)
+	if err != nil {
+		return nil, err
+	}
+	if task.Status == model.StatusDone {
+		if err := s.checkCompletable(id); err != nil {
+			return nil, err
+		}
+	}
+	var status model.Status
+	var next string
+	updated, err := s.patchTask(id, func(current *model.Task) error {
+		status = current.Status
+		dueAt := current.DueAt
+		if err := s.checkTransition(status, task.Status); err != nil {
+			return err
+		}
+		current.Name = task.Name
+		current.Status = task.Status
+		current.Description = task.Description
+		current.Priority = task.Priority
+		current.DueAt = utcTime(task.DueAt)
+		current.Recurrence = rule
+		resetReminders(current, dueAt)
+		s.touch(ctx, current, status)
+		next = takeRecurrence(current, status)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.spawnNext(ctx, updated, next)
+	s.completeParents(ctx, updated, status)
+	return updated, nil
+}
+
+func (s *service) PatchTask(ctx context.Context, id uint32, patch *model.TaskPatch) (*model.Task, error) {
+	s.graph.Lock()
+	defer s.graph.Unlock()
+	patch, err := normalizePatch(patch)
+	if err != nil {
+		return nil, err
+	}
+	if patch.ParentID != nil && *patch.ParentID != 0 {
+		current, err := s.repo.GetTask(id)
+		if err != nil {
+			return nil, err
+		}
+		if err := s.checkParent(id, current.ProjectID, *patch.ParentID); err != nil {
+			return nil, err
+		}
+	}
+	if patch.Status != nil && *patch.Status == model.StatusDone {
+		if err := s.checkCompletable(id); err != nil {
+			return nil, err
+		}
+	}
+	var status model.Status
+	var next string
+	updated, err := s.patchTask(id, func(task *model.Task) error {
+		if patch.Expect != nil && !patch.Expect.Matches(task) {
+			return errcode.PatchTestFailed
+		}
+		status = task.Status
+		if patch.Status != nil {
+			if err := s.checkTransition(status, *patch.Status); err != nil {
+				return err
+			}
+		}
+		dueAt := task.DueAt
+		patch.Apply(task)
+		resetReminders(task, dueAt)
+		s.touch(ctx, task, status)
+		next = takeRecurrence(task, status)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.spawnNext(ctx, updated, next)
+	s.completeParents(ctx, updated, status)
+	return updated, nil
+}
+
+// DeleteTask removes a task. Its subtasks are not deleted but move up to the
+// task's own parent, and the tasks it blocked no longer wait on it. Deleting
+// the last open subtask completes the parent like closing it would.
+func (s *service) DeleteTask(ctx context.Context, id uint32) error {
+	s.graph.Lock()
+	defer s.graph.Unlock()
+	task, err := s.repo.GetTask(id)
+	if err != nil {
+		return err
+	}
+	children, _, err := s.repo.GetTasks(&model.TaskQuery{ParentID: &id})
+	if err != nil {
+		return err
+	}
+	for _, child := range children {
+		_, err := s.patchTask(child.ID, func(child *model.Task) error {
+			child.ParentID = task.ParentID
+			s.touch(ctx, child, child.Status)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if err := s.unblock(ctx, id); err != nil {
+		return err
+	}
+	if err := s.deleteTask(task); err != nil {
+		return err
+	}
+	if !task.Status.Closed() {
+		s.completeAncestors(ctx, task.ParentID)
+	}
+	return nil
+}
+
+func (s *service) GetProjects() ([]*model.Project, error) {
+	return s.repo.GetProjects()
+}
+
+func (s *service) GetProject(id uint32) (*model.Project, error) {
+	return s.repo.GetProject(id)
+}
+
+func (s *service) CreateProject(project *model.Project) (*model.Project, error) {
+	created := *project
+	now := s.timestamp()
+	created.CreatedAt = now
+	created.UpdatedAt = now
+	return s.repo.CreateProject(&created)
 }
 
-func (s *service) UpdateTask(id uint32, name string, status uint8) (*model.Task, error) {
-	return s.repo.UpdateTask(id, name, status)
+func (s *service) UpdateProject(id uint32, project *model.Project) (*model.Project, error) {
+	return s.repo.PatchProject(id, func(current *model.Project) error {
+		current.Name = project.Name
+		current.Description = project.Description
+		current.UpdatedAt = s.timestamp()
+		return nil
+	})
 }
 
-func (s *service) DeleteTask(id uint32) error {
-	return s.repo.DeleteTask(id)
+// DeleteProject emits a deleted event for each task a cascade removes. The
+// tasks are listed under the same locks as the delete, so that none can be
+// created in or moved to the project in between.
+func (s *service) DeleteProject(id uint32, cascade bool) error {
+	s.graph.Lock()
+	defer s.graph.Unlock()
+	s.writes.Lock()
+	defer s.writes.Unlock()
+	var tasks []*model.Task
+	if cascade {
+		var err error
+		if tasks, _, err = s.repo.GetTasks(&model.TaskQuery{ProjectID: &id}); err != nil {
+			return err
+		}
+	}
+	if err := s.repo.DeleteProject(id, cascade); err != nil {
+		return err
+	}
+	for _, task := range tasks {
+		s.emit(model.EventTaskDeleted, task)
+	}
+	return nil
+}
+
+func (s *service) GetTags() ([]*model.Tag, error) {
+	return s.repo.GetTags()
+}
+
+func (s *service) AddTaskTags(ctx context.Context, id uint32, tags []string) (*model.Task, error) {
+	s.graph.Lock()
+	defer s.graph.Unlock()
+	return s.patchTask(id, func(task *model.Task) error {
+		task.Tags = model.NormalizeTags(append(append([]string{}, task.Tags...), tags...))
+		s.touch(ctx, task, task.Status)
+		return nil
+	})
+}
+
+func (s *service) RemoveTaskTags(ctx context.Context, id uint32, tags []string) (*model.Task, error) {
+	remove := make(map[string]bool)
+	for _, tag := range model.NormalizeTags(tags) {
+		remove[tag] = true
+	}
+	s.graph.Lock()
+	defer s.graph.Unlock()
+	return s.patchTask(id, func(task *model.Task) error {
+		var kept []string
+		for _, tag := range task.Tags {
+			if !remove[tag] {
+				kept = append(kept, tag)
+			}
+		}
+		task.Tags = kept
+		s.touch(ctx, task, task.Status)
+		return nil
+	})
+}
+
+// timestamp is the current time as stored: UTC, without the monotonic
+// reading, so it survives a round trip through any backend unchanged.
+func (s *service) timestamp() time.Time {
+	return s.now().UTC().Round(0)
+}
+
+// checkTransition enforces the configured workflow. Without one every
+// transition is allowed; staying in the same status always is.
+func (s *service) checkTransition(from, to model.Status) error {
+	if s.transitions == nil || from == to || s.transitions[from][to] {
+		return nil
+	}
+	return errcode.IllegalTransition.WithDetails(fmt.Sprintf("status: 不能從 %s 變更為 %s", from, to))
+}
+
+// touch maintains updated_at, updated_by and completed_at after a change by
+// the caller of ctx from the previous status.
+func (s *service) touch(ctx context.Context, task *model.Task, previous model.Status) {
+	now := s.timestamp()
+	task.UpdatedAt = now
+	task.UpdatedBy = subject(ctx)
+	switch {
+	case task.Status != model.StatusDone:
+		task.CompletedAt = nil
+	case previous != model.StatusDone:
+		task.CompletedAt = &now
+	}
+}
+
+// subject is who the caller of ctx is, empty when the request was not
+// authenticated.
+func subject(ctx context.Context) string {
+	if p, ok := auth.FromContext(ctx); ok {
+		return p.Subject
+	}
+	return ""
+}
+
+func utcTime(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	u := t.UTC()
+	return &u
 }
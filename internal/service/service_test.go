@@ -1,10 +1,24 @@
 package service
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"github.com/wagaru/task/config"
+	"github.com/wagaru/task/internal/auth"
+	"github.com/wagaru/task/internal/errcode"
 	"github.com/wagaru/task/internal/model"
+	"github.com/wagaru/task/internal/repository"
 	"github.com/wagaru/task/internal/repository/mocks"
 )
 
@@ -14,19 +28,21 @@ func TestGetTasks(t *testing.T) {
 		{
 			ID:     1,
 			Name:   "task1",
-			Status: 0,
+			Status: model.StatusTodo,
 		},
 		{
 			ID:     2,
 			Name:   "task2",
-			Status: 1,
+			Status: model.StatusDone,
 		},
 	}
-	mockRepo.On("GetTasks").Return(tasks, nil).Once()
-	svc := NewService(mockRepo)
-	result, err := svc.GetTasks()
+	query := &model.TaskQuery{Limit: 2}
+	mockRepo.On("GetTasks", query).Return(tasks, "next", nil).Once()
+	svc := newService(t, mockRepo, &config.TaskConfig{})
+	result, next, err := svc.GetTasks(query)
 	assert.NoError(t, err)
 	assert.Equal(t, result, tasks)
+	assert.Equal(t, "next", next)
 	mockRepo.AssertExpectations(t)
 }
 
@@ -35,49 +51,1316 @@ func TestGetTask(t *testing.T) {
 	task := &model.Task{
 		ID:     1,
 		Name:   "task",
-		Status: 0,
+		Status: model.StatusTodo,
 	}
 	mockRepo.On("GetTask", task.ID).Return(task, nil).Once()
-	svc := NewService(mockRepo)
+	svc := newService(t, mockRepo, &config.TaskConfig{})
 	result, err := svc.GetTask(task.ID)
 	assert.NoError(t, err)
 	assert.Equal(t, result, task)
 	mockRepo.AssertExpectations(t)
 }
+
+var testNow = time.Date(2022, 5, 1, 8, 0, 0, 0, time.UTC)
+
+func newTestService(repo *mocks.Repository) *service {
+	s, _ := NewService(repo, &config.TaskConfig{})
+	svc := s.(*service)
+	svc.now = func() time.Time { return testNow }
+	return svc
+}
+
+func newService(t *testing.T, repo repository.Repository, conf *config.TaskConfig, opts ...Option) *service {
+	svc, err := NewService(repo, conf, opts...)
+	require.NoError(t, err)
+	return svc.(*service)
+}
+
+// slowReads widens the window between the checks of the service and its
+// writes, so that tests of concurrent writes fail without serialization.
+type slowReads struct {
+	repository.Repository
+}
+
+func (r slowReads) GetTask(id uint32) (*model.Task, error) {
+	defer time.Sleep(time.Millisecond)
+	return r.Repository.GetTask(id)
+}
+
+// slowWrites delays the return of each task write by up to a millisecond,
+// so that tests of concurrent writes see them finish out of order.
+type slowWrites struct {
+	repository.Repository
+}
+
+func (r slowWrites) PatchTask(id uint32, apply func(*model.Task) error) (*model.Task, error) {
+	defer time.Sleep(time.Duration(rand.Intn(1000)) * time.Microsecond)
+	return r.Repository.PatchTask(id, apply)
+}
+
+// slowLists signals listed after each task listing, then waits a
+// millisecond before returning it.
+type slowLists struct {
+	repository.Repository
+	listed chan struct{}
+}
+
+func (r slowLists) GetTasks(query *model.TaskQuery) ([]*model.Task, string, error) {
+	defer time.Sleep(time.Millisecond)
+	defer func() {
+		select {
+		case r.listed <- struct{}{}:
+		default:
+		}
+	}()
+	return r.Repository.GetTasks(query)
+}
+
+// onPatchTask makes the mock repository run the callback against a copy of
+// stored, as the real backends do.
+func onPatchTask(repo *mocks.Repository, stored *model.Task) *mock.Call {
+	patched := func(id uint32, apply func(*model.Task) error) (*model.Task, error) {
+		result := *stored
+		if err := apply(&result); err != nil {
+			return nil, err
+		}
+		return &result, nil
+	}
+	return repo.On("PatchTask", stored.ID, mock.Anything).Return(
+		func(id uint32, apply func(*model.Task) error) *model.Task {
+			result, _ := patched(id, apply)
+			return result
+		},
+		func(id uint32, apply func(*model.Task) error) error {
+			_, err := patched(id, apply)
+			return err
+		},
+	)
+}
+
 func TestCreateTask(t *testing.T) {
 	mockRepo := new(mocks.Repository)
+	dueAt := time.Date(2022, 5, 2, 8, 0, 0, 0, time.FixedZone("UTC+8", 8*60*60))
+	utcDueAt := dueAt.UTC()
 	task := &model.Task{
-		ID:     1,
-		Name:   "task",
-		Status: 0,
+		ID:          1,
+		Name:        "task",
+		Status:      model.StatusTodo,
+		Description: "description",
+		Priority:    3,
+		DueAt:       &utcDueAt,
+		CreatedAt:   testNow,
+		UpdatedAt:   testNow,
+	}
+	input := &model.Task{
+		Name:        task.Name,
+		Description: task.Description,
+		Priority:    task.Priority,
+		DueAt:       &dueAt,
 	}
-	mockRepo.On("CreateTask", task.Name).Return(task, nil).Once()
-	svc := NewService(mockRepo)
-	result, err := svc.CreateTask(task.Name)
+	mockRepo.On("CreateTask", &model.Task{
+		Name:        task.Name,
+		Status:      model.StatusTodo,
+		Description: task.Description,
+		Priority:    task.Priority,
+		DueAt:       &utcDueAt,
+		CreatedAt:   testNow,
+		UpdatedAt:   testNow,
+	}).Return(task, nil).Once()
+	svc := newTestService(mockRepo)
+	result, err := svc.CreateTask(context.Background(), input)
 	assert.NoError(t, err)
 	assert.Equal(t, result, task)
 	mockRepo.AssertExpectations(t)
 }
+
 func TestUpdateTask(t *testing.T) {
-	mockRepo := new(mocks.Repository)
+	created := testNow.Add(-time.Hour)
+	stored := &model.Task{
+		ID:        1,
+		Name:      "task",
+		Status:    model.StatusTodo,
+		Priority:  1,
+		CreatedAt: created,
+		UpdatedAt: created,
+	}
+	t.Run("Complete", func(t *testing.T) {
+		mockRepo := new(mocks.Repository)
+		mockRepo.On("GetTask", stored.ID).Return(stored, nil).Once()
+		onPatchTask(mockRepo, stored).Once()
+		svc := newTestService(mockRepo)
+		result, err := svc.UpdateTask(context.Background(), stored.ID, &model.Task{Name: "task_rename", Status: model.StatusDone})
+		assert.NoError(t, err)
+		assert.Equal(t, &model.Task{
+			ID:          stored.ID,
+			Name:        "task_rename",
+			Status:      model.StatusDone,
+			CreatedAt:   created,
+			UpdatedAt:   testNow,
+			CompletedAt: &testNow,
+		}, result)
+		mockRepo.AssertExpectations(t)
+	})
+	t.Run("Reopen", func(t *testing.T) {
+		done := *stored
+		done.Status = model.StatusDone
+		done.CompletedAt = &created
+		mockRepo := new(mocks.Repository)
+		onPatchTask(mockRepo, &done).Once()
+		svc := newTestService(mockRepo)
+		result, err := svc.UpdateTask(context.Background(), stored.ID, &model.Task{Name: stored.Name, Status: model.StatusTodo})
+		assert.NoError(t, err)
+		assert.Nil(t, result.CompletedAt)
+		assert.Equal(t, testNow, result.UpdatedAt)
+		mockRepo.AssertExpectations(t)
+	})
+}
+
+func TestPatchTask(t *testing.T) {
 	task := &model.Task{
 		ID:     1,
 		Name:   "task",
-		Status: 0,
+		Status: model.StatusTodo,
 	}
-	mockRepo.On("UpdateTask", task.ID, task.Name, task.Status).Return(task, nil).Once()
-	svc := NewService(mockRepo)
-	result, err := svc.UpdateTask(task.ID, task.Name, task.Status)
-	assert.NoError(t, err)
-	assert.Equal(t, result, task)
-	mockRepo.AssertExpectations(t)
+	t.Run("Success", func(t *testing.T) {
+		mockRepo := new(mocks.Repository)
+		mockRepo.On("GetTask", task.ID).Return(task, nil).Once()
+		onPatchTask(mockRepo, task).Once()
+		svc := newTestService(mockRepo)
+		status := model.StatusDone
+		result, err := svc.PatchTask(context.Background(), task.ID, &model.TaskPatch{Status: &status, Expect: &model.TaskPatch{Name: &task.Name}})
+		assert.NoError(t, err)
+		assert.Equal(t, &model.Task{ID: task.ID, Name: task.Name, Status: status, UpdatedAt: testNow, CompletedAt: &testNow}, result)
+		mockRepo.AssertExpectations(t)
+	})
+	t.Run("DueAt", func(t *testing.T) {
+		dueAt := testNow.Add(time.Hour)
+		stored := *task
+		stored.DueAt = &dueAt
+		mockRepo := new(mocks.Repository)
+		onPatchTask(mockRepo, &stored).Once()
+		svc := newTestService(mockRepo)
+		result, err := svc.PatchTask(context.Background(), task.ID, &model.TaskPatch{DueAt: &time.Time{}, Expect: &model.TaskPatch{DueAt: &dueAt}})
+		assert.NoError(t, err)
+		assert.Nil(t, result.DueAt)
+		mockRepo.AssertExpectations(t)
+	})
+	t.Run("PatchTestFailed", func(t *testing.T) {
+		mockRepo := new(mocks.Repository)
+		onPatchTask(mockRepo, task).Once()
+		svc := newTestService(mockRepo)
+		name := "other"
+		result, err := svc.PatchTask(context.Background(), task.ID, &model.TaskPatch{Name: &name, Expect: &model.TaskPatch{Name: &name}})
+		assert.Nil(t, result)
+		assert.ErrorIs(t, err, errcode.PatchTestFailed)
+		mockRepo.AssertExpectations(t)
+	})
+}
+
+func TestTransitions(t *testing.T) {
+	task := &model.Task{
+		ID:     1,
+		Name:   "task",
+		Status: model.StatusDone,
+	}
+	conf := &config.TaskConfig{Transitions: map[string][]string{
+		"todo": {"done"},
+		"done": {"todo"},
+	}}
+	t.Run("Allowed", func(t *testing.T) {
+		mockRepo := new(mocks.Repository)
+		onPatchTask(mockRepo, task).Once()
+		svc := newService(t, mockRepo, conf)
+		status := model.StatusTodo
+		result, err := svc.PatchTask(context.Background(), task.ID, &model.TaskPatch{Status: &status})
+		assert.NoError(t, err)
+		assert.Equal(t, status, result.Status)
+		mockRepo.AssertExpectations(t)
+	})
+	t.Run("Unchanged", func(t *testing.T) {
+		mockRepo := new(mocks.Repository)
+		mockRepo.On("GetTask", task.ID).Return(task, nil).Once()
+		onPatchTask(mockRepo, task).Once()
+		svc := newService(t, mockRepo, conf)
+		result, err := svc.UpdateTask(context.Background(), task.ID, &model.Task{Name: "task_rename", Status: model.StatusDone})
+		assert.NoError(t, err)
+		assert.Equal(t, "task_rename", result.Name)
+		mockRepo.AssertExpectations(t)
+	})
+	t.Run("IllegalTransition", func(t *testing.T) {
+		mockRepo := new(mocks.Repository)
+		onPatchTask(mockRepo, task).Once()
+		svc := newService(t, mockRepo, conf)
+		status := model.StatusCancelled
+		result, err := svc.PatchTask(context.Background(), task.ID, &model.TaskPatch{Status: &status})
+		assert.Nil(t, result)
+		assert.ErrorIs(t, err, errcode.IllegalTransition)
+		assert.Equal(t, []string{"status: 不能從 done 變更為 cancelled"}, err.(*errcode.Error).Details())
+		mockRepo.AssertExpectations(t)
+	})
+	t.Run("UnknownStatus", func(t *testing.T) {
+		tests := []struct {
+			transitions map[string][]string
+			err         string
+		}{
+			{map[string][]string{"todo": {"doen"}}, `Task.Transitions.todo: unknown status "doen"`},
+			{map[string][]string{"in-progress": {"done"}}, `Task.Transitions: unknown status "in-progress"`},
+		}
+		for _, tt := range tests {
+			_, err := NewService(new(mocks.Repository), &config.TaskConfig{Transitions: tt.transitions})
+			assert.EqualError(t, err, tt.err)
+		}
+	})
+}
+
+func TestTaskTags(t *testing.T) {
+	task := &model.Task{
+		ID:     1,
+		Name:   "task",
+		Status: model.StatusTodo,
+		Tags:   []string{"home", "work"},
+	}
+	t.Run("Add", func(t *testing.T) {
+		mockRepo := new(mocks.Repository)
+		onPatchTask(mockRepo, task).Once()
+		svc := newTestService(mockRepo)
+		result, err := svc.AddTaskTags(context.Background(), task.ID, []string{" Urgent ", "home"})
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"home", "urgent", "work"}, result.Tags)
+		assert.Equal(t, testNow, result.UpdatedAt)
+		assert.Equal(t, []string{"home", "work"}, task.Tags)
+		mockRepo.AssertExpectations(t)
+	})
+	t.Run("Remove", func(t *testing.T) {
+		mockRepo := new(mocks.Repository)
+		onPatchTask(mockRepo, task).Once()
+		svc := newTestService(mockRepo)
+		result, err := svc.RemoveTaskTags(context.Background(), task.ID, []string{"HOME", "other"})
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"work"}, result.Tags)
+		mockRepo.AssertExpectations(t)
+	})
+	t.Run("GetTags", func(t *testing.T) {
+		tags := []*model.Tag{{Name: "home", Count: 1}}
+		mockRepo := new(mocks.Repository)
+		mockRepo.On("GetTags").Return(tags, nil).Once()
+		svc := newTestService(mockRepo)
+		result, err := svc.GetTags()
+		assert.NoError(t, err)
+		assert.Equal(t, tags, result)
+		mockRepo.AssertExpectations(t)
+	})
 }
+
+func TestProjects(t *testing.T) {
+	created := testNow.Add(-time.Hour)
+	project := &model.Project{
+		ID:        1,
+		Name:      "project",
+		CreatedAt: created,
+		UpdatedAt: created,
+	}
+	t.Run("Create", func(t *testing.T) {
+		mockRepo := new(mocks.Repository)
+		mockRepo.On("CreateProject", &model.Project{Name: "project", Description: "description", CreatedAt: testNow, UpdatedAt: testNow}).
+			Return(project, nil).Once()
+		svc := newTestService(mockRepo)
+		result, err := svc.CreateProject(&model.Project{Name: "project", Description: "description"})
+		assert.NoError(t, err)
+		assert.Equal(t, project, result)
+		mockRepo.AssertExpectations(t)
+	})
+	t.Run("Update", func(t *testing.T) {
+		mockRepo := new(mocks.Repository)
+		mockRepo.On("PatchProject", project.ID, mock.Anything).Return(
+			func(id uint32, apply func(*model.Project) error) *model.Project {
+				result := *project
+				_ = apply(&result)
+				return &result
+			},
+			nil,
+		).Once()
+		svc := newTestService(mockRepo)
+		result, err := svc.UpdateProject(project.ID, &model.Project{Name: "project_rename"})
+		assert.NoError(t, err)
+		assert.Equal(t, &model.Project{ID: project.ID, Name: "project_rename", CreatedAt: created, UpdatedAt: testNow}, result)
+		mockRepo.AssertExpectations(t)
+	})
+	t.Run("GetTasks", func(t *testing.T) {
+		mockRepo := new(mocks.Repository)
+		query := &model.TaskQuery{ProjectID: &project.ID}
+		mockRepo.On("GetProject", project.ID).Return(project, nil).Once()
+		mockRepo.On("GetTasks", query).Return([]*model.Task{}, "", nil).Once()
+		svc := newTestService(mockRepo)
+		_, _, err := svc.GetTasks(query)
+		assert.NoError(t, err)
+		mockRepo.AssertExpectations(t)
+	})
+	t.Run("GetTasksRecordNotExists", func(t *testing.T) {
+		mockRepo := new(mocks.Repository)
+		id := uint32(2)
+		mockRepo.On("GetProject", id).Return(nil, errcode.RecordNotExists).Once()
+		svc := newTestService(mockRepo)
+		result, _, err := svc.GetTasks(&model.TaskQuery{ProjectID: &id})
+		assert.Nil(t, result)
+		assert.ErrorIs(t, err, errcode.RecordNotExists)
+		mockRepo.AssertExpectations(t)
+		mockRepo.AssertNotCalled(t, "GetTasks", mock.Anything)
+	})
+	t.Run("Delete", func(t *testing.T) {
+		mockRepo := new(mocks.Repository)
+		mockRepo.On("GetTasks", &model.TaskQuery{ProjectID: &project.ID}).Return([]*model.Task{}, "", nil).Once()
+		mockRepo.On("DeleteProject", project.ID, true).Return(nil).Once()
+		svc := newTestService(mockRepo)
+		assert.NoError(t, svc.DeleteProject(project.ID, true))
+		mockRepo.AssertExpectations(t)
+	})
+}
+
 func TestDeleteTask(t *testing.T) {
-	id := uint32(1)
-	mockRepo := new(mocks.Repository)
-	mockRepo.On("DeleteTask", id).Return(nil).Once()
-	svc := NewService(mockRepo)
-	err := svc.DeleteTask(id)
-	assert.NoError(t, err)
-	mockRepo.AssertExpectations(t)
+	t.Run("Success", func(t *testing.T) {
+		id := uint32(1)
+		mockRepo := new(mocks.Repository)
+		mockRepo.On("GetTask", id).Return(&model.Task{ID: id}, nil).Once()
+		mockRepo.On("GetTasks", &model.TaskQuery{ParentID: &id}).Return([]*model.Task{}, "", nil).Once()
+		mockRepo.On("GetTasks", &model.TaskQuery{Blocker: &id}).Return([]*model.Task{}, "", nil).Once()
+		mockRepo.On("DeleteTask", id).Return(nil).Once()
+		svc := newService(t, mockRepo, &config.TaskConfig{})
+		err := svc.DeleteTask(context.Background(), id)
+		assert.NoError(t, err)
+		mockRepo.AssertExpectations(t)
+	})
+	t.Run("Children", func(t *testing.T) {
+		task := &model.Task{ID: 2, ParentID: 1}
+		child := &model.Task{ID: 3, ParentID: task.ID}
+		mockRepo := new(mocks.Repository)
+		mockRepo.On("GetTask", task.ID).Return(task, nil).Once()
+		mockRepo.On("GetTasks", &model.TaskQuery{ParentID: &task.ID}).Return([]*model.Task{child}, "", nil).Once()
+		mockRepo.On("GetTasks", &model.TaskQuery{Blocker: &task.ID}).Return([]*model.Task{}, "", nil).Once()
+		var moved *model.Task
+		onPatchTask(mockRepo, child).Run(func(args mock.Arguments) {
+			result := *child
+			_ = args.Get(1).(func(*model.Task) error)(&result)
+			moved = &result
+		}).Once()
+		mockRepo.On("DeleteTask", task.ID).Return(nil).Once()
+		svc := newTestService(mockRepo)
+		err := svc.DeleteTask(context.Background(), task.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, task.ParentID, moved.ParentID)
+		mockRepo.AssertExpectations(t)
+	})
+	t.Run("RecordNotExists", func(t *testing.T) {
+		mockRepo := new(mocks.Repository)
+		mockRepo.On("GetTask", uint32(9)).Return(nil, errcode.RecordNotExists).Once()
+		svc := newService(t, mockRepo, &config.TaskConfig{})
+		assert.ErrorIs(t, svc.DeleteTask(context.Background(), 9), errcode.RecordNotExists)
+		mockRepo.AssertExpectations(t)
+	})
+}
+
+func TestSubtasks(t *testing.T) {
+	// 1 ─┬─ 2 ── 3
+	//    └─ 4
+	tasks := map[uint32]*model.Task{
+		1: {ID: 1, Name: "task1", Status: model.StatusTodo},
+		2: {ID: 2, Name: "task2", Status: model.StatusTodo, ParentID: 1},
+		3: {ID: 3, Name: "task3", Status: model.StatusTodo, ParentID: 2},
+		4: {ID: 4, Name: "task4", Status: model.StatusDone, ParentID: 1},
+		5: {ID: 5, Name: "task5", Status: model.StatusTodo, ProjectID: 1},
+	}
+	newRepo := func() *mocks.Repository {
+		mockRepo := new(mocks.Repository)
+		for id, task := range tasks {
+			mockRepo.On("GetTask", id).Return(task, nil).Maybe()
+		}
+		mockRepo.On("GetTask", uint32(9)).Return(nil, errcode.RecordNotExists).Maybe()
+		return mockRepo
+	}
+	parentPatch := func(id uint32) *model.TaskPatch {
+		return &model.TaskPatch{ParentID: &id}
+	}
+	t.Run("CreateTask", func(t *testing.T) {
+		mockRepo := newRepo()
+		mockRepo.On("CreateTask", mock.Anything).Return(tasks[3], nil).Once()
+		svc := newTestService(mockRepo)
+		_, err := svc.CreateTask(context.Background(), &model.Task{Name: "task", ParentID: 2})
+		assert.NoError(t, err)
+
+		_, err = svc.CreateTask(context.Background(), &model.Task{Name: "task", ParentID: 9})
+		assert.ErrorIs(t, err, errcode.RecordNotExists)
+		_, err = svc.CreateTask(context.Background(), &model.Task{Name: "task", ParentID: 2, ProjectID: 1})
+		assert.ErrorIs(t, err, errcode.InvalidParams)
+		mockRepo.AssertExpectations(t)
+	})
+	t.Run("Cycle", func(t *testing.T) {
+		mockRepo := newRepo()
+		svc := newTestService(mockRepo)
+		for _, tt := range []struct{ id, parent uint32 }{{1, 1}, {1, 2}, {1, 3}, {2, 3}} {
+			result, err := svc.PatchTask(context.Background(), tt.id, parentPatch(tt.parent))
+			assert.Nil(t, result)
+			assert.ErrorIs(t, err, errcode.CycleDetected, "%d under %d", tt.id, tt.parent)
+		}
+		mockRepo.AssertNotCalled(t, "PatchTask", mock.Anything, mock.Anything)
+	})
+	t.Run("Move", func(t *testing.T) {
+		mockRepo := newRepo()
+		onPatchTask(mockRepo, tasks[3]).Once()
+		svc := newTestService(mockRepo)
+		result, err := svc.PatchTask(context.Background(), 3, parentPatch(4))
+		assert.NoError(t, err)
+		assert.Equal(t, uint32(4), result.ParentID)
+		mockRepo.AssertExpectations(t)
+	})
+	t.Run("AutoCompleteParent", func(t *testing.T) {
+		mockRepo := newRepo()
+		onPatchTask(mockRepo, tasks[3]).Once()
+		onPatchTask(mockRepo, tasks[2]).Once()
+		onPatchTask(mockRepo, tasks[1]).Once()
+		done := func(task *model.Task) *model.Task {
+			result := *task
+			result.Status = model.StatusDone
+			return &result
+		}
+		mockRepo.On("GetTasks", &model.TaskQuery{ParentID: &tasks[2].ID}).Return([]*model.Task{done(tasks[3])}, "", nil).Once()
+		mockRepo.On("GetTasks", &model.TaskQuery{ParentID: &tasks[1].ID}).Return([]*model.Task{done(tasks[2]), tasks[4]}, "", nil).Once()
+		svc := newService(t, mockRepo, &config.TaskConfig{AutoCompleteParent: true})
+		svc.now = func() time.Time { return testNow }
+		status := model.StatusDone
+		result, err := svc.PatchTask(context.Background(), 3, &model.TaskPatch{Status: &status})
+		assert.NoError(t, err)
+		assert.Equal(t, model.StatusDone, result.Status)
+		mockRepo.AssertExpectations(t)
+	})
+	t.Run("AutoCompleteOpenSibling", func(t *testing.T) {
+		mockRepo := newRepo()
+		open := *tasks[4]
+		open.Status = model.StatusInProgress
+		onPatchTask(mockRepo, &open).Once()
+		mockRepo.On("GetTasks", &model.TaskQuery{ParentID: &tasks[1].ID}).Return([]*model.Task{tasks[2], tasks[4]}, "", nil).Once()
+		svc := newService(t, mockRepo, &config.TaskConfig{AutoCompleteParent: true})
+		svc.now = func() time.Time { return testNow }
+		status := model.StatusDone
+		_, err := svc.PatchTask(context.Background(), 4, &model.TaskPatch{Status: &status})
+		assert.NoError(t, err)
+		mockRepo.AssertExpectations(t)
+		mockRepo.AssertNumberOfCalls(t, "PatchTask", 1)
+	})
+	t.Run("AutoCompleteOnCloseOrDelete", func(t *testing.T) {
+		svc := newService(t, repository.NewRepository(), &config.TaskConfig{AutoCompleteParent: true})
+		ctx := context.Background()
+		newFamily := func(name string, statuses ...model.Status) (*model.Task, []*model.Task) {
+			parent, err := svc.CreateTask(ctx, &model.Task{Name: name})
+			require.NoError(t, err)
+			var children []*model.Task
+			for i, status := range statuses {
+				child, err := svc.CreateTask(ctx, &model.Task{Name: fmt.Sprintf("%s-%d", name, i), ParentID: parent.ID, Status: status})
+				require.NoError(t, err)
+				children = append(children, child)
+			}
+			return parent, children
+		}
+		statusOf := func(id uint32) model.Status {
+			task, err := svc.GetTask(id)
+			require.NoError(t, err)
+			return task.Status
+		}
+		cancelled := model.StatusCancelled
+
+		parent, children := newFamily("cancel", model.StatusDone, model.StatusTodo)
+		_, err := svc.PatchTask(ctx, children[1].ID, &model.TaskPatch{Status: &cancelled})
+		require.NoError(t, err)
+		assert.Equal(t, model.StatusDone, statusOf(parent.ID), "last open subtask cancelled")
+
+		parent, children = newFamily("delete", model.StatusDone, model.StatusTodo)
+		require.NoError(t, svc.DeleteTask(ctx, children[1].ID))
+		assert.Equal(t, model.StatusDone, statusOf(parent.ID), "last open subtask deleted")
+
+		parent, children = newFamily("only", model.StatusTodo)
+		require.NoError(t, svc.DeleteTask(ctx, children[0].ID))
+		assert.Equal(t, model.StatusTodo, statusOf(parent.ID), "no subtasks left")
+	})
+	t.Run("ConcurrentCycle", func(t *testing.T) {
+		svc := newService(t, slowReads{repository.NewRepository()}, nil)
+		ctx := context.Background()
+		for i := 0; i < 10; i++ {
+			a, err := svc.CreateTask(ctx, &model.Task{Name: fmt.Sprintf("a%d", i)})
+			require.NoError(t, err)
+			b, err := svc.CreateTask(ctx, &model.Task{Name: fmt.Sprintf("b%d", i)})
+			require.NoError(t, err)
+			errs := make(chan error, 2)
+			go func() {
+				_, err := svc.PatchTask(ctx, a.ID, parentPatch(b.ID))
+				errs <- err
+			}()
+			go func() {
+				_, err := svc.PatchTask(ctx, b.ID, parentPatch(a.ID))
+				errs <- err
+			}()
+			failed := 0
+			for j := 0; j < 2; j++ {
+				if err := <-errs; err != nil {
+					assert.ErrorIs(t, err, errcode.CycleDetected)
+					failed++
+				}
+			}
+			assert.Equal(t, 1, failed, "a%d and b%d under each other", i, i)
+		}
+	})
+	t.Run("GetTaskTree", func(t *testing.T) {
+		mockRepo := newRepo()
+		all := []*model.Task{tasks[1], tasks[2], tasks[3], tasks[4]}
+		mockRepo.On("GetTasks", &model.TaskQuery{Sort: model.SortByID}).Return(all, "", nil).Twice()
+		svc := newTestService(mockRepo)
+		parent := uint32(2)
+		result, err := svc.GetTaskTree(&model.TaskQuery{ParentID: &parent, Sort: model.SortByID, Limit: 10, Cursor: "abc"})
+		assert.NoError(t, err)
+		assert.Equal(t, []*model.TaskNode{{Task: tasks[3], Children: []*model.TaskNode{}}}, result)
+		result, err = svc.GetTaskTree(&model.TaskQuery{Sort: model.SortByID})
+		assert.NoError(t, err)
+		assert.Len(t, result, 1)
+		assert.Len(t, result[0].Children, 2)
+		mockRepo.AssertExpectations(t)
+	})
+}
+
+func TestDependencies(t *testing.T) {
+	// 1 ── 2 ── 3, 4 is done, 5 is in another project and 6 waits on 4.
+	tasks := map[uint32]*model.Task{
+		1: {ID: 1, Name: "task1", Status: model.StatusTodo},
+		2: {ID: 2, Name: "task2", Status: model.StatusTodo, BlockedBy: []uint32{1}},
+		3: {ID: 3, Name: "task3", Status: model.StatusTodo, BlockedBy: []uint32{2}},
+		4: {ID: 4, Name: "task4", Status: model.StatusDone},
+		5: {ID: 5, Name: "task5", Status: model.StatusTodo, ProjectID: 1},
+		6: {ID: 6, Name: "task6", Status: model.StatusTodo, BlockedBy: []uint32{4}},
+	}
+	newRepo := func() *mocks.Repository {
+		mockRepo := new(mocks.Repository)
+		for id, task := range tasks {
+			mockRepo.On("GetTask", id).Return(task, nil).Maybe()
+		}
+		mockRepo.On("GetTask", uint32(9)).Return(nil, errcode.RecordNotExists).Maybe()
+		return mockRepo
+	}
+	t.Run("AddTaskBlockers", func(t *testing.T) {
+		mockRepo := newRepo()
+		onPatchTask(mockRepo, tasks[2]).Once()
+		svc := newTestService(mockRepo)
+		result, err := svc.AddTaskBlockers(context.Background(), 2, []uint32{4, 1, 4})
+		assert.NoError(t, err)
+		assert.Equal(t, []uint32{1, 4}, result.BlockedBy)
+		assert.Equal(t, []uint32{1}, tasks[2].BlockedBy)
+		mockRepo.AssertExpectations(t)
+	})
+	t.Run("InvalidBlockers", func(t *testing.T) {
+		mockRepo := newRepo()
+		svc := newTestService(mockRepo)
+		tests := []struct {
+			id, blocker uint32
+			err         error
+		}{
+			{1, 1, errcode.CycleDetected},
+			{1, 3, errcode.CycleDetected},
+			{2, 3, errcode.CycleDetected},
+			{1, 9, errcode.RecordNotExists},
+			{1, 5, errcode.InvalidParams},
+		}
+		for _, tt := range tests {
+			result, err := svc.AddTaskBlockers(context.Background(), tt.id, []uint32{tt.blocker})
+			assert.Nil(t, result)
+			assert.ErrorIs(t, err, tt.err, "%d blocked by %d", tt.id, tt.blocker)
+		}
+		mockRepo.AssertNotCalled(t, "PatchTask", mock.Anything, mock.Anything)
+	})
+	t.Run("OpenBlockerOnDoneTask", func(t *testing.T) {
+		mockRepo := newRepo()
+		svc := newTestService(mockRepo)
+		result, err := svc.AddTaskBlockers(context.Background(), 4, []uint32{1})
+		assert.Nil(t, result)
+		assert.ErrorIs(t, err, errcode.TaskBlocked)
+		mockRepo.AssertNotCalled(t, "PatchTask", mock.Anything, mock.Anything)
+	})
+	t.Run("ConcurrentCycle", func(t *testing.T) {
+		svc := newService(t, slowReads{repository.NewRepository()}, nil)
+		ctx := context.Background()
+		for i := 0; i < 10; i++ {
+			a, err := svc.CreateTask(ctx, &model.Task{Name: fmt.Sprintf("a%d", i)})
+			require.NoError(t, err)
+			b, err := svc.CreateTask(ctx, &model.Task{Name: fmt.Sprintf("b%d", i)})
+			require.NoError(t, err)
+			errs := make(chan error, 2)
+			go func() {
+				_, err := svc.AddTaskBlockers(ctx, a.ID, []uint32{b.ID})
+				errs <- err
+			}()
+			go func() {
+				_, err := svc.AddTaskBlockers(ctx, b.ID, []uint32{a.ID})
+				errs <- err
+			}()
+			failed := 0
+			for j := 0; j < 2; j++ {
+				if err := <-errs; err != nil {
+					assert.ErrorIs(t, err, errcode.CycleDetected)
+					failed++
+				}
+			}
+			assert.Equal(t, 1, failed, "a%d and b%d block each other", i, i)
+		}
+	})
+	t.Run("RemoveTaskBlockers", func(t *testing.T) {
+		mockRepo := newRepo()
+		onPatchTask(mockRepo, tasks[3]).Once()
+		svc := newTestService(mockRepo)
+		result, err := svc.RemoveTaskBlockers(context.Background(), 3, []uint32{2})
+		assert.NoError(t, err)
+		assert.Nil(t, result.BlockedBy)
+		mockRepo.AssertExpectations(t)
+	})
+	t.Run("TaskBlocked", func(t *testing.T) {
+		mockRepo := newRepo()
+		svc := newTestService(mockRepo)
+		status := model.StatusDone
+		result, err := svc.PatchTask(context.Background(), 2, &model.TaskPatch{Status: &status})
+		assert.Nil(t, result)
+		assert.ErrorIs(t, err, errcode.TaskBlocked)
+		assert.Equal(t, []string{"blocked_by: 任務 1 尚未完成"}, err.(*errcode.Error).Details())
+		_, err = svc.UpdateTask(context.Background(), 3, &model.Task{Name: "task3", Status: model.StatusDone})
+		assert.ErrorIs(t, err, errcode.TaskBlocked)
+		mockRepo.AssertNotCalled(t, "PatchTask", mock.Anything, mock.Anything)
+	})
+	t.Run("Unblocked", func(t *testing.T) {
+		mockRepo := newRepo()
+		onPatchTask(mockRepo, tasks[6]).Once()
+		svc := newTestService(mockRepo)
+		status := model.StatusDone
+		result, err := svc.PatchTask(context.Background(), 6, &model.TaskPatch{Status: &status})
+		assert.NoError(t, err)
+		assert.Equal(t, model.StatusDone, result.Status)
+		mockRepo.AssertExpectations(t)
+	})
+	t.Run("GetTaskDependencies", func(t *testing.T) {
+		mockRepo := newRepo()
+		id := uint32(2)
+		mockRepo.On("GetTasks", &model.TaskQuery{Blocker: &id}).Return([]*model.Task{tasks[3]}, "", nil).Once()
+		svc := newTestService(mockRepo)
+		result, err := svc.GetTaskDependencies(id)
+		assert.NoError(t, err)
+		assert.Equal(t, &model.TaskDependencies{BlockedBy: []*model.Task{tasks[1]}, Blocking: []*model.Task{tasks[3]}}, result)
+		mockRepo.AssertExpectations(t)
+	})
+	t.Run("GetTaskOrder", func(t *testing.T) {
+		mockRepo := newRepo()
+		query := &model.TaskQuery{Sort: model.SortByName, Desc: true}
+		mockRepo.On("GetTasks", query).Return([]*model.Task{tasks[6], tasks[4], tasks[3], tasks[2], tasks[1]}, "", nil).Once()
+		svc := newTestService(mockRepo)
+		result, err := svc.GetTaskOrder(&model.TaskQuery{Sort: model.SortByName, Desc: true, Limit: 1, Cursor: "abc"})
+		assert.NoError(t, err)
+		assert.Equal(t, []*model.Task{tasks[4], tasks[6], tasks[1], tasks[2], tasks[3]}, result)
+		mockRepo.AssertExpectations(t)
+	})
+	t.Run("GetTaskOrderCycle", func(t *testing.T) {
+		mockRepo := newRepo()
+		cyclic := &model.Task{ID: 1, Name: "task1", BlockedBy: []uint32{3}}
+		mockRepo.On("GetTasks", &model.TaskQuery{}).Return([]*model.Task{cyclic, tasks[2], tasks[3]}, "", nil).Once()
+		svc := newTestService(mockRepo)
+		result, err := svc.GetTaskOrder(&model.TaskQuery{})
+		assert.Nil(t, result)
+		assert.ErrorIs(t, err, errcode.CycleDetected)
+		mockRepo.AssertExpectations(t)
+	})
+	t.Run("DeleteTask", func(t *testing.T) {
+		id := uint32(1)
+		mockRepo := newRepo()
+		mockRepo.On("GetTasks", &model.TaskQuery{ParentID: &id}).Return([]*model.Task{}, "", nil).Once()
+		mockRepo.On("GetTasks", &model.TaskQuery{Blocker: &id}).Return([]*model.Task{tasks[2]}, "", nil).Once()
+		var unblocked *model.Task
+		onPatchTask(mockRepo, tasks[2]).Run(func(args mock.Arguments) {
+			result := *tasks[2]
+			_ = args.Get(1).(func(*model.Task) error)(&result)
+			unblocked = &result
+		}).Once()
+		mockRepo.On("DeleteTask", id).Return(nil).Once()
+		svc := newTestService(mockRepo)
+		assert.NoError(t, svc.DeleteTask(context.Background(), id))
+		assert.Nil(t, unblocked.BlockedBy)
+		mockRepo.AssertExpectations(t)
+	})
+}
+
+func TestRecurrence(t *testing.T) {
+	dueAt := time.Date(2022, 5, 2, 9, 0, 0, 0, time.UTC)
+	stored := &model.Task{
+		ID:          1,
+		ProjectID:   2,
+		Name:        "water plants",
+		Status:      model.StatusTodo,
+		Description: "description",
+		Priority:    2,
+		DueAt:       &dueAt,
+		Recurrence:  "FREQ=WEEKLY;BYDAY=MO,TH;COUNT=3",
+		Tags:        []string{"home"},
+		BlockedBy:   []uint32{4},
+	}
+	blocker := &model.Task{ID: 4, Name: "buy water", Status: model.StatusDone}
+	nextDue := time.Date(2022, 5, 5, 9, 0, 0, 0, time.UTC)
+	next := &model.Task{
+		ProjectID:   2,
+		Name:        "water plants (2022-05-05)",
+		Status:      model.StatusTodo,
+		Description: "description",
+		Priority:    2,
+		DueAt:       &nextDue,
+		Recurrence:  "FREQ=WEEKLY;BYDAY=MO,TH;COUNT=2",
+		Tags:        []string{"home"},
+		CreatedAt:   testNow,
+		UpdatedAt:   testNow,
+	}
+	t.Run("UpdateTask", func(t *testing.T) {
+		mockRepo := new(mocks.Repository)
+		mockRepo.On("GetTask", stored.ID).Return(stored, nil).Once()
+		mockRepo.On("GetTask", blocker.ID).Return(blocker, nil).Once()
+		onPatchTask(mockRepo, stored).Once()
+		created := *next
+		created.ID = 5
+		mockRepo.On("CreateTask", next).Return(&created, nil).Once()
+		svc := newTestService(mockRepo)
+		result, err := svc.UpdateTask(context.Background(), stored.ID, &model.Task{
+			Name:        stored.Name,
+			Status:      model.StatusDone,
+			Description: stored.Description,
+			Priority:    stored.Priority,
+			DueAt:       &dueAt,
+			Recurrence:  "rrule:freq=weekly;byday=mo,th;count=3",
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, model.StatusDone, result.Status)
+		assert.Empty(t, result.Recurrence)
+		mockRepo.AssertExpectations(t)
+	})
+	t.Run("UniqueName", func(t *testing.T) {
+		done := *next
+		done.ID = 5
+		mockRepo := new(mocks.Repository)
+		mockRepo.On("GetTask", done.ID).Return(&done, nil).Once()
+		onPatchTask(mockRepo, &done).Once()
+		lastDue := time.Date(2022, 5, 9, 9, 0, 0, 0, time.UTC)
+		last := *next
+		last.DueAt = &lastDue
+		last.Recurrence = "FREQ=WEEKLY;BYDAY=MO,TH;COUNT=1"
+		var names []string
+		mockRepo.On("CreateTask", mock.Anything).Return(nil, errcode.DuplicateRecords).Twice().Run(func(args mock.Arguments) {
+			names = append(names, args.Get(0).(*model.Task).Name)
+		})
+		mockRepo.On("CreateTask", mock.Anything).Return(&last, nil).Once().Run(func(args mock.Arguments) {
+			names = append(names, args.Get(0).(*model.Task).Name)
+		})
+		svc := newTestService(mockRepo)
+		status := model.StatusDone
+		_, err := svc.PatchTask(context.Background(), done.ID, &model.TaskPatch{Status: &status})
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"water plants (2022-05-09)", "water plants (2022-05-09) #2", "water plants (2022-05-09) #3"}, names)
+		mockRepo.AssertExpectations(t)
+	})
+	t.Run("LastOccurrence", func(t *testing.T) {
+		last := *next
+		last.ID = 6
+		last.Recurrence = "FREQ=WEEKLY;BYDAY=MO,TH;COUNT=1"
+		mockRepo := new(mocks.Repository)
+		mockRepo.On("GetTask", last.ID).Return(&last, nil).Once()
+		onPatchTask(mockRepo, &last).Once()
+		svc := newTestService(mockRepo)
+		status := model.StatusDone
+		result, err := svc.PatchTask(context.Background(), last.ID, &model.TaskPatch{Status: &status})
+		assert.NoError(t, err)
+		assert.Empty(t, result.Recurrence)
+		mockRepo.AssertNotCalled(t, "CreateTask", mock.Anything)
+	})
+	t.Run("NoDueDate", func(t *testing.T) {
+		task := &model.Task{ID: 7, Name: "stretch", Status: model.StatusTodo, Recurrence: "FREQ=DAILY"}
+		mockRepo := new(mocks.Repository)
+		mockRepo.On("GetTask", task.ID).Return(task, nil).Once()
+		onPatchTask(mockRepo, task).Once()
+		tomorrow := testNow.AddDate(0, 0, 1)
+		mockRepo.On("CreateTask", &model.Task{
+			Name:       "stretch (2022-05-02)",
+			Status:     model.StatusTodo,
+			DueAt:      &tomorrow,
+			Recurrence: "FREQ=DAILY",
+			CreatedAt:  testNow,
+			UpdatedAt:  testNow,
+		}).Return(task, nil).Once()
+		svc := newTestService(mockRepo)
+		status := model.StatusDone
+		_, err := svc.PatchTask(context.Background(), task.ID, &model.TaskPatch{Status: &status})
+		assert.NoError(t, err)
+		mockRepo.AssertExpectations(t)
+	})
+	t.Run("Normalize", func(t *testing.T) {
+		mockRepo := new(mocks.Repository)
+		mockRepo.On("CreateTask", mock.MatchedBy(func(task *model.Task) bool {
+			return task.Recurrence == "FREQ=WEEKLY"
+		})).Return(stored, nil).Once()
+		onPatchTask(mockRepo, stored).Once()
+		svc := newTestService(mockRepo)
+		_, err := svc.CreateTask(context.Background(), &model.Task{Name: "task", Recurrence: "weekly"})
+		assert.NoError(t, err)
+		rule := "monthly"
+		result, err := svc.PatchTask(context.Background(), stored.ID, &model.TaskPatch{Recurrence: &rule})
+		assert.NoError(t, err)
+		assert.Equal(t, "FREQ=MONTHLY", result.Recurrence)
+		assert.Equal(t, "monthly", rule)
+		mockRepo.AssertExpectations(t)
+	})
+	t.Run("TimeZone", func(t *testing.T) {
+		// Monday 07:00 in Taipei is still Sunday in UTC; the rule and the
+		// name follow Taipei.
+		taipei := time.FixedZone("CST", 8*60*60)
+		due := time.Date(2022, 5, 2, 7, 0, 0, 0, taipei).UTC()
+		task := &model.Task{ID: 8, Name: "standup", Status: model.StatusTodo, DueAt: &due, Recurrence: "FREQ=WEEKLY;BYDAY=MO"}
+		nextDue := time.Date(2022, 5, 9, 7, 0, 0, 0, taipei).UTC()
+		mockRepo := new(mocks.Repository)
+		mockRepo.On("GetTask", task.ID).Return(task, nil).Once()
+		onPatchTask(mockRepo, task).Once()
+		mockRepo.On("CreateTask", &model.Task{
+			Name:       "standup (2022-05-09)",
+			Status:     model.StatusTodo,
+			DueAt:      &nextDue,
+			Recurrence: "FREQ=WEEKLY;BYDAY=MO",
+			CreatedAt:  testNow,
+			UpdatedAt:  testNow,
+		}).Return(task, nil).Once()
+		svc := newService(t, mockRepo, &config.TaskConfig{TimeZone: "Asia/Taipei"})
+		svc.now = func() time.Time { return testNow }
+		status := model.StatusDone
+		_, err := svc.PatchTask(context.Background(), task.ID, &model.TaskPatch{Status: &status})
+		assert.NoError(t, err)
+		mockRepo.AssertExpectations(t)
+
+		_, err = NewService(mockRepo, &config.TaskConfig{TimeZone: "Mars/Olympus"})
+		assert.EqualError(t, err, "Task.TimeZone: unknown time zone Mars/Olympus")
+	})
+	t.Run("InvalidParams", func(t *testing.T) {
+		mockRepo := new(mocks.Repository)
+		svc := newTestService(mockRepo)
+		_, err := svc.CreateTask(context.Background(), &model.Task{Name: "task", Recurrence: "hourly"})
+		assert.ErrorIs(t, err, errcode.InvalidParams)
+		_, err = svc.UpdateTask(context.Background(), stored.ID, &model.Task{Name: "task", Status: model.StatusTodo, Recurrence: "FREQ=DAILY;COUNT=0"})
+		assert.ErrorIs(t, err, errcode.InvalidParams)
+		rule := "FREQ=WEEKLY;BYMONTHDAY=1"
+		_, err = svc.PatchTask(context.Background(), stored.ID, &model.TaskPatch{Recurrence: &rule})
+		assert.ErrorIs(t, err, errcode.InvalidParams)
+		mockRepo.AssertExpectations(t)
+	})
+}
+
+func TestReminders(t *testing.T) {
+	dueAt := testNow.Add(time.Hour)
+	remindedAt := testNow.Add(-time.Hour)
+	stored := &model.Task{
+		ID:         1,
+		Name:       "task",
+		Status:     model.StatusTodo,
+		DueAt:      &dueAt,
+		RemindedAt: &remindedAt,
+	}
+	t.Run("GetDueTasks", func(t *testing.T) {
+		done := &model.Task{ID: 2, Name: "done", Status: model.StatusDone, DueAt: &dueAt}
+		mockRepo := new(mocks.Repository)
+		mockRepo.On("GetTasks", &model.TaskQuery{DueBefore: &dueAt}).Return([]*model.Task{stored, done}, "", nil).Once()
+		svc := newTestService(mockRepo)
+		result, err := svc.GetDueTasks(dueAt)
+		assert.NoError(t, err)
+		assert.Equal(t, []*model.Task{stored}, result)
+		mockRepo.AssertExpectations(t)
+	})
+	t.Run("FireReminder", func(t *testing.T) {
+		mockRepo := new(mocks.Repository)
+		onPatchTask(mockRepo, stored).Twice()
+		svc := newTestService(mockRepo)
+		result, err := svc.FireReminder(&model.Reminder{Kind: model.ReminderUpcoming, TaskID: stored.ID, DueAt: dueAt, At: testNow})
+		assert.NoError(t, err)
+		assert.Equal(t, &testNow, result.RemindedAt)
+		assert.Nil(t, result.OverdueAt)
+		assert.Equal(t, time.Time{}, result.UpdatedAt)
+		result, err = svc.FireReminder(&model.Reminder{Kind: model.ReminderOverdue, TaskID: stored.ID, DueAt: dueAt, At: testNow})
+		assert.NoError(t, err)
+		assert.Equal(t, &remindedAt, result.RemindedAt)
+		assert.Equal(t, &testNow, result.OverdueAt)
+		mockRepo.AssertExpectations(t)
+	})
+	t.Run("FireReminderStale", func(t *testing.T) {
+		done := *stored
+		done.Status = model.StatusDone
+		mockRepo := new(mocks.Repository)
+		onPatchTask(mockRepo, stored).Once()
+		onPatchTask(mockRepo, &done).Once()
+		svc := newTestService(mockRepo)
+		_, err := svc.FireReminder(&model.Reminder{Kind: model.ReminderUpcoming, TaskID: stored.ID, DueAt: testNow, At: testNow})
+		assert.ErrorIs(t, err, errcode.PatchTestFailed)
+		_, err = svc.FireReminder(&model.Reminder{Kind: model.ReminderUpcoming, TaskID: stored.ID, DueAt: dueAt, At: testNow})
+		assert.ErrorIs(t, err, errcode.PatchTestFailed)
+		mockRepo.AssertExpectations(t)
+	})
+	t.Run("DueAtChanged", func(t *testing.T) {
+		mockRepo := new(mocks.Repository)
+		onPatchTask(mockRepo, stored).Twice()
+		svc := newTestService(mockRepo)
+		result, err := svc.PatchTask(context.Background(), stored.ID, &model.TaskPatch{Name: &stored.Name})
+		assert.NoError(t, err)
+		assert.Equal(t, &remindedAt, result.RemindedAt)
+		later := dueAt.Add(time.Hour)
+		result, err = svc.PatchTask(context.Background(), stored.ID, &model.TaskPatch{DueAt: &later})
+		assert.NoError(t, err)
+		assert.Nil(t, result.RemindedAt)
+		mockRepo.AssertExpectations(t)
+	})
+}
+
+// fakeWebhooks records the events published to it and refuses the hosts in
+// refused.
+type fakeWebhooks struct {
+	events     []*model.Event
+	deliveries []*model.WebhookDelivery
+	refused    []string
+}
+
+func (f *fakeWebhooks) Publish(event *model.Event) {
+	f.events = append(f.events, event)
+}
+
+func (f *fakeWebhooks) Deliveries(webhookID uint32) []*model.WebhookDelivery {
+	return f.deliveries
+}
+
+func (f *fakeWebhooks) AllowsHost(host string) bool {
+	for _, refused := range f.refused {
+		if host == refused {
+			return false
+		}
+	}
+	return true
+}
+
+func newWebhookService(repo *mocks.Repository) (*service, *fakeWebhooks) {
+	webhooks := &fakeWebhooks{}
+	s, _ := NewService(repo, &config.TaskConfig{}, WithWebhooks(webhooks))
+	svc := s.(*service)
+	svc.now = func() time.Time { return testNow }
+	return svc, webhooks
+}
+
+func TestWebhooks(t *testing.T) {
+	webhook := &model.Webhook{
+		ID:        1,
+		URL:       "https://example.com/hook",
+		Events:    []string{model.EventTaskDeleted, model.EventTaskCreated},
+		Secret:    "secret",
+		Active:    true,
+		CreatedAt: testNow,
+		UpdatedAt: testNow,
+	}
+	t.Run("Create", func(t *testing.T) {
+		mockRepo := new(mocks.Repository)
+		mockRepo.On("CreateWebhook", mock.Anything).Return(func(webhook *model.Webhook) *model.Webhook {
+			created := *webhook
+			created.ID = 1
+			return &created
+		}, nil).Once()
+		svc := newTestService(mockRepo)
+		result, err := svc.CreateWebhook(&model.Webhook{
+			URL:    webhook.URL,
+			Events: []string{model.EventTaskDeleted, model.EventTaskCreated, model.EventTaskDeleted},
+			Active: true,
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, []string{model.EventTaskCreated, model.EventTaskDeleted}, result.Events)
+		assert.Len(t, result.Secret, 2*webhookSecretBytes)
+		assert.Equal(t, testNow, result.CreatedAt)
+		mockRepo.AssertExpectations(t)
+	})
+	t.Run("InvalidParams", func(t *testing.T) {
+		mockRepo := new(mocks.Repository)
+		svc := newTestService(mockRepo)
+		for _, invalid := range []*model.Webhook{
+			{URL: "example.com/hook"},
+			{URL: "ftp://example.com/hook"},
+			{URL: "https://example.com/hook", Events: []string{"task.moved"}},
+		} {
+			_, err := svc.CreateWebhook(invalid)
+			assert.ErrorIs(t, err, errcode.InvalidParams)
+			_, err = svc.UpdateWebhook(1, invalid)
+			assert.ErrorIs(t, err, errcode.InvalidParams)
+		}
+		mockRepo.AssertNotCalled(t, "CreateWebhook", mock.Anything)
+		mockRepo.AssertNotCalled(t, "PatchWebhook", mock.Anything, mock.Anything)
+	})
+	t.Run("PrivateHost", func(t *testing.T) {
+		mockRepo := new(mocks.Repository)
+		svc, webhooks := newWebhookService(mockRepo)
+		webhooks.refused = []string{"169.254.169.254"}
+		_, err := svc.CreateWebhook(&model.Webhook{URL: "http://169.254.169.254/latest/meta-data"})
+		assert.ErrorIs(t, err, errcode.InvalidParams)
+		assert.Equal(t, []string{"url: 不能是本機或內部網路位址"}, err.(*errcode.Error).Details())
+		_, err = svc.UpdateWebhook(1, &model.Webhook{URL: "http://169.254.169.254:80/"})
+		assert.ErrorIs(t, err, errcode.InvalidParams)
+		mockRepo.AssertNotCalled(t, "CreateWebhook", mock.Anything)
+		mockRepo.AssertNotCalled(t, "PatchWebhook", mock.Anything, mock.Anything)
+	})
+	t.Run("Update", func(t *testing.T) {
+		mockRepo := new(mocks.Repository)
+		mockRepo.On("PatchWebhook", webhook.ID, mock.Anything).Return(func(id uint32, apply func(*model.Webhook) error) *model.Webhook {
+			result := *webhook
+			_ = apply(&result)
+			return &result
+		}, nil).Once()
+		svc := newTestService(mockRepo)
+		result, err := svc.UpdateWebhook(webhook.ID, &model.Webhook{URL: "http://example.com/other"})
+		assert.NoError(t, err)
+		assert.Equal(t, &model.Webhook{ID: 1, URL: "http://example.com/other", Secret: "secret", CreatedAt: testNow, UpdatedAt: testNow}, result)
+		mockRepo.AssertExpectations(t)
+	})
+	t.Run("Deliveries", func(t *testing.T) {
+		deliveries := []*model.WebhookDelivery{{ID: 1, WebhookID: webhook.ID, Status: model.DeliveryDead}}
+		mockRepo := new(mocks.Repository)
+		mockRepo.On("GetWebhook", webhook.ID).Return(webhook, nil).Once()
+		mockRepo.On("GetWebhook", uint32(9)).Return(nil, errcode.RecordNotExists).Once()
+		mockRepo.On("GetDeadLetters").Return(deliveries, nil).Once()
+		svc, webhooks := newWebhookService(mockRepo)
+		webhooks.deliveries = deliveries
+		result, err := svc.GetWebhookDeliveries(webhook.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, deliveries, result)
+		_, err = svc.GetWebhookDeliveries(9)
+		assert.ErrorIs(t, err, errcode.RecordNotExists)
+		result, err = svc.GetDeadLetters()
+		assert.NoError(t, err)
+		assert.Equal(t, deliveries, result)
+		mockRepo.AssertExpectations(t)
+	})
+}
+
+func TestAPIKeys(t *testing.T) {
+	t.Run("Create", func(t *testing.T) {
+		mockRepo := new(mocks.Repository)
+		var stored *model.APIKey
+		mockRepo.On("CreateAPIKey", mock.Anything).Return(func(key *model.APIKey) *model.APIKey {
+			stored = key
+			stored.ID = 1
+			return stored
+		}, nil).Once()
+		svc := newTestService(mockRepo)
+		result, err := svc.CreateAPIKey(&model.APIKey{Name: "ci", Scopes: []string{auth.ScopeTasksWrite, auth.ScopeTasksRead, auth.ScopeTasksWrite}})
+		assert.NoError(t, err)
+		assert.Equal(t, []string{auth.ScopeTasksRead, auth.ScopeTasksWrite}, result.Scopes)
+		assert.Equal(t, testNow, result.CreatedAt)
+		assert.True(t, strings.HasPrefix(result.Token, APIKeyPrefix))
+		assert.Len(t, result.Token, len(APIKeyPrefix)+2*apiKeyBytes)
+		assert.Equal(t, result.Token[:apiKeyShown], result.Prefix)
+		// Only the hash of the token reaches the repository, even when it
+		// returns the key it keeps.
+		assert.Empty(t, stored.Token)
+		sum := sha256.Sum256([]byte(result.Token))
+		assert.Equal(t, hex.EncodeToString(sum[:]), stored.Hash)
+		mockRepo.AssertExpectations(t)
+	})
+	t.Run("InvalidParams", func(t *testing.T) {
+		mockRepo := new(mocks.Repository)
+		svc := newTestService(mockRepo)
+		for _, invalid := range []*model.APIKey{
+			{Name: "ci"},
+			{Name: "ci", Scopes: []string{"tasks:delete"}},
+			{Name: " ", Scopes: []string{auth.ScopeAdmin}},
+		} {
+			_, err := svc.CreateAPIKey(invalid)
+			assert.ErrorIs(t, err, errcode.InvalidParams)
+		}
+		mockRepo.AssertNotCalled(t, "CreateAPIKey", mock.Anything)
+	})
+	t.Run("Authenticate", func(t *testing.T) {
+		mockRepo := new(mocks.Repository)
+		key := &model.APIKey{ID: 3, Name: "ci", Scopes: []string{auth.ScopeTasksRead}}
+		mockRepo.On("GetAPIKeyByHash", hashToken("tk_valid")).Return(key, nil).Once()
+		mockRepo.On("GetAPIKeyByHash", hashToken("tk_revoked")).Return(nil, errcode.RecordNotExists).Once()
+		svc := newTestService(mockRepo)
+		principal, err := svc.Authenticate("tk_valid")
+		assert.NoError(t, err)
+		assert.Equal(t, &auth.Principal{Subject: "apikey:3", Scopes: key.Scopes}, principal)
+		_, err = svc.Authenticate("tk_revoked")
+		assert.ErrorIs(t, err, errcode.Unauthorized)
+		mockRepo.AssertExpectations(t)
+	})
+}
+
+func TestAuthors(t *testing.T) {
+	ctx := auth.NewContext(context.Background(), &auth.Principal{Subject: "alice"})
+	t.Run("Create", func(t *testing.T) {
+		mockRepo := new(mocks.Repository)
+		mockRepo.On("CreateTask", mock.Anything).Return(func(task *model.Task) *model.Task {
+			return task
+		}, nil).Once()
+		svc := newTestService(mockRepo)
+		result, err := svc.CreateTask(ctx, &model.Task{Name: "task", CreatedBy: "mallory", UpdatedBy: "mallory"})
+		assert.NoError(t, err)
+		assert.Equal(t, "alice", result.CreatedBy)
+		assert.Equal(t, "alice", result.UpdatedBy)
+		mockRepo.AssertExpectations(t)
+	})
+	t.Run("Update", func(t *testing.T) {
+		stored := &model.Task{ID: 1, Name: "task", Status: model.StatusTodo, CreatedBy: "bob", UpdatedBy: "bob"}
+		mockRepo := new(mocks.Repository)
+		onPatchTask(mockRepo, stored)
+		svc := newTestService(mockRepo)
+		result, err := svc.AddTaskTags(ctx, stored.ID, []string{"a"})
+		assert.NoError(t, err)
+		assert.Equal(t, "bob", result.CreatedBy)
+		assert.Equal(t, "alice", result.UpdatedBy)
+
+		// Without authentication nobody is recorded.
+		result, err = svc.AddTaskTags(context.Background(), stored.ID, []string{"a"})
+		assert.NoError(t, err)
+		assert.Equal(t, "bob", result.CreatedBy)
+		assert.Empty(t, result.UpdatedBy)
+	})
+	t.Run("Recurrence", func(t *testing.T) {
+		stored := &model.Task{ID: 1, Name: "task", Status: model.StatusTodo, Recurrence: "FREQ=DAILY", CreatedBy: "bob"}
+		mockRepo := new(mocks.Repository)
+		onPatchTask(mockRepo, stored).Once()
+		mockRepo.On("GetTask", stored.ID).Return(stored, nil)
+		mockRepo.On("CreateTask", mock.MatchedBy(func(task *model.Task) bool {
+			return task.CreatedBy == "alice" && task.UpdatedBy == "alice"
+		})).Return(&model.Task{ID: 2}, nil).Once()
+		svc := newTestService(mockRepo)
+		done := model.StatusDone
+		_, err := svc.PatchTask(ctx, stored.ID, &model.TaskPatch{Status: &done})
+		assert.NoError(t, err)
+		mockRepo.AssertExpectations(t)
+	})
+}
+
+func TestEvents(t *testing.T) {
+	task := &model.Task{ID: 1, Name: "task", Status: model.StatusTodo}
+	t.Run("Created", func(t *testing.T) {
+		mockRepo := new(mocks.Repository)
+		mockRepo.On("CreateTask", mock.Anything).Return(task, nil).Once()
+		svc, webhooks := newWebhookService(mockRepo)
+		_, err := svc.CreateTask(context.Background(), &model.Task{Name: task.Name})
+		assert.NoError(t, err)
+		assert.Equal(t, []*model.Event{{ID: 1, Type: model.EventTaskCreated, Task: task, At: testNow}}, webhooks.events)
+	})
+	t.Run("Updated", func(t *testing.T) {
+		mockRepo := new(mocks.Repository)
+		onPatchTask(mockRepo, task).Once()
+		svc, webhooks := newWebhookService(mockRepo)
+		name := "renamed"
+		result, err := svc.PatchTask(context.Background(), task.ID, &model.TaskPatch{Name: &name})
+		assert.NoError(t, err)
+		assert.Equal(t, []*model.Event{{ID: 1, Type: model.EventTaskUpdated, Task: result, At: testNow}}, webhooks.events)
+	})
+	t.Run("Subscribe", func(t *testing.T) {
+		mockRepo := new(mocks.Repository)
+		mockRepo.On("CreateTask", mock.Anything).Return(task, nil).Twice()
+		svc, webhooks := newWebhookService(mockRepo)
+		_, err := svc.CreateTask(context.Background(), &model.Task{Name: task.Name})
+		assert.NoError(t, err)
+		sub := svc.SubscribeEvents(0)
+		defer sub.Close()
+		_, err = svc.CreateTask(context.Background(), &model.Task{Name: task.Name})
+		assert.NoError(t, err)
+		assert.Equal(t, []*model.Event{
+			{ID: 1, Type: model.EventTaskCreated, Task: task, At: testNow},
+			{ID: 2, Type: model.EventTaskCreated, Task: task, At: testNow},
+		}, webhooks.events)
+		assert.Equal(t, webhooks.events[0], <-sub.C)
+		assert.Equal(t, webhooks.events[1], <-sub.C)
+	})
+	t.Run("Failed", func(t *testing.T) {
+		mockRepo := new(mocks.Repository)
+		mockRepo.On("PatchTask", task.ID, mock.Anything).Return(nil, errcode.DuplicateRecords).Once()
+		svc, webhooks := newWebhookService(mockRepo)
+		name := "renamed"
+		_, err := svc.PatchTask(context.Background(), task.ID, &model.TaskPatch{Name: &name})
+		assert.ErrorIs(t, err, errcode.DuplicateRecords)
+		assert.Empty(t, webhooks.events)
+	})
+	t.Run("Deleted", func(t *testing.T) {
+		mockRepo := new(mocks.Repository)
+		mockRepo.On("GetTask", task.ID).Return(task, nil).Once()
+		mockRepo.On("GetTasks", &model.TaskQuery{ParentID: &task.ID}).Return([]*model.Task{}, "", nil).Once()
+		mockRepo.On("GetTasks", &model.TaskQuery{Blocker: &task.ID}).Return([]*model.Task{}, "", nil).Once()
+		mockRepo.On("DeleteTask", task.ID).Return(nil).Once()
+		svc, webhooks := newWebhookService(mockRepo)
+		assert.NoError(t, svc.DeleteTask(context.Background(), task.ID))
+		assert.Equal(t, []*model.Event{{ID: 1, Type: model.EventTaskDeleted, Task: task, At: testNow}}, webhooks.events)
+	})
+	t.Run("DeleteProject", func(t *testing.T) {
+		projectID := uint32(2)
+		mockRepo := new(mocks.Repository)
+		mockRepo.On("GetTasks", &model.TaskQuery{ProjectID: &projectID}).Return([]*model.Task{task}, "", nil).Once()
+		mockRepo.On("DeleteProject", projectID, true).Return(nil).Once()
+		svc, webhooks := newWebhookService(mockRepo)
+		assert.NoError(t, svc.DeleteProject(projectID, true))
+		assert.Equal(t, []*model.Event{{ID: 1, Type: model.EventTaskDeleted, Task: task, At: testNow}}, webhooks.events)
+		mockRepo.AssertExpectations(t)
+	})
+	t.Run("ConcurrentWrites", func(t *testing.T) {
+		svc := newService(t, slowWrites{repository.NewRepository()}, nil)
+		ctx := context.Background()
+		created, err := svc.CreateTask(ctx, &model.Task{Name: "task"})
+		require.NoError(t, err)
+		sub := svc.SubscribeEvents(1)
+		defer sub.Close()
+		var wg sync.WaitGroup
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, err := svc.AddTaskTags(ctx, created.ID, []string{fmt.Sprintf("tag%d", i)})
+				assert.NoError(t, err)
+			}()
+		}
+		wg.Wait()
+		// Each event holds one tag more than the one before it.
+		for i := 1; i <= 20; i++ {
+			e := <-sub.C
+			assert.Len(t, e.Task.Tags, i)
+		}
+	})
+	t.Run("ConcurrentDeleteProject", func(t *testing.T) {
+		repo := slowLists{Repository: repository.NewRepository(), listed: make(chan struct{}, 1)}
+		svc := newService(t, repo, nil)
+		ctx := context.Background()
+		project, err := svc.CreateProject(&model.Project{Name: "project"})
+		require.NoError(t, err)
+		sub := svc.SubscribeEvents(0)
+		defer sub.Close()
+		deleted := make(chan error)
+		go func() {
+			deleted <- svc.DeleteProject(project.ID, true)
+		}()
+		// Created once the cascade has listed the tasks of the project.
+		<-repo.listed
+		task, err := svc.CreateTask(ctx, &model.Task{ProjectID: project.ID, Name: "task"})
+		require.NoError(t, <-deleted)
+		if err != nil {
+			return
+		}
+		_, err = svc.GetTask(task.ID)
+		assert.ErrorIs(t, err, errcode.RecordNotExists)
+		var events []string
+		for len(sub.C) > 0 {
+			events = append(events, (<-sub.C).Type)
+		}
+		assert.Equal(t, []string{model.EventTaskCreated, model.EventTaskDeleted}, events)
+	})
+	t.Run("FireReminder", func(t *testing.T) {
+		dueAt := testNow.Add(time.Hour)
+		stored := *task
+		stored.DueAt = &dueAt
+		mockRepo := new(mocks.Repository)
+		onPatchTask(mockRepo, &stored).Once()
+		svc, webhooks := newWebhookService(mockRepo)
+		_, err := svc.FireReminder(&model.Reminder{Kind: model.ReminderUpcoming, TaskID: task.ID, DueAt: dueAt, At: testNow})
+		assert.NoError(t, err)
+		assert.Empty(t, webhooks.events)
+	})
 }
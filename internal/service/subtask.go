@@ -0,0 +1,141 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log"
+
+	"github.com/wagaru/task/internal/errcode"
+	"github.com/wagaru/task/internal/model"
+)
+
+// GetTaskTree lists every task matching the query, nested under its parent.
+// Limit and Cursor are ignored; a ParentID returns the subtree below that
+// task instead of the whole forest.
+func (s *service) GetTaskTree(query *model.TaskQuery) ([]*model.TaskNode, error) {
+	if err := s.checkQuery(query); err != nil {
+		return nil, err
+	}
+	all := *query
+	all.ParentID = nil
+	all.Limit = 0
+	all.Cursor = ""
+	tasks, _, err := s.repo.GetTasks(&all)
+	if err != nil {
+		return nil, err
+	}
+	var root uint32
+	if query.ParentID != nil {
+		root = *query.ParentID
+	}
+	return model.BuildTree(tasks, root), nil
+}
+
+// checkQuery reports a project or parent filter naming a record that does
+// not exist.
+func (s *service) checkQuery(query *model.TaskQuery) error {
+	if query == nil {
+		return nil
+	}
+	if query.ProjectID != nil && *query.ProjectID != 0 {
+		if _, err := s.repo.GetProject(*query.ProjectID); err != nil {
+			return err
+		}
+	}
+	if query.ParentID != nil && *query.ParentID != 0 {
+		if _, err := s.repo.GetTask(*query.ParentID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkParent verifies that parentID may become the parent of task id (zero
+// for a task not created yet) in the given project: it has to exist, belong
+// to the same project and not be the task itself or one of its descendants.
+func (s *service) checkParent(id, projectID, parentID uint32) error {
+	visited := make(map[uint32]bool)
+	for next := parentID; next != 0; {
+		if next == id || visited[next] {
+			return errcode.CycleDetected.WithDetails("parent_id: 不能將任務移到自己或自己的子任務之下")
+		}
+		visited[next] = true
+		task, err := s.repo.GetTask(next)
+		if errors.Is(err, errcode.RecordNotExists) {
+			if next == parentID {
+				return errcode.RecordNotExists.WithDetails("parent_id: 上層任務不存在")
+			}
+			// A dangling ancestor ends the chain like a top-level task.
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if next == parentID && task.ProjectID != projectID {
+			return errcode.InvalidParams.WithDetails("parent_id: 上層任務必須在同一個專案中")
+		}
+		next = task.ParentID
+	}
+	return nil
+}
+
+// completeParents marks the ancestors of a task that has just become done or
+// cancelled, from the nearest one up, as done once none of their subtasks is
+// left open. The task itself is already saved, so failures are only logged.
+func (s *service) completeParents(ctx context.Context, task *model.Task, previous model.Status) {
+	if !task.Status.Closed() || previous.Closed() {
+		return
+	}
+	s.completeAncestors(ctx, task.ParentID)
+}
+
+// completeAncestors does the work of completeParents from task id up.
+func (s *service) completeAncestors(ctx context.Context, id uint32) {
+	if !s.autoCompleteParent {
+		return
+	}
+	for id != 0 {
+		parent, err := s.completeParent(ctx, id)
+		if err != nil {
+			if !errors.Is(err, errcode.IllegalTransition) && !errors.Is(err, errcode.TaskBlocked) {
+				log.Printf("service: auto-complete task %d: %v", id, err)
+			}
+			return
+		}
+		if parent == nil {
+			return
+		}
+		id = parent.ParentID
+	}
+}
+
+// completeParent marks a task done if it has subtasks, all of them done or
+// cancelled, and nothing else blocks it. It returns nil when it left the
+// task as it was.
+func (s *service) completeParent(ctx context.Context, id uint32) (*model.Task, error) {
+	children, _, err := s.repo.GetTasks(&model.TaskQuery{ParentID: &id})
+	if err != nil || len(children) == 0 {
+		return nil, err
+	}
+	for _, child := range children {
+		if !child.Status.Closed() {
+			return nil, nil
+		}
+	}
+	parent, err := s.repo.GetTask(id)
+	if err != nil || parent.Status == model.StatusDone {
+		return nil, err
+	}
+	if err := s.checkOpenBlockers(parent); err != nil {
+		return nil, err
+	}
+	return s.patchTask(id, func(task *model.Task) error {
+		status := task.Status
+		if err := s.checkTransition(status, model.StatusDone); err != nil {
+			return err
+		}
+		task.Status = model.StatusDone
+		s.touch(ctx, task, status)
+		return nil
+	})
+}
@@ -0,0 +1,122 @@
+package service
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"sort"
+
+	"github.com/wagaru/task/internal/errcode"
+	"github.com/wagaru/task/internal/model"
+)
+
+// Webhooks delivers the events the service emits to the subscribed webhooks
+// and keeps a log of the deliveries. AllowsHost reports whether it posts to
+// the host of a webhook URL at all.
+type Webhooks interface {
+	Publish(event *model.Event)
+	Deliveries(webhookID uint32) []*model.WebhookDelivery
+	AllowsHost(host string) bool
+}
+
+const webhookSecretBytes = 32
+
+func (s *service) GetWebhooks() ([]*model.Webhook, error) {
+	return s.repo.GetWebhooks()
+}
+
+func (s *service) GetWebhook(id uint32) (*model.Webhook, error) {
+	return s.repo.GetWebhook(id)
+}
+
+// CreateWebhook generates a secret unless one is given.
+func (s *service) CreateWebhook(webhook *model.Webhook) (*model.Webhook, error) {
+	created := *webhook
+	events, err := s.checkWebhook(&created)
+	if err != nil {
+		return nil, err
+	}
+	created.Events = events
+	if created.Secret == "" {
+		b := make([]byte, webhookSecretBytes)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		created.Secret = hex.EncodeToString(b)
+	}
+	now := s.timestamp()
+	created.CreatedAt = now
+	created.UpdatedAt = now
+	return s.repo.CreateWebhook(&created)
+}
+
+// UpdateWebhook replaces the URL, events and active flag of a webhook. The
+// secret is only replaced when a new one is given.
+func (s *service) UpdateWebhook(id uint32, webhook *model.Webhook) (*model.Webhook, error) {
+	events, err := s.checkWebhook(webhook)
+	if err != nil {
+		return nil, err
+	}
+	return s.repo.PatchWebhook(id, func(current *model.Webhook) error {
+		current.URL = webhook.URL
+		current.Events = events
+		current.Active = webhook.Active
+		if webhook.Secret != "" {
+			current.Secret = webhook.Secret
+		}
+		current.UpdatedAt = s.timestamp()
+		return nil
+	})
+}
+
+func (s *service) DeleteWebhook(id uint32) error {
+	return s.repo.DeleteWebhook(id)
+}
+
+// GetWebhookDeliveries lists the logged deliveries to a webhook, newest
+// first.
+func (s *service) GetWebhookDeliveries(id uint32) ([]*model.WebhookDelivery, error) {
+	if _, err := s.repo.GetWebhook(id); err != nil {
+		return nil, err
+	}
+	if s.webhooks == nil {
+		return []*model.WebhookDelivery{}, nil
+	}
+	return s.webhooks.Deliveries(id), nil
+}
+
+// GetDeadLetters lists the deliveries that failed every attempt or were
+// given up on at shutdown, newest first.
+func (s *service) GetDeadLetters() ([]*model.WebhookDelivery, error) {
+	return s.repo.GetDeadLetters()
+}
+
+// checkWebhook validates a webhook and returns its events sorted and without
+// duplicates.
+func (s *service) checkWebhook(webhook *model.Webhook) ([]string, error) {
+	u, err := url.Parse(webhook.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, errcode.InvalidParams.WithDetails("url: 必須是 http 或 https 網址")
+	}
+	if s.webhooks != nil && !s.webhooks.AllowsHost(u.Hostname()) {
+		return nil, errcode.InvalidParams.WithDetails("url: 不能是本機或內部網路位址")
+	}
+	known := make(map[string]bool)
+	for _, event := range model.EventTypes {
+		known[event] = true
+	}
+	seen := make(map[string]bool)
+	var events []string
+	for _, event := range webhook.Events {
+		if !known[event] {
+			return nil, errcode.InvalidParams.WithDetails(fmt.Sprintf("events: 不支援的事件 %q", event))
+		}
+		if !seen[event] {
+			seen[event] = true
+			events = append(events, event)
+		}
+	}
+	sort.Strings(events)
+	return events, nil
+}
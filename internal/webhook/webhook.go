@@ -0,0 +1,408 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/wagaru/task/config"
+	"github.com/wagaru/task/internal/model"
+)
+
+const (
+	// SignatureHeader carries "sha256=" and the hex HMAC-SHA256 of the body,
+	// keyed with the webhook's secret.
+	SignatureHeader = "X-Task-Signature"
+	EventHeader     = "X-Task-Event"
+	DeliveryHeader  = "X-Task-Delivery"
+)
+
+const (
+	defaultMaxAttempts = 5
+	defaultBackoff     = time.Second
+	defaultMaxBackoff  = 5 * time.Minute
+	defaultTimeout     = 10 * time.Second
+	defaultLogSize     = 1000
+)
+
+// Store is where the dispatcher looks up the webhooks subscribed to an event
+// and keeps the dead letters.
+type Store interface {
+	GetWebhooks() ([]*model.Webhook, error)
+	GetDeadLetters() ([]*model.WebhookDelivery, error)
+	PutDeadLetter(delivery *model.WebhookDelivery) error
+}
+
+// Dispatcher posts events to webhooks in the background, retrying failed
+// deliveries with exponential backoff. Each webhook has a queue of its own,
+// so it receives events in the order they were published, and a delivery
+// waiting for a retry holds back the ones after it. The delivery log is kept
+// in memory only; dead letters go to the store.
+type Dispatcher struct {
+	store        Store
+	client       *http.Client
+	maxAttempts  int
+	backoff      time.Duration
+	maxBackoff   time.Duration
+	logSize      int
+	allowPrivate bool
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu     sync.Mutex
+	closed bool
+	seq    uint64
+	log    []*model.WebhookDelivery
+	// queues holds the deliveries waiting for each webhook, oldest first.
+	// A webhook has a worker exactly while it has an entry.
+	queues map[uint32][]*job
+}
+
+// job is a delivery together with what posting it takes.
+type job struct {
+	webhook  model.Webhook
+	delivery *model.WebhookDelivery
+	body     []byte
+}
+
+// errShutdown is the error of deliveries given up on by Shutdown.
+var errShutdown = errors.New("dispatcher shut down")
+
+// NewDispatcher numbers deliveries after the last dead letter in store, so
+// that they keep unique IDs across restarts.
+func NewDispatcher(store Store, conf *config.WebhookConfig) *Dispatcher {
+	d := &Dispatcher{
+		store:       store,
+		maxAttempts: defaultMaxAttempts,
+		backoff:     defaultBackoff,
+		maxBackoff:  defaultMaxBackoff,
+		logSize:     defaultLogSize,
+		queues:      make(map[uint32][]*job),
+	}
+	timeout := defaultTimeout
+	if conf != nil {
+		if conf.MaxAttempts > 0 {
+			d.maxAttempts = conf.MaxAttempts
+		}
+		if conf.Backoff > 0 {
+			d.backoff = conf.Backoff
+		}
+		if conf.MaxBackoff > 0 {
+			d.maxBackoff = conf.MaxBackoff
+		}
+		if conf.Timeout > 0 {
+			timeout = conf.Timeout
+		}
+		if conf.LogSize > 0 {
+			d.logSize = conf.LogSize
+		}
+		d.allowPrivate = conf.AllowPrivate
+	}
+	d.client = d.newClient(timeout)
+	dead, err := store.GetDeadLetters()
+	if err != nil {
+		log.Printf("webhook.GetDeadLetters err:%v", err)
+	}
+	for _, delivery := range dead {
+		if delivery.ID > d.seq {
+			d.seq = delivery.ID
+		}
+	}
+	d.ctx, d.cancel = context.WithCancel(context.Background())
+	return d
+}
+
+// Sign returns the SignatureHeader value for body.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Publish queues event for every active webhook subscribed to it and returns
+// without waiting for the deliveries.
+func (d *Dispatcher) Publish(event *model.Event) {
+	webhooks, err := d.store.GetWebhooks()
+	if err != nil {
+		log.Printf("webhook.GetWebhooks err:%v", err)
+		return
+	}
+	body, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("webhook: encode %s event err:%v", event.Type, err)
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		return
+	}
+	for _, webhook := range webhooks {
+		if !webhook.Subscribes(event.Type) {
+			continue
+		}
+		d.seq++
+		now := timestamp()
+		delivery := &model.WebhookDelivery{
+			ID:        d.seq,
+			WebhookID: webhook.ID,
+			URL:       webhook.URL,
+			Event:     event,
+			Status:    model.DeliveryPending,
+			CreatedAt: now,
+			UpdatedAt: now,
+		}
+		d.log = appendBounded(d.log, delivery, d.logSize)
+		queue, working := d.queues[webhook.ID]
+		d.queues[webhook.ID] = append(queue, &job{webhook: *webhook, delivery: delivery, body: body})
+		if !working {
+			d.wg.Add(1)
+			go d.work(webhook.ID)
+		}
+	}
+}
+
+// Deliveries lists the logged deliveries to a webhook, newest first.
+func (d *Dispatcher) Deliveries(webhookID uint32) []*model.WebhookDelivery {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	deliveries := make([]*model.WebhookDelivery, 0)
+	for i := len(d.log) - 1; i >= 0; i-- {
+		if d.log[i].WebhookID == webhookID {
+			delivery := *d.log[i]
+			deliveries = append(deliveries, &delivery)
+		}
+	}
+	return deliveries
+}
+
+// AllowsHost reports whether webhooks may post to host. Unless the
+// configuration allows it, loopback, private and link-local addresses are
+// refused, so that a webhook cannot reach services inside the network. Host
+// names are checked again for the addresses they resolve to when posting.
+func (d *Dispatcher) AllowsHost(host string) bool {
+	if d.allowPrivate {
+		return true
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return false
+	}
+	addr, err := netip.ParseAddr(host)
+	return err != nil || public(addr)
+}
+
+// Shutdown stops accepting events and dead-letters the deliveries still
+// pending, cancelling requests in flight and retries waiting to run. It
+// waits for that to finish or ctx to expire.
+func (d *Dispatcher) Shutdown(ctx context.Context) error {
+	d.mu.Lock()
+	d.closed = true
+	d.cancel()
+	d.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		d.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// work delivers the queue of a webhook one job at a time until it is empty.
+// Once the dispatcher shuts down, the rest of the queue is dead-lettered
+// without being tried.
+func (d *Dispatcher) work(webhookID uint32) {
+	defer d.wg.Done()
+	for {
+		d.mu.Lock()
+		queue := d.queues[webhookID]
+		if len(queue) == 0 {
+			delete(d.queues, webhookID)
+			d.mu.Unlock()
+			return
+		}
+		job := queue[0]
+		d.queues[webhookID] = queue[1:]
+		d.mu.Unlock()
+
+		if d.ctx.Err() != nil {
+			d.bury(job.delivery, errShutdown)
+			continue
+		}
+		d.deliver(job)
+	}
+}
+
+func (d *Dispatcher) deliver(job *job) {
+	delivery := job.delivery
+	wait := d.backoff
+	for attempt := 1; ; attempt++ {
+		code, err := d.post(&job.webhook, delivery, job.body)
+
+		d.mu.Lock()
+		delivery.Attempts = attempt
+		delivery.StatusCode = code
+		delivery.Error = ""
+		if err != nil {
+			delivery.Error = err.Error()
+		}
+		delivery.UpdatedAt = timestamp()
+		delivery.NextRetry = nil
+		switch {
+		case err == nil:
+			delivery.Status = model.DeliverySucceeded
+		case attempt >= d.maxAttempts:
+			delivery.Status = model.DeliveryDead
+		default:
+			next := delivery.UpdatedAt.Add(wait)
+			delivery.NextRetry = &next
+		}
+		status := delivery.Status
+		d.mu.Unlock()
+		switch status {
+		case model.DeliverySucceeded:
+			return
+		case model.DeliveryDead:
+			d.putDeadLetter(delivery)
+			return
+		}
+
+		select {
+		case <-d.ctx.Done():
+			d.bury(delivery, errShutdown)
+			return
+		case <-time.After(wait):
+		}
+		wait *= 2
+		if wait > d.maxBackoff {
+			wait = d.maxBackoff
+		}
+	}
+}
+
+// bury gives up on a delivery that has not failed every attempt yet.
+func (d *Dispatcher) bury(delivery *model.WebhookDelivery, err error) {
+	d.mu.Lock()
+	delivery.Status = model.DeliveryDead
+	delivery.Error = err.Error()
+	delivery.UpdatedAt = timestamp()
+	delivery.NextRetry = nil
+	d.mu.Unlock()
+	d.putDeadLetter(delivery)
+}
+
+func (d *Dispatcher) putDeadLetter(delivery *model.WebhookDelivery) {
+	if err := d.store.PutDeadLetter(d.snapshot(delivery)); err != nil {
+		log.Printf("webhook.PutDeadLetter %d err:%v", delivery.ID, err)
+	}
+}
+
+// snapshot copies a delivery the log keeps updating.
+func (d *Dispatcher) snapshot(delivery *model.WebhookDelivery) *model.WebhookDelivery {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	copied := *delivery
+	return &copied
+}
+
+// post sends one attempt; anything but a 2xx response is a failure.
+func (d *Dispatcher) post(webhook *model.Webhook, delivery *model.WebhookDelivery, body []byte) (int, error) {
+	req, err := http.NewRequestWithContext(d.ctx, http.MethodPost, webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "task-webhook")
+	req.Header.Set(EventHeader, delivery.Event.Type)
+	req.Header.Set(DeliveryHeader, strconv.FormatUint(delivery.ID, 10))
+	req.Header.Set(SignatureHeader, Sign(webhook.Secret, body))
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// appendBounded appends delivery, dropping the oldest entry once size is
+// reached.
+func appendBounded(deliveries []*model.WebhookDelivery, delivery *model.WebhookDelivery, size int) []*model.WebhookDelivery {
+	if len(deliveries) < size {
+		return append(deliveries, delivery)
+	}
+	copy(deliveries, deliveries[1:])
+	deliveries[len(deliveries)-1] = delivery
+	return deliveries
+}
+
+func timestamp() time.Time {
+	return time.Now().UTC().Round(0)
+}
+
+// newClient returns a client that, unless private addresses are allowed,
+// refuses to connect to them whatever name or redirect led there. Such a
+// client goes around any proxy of the environment, since checking the
+// address of the proxy says nothing of the target.
+func (d *Dispatcher) newClient(timeout time.Duration) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if !d.allowPrivate {
+		transport.Proxy = nil
+		dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second, Control: checkDial}
+		transport.DialContext = dialer.DialContext
+	}
+	return &http.Client{Timeout: timeout, Transport: transport}
+}
+
+// checkDial runs once the address to connect to is resolved.
+func checkDial(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return err
+	}
+	if !public(addr) {
+		return fmt.Errorf("refusing to connect to private address %s", host)
+	}
+	return nil
+}
+
+// public reports whether addr may be reached from the internet, as opposed
+// to loopback, private, link-local, multicast and unspecified addresses.
+func public(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	return addr.IsGlobalUnicast() && !addr.IsPrivate() && !sharedAddressSpace.Contains(addr)
+}
+
+// sharedAddressSpace is the carrier-grade NAT range of RFC 6598, which is
+// not covered by netip.Addr.IsPrivate.
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
@@ -0,0 +1,264 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wagaru/task/config"
+	"github.com/wagaru/task/internal/model"
+)
+
+// store serves webhooks and keeps the dead letters in memory.
+type store struct {
+	webhooks []*model.Webhook
+	mu       sync.Mutex
+	dead     []*model.WebhookDelivery
+}
+
+func newStore(webhooks ...*model.Webhook) *store {
+	return &store{webhooks: webhooks}
+}
+
+func (s *store) GetWebhooks() ([]*model.Webhook, error) {
+	return s.webhooks, nil
+}
+
+func (s *store) GetDeadLetters() ([]*model.WebhookDelivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*model.WebhookDelivery{}, s.dead...), nil
+}
+
+func (s *store) PutDeadLetter(delivery *model.WebhookDelivery) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.dead = append([]*model.WebhookDelivery{delivery}, s.dead...)
+	return nil
+}
+
+// testConfig allows private addresses, as the test receivers listen on the
+// loopback interface.
+var testConfig = &config.WebhookConfig{MaxAttempts: 3, Backoff: time.Millisecond, MaxBackoff: 2 * time.Millisecond, Timeout: time.Second, LogSize: 10, AllowPrivate: true}
+
+var testEvent = &model.Event{
+	Type: model.EventTaskCreated,
+	Task: &model.Task{ID: 1, Name: "task", Status: model.StatusTodo},
+	At:   time.Date(2022, 5, 1, 8, 0, 0, 0, time.UTC),
+}
+
+// receiver answers with the given status codes in turn, then with 200.
+func receiver(t *testing.T, codes ...int) (*httptest.Server, chan *http.Request) {
+	requests := make(chan *http.Request, 10)
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		requests <- r
+		n := int(atomic.AddInt32(&calls, 1))
+		if n <= len(codes) {
+			w.WriteHeader(codes[n-1])
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server, requests
+}
+
+func waitStatus(t *testing.T, d *Dispatcher, webhookID uint32, status string) *model.WebhookDelivery {
+	var delivery *model.WebhookDelivery
+	require.Eventually(t, func() bool {
+		deliveries := d.Deliveries(webhookID)
+		if len(deliveries) == 0 || deliveries[0].Status != status {
+			return false
+		}
+		delivery = deliveries[0]
+		return true
+	}, time.Second, time.Millisecond)
+	return delivery
+}
+
+func TestPublish(t *testing.T) {
+	t.Run("Signed", func(t *testing.T) {
+		server, requests := receiver(t)
+		webhook := &model.Webhook{ID: 1, URL: server.URL, Secret: "secret", Active: true}
+		d := NewDispatcher(newStore(webhook), testConfig)
+		d.Publish(testEvent)
+
+		req := <-requests
+		body, _ := io.ReadAll(req.Body)
+		expected, _ := json.Marshal(testEvent)
+		assert.JSONEq(t, string(expected), string(body))
+		assert.Equal(t, Sign("secret", body), req.Header.Get(SignatureHeader))
+		assert.Equal(t, model.EventTaskCreated, req.Header.Get(EventHeader))
+		assert.Equal(t, "1", req.Header.Get(DeliveryHeader))
+		assert.Equal(t, "application/json", req.Header.Get("Content-Type"))
+
+		delivery := waitStatus(t, d, webhook.ID, model.DeliverySucceeded)
+		assert.Equal(t, 1, delivery.Attempts)
+		assert.Equal(t, http.StatusOK, delivery.StatusCode)
+		assert.Empty(t, d.store.(*store).dead)
+		assert.NoError(t, d.Shutdown(context.Background()))
+	})
+	t.Run("Retry", func(t *testing.T) {
+		server, _ := receiver(t, http.StatusInternalServerError, http.StatusBadGateway)
+		webhook := &model.Webhook{ID: 1, URL: server.URL, Active: true}
+		d := NewDispatcher(newStore(webhook), testConfig)
+		d.Publish(testEvent)
+
+		delivery := waitStatus(t, d, webhook.ID, model.DeliverySucceeded)
+		assert.Equal(t, 3, delivery.Attempts)
+		assert.Empty(t, delivery.Error)
+		assert.Nil(t, delivery.NextRetry)
+		assert.NoError(t, d.Shutdown(context.Background()))
+	})
+	t.Run("DeadLetter", func(t *testing.T) {
+		server, _ := receiver(t, 500, 500, 500)
+		webhook := &model.Webhook{ID: 1, URL: server.URL, Active: true}
+		d := NewDispatcher(newStore(webhook), testConfig)
+		d.Publish(testEvent)
+
+		delivery := waitStatus(t, d, webhook.ID, model.DeliveryDead)
+		assert.Equal(t, 3, delivery.Attempts)
+		assert.Equal(t, http.StatusInternalServerError, delivery.StatusCode)
+		assert.Equal(t, "unexpected status 500", delivery.Error)
+		require.Eventually(t, func() bool {
+			dead, _ := d.store.GetDeadLetters()
+			return len(dead) == 1
+		}, time.Second, time.Millisecond)
+		assert.Equal(t, []*model.WebhookDelivery{delivery}, d.store.(*store).dead)
+		assert.NoError(t, d.Shutdown(context.Background()))
+	})
+	t.Run("Subscriptions", func(t *testing.T) {
+		server, requests := receiver(t)
+		webhooks := newStore(
+			&model.Webhook{ID: 1, URL: server.URL, Active: false},
+			&model.Webhook{ID: 2, URL: server.URL, Events: []string{model.EventTaskDeleted}, Active: true},
+			&model.Webhook{ID: 3, URL: server.URL, Events: []string{model.EventTaskCreated, model.EventTaskDeleted}, Active: true},
+		)
+		d := NewDispatcher(webhooks, testConfig)
+		d.Publish(testEvent)
+
+		req := <-requests
+		assert.Equal(t, "1", req.Header.Get(DeliveryHeader))
+		waitStatus(t, d, 3, model.DeliverySucceeded)
+		assert.Empty(t, d.Deliveries(1))
+		assert.Empty(t, d.Deliveries(2))
+		assert.Len(t, requests, 0)
+		assert.NoError(t, d.Shutdown(context.Background()))
+	})
+	t.Run("LogSize", func(t *testing.T) {
+		server, _ := receiver(t)
+		webhook := &model.Webhook{ID: 1, URL: server.URL, Active: true}
+		d := NewDispatcher(newStore(webhook), &config.WebhookConfig{LogSize: 2, AllowPrivate: true})
+		for i := 0; i < 3; i++ {
+			d.Publish(testEvent)
+		}
+		assert.NoError(t, d.Shutdown(context.Background()))
+		deliveries := d.Deliveries(webhook.ID)
+		require.Len(t, deliveries, 2)
+		assert.Equal(t, uint64(3), deliveries[0].ID)
+		assert.Equal(t, uint64(2), deliveries[1].ID)
+	})
+	t.Run("Order", func(t *testing.T) {
+		server, requests := receiver(t, http.StatusInternalServerError)
+		webhook := &model.Webhook{ID: 1, URL: server.URL, Active: true}
+		d := NewDispatcher(newStore(webhook), testConfig)
+		for id := uint32(1); id <= 3; id++ {
+			d.Publish(&model.Event{Type: model.EventTaskCreated, Task: &model.Task{ID: id}})
+		}
+
+		var order []string
+		for i := 0; i < 4; i++ {
+			order = append(order, (<-requests).Header.Get(DeliveryHeader))
+		}
+		assert.Equal(t, []string{"1", "1", "2", "3"}, order, "the retry of 1 comes before 2")
+		assert.NoError(t, d.Shutdown(context.Background()))
+	})
+	t.Run("SequenceAfterDeadLetters", func(t *testing.T) {
+		server, requests := receiver(t)
+		webhook := &model.Webhook{ID: 1, URL: server.URL, Active: true}
+		s := newStore(webhook)
+		s.dead = []*model.WebhookDelivery{{ID: 41, WebhookID: 1, Status: model.DeliveryDead}}
+		d := NewDispatcher(s, testConfig)
+		d.Publish(testEvent)
+		assert.Equal(t, "42", (<-requests).Header.Get(DeliveryHeader))
+		assert.NoError(t, d.Shutdown(context.Background()))
+	})
+}
+
+func TestPrivateAddresses(t *testing.T) {
+	t.Run("AllowsHost", func(t *testing.T) {
+		d := NewDispatcher(newStore(), &config.WebhookConfig{})
+		for _, host := range []string{"localhost", "api.localhost", "127.0.0.1", "10.0.0.1", "172.16.0.1", "192.168.1.1", "169.254.169.254", "100.64.0.1", "0.0.0.0", "::1", "fd00::1", "fe80::1", "::ffff:127.0.0.1"} {
+			assert.False(t, d.AllowsHost(host), host)
+		}
+		for _, host := range []string{"example.com", "93.184.216.34", "2606:2800:220:1::"} {
+			assert.True(t, d.AllowsHost(host), host)
+		}
+		assert.True(t, NewDispatcher(newStore(), &config.WebhookConfig{AllowPrivate: true}).AllowsHost("169.254.169.254"))
+	})
+	t.Run("Dial", func(t *testing.T) {
+		// Checked again once connecting, whatever name or redirect led
+		// to the address.
+		server, requests := receiver(t)
+		webhook := &model.Webhook{ID: 1, URL: server.URL, Active: true}
+		d := NewDispatcher(newStore(webhook), &config.WebhookConfig{MaxAttempts: 1})
+		d.Publish(testEvent)
+
+		delivery := waitStatus(t, d, webhook.ID, model.DeliveryDead)
+		assert.Contains(t, delivery.Error, "refusing to connect to private address 127.0.0.1")
+		assert.Len(t, requests, 0)
+		assert.NoError(t, d.Shutdown(context.Background()))
+	})
+	t.Run("Proxy", func(t *testing.T) {
+		proxy, requests := receiver(t)
+		t.Setenv("HTTP_PROXY", proxy.URL)
+		webhook := &model.Webhook{ID: 1, URL: "http://169.254.169.254/latest/meta-data/", Active: true}
+		d := NewDispatcher(newStore(webhook), &config.WebhookConfig{MaxAttempts: 1})
+		assert.Nil(t, d.client.Transport.(*http.Transport).Proxy)
+		d.Publish(testEvent)
+
+		delivery := waitStatus(t, d, webhook.ID, model.DeliveryDead)
+		assert.Contains(t, delivery.Error, "refusing to connect to private address 169.254.169.254")
+		assert.Len(t, requests, 0)
+		assert.NoError(t, d.Shutdown(context.Background()))
+	})
+}
+
+func TestShutdown(t *testing.T) {
+	server, requests := receiver(t, 500)
+	webhook := &model.Webhook{ID: 1, URL: server.URL, Active: true}
+	s := newStore(webhook)
+	d := NewDispatcher(s, &config.WebhookConfig{Backoff: time.Hour, AllowPrivate: true})
+	d.Publish(testEvent)
+	d.Publish(testEvent)
+	<-requests
+	require.Eventually(t, func() bool {
+		return d.Deliveries(webhook.ID)[1].NextRetry != nil
+	}, time.Second, time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	assert.NoError(t, d.Shutdown(ctx))
+	d.Publish(testEvent)
+	deliveries := d.Deliveries(webhook.ID)
+	require.Len(t, deliveries, 2)
+	for _, delivery := range deliveries {
+		assert.Equal(t, model.DeliveryDead, delivery.Status)
+		assert.Equal(t, "dispatcher shut down", delivery.Error)
+		assert.Nil(t, delivery.NextRetry)
+	}
+	assert.Equal(t, 1, deliveries[1].Attempts, "retry abandoned")
+	assert.Equal(t, 0, deliveries[0].Attempts, "never tried")
+	assert.Equal(t, deliveries, s.dead)
+	assert.Len(t, requests, 0)
+}